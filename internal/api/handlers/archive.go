@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"devsync-be/internal/archive"
+	"devsync-be/internal/collab"
+	"devsync-be/internal/filetree"
+	"devsync-be/internal/gitstore"
+	"devsync-be/internal/history"
+	"devsync-be/internal/models"
+	"devsync-be/internal/storage"
+	"devsync-be/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxArchiveSize is the largest archive accepted for import
+	maxArchiveSize = 50 << 20
+	// maxImportSize is the total size of the files in an imported archive
+	maxImportSize = 100 << 20
+	// maxImportEntries is the number of files and folders in an archive
+	maxImportEntries = 5000
+)
+
+type ArchiveHandler struct {
+	db      *gorm.DB
+	hub     *websocket.Hub
+	storage *storage.GCSStorage
+	repos   *gitstore.Store
+}
+
+func NewArchiveHandler(db *gorm.DB, hub *websocket.Hub, storage *storage.GCSStorage, repos *gitstore.Store) *ArchiveHandler {
+	return &ArchiveHandler{db: db, hub: hub, storage: storage, repos: repos}
+}
+
+// ImportedFile is a file created by an import
+type ImportedFile struct {
+	ID   uint   `json:"id"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// ImportResponse reports what an import created and what it left out
+type ImportResponse struct {
+	Files     []ImportedFile    `json:"files"`
+	Folders   int               `json:"folders"`
+	Conflicts []archive.Skipped `json:"conflicts"`
+	Skipped   []archive.Skipped `json:"skipped"`
+}
+
+// @Summary Export project files
+// @Description Download every file and folder of the project, including uploads stored in GCS, as a zip or tar.gz archive
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param format query string false "zip (default) or tar.gz"
+// @Produce application/zip
+// @Success 200 {file} file
+// @Router /projects/{id}/export [get]
+func (h *ArchiveHandler) Export(c *gin.Context) {
+	project := c.MustGet("project").(*models.Project)
+
+	format := c.DefaultQuery("format", archive.FormatZip)
+	if format != archive.FormatZip && format != archive.FormatTarGz {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be zip or tar.gz"})
+		return
+	}
+
+	var folders []models.Folder
+	if err := h.db.Where("project_id = ?", project.ID).Order("path ASC").Find(&folders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch folders"})
+		return
+	}
+	var files []models.File
+	if err := h.db.Select("id, path, file_url, updated_at").
+		Where("project_id = ?", project.ID).
+		Order("path ASC").
+		Find(&files).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		return
+	}
+
+	// Files being edited may have edits not yet saved to File.Content
+	var editing []uint
+	if err := h.db.Model(&models.FileEditState{}).
+		Joins("JOIN files ON files.id = file_edit_states.file_id").
+		Where("files.project_id = ? AND file_edit_states.revision > file_edit_states.snapshot_revision", project.ID).
+		Pluck("file_edit_states.file_id", &editing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		return
+	}
+	unsaved := make(map[uint]bool, len(editing))
+	for _, id := range editing {
+		unsaved[id] = true
+	}
+
+	filename := archiveName(project.Name) + archive.Extension(format)
+	c.Header("Content-Type", archive.ContentType(format))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Status(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the archive
+	// short
+	w, _ := archive.NewWriter(format, c.Writer)
+	for _, folder := range folders {
+		if err := w.Dir(folder.Path, folder.UpdatedAt); err != nil {
+			log.Printf("Export of project %d failed: %v", project.ID, err)
+			return
+		}
+	}
+	for _, file := range files {
+		if err := h.exportFile(c, w, project.ID, &file, unsaved[file.ID]); err != nil {
+			log.Printf("Export of project %d failed at %s: %v", project.ID, file.Path, err)
+			return
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Export of project %d failed: %v", project.ID, err)
+	}
+}
+
+func (h *ArchiveHandler) exportFile(c *gin.Context, w archive.Writer, projectID uint, file *models.File, unsaved bool) error {
+	if file.FileURL != "" {
+		// Objects are read with the server's credentials, so only uploads
+		// of this project are exported
+		name, err := h.storage.ObjectName(file.FileURL)
+		if err != nil || !strings.HasPrefix(name, storage.ProjectFolder(projectID)) {
+			log.Printf("Export of project %d skips %s: %s is not an upload of the project", projectID, file.Path, file.FileURL)
+			return nil
+		}
+		content, size, err := h.storage.Open(c.Request.Context(), file.FileURL)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		return w.File(file.Path, size, file.UpdatedAt, content)
+	}
+
+	var text string
+	var err error
+	if unsaved {
+		text, _, err = h.hub.FileContent(projectID, file.ID)
+	} else {
+		err = h.db.Model(&models.File{}).Select("content").Where("id = ?", file.ID).Scan(&text).Error
+	}
+	if err != nil {
+		return err
+	}
+	return w.File(file.Path, int64(len(text)), file.UpdatedAt, strings.NewReader(text))
+}
+
+// @Summary Import project files
+// @Description Create files and folders from a zip or tar.gz archive. Text files become editable files; other files are uploaded to GCS. Paths that already exist are reported as conflicts and left unchanged, or fail the whole import with on_conflict=fail. Archives with entries outside the project root are rejected.
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param archive formData file true "zip or tar.gz archive (max 50MB)"
+// @Param path formData string false "Folder to import into (default: project root)"
+// @Param strip_components formData int false "Leading path elements to remove from entries"
+// @Param on_conflict formData string false "skip (default) or fail"
+// @Success 201 {object} ImportResponse
+// @Failure 409 {object} ImportResponse
+// @Failure 413 {object} map[string]interface{}
+// @Router /projects/{id}/import [post]
+func (h *ArchiveHandler) Import(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+	userID := c.GetUint("userID")
+
+	header, err := c.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No archive uploaded"})
+		return
+	}
+	if header.Size > maxArchiveSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Archive too large (max 50MB)", "code": "import_too_large"})
+		return
+	}
+
+	failOnConflict := false
+	switch c.DefaultPostForm("on_conflict", "skip") {
+	case "skip":
+	case "fail":
+		failOnConflict = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "on_conflict must be skip or fail"})
+		return
+	}
+	strip, err := strconv.Atoi(c.DefaultPostForm("strip_components", "0"))
+	if err != nil || strip < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid strip_components"})
+		return
+	}
+	prefix := ""
+	if p := c.PostForm("path"); strings.Trim(p, "/") != "" {
+		if prefix, err = filetree.Clean(p); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_path"})
+			return
+		}
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read archive"})
+		return
+	}
+	defer f.Close()
+
+	head := make([]byte, 4)
+	n, _ := f.ReadAt(head, 0)
+	format, err := archive.Detect(header.Filename, head[:n])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Archive must be zip or tar.gz", "code": "invalid_archive"})
+		return
+	}
+
+	entries, skipped, err := archive.Read(format, f, header.Size, strip, archive.Limits{
+		MaxEntries:   maxImportEntries,
+		MaxFileSize:  maxUploadSize,
+		MaxTotalSize: maxImportSize,
+	})
+	switch {
+	case errors.Is(err, archive.ErrUnsafePath):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "unsafe_path"})
+		return
+	case errors.Is(err, archive.ErrTooLarge), errors.Is(err, archive.ErrTooManyEntries):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "code": "import_too_large"})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid archive: " + err.Error(), "code": "invalid_archive"})
+		return
+	}
+
+	response := ImportResponse{Files: []ImportedFile{}, Conflicts: []archive.Skipped{}, Skipped: skipped}
+	if response.Skipped == nil {
+		response.Skipped = []archive.Skipped{}
+	}
+
+	// Report conflicts with existing paths before anything is uploaded
+	var files []*models.File
+	var folders []string
+
+	// Uploaded objects of files that end up not being created are removed
+	// again, however the import ends
+	created := make(map[*models.File]bool)
+	defer func() {
+		ctx := context.WithoutCancel(c.Request.Context())
+		for _, file := range files {
+			if file.FileURL != "" && !created[file] {
+				if err := h.storage.Delete(ctx, file.FileURL); err != nil {
+					log.Printf("Import: failed to delete unused object %s: %v", file.FileURL, err)
+				}
+			}
+		}
+	}()
+	for _, entry := range entries {
+		p := filetree.Join(prefix, entry.Path)
+		if entry.Dir {
+			folders = append(folders, p)
+			continue
+		}
+
+		reason, err := h.conflict(projectID, p)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check paths"})
+			return
+		}
+		if reason != "" {
+			response.Conflicts = append(response.Conflicts, archive.Skipped{Path: p, Reason: reason})
+			continue
+		}
+
+		file := &models.File{Path: p, ProjectID: projectID, UploadedBy: userID, Version: 1}
+		if isText(entry.Data) {
+			file.Content = string(entry.Data)
+		} else {
+			// Everything else is stored like an upload
+			file.FileSize = int64(len(entry.Data))
+			file.FileType = getFileType(p)
+			file.MimeType = mime.TypeByExtension(path.Ext(p))
+			if file.MimeType == "" {
+				file.MimeType = http.DetectContentType(entry.Data)
+			}
+			folder := storage.ProjectFolder(projectID) + file.FileType
+			if file.FileURL, err = h.storage.Upload(c.Request.Context(), bytes.NewReader(entry.Data), folder, path.Base(p), file.MimeType); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+				return
+			}
+		}
+		files = append(files, file)
+	}
+	if failOnConflict && len(response.Conflicts) > 0 {
+		c.JSON(http.StatusConflict, response)
+		return
+	}
+
+	// Paths can still collide with each other or with changes made since
+	// the check; each file is placed in its own savepoint so one conflict
+	// does not undo the others
+	var placed []*models.File
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		for _, p := range folders {
+			err := tx.Transaction(func(tx *gorm.DB) error {
+				_, err := filetree.MakeFolders(tx, projectID, userID, p)
+				return err
+			})
+			if errors.Is(err, filetree.ErrParentNotFolder) {
+				response.Conflicts = append(response.Conflicts, archive.Skipped{Path: p, Reason: "parent_not_folder"})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			response.Folders++
+		}
+
+		for _, file := range files {
+			err := tx.Transaction(func(tx *gorm.DB) error {
+				if err := filetree.Place(tx, file); err != nil {
+					return err
+				}
+				if err := tx.Create(file).Error; err != nil {
+					return err
+				}
+				if file.FileURL != "" {
+					return nil
+				}
+				_, err := history.Record(tx, file.ID, userID, file.Content, "Import")
+				return err
+			})
+			if reason := conflictCode(err); reason != "" {
+				response.Conflicts = append(response.Conflicts, archive.Skipped{Path: file.Path, Reason: reason})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			size := file.FileSize
+			if file.FileURL == "" {
+				size = int64(len(file.Content))
+			}
+			response.Files = append(response.Files, ImportedFile{ID: file.ID, Path: file.Path, Size: size})
+			placed = append(placed, file)
+		}
+
+		if failOnConflict && len(response.Conflicts) > 0 {
+			return errImportConflict
+		}
+		return nil
+	})
+	if errors.Is(err, errImportConflict) {
+		response.Files, response.Folders = []ImportedFile{}, 0
+		c.JSON(http.StatusConflict, response)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import files"})
+		return
+	}
+	for _, file := range placed {
+		created[file] = true
+	}
+	if len(response.Files) > 0 {
+		h.repos.Record(projectID, c.GetUint("userID"), fmt.Sprintf("Import %d files", len(response.Files)))
+	}
+
+	// One event for the whole import; clients reload the tree
+	message := map[string]interface{}{
+		"type":       "files_imported",
+		"project_id": projectID,
+		"data": map[string]interface{}{
+			"path":    prefix,
+			"files":   len(response.Files),
+			"folders": response.Folders,
+		},
+	}
+	if msgBytes, err := json.Marshal(message); err == nil {
+		h.hub.Broadcast(msgBytes)
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// errImportConflict rolls back an import with on_conflict=fail
+var errImportConflict = errors.New("import conflict")
+
+// conflict returns why a file cannot be created at p, or "" if it can.
+func (h *ArchiveHandler) conflict(projectID uint, p string) (string, error) {
+	exists, err := filetree.Exists(h.db, projectID, p)
+	if err != nil || exists {
+		return "path_exists", err
+	}
+	for dir, _ := filetree.Split(p); dir != ""; dir, _ = filetree.Split(dir) {
+		var count int64
+		if err := h.db.Model(&models.File{}).Where("project_id = ? AND path = ?", projectID, dir).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return "parent_not_folder", nil
+		}
+	}
+	return "", nil
+}
+
+// conflictCode returns the conflict reason of a tree error, or "".
+func conflictCode(err error) string {
+	switch {
+	case errors.Is(err, filetree.ErrPathExists):
+		return "path_exists"
+	case errors.Is(err, filetree.ErrParentNotFolder):
+		return "parent_not_folder"
+	}
+	return ""
+}
+
+// isText reports whether data can be a collaboratively edited file.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0 && len(collab.Encode(string(data))) <= collab.MaxDocumentLength
+}
+
+// archiveName turns a project name into a file name
+func archiveName(name string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '"' || r < 0x20 {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if cleaned == "" {
+		return "project"
+	}
+	return cleaned
+}
@@ -5,137 +5,53 @@ import (
 
     "devsync-be/internal/auth"
     "devsync-be/internal/config"
+    "devsync-be/internal/mailer"
     "devsync-be/internal/models"
+    "devsync-be/internal/secrets"
 
     "github.com/gin-gonic/gin"
     "gorm.io/gorm"
 )
 
 type AuthHandler struct {
-    db  *gorm.DB
-    cfg *config.Config
+    db        *gorm.DB
+    cfg       *config.Config
+    keys      *auth.KeySet
+    providers auth.Providers
+    tokens    *secrets.TokenStore
+    mailer    mailer.Mailer
 }
 
-func NewAuthHandler(db *gorm.DB, cfg *config.Config) *AuthHandler {
+func NewAuthHandler(db *gorm.DB, cfg *config.Config, keys *auth.KeySet, providers auth.Providers, tokens *secrets.TokenStore, mail mailer.Mailer) *AuthHandler {
     return &AuthHandler{
-        db:  db,
-        cfg: cfg,
+        db:        db,
+        cfg:       cfg,
+        keys:      keys,
+        providers: providers,
+        tokens:    tokens,
+        mailer:    mail,
     }
 }
 
 // @Summary GitHub OAuth login
-// @Description Redirect to GitHub OAuth
+// @Description Redirect to GitHub OAuth. Same as /auth/providers/github/login.
 // @Tags auth
+// @Param redirect_to query string false "Frontend path or URL to continue to after login"
 // @Success 302 {string} string "redirect"
 // @Router /auth/github [get]
 func (h *AuthHandler) GitHubLogin(c *gin.Context) {
-    config := auth.GetGitHubOAuthConfig(h.cfg.GitHubClientID, h.cfg.GitHubSecret, h.cfg.RedirectURL)
-    url := config.AuthCodeURL("state")
-    c.Redirect(http.StatusTemporaryRedirect, url)
+    h.providerLogin(c, "github")
 }
 
 // @Summary GitHub OAuth callback
-// @Description Handle GitHub OAuth callback
+// @Description Handle GitHub OAuth callback. Same as /auth/providers/github/callback.
 // @Tags auth
 // @Param code query string true "OAuth code"
-// @Success 200 {object} map[string]interface{}
+// @Param state query string true "Login state"
+// @Success 302 {string} string "redirect"
 // @Router /auth/github/callback [get]
 func (h *AuthHandler) GitHubCallback(c *gin.Context) {
-    code := c.Query("code")
-    if code == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Code not provided"})
-        return
-    }
-
-    config := auth.GetGitHubOAuthConfig(h.cfg.GitHubClientID, h.cfg.GitHubSecret, h.cfg.RedirectURL)
-    githubUser, accessToken, err := auth.GetGitHubUser(c.Request.Context(), config, code)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
-        return
-    }
-
-    // Find or create user - gunakan git_hub_id bukan github_id
-    var user models.User
-    result := h.db.Where("git_hub_id = ?", githubUser.ID).First(&user)
-    if result.Error != nil {
-        if result.Error == gorm.ErrRecordNotFound {
-            // Create new user
-            user = models.User{
-                GitHubID:    githubUser.ID,
-                Username:    githubUser.Login,
-                Email:       githubUser.Email,
-                Name:        githubUser.Name,
-                AvatarURL:   githubUser.AvatarURL,
-                AccessToken: accessToken,
-            }
-            if createErr := h.db.Create(&user).Error; createErr != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
-                return
-            }
-        } else {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-            return
-        }
-    } else {
-        // Update existing user
-        user.AccessToken = accessToken
-        if updateErr := h.db.Save(&user).Error; updateErr != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
-            return
-        }
-    }
-
-    user.AvatarURL = githubUser.AvatarURL
-    user.Name = githubUser.Name
-    h.db.Save(&user)
-
-    // Generate JWT token
-    token, err := auth.GenerateToken(user.ID, user.Username, h.cfg.JWTSecret)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-        return
-    }
-
-    // Return success response with token and user info
-    c.JSON(http.StatusOK, gin.H{
-        "message": "Login successful",
-        "token":   token,
-        "user": gin.H{
-            "id":         user.ID,
-            "username":   user.Username,
-            "email":      user.Email,
-            "name":       user.Name,
-            "avatar_url": user.AvatarURL,
-        },
-    })
-}
-
-// @Summary Refresh token
-// @Description Refresh JWT token
-// @Tags auth
-// @Security BearerAuth
-// @Success 200 {object} map[string]interface{}
-// @Router /auth/refresh [post]
-func (h *AuthHandler) RefreshToken(c *gin.Context) {
-    userID, exists := c.Get("userID")
-    if !exists {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-        return
-    }
-
-    username, exists := c.Get("username")
-    if !exists {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Username not found"})
-        return
-    }
-
-    token, err := auth.GenerateToken(userID.(uint), username.(string), h.cfg.JWTSecret)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{"token": token})
+    h.providerCallback(c, "github")
 }
 
 // @Summary Get current user
@@ -161,7 +77,7 @@ func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 }
 
 // @Summary Development login
-// @Description Login for development purposes without GitHub
+// @Description Login for development purposes without GitHub. Only available when DEV_MODE is enabled.
 // @Tags auth
 // @Param request body models.DevLoginRequest true "Dev login request"
 // @Success 200 {object} map[string]interface{}
@@ -206,25 +122,33 @@ func (h *AuthHandler) DevLogin(c *gin.Context) {
         }
     }
 
-    // Generate JWT token
-    token, err := auth.GenerateToken(user.ID, user.Username, h.cfg.JWTSecret)
+    // Start a session and issue the token pair
+    response, err := h.startSession(c, &user)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
         return
     }
 
-    // Return success response with token and user info
-    c.JSON(http.StatusOK, gin.H{
-        "message": "Login successful",
-        "token":   token,
-        "user": gin.H{
-            "id":         user.ID,
-            "username":   user.Username,
-            "email":      user.Email,
-            "name":       user.Name,
-            "avatar_url": user.AvatarURL,
-            "created_at": user.CreatedAt,
-            "updated_at": user.UpdatedAt,
-        },
-    })
-}
\ No newline at end of file
+    // Return success response with tokens and user info
+    response["message"] = "Login successful"
+    response["user"] = gin.H{
+        "id":         user.ID,
+        "username":   user.Username,
+        "email":      user.Email,
+        "name":       user.Name,
+        "avatar_url": user.AvatarURL,
+        "created_at": user.CreatedAt,
+        "updated_at": user.UpdatedAt,
+    }
+    c.JSON(http.StatusOK, response)
+}
+
+// @Summary JSON Web Key Set
+// @Description Public keys for verifying DevSync access tokens
+// @Tags auth
+// @Success 200 {object} map[string]interface{}
+// @Router /.well-known/jwks.json [get]
+func (h *AuthHandler) JWKS(c *gin.Context) {
+    c.Header("Cache-Control", "public, max-age=300")
+    c.JSON(http.StatusOK, h.keys.JWKS())
+}
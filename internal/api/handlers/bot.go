@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"devsync-be/internal/auth"
+	"devsync-be/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var botSlugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+type BotHandler struct {
+	db *gorm.DB
+}
+
+func NewBotHandler(db *gorm.DB) *BotHandler {
+	return &BotHandler{db: db}
+}
+
+// CreateBotRequest represents the request body for creating a project bot
+type CreateBotRequest struct {
+	Name      string             `json:"name" binding:"required"`
+	Role      models.ProjectRole `json:"role,omitempty"`
+	Scopes    []string           `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time         `json:"expires_at,omitempty"`
+}
+
+// CreateBotKeyRequest represents the request body for issuing a bot key
+type CreateBotKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// BotResponse is a bot account with its role and active keys
+type BotResponse struct {
+	models.User
+	Role models.ProjectRole `json:"role"`
+	Keys []models.APIToken  `json:"keys"`
+}
+
+// @Summary List bots
+// @Description List the bot accounts of a project with their active keys
+// @Tags bots
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Success 200 {array} BotResponse
+// @Router /projects/{id}/bots [get]
+func (h *BotHandler) GetBots(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	var bots []models.User
+	if err := h.db.Where("is_bot = ? AND bot_project_id = ?", true, projectID).Find(&bots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bots"})
+		return
+	}
+
+	response := make([]BotResponse, 0, len(bots))
+	for _, bot := range bots {
+		var membership models.UserProject
+		h.db.Where("user_id = ? AND project_id = ?", bot.ID, projectID).First(&membership)
+
+		var keys []models.APIToken
+		h.db.Where("user_id = ? AND revoked_at IS NULL", bot.ID).Order("created_at DESC").Find(&keys)
+
+		response = append(response, BotResponse{User: bot, Role: membership.Role, Keys: keys})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Create bot
+// @Description Create a bot account in the project and issue its first key. The key is only shown once.
+// @Tags bots
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param bot body CreateBotRequest true "Bot data"
+// @Success 201 {object} map[string]interface{}
+// @Router /projects/{id}/bots [post]
+func (h *BotHandler) CreateBot(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	var req CreateBotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = models.ProjectRoleMember
+	}
+	if req.Role != models.ProjectRoleMember && req.Role != models.ProjectRoleViewer {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bots can only be members or viewers"})
+		return
+	}
+
+	slug := strings.Trim(botSlugPattern.ReplaceAllString(strings.ToLower(req.Name), "-"), "-")
+	if slug == "" {
+		slug = "bot"
+	}
+	suffix, err := auth.RandomToken(4)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create bot"})
+		return
+	}
+	suffix = strings.ToLower(botSlugPattern.ReplaceAllString(suffix, ""))
+
+	bot := models.User{
+		Username:     fmt.Sprintf("%s-%d-%s[bot]", slug, projectID, suffix),
+		Email:        fmt.Sprintf("%s-%d-%s@bots.devsync.local", slug, projectID, suffix),
+		Name:         req.Name,
+		AvatarURL:    "https://via.placeholder.com/150",
+		IsBot:        true,
+		BotProjectID: &projectID,
+	}
+
+	var key *models.APIToken
+	var plaintext string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&bot).Error; err != nil {
+			return err
+		}
+		membership := models.UserProject{UserID: bot.ID, ProjectID: projectID, Role: req.Role}
+		if err := tx.Create(&membership).Error; err != nil {
+			return err
+		}
+		var err error
+		key, plaintext, err = createAPIToken(tx, bot.ID, models.BotTokenPrefix, CreateTokenRequest{
+			Name:      req.Name,
+			Scopes:    req.Scopes,
+			ProjectID: &projectID,
+			ExpiresAt: req.ExpiresAt,
+		})
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create bot: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"bot":   BotResponse{User: bot, Role: req.Role, Keys: []models.APIToken{*key}},
+		"token": plaintext,
+	})
+}
+
+// @Summary Delete bot
+// @Description Delete a bot account, revoking all its keys and its membership
+// @Tags bots
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param botId path int true "Bot user ID"
+// @Success 204
+// @Router /projects/{id}/bots/{botId} [delete]
+func (h *BotHandler) DeleteBot(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	bot, ok := h.findBot(c, projectID)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.APIToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", bot.ID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND project_id = ?", bot.ID, projectID).Delete(&models.UserProject{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(bot).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete bot"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Create bot key
+// @Description Issue an additional key for a bot. The key is only shown once.
+// @Tags bots
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param botId path int true "Bot user ID"
+// @Param key body CreateBotKeyRequest true "Key data"
+// @Success 201 {object} map[string]interface{}
+// @Router /projects/{id}/bots/{botId}/keys [post]
+func (h *BotHandler) CreateBotKey(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	bot, ok := h.findBot(c, projectID)
+	if !ok {
+		return
+	}
+
+	var req CreateBotKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, plaintext, err := createAPIToken(h.db, bot.ID, models.BotTokenPrefix, CreateTokenRequest{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ProjectID: &projectID,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":   plaintext,
+		"details": key,
+	})
+}
+
+// @Summary Revoke bot key
+// @Description Revoke one key of a bot
+// @Tags bots
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param botId path int true "Bot user ID"
+// @Param keyId path int true "Key ID"
+// @Success 204
+// @Router /projects/{id}/bots/{botId}/keys/{keyId} [delete]
+func (h *BotHandler) RevokeBotKey(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	bot, ok := h.findBot(c, projectID)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.Atoi(c.Param("keyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return
+	}
+
+	result := h.db.Model(&models.APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, bot.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke key"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// findBot loads the :botId route parameter scoped to the project, writing
+// an error response and returning false if it fails.
+func (h *BotHandler) findBot(c *gin.Context, projectID uint) (*models.User, bool) {
+	botID, err := strconv.Atoi(c.Param("botId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bot ID"})
+		return nil, false
+	}
+
+	var bot models.User
+	if err := h.db.Where("is_bot = ? AND bot_project_id = ?", true, projectID).First(&bot, botID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bot not found"})
+		return nil, false
+	}
+
+	return &bot, true
+}
@@ -3,13 +3,13 @@ package handlers
 import (
     "encoding/json"
     "net/http"
-    "strconv"
 
     "devsync-be/internal/models"
     "devsync-be/internal/websocket"
 
     "github.com/gin-gonic/gin"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
 )
 
 type ChatHandler struct {
@@ -34,11 +34,7 @@ func NewChatHandler(db *gorm.DB, hub *websocket.Hub) *ChatHandler {
 // @Success 200 {array} models.ChatMessage
 // @Router /projects/{id}/messages [get]
 func (h *ChatHandler) GetMessages(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     query := h.db.Where("project_id = ?", projectID).
         Preload("User").
@@ -73,11 +69,7 @@ func (h *ChatHandler) GetMessages(c *gin.Context) {
 // @Success 201 {object} models.ChatMessage
 // @Router /projects/{id}/messages [post]
 func (h *ChatHandler) SendMessage(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     userID, exists := c.Get("userID")
     if !exists {
@@ -91,10 +83,21 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
         return
     }
 
-    message.ProjectID = uint(projectID)
+    message.ID = 0
+    message.ProjectID = projectID
     message.UserID = userID.(uint)
 
-    if err := h.db.Create(&message).Error; err != nil {
+    // Attached file or task must live in the same project
+    if message.FileID != nil && !belongsToProject(h.db, &models.File{}, *message.FileID, projectID) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "File does not belong to this project"})
+        return
+    }
+    if message.TaskID != nil && !belongsToProject(h.db, &models.Task{}, *message.TaskID, projectID) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Task does not belong to this project"})
+        return
+    }
+
+    if err := h.db.Omit(clause.Associations).Create(&message).Error; err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
         return
     }
@@ -106,6 +109,7 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
     wsMessage := map[string]interface{}{
         "type":       "chat_message",
         "project_id": projectID,
+        "user_id":    c.GetUint("userID"),
         "data":       message,
     }
     if msgBytes, err := json.Marshal(wsMessage); err == nil {
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errVersionConflict rolls back an update whose row changed after the
+// If-Match check.
+var errVersionConflict = errors.New("version conflict")
+
+// entityTag formats the version of a resource as a strong ETag. Files add
+// their edit revision, since collaborative edits change the content
+// without a new version.
+func entityTag(version int64, revision ...int64) string {
+	if len(revision) > 0 {
+		return fmt.Sprintf(`"%d.%d"`, version, revision[0])
+	}
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// respondWithETag writes current with its ETag, or 304 if the client sent
+// the same tag in If-None-Match.
+func respondWithETag(c *gin.Context, tag string, current interface{}) {
+	c.Header("ETag", tag)
+	if matchesTag(c.GetHeader("If-None-Match"), tag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, current)
+}
+
+// checkIfMatch requires an If-Match header matching tag before a resource
+// is changed. Otherwise it responds 428, or 412 with the current
+// representation so the client can merge, and returns false.
+func checkIfMatch(c *gin.Context, tag string, current interface{}) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header is required", "code": "precondition_required"})
+		return false
+	}
+	if header == "*" || matchesTag(header, tag) {
+		return true
+	}
+	preconditionFailed(c, tag, current)
+	return false
+}
+
+// preconditionFailed responds 412 with the current representation. It is
+// also used when the row changed between the If-Match check and the update.
+func preconditionFailed(c *gin.Context, tag string, current interface{}) {
+	c.Header("ETag", tag)
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":   "The resource was modified by someone else",
+		"code":    "version_mismatch",
+		"current": current,
+	})
+}
+
+// matchesTag reports whether a comma-separated If-Match or If-None-Match
+// list contains tag. Weak tags never match, as If-Match needs a strong
+// comparison.
+func matchesTag(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimSpace(candidate) == tag {
+			return true
+		}
+	}
+	return false
+}
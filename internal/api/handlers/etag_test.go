@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"devsync-be/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEntityTag(t *testing.T) {
+	if got, want := entityTag(3), `"3"`; got != want {
+		t.Errorf("entityTag(3) = %s, want %s", got, want)
+	}
+	if got, want := entityTag(3, 12), `"3.12"`; got != want {
+		t.Errorf("entityTag(3, 12) = %s, want %s", got, want)
+	}
+}
+
+func TestMatchesTag(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"3.12"`, true},
+		{`"1.0", "3.12"`, true},
+		{`"1.0","3.12"`, true},
+		{`"3.11"`, false},
+		{`"3"`, false},
+		{`3.12`, false},
+		// If-Match needs a strong comparison
+		{`W/"3.12"`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := matchesTag(tt.header, `"3.12"`); got != tt.want {
+			t.Errorf("matchesTag(%s) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	current := map[string]interface{}{"id": 5, "version": 3}
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+		status int
+		code   string
+	}{
+		{"missing", "", false, http.StatusPreconditionRequired, "precondition_required"},
+		{"match", `"3.12"`, true, http.StatusOK, ""},
+		{"any", `*`, true, http.StatusOK, ""},
+		{"in list", `"2.0", "3.12"`, true, http.StatusOK, ""},
+		{"stale", `"3.11"`, false, http.StatusPreconditionFailed, "version_mismatch"},
+		{"weak", `W/"3.12"`, false, http.StatusPreconditionFailed, "version_mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := newTestContext(nil)
+			c.Request = httptest.NewRequest(http.MethodPut, "/files/5", nil)
+			if tt.header != "" {
+				c.Request.Header.Set("If-Match", tt.header)
+			}
+
+			if got := checkIfMatch(c, `"3.12"`, current); got != tt.ok {
+				t.Fatalf("checkIfMatch() = %v, want %v", got, tt.ok)
+			}
+			if tt.ok {
+				if w.Body.Len() != 0 {
+					t.Errorf("checkIfMatch() responded %s", w.Body)
+				}
+				return
+			}
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			var body struct {
+				Code    string                 `json:"code"`
+				Current map[string]interface{} `json:"current"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Code, tt.code)
+			}
+			// A 412 carries what the client has to merge with
+			if tt.status == http.StatusPreconditionFailed {
+				if got := w.Header().Get("ETag"); got != `"3.12"` {
+					t.Errorf("ETag = %s, want \"3.12\"", got)
+				}
+				if body.Current["version"] != float64(3) {
+					t.Errorf("current = %v, want the current resource", body.Current)
+				}
+			}
+		})
+	}
+}
+
+func TestRespondWithETag(t *testing.T) {
+	tests := []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusOK},
+		{`"3.12"`, http.StatusNotModified},
+		{`"3.11"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		c, w := newTestContext(nil)
+		c.Request = httptest.NewRequest(http.MethodGet, "/files/5", nil)
+		if tt.header != "" {
+			c.Request.Header.Set("If-None-Match", tt.header)
+		}
+		respondWithETag(c, `"3.12"`, map[string]int{"version": 3})
+		c.Writer.WriteHeaderNow()
+
+		if w.Code != tt.status {
+			t.Errorf("If-None-Match %s: status = %d, want %d", tt.header, w.Code, tt.status)
+		}
+		if got := w.Header().Get("ETag"); got != `"3.12"` {
+			t.Errorf("If-None-Match %s: ETag = %s, want \"3.12\"", tt.header, got)
+		}
+	}
+}
+
+// TestDeleteProjectChanged checks the conflict after the If-Match check:
+// the row changed before the conditional delete, which then matches no row
+// and responds 412 with the project as it is now.
+func TestDeleteProjectChanged(t *testing.T) {
+	db, mock := newMockDB(t)
+	h := &ProjectHandler{db: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(exact(`UPDATE "projects" SET "deleted_at"=$1 WHERE version = $2 AND "projects"."id" = $3`)).
+		WithArgs(sqlmock.AnyArg(), 3, 4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(exact(`SELECT * FROM "projects" WHERE "projects"."id" = $1`)).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version"}).AddRow(4, "Renamed", 4))
+
+	c, w := newTestContext(map[string]interface{}{"project": &models.Project{ID: 4, Version: 3}})
+	c.Request = httptest.NewRequest(http.MethodDelete, "/projects/4", nil)
+	c.Request.Header.Set("If-Match", `"3"`)
+	h.DeleteProject(c)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("DeleteProject() = %d %s, want 412", w.Code, w.Body)
+	}
+	if got := w.Header().Get("ETag"); got != `"4"` {
+		t.Errorf("ETag = %s, want the current version \"4\"", got)
+	}
+}
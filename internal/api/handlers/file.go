@@ -2,9 +2,16 @@ package handlers
 
 import (
     "encoding/json"
+    "errors"
     "net/http"
+    "path"
     "strconv"
+    "time"
 
+    "devsync-be/internal/collab"
+    "devsync-be/internal/filetree"
+    "devsync-be/internal/gitstore"
+    "devsync-be/internal/history"
     "devsync-be/internal/models"
     "devsync-be/internal/websocket"
 
@@ -12,15 +19,35 @@ import (
     "gorm.io/gorm"
 )
 
+// CreateFileRequest is a new text file. Storage fields such as file_url
+// are only set by uploads.
+type CreateFileRequest struct {
+    Path     string `json:"path"`
+    Name     string `json:"name"`
+    Content  string `json:"content"`
+    FileType string `json:"file_type"`
+}
+
+// UpdateFileRequest holds the editable fields of a file; omitted fields
+// are left as they are. message is saved with the file revision for new
+// content.
+type UpdateFileRequest struct {
+    Content  *string `json:"content"`
+    FileType *string `json:"file_type"`
+    Message  string  `json:"message"`
+}
+
 type FileHandler struct {
-    db  *gorm.DB
-    hub *websocket.Hub
+    db    *gorm.DB
+    hub   *websocket.Hub
+    repos *gitstore.Store
 }
 
-func NewFileHandler(db *gorm.DB, hub *websocket.Hub) *FileHandler {
+func NewFileHandler(db *gorm.DB, hub *websocket.Hub, repos *gitstore.Store) *FileHandler {
     return &FileHandler{
-        db:  db,
-        hub: hub,
+        db:    db,
+        hub:   hub,
+        repos: repos,
     }
 }
 
@@ -32,11 +59,7 @@ func NewFileHandler(db *gorm.DB, hub *websocket.Hub) *FileHandler {
 // @Success 200 {array} models.File
 // @Router /projects/{id}/files [get]
 func (h *FileHandler) GetFiles(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     var files []models.File
     if err := h.db.Where("project_id = ?", projectID).Find(&files).Error; err != nil {
@@ -48,52 +71,66 @@ func (h *FileHandler) GetFiles(c *gin.Context) {
 }
 
 // @Summary Create file
-// @Description Create a new file in project
+// @Description Create a new file in project. path is the full path of the file; missing parent folders are created.
 // @Tags files
 // @Security BearerAuth
 // @Param id path int true "Project ID"
-// @Param file body models.File true "File data"
+// @Param file body CreateFileRequest true "File data"
 // @Success 201 {object} models.File
 // @Router /projects/{id}/files [post]
 func (h *FileHandler) CreateFile(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
-    var file models.File
-    if err := c.ShouldBindJSON(&file); err != nil {
+    var req CreateFileRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
     // Validate required fields
-    if file.Name == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "File name is required"})
+    if req.Path == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "File path is required"})
         return
     }
-    if file.Path == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "File path is required"})
+
+    // Older clients send the folder as path and the file name separately
+    if req.Name != "" && path.Base(req.Path) != req.Name {
+        req.Path = req.Path + "/" + req.Name
+    }
+    cleanPath, err := filetree.Clean(req.Path)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_path"})
         return
     }
 
-    // Set project ID and uploaded_by from context
-    file.ProjectID = uint(projectID)
-    
+    file := models.File{
+        Path:      cleanPath,
+        Content:   req.Content,
+        FileType:  req.FileType,
+        ProjectID: projectID,
+        Version:   1,
+    }
+
     // Get user ID from JWT token context
     if userID, exists := c.Get("userID"); exists {
         file.UploadedBy = userID.(uint)
     }
 
-    // Verify project exists
-    var project models.Project
-    if err := h.db.First(&project, projectID).Error; err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+    // The initial content is the file's first revision
+    err = h.db.Transaction(func(tx *gorm.DB) error {
+        if err := filetree.Place(tx, &file); err != nil {
+            return err
+        }
+        if err := tx.Create(&file).Error; err != nil {
+            return err
+        }
+        _, err := history.Record(tx, file.ID, file.UploadedBy, file.Content, "")
+        return err
+    })
+    if respondTreeError(c, err) {
         return
     }
-
-    if err := h.db.Create(&file).Error; err != nil {
+    if err != nil {
         // Log the actual error for debugging
         c.JSON(http.StatusInternalServerError, gin.H{
             "error": "Failed to create file",
@@ -101,6 +138,7 @@ func (h *FileHandler) CreateFile(c *gin.Context) {
         })
         return
     }
+    h.repos.Record(projectID, file.UploadedBy, "Create "+file.Path)
 
     // Broadcast file creation to WebSocket clients
     message := map[string]interface{}{
@@ -116,7 +154,7 @@ func (h *FileHandler) CreateFile(c *gin.Context) {
 }
 
 // @Summary Get file
-// @Description Get file by ID
+// @Description Get file by ID. The ETag header is needed to update or delete it.
 // @Tags files
 // @Security BearerAuth
 // @Param id path int true "Project ID"
@@ -124,36 +162,35 @@ func (h *FileHandler) CreateFile(c *gin.Context) {
 // @Success 200 {object} models.File
 // @Router /projects/{id}/files/{fileId} [get]
 func (h *FileHandler) GetFile(c *gin.Context) {
+    projectID := c.GetUint("projectID")
+
     fileID, err := strconv.Atoi(c.Param("fileId"))
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
         return
     }
 
-    var file models.File
-    if err := h.db.First(&file, fileID).Error; err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+    file, revision, ok := h.loadFile(c, projectID, uint(fileID))
+    if !ok {
         return
     }
 
-    c.JSON(http.StatusOK, file)
+    respondWithETag(c, entityTag(file.Version, revision), file)
 }
 
 // @Summary Update file
-// @Description Update file content
+// @Description Update file content and metadata. Requires If-Match with the file's ETag; responds 412 with the current file if it changed.
 // @Tags files
 // @Security BearerAuth
 // @Param id path int true "Project ID"
 // @Param fileId path int true "File ID"
-// @Param file body models.File true "File data"
+// @Param If-Match header string true "ETag of the file"
+// @Param file body UpdateFileRequest true "File data"
 // @Success 200 {object} models.File
+// @Failure 412 {object} map[string]interface{}
 // @Router /projects/{id}/files/{fileId} [put]
 func (h *FileHandler) UpdateFile(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     fileID, err := strconv.Atoi(c.Param("fileId"))
     if err != nil {
@@ -161,49 +198,42 @@ func (h *FileHandler) UpdateFile(c *gin.Context) {
         return
     }
 
-    var file models.File
-    if err := h.db.First(&file, fileID).Error; err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+    file, revision, ok := h.loadFile(c, projectID, uint(fileID))
+    if !ok {
         return
     }
-
-    if err := c.ShouldBindJSON(&file); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    if !checkIfMatch(c, entityTag(file.Version, revision), file) {
         return
     }
 
-    if err := h.db.Save(&file).Error; err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file"})
+    // Paths change through MoveFile; storage fields only through uploads
+    var req UpdateFileRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
-
-    // Broadcast file update to WebSocket clients
-    message := map[string]interface{}{
-        "type":       "file_updated",
-        "project_id": projectID,
-        "data":       file,
+    if req.Content != nil {
+        file.Content = *req.Content
     }
-    if msgBytes, err := json.Marshal(message); err == nil {
-        h.hub.Broadcast(msgBytes)
+    if req.FileType != nil {
+        file.FileType = *req.FileType
     }
 
-    c.JSON(http.StatusOK, file)
+    h.saveFile(c, file, file.Version, revision, req.Message)
 }
 
 // @Summary Delete file
-// @Description Delete file by ID
+// @Description Delete file by ID. Requires If-Match with the file's ETag.
 // @Tags files
 // @Security BearerAuth
 // @Param id path int true "Project ID"
 // @Param fileId path int true "File ID"
+// @Param If-Match header string true "ETag of the file"
 // @Success 204
+// @Failure 412 {object} map[string]interface{}
 // @Router /projects/{id}/files/{fileId} [delete]
 func (h *FileHandler) DeleteFile(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     fileID, err := strconv.Atoi(c.Param("fileId"))
     if err != nil {
@@ -211,20 +241,203 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
         return
     }
 
-    if err := h.db.Delete(&models.File{}, fileID).Error; err != nil {
+    file, revision, ok := h.loadFile(c, projectID, uint(fileID))
+    if !ok {
+        return
+    }
+    if !checkIfMatch(c, entityTag(file.Version, revision), file) {
+        return
+    }
+
+    // The edit state stays locked until commit, so edits wait and then
+    // fail instead of being applied to a deleted file
+    err = h.db.Transaction(func(tx *gorm.DB) error {
+        current, err := collab.LockRevision(tx, projectID, file.ID)
+        if err != nil {
+            return err
+        }
+        if current != revision {
+            return errVersionConflict
+        }
+        result := tx.Where("project_id = ? AND version = ?", projectID, file.Version).Delete(&models.File{}, file.ID)
+        if result.Error != nil {
+            return result.Error
+        }
+        if result.RowsAffected == 0 {
+            return errVersionConflict
+        }
+        return collab.Forget(tx, file.ID)
+    })
+    if errors.Is(err, errVersionConflict) || errors.Is(err, collab.ErrFileNotFound) {
+        if current, currentRevision, ok := h.loadFile(c, projectID, file.ID); ok {
+            preconditionFailed(c, entityTag(current.Version, currentRevision), current)
+        }
+        return
+    }
+    if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
         return
     }
+    h.hub.FileDeleted(projectID, file.ID)
+    h.repos.Record(projectID, c.GetUint("userID"), "Delete "+file.Path)
 
     // Broadcast file deletion to WebSocket clients
     message := map[string]interface{}{
         "type":       "file_deleted",
         "project_id": projectID,
-        "data":       map[string]interface{}{"id": fileID},
+        "data":       map[string]interface{}{"id": fileID, "version": file.Version},
     }
     if msgBytes, err := json.Marshal(message); err == nil {
         h.hub.Broadcast(msgBytes)
     }
 
     c.Status(http.StatusNoContent)
-}
\ No newline at end of file
+}
+
+// @Summary Move file
+// @Description Move or rename a file. Send the new full path, or only a new name to rename it in place. Requires If-Match with the file's ETag.
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param fileId path int true "File ID"
+// @Param If-Match header string true "ETag of the file"
+// @Param request body MoveRequest true "New path or name"
+// @Success 200 {object} models.File
+// @Failure 409 {object} map[string]interface{}
+// @Failure 412 {object} map[string]interface{}
+// @Router /projects/{id}/files/{fileId}/move [post]
+func (h *FileHandler) MoveFile(c *gin.Context) {
+    projectID := c.GetUint("projectID")
+
+    fileID, err := strconv.Atoi(c.Param("fileId"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+        return
+    }
+
+    file, revision, ok := h.loadFile(c, projectID, uint(fileID))
+    if !ok {
+        return
+    }
+    if !checkIfMatch(c, entityTag(file.Version, revision), file) {
+        return
+    }
+
+    var req MoveRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    target, ok := req.target(c, file.Dir)
+    if !ok {
+        return
+    }
+
+    oldPath := file.Path
+    err = h.db.Transaction(func(tx *gorm.DB) error {
+        return filetree.MoveFile(tx, file, c.GetUint("userID"), target)
+    })
+    if errors.Is(err, filetree.ErrVersionMismatch) {
+        if current, currentRevision, ok := h.loadFile(c, projectID, file.ID); ok {
+            preconditionFailed(c, entityTag(current.Version, currentRevision), current)
+        }
+        return
+    }
+    if respondTreeError(c, err) {
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move file"})
+        return
+    }
+    h.repos.Record(projectID, c.GetUint("userID"), "Move "+oldPath+" to "+file.Path)
+
+    // Broadcast the move to WebSocket clients
+    message := map[string]interface{}{
+        "type":       "file_moved",
+        "project_id": projectID,
+        "data": map[string]interface{}{
+            "id":       file.ID,
+            "old_path": oldPath,
+            "path":     file.Path,
+            "name":     file.Name,
+            "dir":      file.Dir,
+            "version":  file.Version,
+        },
+    }
+    if msgBytes, err := json.Marshal(message); err == nil {
+        h.hub.Broadcast(msgBytes)
+    }
+
+    c.Header("ETag", entityTag(file.Version, revision))
+    c.JSON(http.StatusOK, file)
+}
+
+// loadFile fetches a file of the project with its current content, which
+// includes edits from editing sessions not yet saved to the row. It
+// responds with an error and returns false if that fails.
+func (h *FileHandler) loadFile(c *gin.Context, projectID, fileID uint) (*models.File, int64, bool) {
+    var file models.File
+    if err := h.db.Where("project_id = ?", projectID).First(&file, fileID).Error; err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+        return nil, 0, false
+    }
+
+    content, revision, err := h.hub.FileContent(projectID, file.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch file"})
+        return nil, 0, false
+    }
+    file.Content = content
+    return &file, revision, true
+}
+
+// saveFile writes file, whose version and edit revision were checked
+// against If-Match, and responds with it. Content changes go through the
+// editing engine so open editors get them as an edit instead of being
+// overwritten; the rest of the row is saved in the same transaction.
+func (h *FileHandler) saveFile(c *gin.Context, file *models.File, version, revision int64, message string) {
+    if len(collab.Encode(file.Content)) > collab.MaxDocumentLength {
+        c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File content is too large", "code": "document_too_large"})
+        return
+    }
+
+    file.Version = version + 1
+    revision, err := h.hub.UpdateFile(file.ProjectID, file.ID, c.GetUint("userID"), file.Content, revision, message, func(tx *gorm.DB) error {
+        // Only the editable columns; the row's storage fields are never
+        // taken from a request
+        file.UpdatedAt = time.Now()
+        result := tx.Model(file).Where("version = ?", version).Updates(map[string]interface{}{
+            "file_type":  file.FileType,
+            "version":    file.Version,
+            "updated_at": file.UpdatedAt,
+        })
+        if result.Error == nil && result.RowsAffected == 0 {
+            return errVersionConflict
+        }
+        return result.Error
+    })
+    if errors.Is(err, errVersionConflict) || errors.Is(err, collab.ErrRevisionUnavailable) {
+        if current, currentRevision, ok := h.loadFile(c, file.ProjectID, file.ID); ok {
+            preconditionFailed(c, entityTag(current.Version, currentRevision), current)
+        }
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file"})
+        return
+    }
+
+    // Broadcast file update to WebSocket clients
+    broadcast := map[string]interface{}{
+        "type":       "file_updated",
+        "project_id": file.ProjectID,
+        "data":       file,
+    }
+    if msgBytes, err := json.Marshal(broadcast); err == nil {
+        h.hub.Broadcast(msgBytes)
+    }
+
+    c.Header("ETag", entityTag(file.Version, revision))
+    c.JSON(http.StatusOK, file)
+}
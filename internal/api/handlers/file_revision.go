@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"devsync-be/internal/history"
+	"devsync-be/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileRevisionResponse is a file revision together with its content
+type FileRevisionResponse struct {
+	models.FileRevision
+	Content string `json:"content"`
+}
+
+// FileDiffResponse is the unified diff between two revisions of a file
+type FileDiffResponse struct {
+	From int64  `json:"from"`
+	To   int64  `json:"to"`
+	Diff string `json:"diff"`
+}
+
+// @Summary Get file revisions
+// @Description List the saved revisions of a file, newest first
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param fileId path int true "File ID"
+// @Param limit query int false "Limit results (default: 50, max: 200)"
+// @Param offset query int false "Offset for pagination (default: 0)"
+// @Success 200 {array} models.FileRevision
+// @Router /projects/{id}/files/{fileId}/revisions [get]
+func (h *FileHandler) GetRevisions(c *gin.Context) {
+	fileID, _ := strconv.Atoi(c.Param("fileId"))
+
+	limit, offset := 50, 0
+	if parsedLimit := parseLimit(c.Query("limit")); parsedLimit > 0 && parsedLimit <= 200 {
+		limit = parsedLimit
+	}
+	if parsedOffset := parseLimit(c.Query("offset")); parsedOffset > 0 {
+		offset = parsedOffset
+	}
+
+	var revisions []models.FileRevision
+	if err := h.db.Preload("User").
+		Where("file_id = ?", fileID).
+		Order("number DESC").
+		Limit(limit).Offset(offset).
+		Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions)
+}
+
+// @Summary Get file revision
+// @Description Get one revision of a file with its content
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param fileId path int true "File ID"
+// @Param number path int true "Revision number"
+// @Success 200 {object} FileRevisionResponse
+// @Router /projects/{id}/files/{fileId}/revisions/{number} [get]
+func (h *FileHandler) GetRevision(c *gin.Context) {
+	revision, content, ok := h.loadRevision(c, c.Param("number"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, FileRevisionResponse{FileRevision: *revision, Content: content})
+}
+
+// @Summary Diff file revisions
+// @Description Get a unified diff between two revisions of a file. to defaults to the latest revision.
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param fileId path int true "File ID"
+// @Param from query int true "Old revision number"
+// @Param to query int false "New revision number"
+// @Success 200 {object} FileDiffResponse
+// @Router /projects/{id}/files/{fileId}/diff [get]
+func (h *FileHandler) DiffRevisions(c *gin.Context) {
+	to := c.Query("to")
+	if to == "" {
+		var latest models.FileRevision
+		if err := h.db.Where("file_id = ?", c.Param("fileId")).Order("number DESC").Limit(1).Find(&latest).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
+			return
+		}
+		to = strconv.FormatInt(latest.Number, 10)
+	}
+
+	from, oldContent, ok := h.loadRevision(c, c.Query("from"))
+	if !ok {
+		return
+	}
+	target, newContent, ok := h.loadRevision(c, to)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, FileDiffResponse{
+		From: from.Number,
+		To:   target.Number,
+		Diff: history.Unified(fmt.Sprintf("a/%d", from.Number), fmt.Sprintf("b/%d", target.Number), oldContent, newContent),
+	})
+}
+
+// @Summary Restore file revision
+// @Description Set the file content back to an old revision. This saves a new revision and keeps the history. Requires If-Match with the file's ETag.
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param fileId path int true "File ID"
+// @Param number path int true "Revision number"
+// @Param If-Match header string true "ETag of the file"
+// @Success 200 {object} models.File
+// @Failure 412 {object} map[string]interface{}
+// @Router /projects/{id}/files/{fileId}/revisions/{number}/restore [post]
+func (h *FileHandler) RestoreRevision(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+	fileID, _ := strconv.Atoi(c.Param("fileId"))
+
+	file, revision, ok := h.loadFile(c, projectID, uint(fileID))
+	if !ok {
+		return
+	}
+	if !checkIfMatch(c, entityTag(file.Version, revision), file) {
+		return
+	}
+
+	old, content, ok := h.loadRevision(c, c.Param("number"))
+	if !ok {
+		return
+	}
+
+	file.Content = content
+	h.saveFile(c, file, file.Version, revision, fmt.Sprintf("Restore revision %d", old.Number))
+}
+
+// loadRevision fetches a revision of the :fileId file by number and its
+// content. It responds with an error and returns false if that fails.
+func (h *FileHandler) loadRevision(c *gin.Context, number string) (*models.FileRevision, string, bool) {
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+		return nil, "", false
+	}
+
+	var revision models.FileRevision
+	if err := h.db.Preload("User").Where("file_id = ? AND number = ?", c.Param("fileId"), n).First(&revision).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return nil, "", false
+	}
+
+	content, err := history.Content(h.db, &revision)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revision content"})
+		return nil, "", false
+	}
+	return &revision, content, true
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"devsync-be/internal/filetree"
+	"devsync-be/internal/gitstore"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type GitHandler struct {
+	db    *gorm.DB
+	repos *gitstore.Store
+}
+
+func NewGitHandler(db *gorm.DB, repos *gitstore.Store) *GitHandler {
+	return &GitHandler{db: db, repos: repos}
+}
+
+// GitDiffResponse is a unified diff between two commits
+type GitDiffResponse struct {
+	From  string              `json:"from"`
+	To    string              `json:"to"`
+	Files []gitstore.FileStat `json:"files"`
+	Diff  string              `json:"diff"`
+}
+
+// @Summary Get commit log
+// @Description List the commits of the project repository, newest first. Every change to the text files is a commit.
+// @Tags git
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param path query string false "Only commits that changed this file or folder"
+// @Param limit query int false "Limit results (default: 50, max: 200)"
+// @Param offset query int false "Offset for pagination (default: 0)"
+// @Success 200 {array} gitstore.Commit
+// @Router /projects/{id}/git/commits [get]
+func (h *GitHandler) GetLog(c *gin.Context) {
+	p, ok := queryPath(c)
+	if !ok {
+		return
+	}
+
+	limit, offset := 50, 0
+	if parsedLimit := parseLimit(c.Query("limit")); parsedLimit > 0 && parsedLimit <= 200 {
+		limit = parsedLimit
+	}
+	if parsedOffset := parseLimit(c.Query("offset")); parsedOffset > 0 {
+		offset = parsedOffset
+	}
+
+	commits, err := h.repos.Log(c.GetUint("projectID"), p, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch commits"})
+		return
+	}
+	c.JSON(http.StatusOK, commits)
+}
+
+// @Summary Get commit
+// @Description Get a commit with the files it changed. sha may be a full or short SHA, or a revision such as main~1.
+// @Tags git
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param sha path string true "Commit"
+// @Success 200 {object} gitstore.CommitDetail
+// @Router /projects/{id}/git/commits/{sha} [get]
+func (h *GitHandler) GetCommit(c *gin.Context) {
+	commit, err := h.repos.Show(c.GetUint("projectID"), c.Param("sha"))
+	if h.respondError(c, err, "Failed to fetch commit") {
+		return
+	}
+	c.JSON(http.StatusOK, commit)
+}
+
+// @Summary Show file or folder at commit
+// @Description Get a file with its content, or the entries of a folder, as it was at a commit
+// @Tags git
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param sha path string true "Commit"
+// @Param path query string false "File or folder path (default: project root)"
+// @Success 200 {object} gitstore.Object
+// @Router /projects/{id}/git/commits/{sha}/tree [get]
+func (h *GitHandler) GetTreeAtCommit(c *gin.Context) {
+	p, ok := queryPath(c)
+	if !ok {
+		return
+	}
+
+	object, err := h.repos.Read(c.GetUint("projectID"), c.Param("sha"), p)
+	if h.respondError(c, err, "Failed to read commit") {
+		return
+	}
+	c.JSON(http.StatusOK, object)
+}
+
+// @Summary Diff commits
+// @Description Get a unified diff between two commits. from defaults to the parent of to, to defaults to the latest commit.
+// @Tags git
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param from query string false "Old commit"
+// @Param to query string false "New commit"
+// @Param path query string false "Only compare this file or folder"
+// @Success 200 {object} GitDiffResponse
+// @Router /projects/{id}/git/diff [get]
+func (h *GitHandler) Diff(c *gin.Context) {
+	p, ok := queryPath(c)
+	if !ok {
+		return
+	}
+
+	patch, files, err := h.repos.Diff(c.GetUint("projectID"), c.Query("from"), c.Query("to"), p)
+	if h.respondError(c, err, "Failed to diff commits") {
+		return
+	}
+	c.JSON(http.StatusOK, GitDiffResponse{From: c.Query("from"), To: c.Query("to"), Files: files, Diff: patch})
+}
+
+// @Summary Git reference advertisement
+// @Description Smart HTTP endpoint used by git clone and git fetch. Authenticate with HTTP basic auth, any username and a personal access token with files:read as the password.
+// @Tags git
+// @Param id path string true "Project ID, optionally with a .git suffix"
+// @Param service query string true "git-upload-pack"
+// @Router /git/projects/{id}/info/refs [get]
+func (h *GitHandler) InfoRefs(c *gin.Context) {
+	if c.Query("service") != gitstore.UploadPackService {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only cloning and fetching are supported"})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-"+gitstore.UploadPackService+"-advertisement")
+	c.Header("Cache-Control", "no-cache")
+	if err := h.repos.AdvertiseRefs(c.Request.Context(), c.GetUint("projectID"), c.Writer); err != nil {
+		log.Printf("Git: failed to advertise refs of project %d: %v", c.GetUint("projectID"), err)
+		if !c.Writer.Written() {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read repository"})
+		}
+	}
+}
+
+// @Summary Git upload-pack
+// @Description Smart HTTP endpoint that sends the objects a git client asks for
+// @Tags git
+// @Param id path string true "Project ID, optionally with a .git suffix"
+// @Router /git/projects/{id}/git-upload-pack [post]
+func (h *GitHandler) UploadPack(c *gin.Context) {
+	body := io.Reader(c.Request.Body)
+	if c.GetHeader("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gzip body"})
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	c.Header("Content-Type", "application/x-"+gitstore.UploadPackService+"-result")
+	c.Header("Cache-Control", "no-cache")
+	if err := h.repos.UploadPack(c.Request.Context(), c.GetUint("projectID"), body, c.Writer); err != nil {
+		log.Printf("Git: upload-pack for project %d failed: %v", c.GetUint("projectID"), err)
+		if !c.Writer.Written() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload-pack request"})
+		}
+	}
+}
+
+// queryPath returns the clean ?path query parameter, "" for the project
+// root. It responds 400 and returns false if the path is invalid.
+func queryPath(c *gin.Context) (string, bool) {
+	p := c.Query("path")
+	if strings.Trim(p, "/") == "" {
+		return "", true
+	}
+	cleaned, err := filetree.Clean(p)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_path"})
+		return "", false
+	}
+	return cleaned, true
+}
+
+// respondError responds to a failed repository read and reports whether
+// there was an error.
+func (h *GitHandler) respondError(c *gin.Context, err error, message string) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, gitstore.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Commit or path not found"})
+		return true
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	return true
+}
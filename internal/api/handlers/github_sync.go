@@ -0,0 +1,623 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"devsync-be/internal/collab"
+	"devsync-be/internal/filetree"
+	"devsync-be/internal/githubsync"
+	"devsync-be/internal/gitstore"
+	"devsync-be/internal/history"
+	"devsync-be/internal/models"
+	"devsync-be/internal/secrets"
+	"devsync-be/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type GitHubSyncHandler struct {
+	db     *gorm.DB
+	hub    *websocket.Hub
+	repos  *gitstore.Store
+	tokens *secrets.TokenStore
+	apiURL string
+}
+
+func NewGitHubSyncHandler(db *gorm.DB, hub *websocket.Hub, repos *gitstore.Store, tokens *secrets.TokenStore, apiURL string) *GitHubSyncHandler {
+	return &GitHubSyncHandler{db: db, hub: hub, repos: repos, tokens: tokens, apiURL: apiURL}
+}
+
+// GitHubSyncStatus is the sync state of a project with the files changed
+// locally since the last sync
+type GitHubSyncStatus struct {
+	models.GitHubSync
+	LocalChanges []githubsync.Change `json:"local_changes"`
+}
+
+// GitHubPullRequest pulls a branch of the linked repository
+type GitHubPullRequest struct {
+	Branch     string `json:"branch"`
+	OnConflict string `json:"on_conflict"`
+}
+
+// GitHubPullResponse lists what a pull changed and what it left alone
+type GitHubPullResponse struct {
+	Branch    string                `json:"branch"`
+	Commit    string                `json:"commit"`
+	Created   []string              `json:"created"`
+	Updated   []string              `json:"updated"`
+	Deleted   []string              `json:"deleted"`
+	Conflicts []githubsync.Conflict `json:"conflicts"`
+	Skipped   []githubsync.Conflict `json:"skipped"`
+}
+
+// GitHubPushRequest pushes the files changed since the last sync
+type GitHubPushRequest struct {
+	Message string `json:"message"`
+	Mode    string `json:"mode"`
+	Branch  string `json:"branch"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+}
+
+// GitHubPushResponse is the commit a push made on GitHub
+type GitHubPushResponse struct {
+	Branch      string                  `json:"branch"`
+	Commit      string                  `json:"commit"`
+	Files       []githubsync.Change     `json:"files"`
+	PullRequest *githubsync.PullRequest `json:"pull_request,omitempty"`
+}
+
+// localFile is a text file of the project with its current content
+type localFile struct {
+	file     models.File
+	content  string
+	revision int64
+}
+
+// syncContext is what every sync operation loads first
+type syncContext struct {
+	projectID uint
+	userID    uint
+	repo      githubsync.Repo
+	client    *githubsync.Client
+	state     *models.GitHubSync
+	base      map[string]string
+}
+
+// @Summary Get GitHub sync status
+// @Description Get the branch and commit the project was last synced with and the files changed locally since then
+// @Tags github
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Success 200 {object} GitHubSyncStatus
+// @Router /projects/{id}/github/sync [get]
+func (h *GitHubSyncHandler) GetStatus(c *gin.Context) {
+	project := c.MustGet("project").(*models.Project)
+
+	repo, err := githubsync.ParseRepo(project.GitHubRepo)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_github_repo"})
+		return
+	}
+	state, base, err := h.loadState(project.ID, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sync state"})
+		return
+	}
+	local, err := h.localFiles(project.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read project files"})
+		return
+	}
+
+	changes := githubsync.Compare(base, localSHAs(local), base).Push
+	if changes == nil {
+		changes = []githubsync.Change{}
+	}
+	c.JSON(http.StatusOK, GitHubSyncStatus{GitHubSync: *state, LocalChanges: changes})
+}
+
+// @Summary Pull from GitHub
+// @Description Bring the project files up to date with a branch of the linked repository using the caller's GitHub token. Files changed on both sides since the last sync are reported in conflicts and left alone, unless on_conflict is theirs.
+// @Tags github
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param request body GitHubPullRequest false "Branch (default: the tracked or default branch) and on_conflict (skip or theirs)"
+// @Success 200 {object} GitHubPullResponse
+// @Router /projects/{id}/github/pull [post]
+func (h *GitHubSyncHandler) Pull(c *gin.Context) {
+	var req GitHubPullRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.OnConflict == "" {
+		req.OnConflict = "skip"
+	}
+	if req.OnConflict != "skip" && req.OnConflict != "theirs" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "on_conflict must be skip or theirs"})
+		return
+	}
+
+	sc, ok := h.prepare(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	branch, head, ok := h.branchHead(c, sc, req.Branch)
+	if !ok {
+		return
+	}
+	local, err := h.localFiles(sc.projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read project files"})
+		return
+	}
+	plan, err := sc.client.PlanPull(ctx, sc.repo, head, sc.base, localSHAs(local), req.OnConflict == "theirs")
+	if respondGitHubError(c, err) {
+		return
+	}
+
+	response := GitHubPullResponse{
+		Branch: branch, Commit: head,
+		Created: []string{}, Updated: []string{}, Deleted: []string{},
+		Conflicts: plan.Conflicts, Skipped: plan.Skipped,
+	}
+
+	message := fmt.Sprintf("Pull %s@%s from GitHub", sc.repo, shortSHA(head))
+
+	// Updates go through the editing engine so open editors receive them;
+	// new files and deletions are applied together below
+	synced := make(map[string]string)
+	var creates []*models.File
+	var deletes []*localFile
+	for _, change := range plan.Changes {
+		if change.Action == githubsync.ActionDelete {
+			deletes = append(deletes, local[change.Path])
+			continue
+		}
+
+		data := plan.Data[change.Path]
+		if !isText(data) {
+			response.Skipped = append(response.Skipped, githubsync.Conflict{Path: change.Path, Reason: "not_text", RemoteSHA: change.SHA})
+			continue
+		}
+
+		if change.Action == githubsync.ActionCreate {
+			creates = append(creates, &models.File{Path: change.Path, Content: string(data), ProjectID: sc.projectID, UploadedBy: sc.userID, Version: 1})
+			continue
+		}
+
+		current := local[change.Path]
+		_, err = h.hub.UpdateFile(sc.projectID, current.file.ID, sc.userID, string(data), current.revision, message, func(tx *gorm.DB) error {
+			result := tx.Model(&models.File{}).
+				Where("id = ? AND version = ?", current.file.ID, current.file.Version).
+				Update("version", gorm.Expr("version + 1"))
+			if result.Error == nil && result.RowsAffected == 0 {
+				return errVersionConflict
+			}
+			return result.Error
+		})
+		if errors.Is(err, errVersionConflict) || errors.Is(err, collab.ErrRevisionUnavailable) || errors.Is(err, collab.ErrFileNotFound) {
+			response.Conflicts = append(response.Conflicts, githubsync.Conflict{Path: change.Path, Reason: githubsync.ConflictChangedDuringRun, RemoteSHA: change.SHA})
+			continue
+		}
+		if errors.Is(err, collab.ErrDocumentTooLarge) {
+			response.Skipped = append(response.Skipped, githubsync.Conflict{Path: change.Path, Reason: "too_large", RemoteSHA: change.SHA})
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file " + change.Path})
+			return
+		}
+		synced[change.Path] = change.SHA
+		response.Updated = append(response.Updated, change.Path)
+	}
+
+	var deleted []models.File
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := filetree.Lock(tx, sc.projectID); err != nil {
+			return err
+		}
+
+		for _, file := range creates {
+			err := tx.Transaction(func(tx *gorm.DB) error {
+				if err := filetree.Place(tx, file); err != nil {
+					return err
+				}
+				if err := tx.Create(file).Error; err != nil {
+					return err
+				}
+				_, err := history.Record(tx, file.ID, sc.userID, file.Content, message)
+				return err
+			})
+			if errors.Is(err, filetree.ErrPathExists) || errors.Is(err, filetree.ErrParentNotFolder) {
+				response.Conflicts = append(response.Conflicts, githubsync.Conflict{Path: file.Path, Reason: githubsync.ConflictPathTaken, RemoteSHA: plan.Remote[file.Path]})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			synced[file.Path] = plan.Remote[file.Path]
+			response.Created = append(response.Created, file.Path)
+		}
+
+		// A file is only deleted if nobody edited it since it was compared;
+		// the edit state stays locked until commit so edits wait and then
+		// fail instead of being lost
+		for _, current := range deletes {
+			revision, err := collab.LockRevision(tx, sc.projectID, current.file.ID)
+			if err != nil && !errors.Is(err, collab.ErrFileNotFound) {
+				return err
+			}
+			changed := err != nil || revision != current.revision
+			if !changed {
+				result := tx.Where("version = ?", current.file.Version).Delete(&models.File{}, current.file.ID)
+				if result.Error != nil {
+					return result.Error
+				}
+				changed = result.RowsAffected == 0
+			}
+			if !changed {
+				if err := collab.Forget(tx, current.file.ID); err != nil {
+					return err
+				}
+			}
+			if changed {
+				response.Conflicts = append(response.Conflicts, githubsync.Conflict{Path: current.file.Path, Reason: githubsync.ConflictChangedDuringRun})
+				continue
+			}
+			synced[current.file.Path] = ""
+			response.Deleted = append(response.Deleted, current.file.Path)
+			deleted = append(deleted, current.file)
+		}
+
+		for _, same := range plan.Same {
+			synced[same.Path] = same.SHA
+		}
+		return h.saveState(tx, sc, branch, head, synced)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply pulled files"})
+		return
+	}
+
+	if len(response.Created)+len(response.Updated)+len(response.Deleted) > 0 {
+		h.repos.Record(sc.projectID, sc.userID, message)
+	}
+
+	// Same as deleting the file over REST, so open editors close it
+	for _, file := range deleted {
+		h.hub.FileDeleted(sc.projectID, file.ID)
+		event := map[string]interface{}{
+			"type":       "file_deleted",
+			"project_id": sc.projectID,
+			"data":       map[string]interface{}{"id": file.ID, "version": file.Version},
+		}
+		if msgBytes, err := json.Marshal(event); err == nil {
+			h.hub.Broadcast(msgBytes)
+		}
+	}
+	h.broadcast(sc.projectID, map[string]interface{}{
+		"direction": "pull",
+		"branch":    branch,
+		"commit":    head,
+		"created":   response.Created,
+		"updated":   response.Updated,
+		"deleted":   response.Deleted,
+	})
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Push to GitHub
+// @Description Push the files changed since the last sync to the linked repository using the caller's GitHub token. mode commit (default) commits onto the tracked branch and fails with 409 and the conflicting files if GitHub changed them too. mode pull_request commits onto a new branch from the last synced commit and opens a pull request.
+// @Tags github
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param request body GitHubPushRequest false "Commit message, mode and pull request details"
+// @Success 200 {object} GitHubPushResponse
+// @Failure 409 {object} map[string]interface{}
+// @Router /projects/{id}/github/push [post]
+func (h *GitHubSyncHandler) Push(c *gin.Context) {
+	var req GitHubPushRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Mode == "" {
+		req.Mode = githubsync.ModeCommit
+	}
+	if req.Mode != githubsync.ModeCommit && req.Mode != githubsync.ModePullRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be commit or pull_request"})
+		return
+	}
+	if req.Message == "" {
+		req.Message = "Update from DevSync"
+	}
+
+	sc, ok := h.prepare(c)
+	if !ok {
+		return
+	}
+	if sc.state.LastSyncedSHA == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Pull from GitHub before the first push", "code": "pull_required"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	branch, head, ok := h.branchHead(c, sc, "")
+	if !ok {
+		return
+	}
+
+	local, err := h.localFiles(sc.projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read project files"})
+		return
+	}
+	contents := make(map[string]string, len(local))
+	for p, file := range local {
+		contents[p] = file.content
+	}
+
+	opts := githubsync.PushOptions{
+		Mode:    req.Mode,
+		Message: req.Message,
+		Author:  h.author(sc.userID),
+		Branch:  req.Branch,
+		Title:   req.Title,
+		Body:    req.Body,
+	}
+	if opts.Branch == "" {
+		opts.Branch = fmt.Sprintf("devsync/project-%d-%d", sc.projectID, time.Now().Unix())
+	}
+	pushed, err := sc.client.Push(ctx, sc.repo, branch, head, sc.state.LastSyncedSHA, sc.base, contents, opts)
+	if errors.Is(err, githubsync.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "Files changed on GitHub since the last sync; pull or push a pull request",
+			"code":      "sync_conflict",
+			"conflicts": pushed.Conflicts,
+		})
+		return
+	}
+	if respondGitHubError(c, err) {
+		return
+	}
+
+	response := GitHubPushResponse{Branch: branch, Commit: pushed.Commit, Files: pushed.Files, PullRequest: pushed.PullRequest}
+	if pushed.Synced == nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		return h.saveState(tx, sc, branch, pushed.Commit, pushed.Synced)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Pushed to GitHub but failed to save the sync state"})
+		return
+	}
+
+	h.broadcast(sc.projectID, map[string]interface{}{
+		"direction": "push",
+		"branch":    branch,
+		"commit":    pushed.Commit,
+		"files":     pushed.Files,
+	})
+	c.JSON(http.StatusOK, response)
+}
+
+// prepare loads the linked repository, the caller's GitHub token and the
+// sync state. It responds with an error and returns false if that fails.
+func (h *GitHubSyncHandler) prepare(c *gin.Context) (*syncContext, bool) {
+	project := c.MustGet("project").(*models.Project)
+	userID := c.GetUint("userID")
+
+	if project.GitHubRepo == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The project has no linked GitHub repository", "code": "github_repo_not_set"})
+		return nil, false
+	}
+	repo, err := githubsync.ParseRepo(project.GitHubRepo)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_github_repo"})
+		return nil, false
+	}
+
+	token, err := h.tokens.GitHubToken(userID)
+	if errors.Is(err, secrets.ErrNoToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign in with GitHub to link a token with the repo scope", "code": "github_not_linked"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read GitHub token"})
+		return nil, false
+	}
+
+	state, base, err := h.loadState(project.ID, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sync state"})
+		return nil, false
+	}
+
+	return &syncContext{
+		projectID: project.ID,
+		userID:    userID,
+		repo:      repo,
+		client:    githubsync.NewClient(h.apiURL, token),
+		state:     state,
+		base:      base,
+	}, true
+}
+
+// branchHead resolves the branch to sync, defaulting to the tracked branch
+// and then the repository's default branch, and its head commit.
+func (h *GitHubSyncHandler) branchHead(c *gin.Context, sc *syncContext, branch string) (string, string, bool) {
+	ctx := c.Request.Context()
+	if branch == "" {
+		branch = sc.state.Branch
+	}
+	if branch == "" {
+		var err error
+		if branch, err = sc.client.DefaultBranch(ctx, sc.repo); respondGitHubError(c, err) {
+			return "", "", false
+		}
+	}
+
+	head, err := sc.client.BranchHead(ctx, sc.repo, branch)
+	if respondGitHubError(c, err) {
+		return "", "", false
+	}
+	if head == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found on GitHub", "code": "branch_not_found", "branch": branch})
+		return "", "", false
+	}
+	return branch, head, true
+}
+
+// loadState returns the sync state of a project and the blob SHA of every
+// path at the last sync. Linking another repository starts over.
+func (h *GitHubSyncHandler) loadState(projectID uint, repo githubsync.Repo) (*models.GitHubSync, map[string]string, error) {
+	base := make(map[string]string)
+
+	var state models.GitHubSync
+	if err := h.db.Where("project_id = ?", projectID).Limit(1).Find(&state).Error; err != nil {
+		return nil, nil, err
+	}
+	if state.ID == 0 || state.Repo != repo.String() {
+		return &models.GitHubSync{ID: state.ID, ProjectID: projectID, Repo: repo.String(), CreatedAt: state.CreatedAt}, base, nil
+	}
+
+	var files []models.GitHubSyncFile
+	if err := h.db.Where("project_id = ?", projectID).Find(&files).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, file := range files {
+		base[file.Path] = file.BlobSHA
+	}
+	return &state, base, nil
+}
+
+// saveState records a sync with commit. synced maps the paths that are now
+// the same on both sides to their blob SHA, "" for paths gone from both.
+func (h *GitHubSyncHandler) saveState(tx *gorm.DB, sc *syncContext, branch, commit string, synced map[string]string) error {
+	now := time.Now()
+	state := sc.state
+	if state.Repo != "" && state.LastSyncedSHA == "" && state.ID != 0 {
+		// The linked repository changed; the old base no longer applies
+		if err := tx.Where("project_id = ?", sc.projectID).Delete(&models.GitHubSyncFile{}).Error; err != nil {
+			return err
+		}
+	}
+	state.Branch, state.LastSyncedSHA, state.LastSyncedAt, state.LastSyncedBy = branch, commit, &now, &sc.userID
+	if err := tx.Save(state).Error; err != nil {
+		return err
+	}
+
+	for p, sha := range synced {
+		if err := tx.Where("project_id = ? AND path = ?", sc.projectID, p).Delete(&models.GitHubSyncFile{}).Error; err != nil {
+			return err
+		}
+		if sha == "" {
+			continue
+		}
+		if err := tx.Create(&models.GitHubSyncFile{ProjectID: sc.projectID, Path: p, BlobSHA: sha}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localFiles returns the text files of a project by path, with edits not
+// yet saved to File.Content.
+func (h *GitHubSyncHandler) localFiles(projectID uint) (map[string]*localFile, error) {
+	var files []models.File
+	if err := h.db.Where("project_id = ? AND COALESCE(file_url, '') = ''", projectID).Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	local := make(map[string]*localFile, len(files))
+	for _, file := range files {
+		content, revision, err := h.hub.FileContent(projectID, file.ID)
+		if err != nil {
+			return nil, err
+		}
+		local[file.Path] = &localFile{file: file, content: content, revision: revision}
+	}
+	return local, nil
+}
+
+func localSHAs(local map[string]*localFile) map[string]string {
+	shas := make(map[string]string, len(local))
+	for p, file := range local {
+		shas[p] = githubsync.BlobSHA([]byte(file.content))
+	}
+	return shas
+}
+
+// author returns the GitHub commit author for a DevSync user, or nil to
+// let GitHub use the token owner.
+func (h *GitHubSyncHandler) author(userID uint) *githubsync.Author {
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil || user.Email == "" {
+		return nil
+	}
+	name := user.Name
+	if name == "" {
+		name = user.Username
+	}
+	return &githubsync.Author{Name: name, Email: user.Email}
+}
+
+func (h *GitHubSyncHandler) broadcast(projectID uint, data interface{}) {
+	message := map[string]interface{}{
+		"type":       "github_synced",
+		"project_id": projectID,
+		"data":       data,
+	}
+	if msgBytes, err := json.Marshal(message); err == nil {
+		h.hub.Broadcast(msgBytes)
+	}
+}
+
+// respondGitHubError responds to a failed GitHub call and reports whether
+// there was an error.
+func respondGitHubError(c *gin.Context, err error) bool {
+	var apiErr *githubsync.APIError
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, githubsync.ErrUnauthorized):
+		c.JSON(http.StatusForbidden, gin.H{"error": "GitHub rejected your token; sign in with GitHub again", "code": "github_unauthorized"})
+	case errors.Is(err, githubsync.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found on GitHub or not accessible with your token", "code": "github_not_found"})
+	case errors.Is(err, githubsync.ErrNotFastForward):
+		c.JSON(http.StatusConflict, gin.H{"error": "The branch moved on GitHub during the push; try again", "code": "branch_moved"})
+	case errors.Is(err, githubsync.ErrBranchExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "branch_exists"})
+	case errors.Is(err, githubsync.ErrTreeTruncated):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "code": "repository_too_large"})
+	case errors.As(err, &apiErr):
+		c.JSON(http.StatusBadGateway, gin.H{"error": apiErr.Error(), "code": "github_error"})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach GitHub", "code": "github_error"})
+	}
+	return true
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -0,0 +1,624 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"devsync-be/internal/auth"
+	"devsync-be/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	oauthStateTTL      = 10 * time.Minute
+	oauthBindingCookie = "devsync_oauth_binding"
+	oauthCookiePath    = "/api/v1/auth/providers"
+)
+
+var (
+	errOAuthStateInvalid       = errors.New("invalid login state")
+	errOAuthStateExpired       = errors.New("login state expired")
+	errOAuthStateMismatch      = errors.New("login state mismatch")
+	errIdentityLinkedElsewhere = errors.New("identity is linked to another user")
+	errIdentityEmailTaken      = errors.New("email belongs to another account")
+	errIdentityNoEmail         = errors.New("provider returned no email address")
+)
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// @Summary List login providers
+// @Description External login providers enabled on this server
+// @Tags auth
+// @Success 200 {array} map[string]interface{}
+// @Router /auth/providers [get]
+func (h *AuthHandler) GetProviders(c *gin.Context) {
+	response := make([]gin.H, 0, len(h.providers))
+	for _, name := range h.providers.Names() {
+		response = append(response, gin.H{
+			"name":      name,
+			"login_url": "/api/v1/auth/providers/" + name + "/login",
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Login with provider
+// @Description Redirect to an external login provider using state, nonce and PKCE. After login the browser is sent to the frontend callback page.
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Param redirect_to query string false "Frontend path or URL to continue to after login"
+// @Success 302 {string} string "redirect"
+// @Router /auth/providers/{provider}/login [get]
+func (h *AuthHandler) ProviderLogin(c *gin.Context) {
+	h.providerLogin(c, c.Param("provider"))
+}
+
+func (h *AuthHandler) providerLogin(c *gin.Context, name string) {
+	provider, ok := h.providers[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider", "code": "unknown_provider"})
+		return
+	}
+
+	redirectTo, ok := h.validRedirectTarget(c.Query("redirect_to"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "redirect_to must be a path or URL on the frontend", "code": "invalid_redirect"})
+		return
+	}
+
+	authURL, err := h.beginProviderFlow(c, provider, nil, redirectTo)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start login with provider", "code": "provider_unavailable"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// @Summary Provider callback
+// @Description Complete a login or account link started with an external provider. Redirects to the frontend callback page with the tokens, or an error code, in the URL fragment.
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "Login state"
+// @Success 302 {string} string "redirect"
+// @Router /auth/providers/{provider}/callback [get]
+func (h *AuthHandler) ProviderCallback(c *gin.Context) {
+	h.providerCallback(c, c.Param("provider"))
+}
+
+func (h *AuthHandler) providerCallback(c *gin.Context, name string) {
+	provider, ok := h.providers[name]
+	if !ok {
+		h.redirectLoginError(c, "unknown_provider", "Unknown login provider")
+		return
+	}
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		h.redirectLoginError(c, "access_denied", "Login was denied by the provider ("+providerErr+")")
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" {
+		h.redirectLoginError(c, "state_missing", "The login state is missing; start the login again")
+		return
+	}
+	if code == "" {
+		h.redirectLoginError(c, "invalid_request", "The authorization code is missing")
+		return
+	}
+
+	record, err := h.consumeOAuthState(provider.Name(), state)
+	switch {
+	case errors.Is(err, errOAuthStateExpired):
+		h.redirectLoginError(c, "state_expired", "The login took too long; start the login again")
+		return
+	case errors.Is(err, errOAuthStateMismatch):
+		h.redirectLoginError(c, "state_mismatch", "The login state does not belong to this provider")
+		return
+	case err != nil:
+		h.redirectLoginError(c, "state_invalid", "The login state is unknown or was already used")
+		return
+	}
+
+	// A login or link must finish in the browser that started it
+	binding, err := c.Cookie(oauthBindingCookie)
+	c.SetCookie(oauthBindingCookie, "", -1, oauthCookiePath, "", h.secureCookies(), true)
+	if err != nil || record.BindingHash == "" || auth.HashToken(binding) != record.BindingHash {
+		h.redirectLoginError(c, "state_mismatch", "The login was started in a different browser")
+		return
+	}
+
+	// and a link only while the session that started it is still signed in
+	if record.LinkUserID != nil && !h.linkSessionActive(record) {
+		h.redirectLoginError(c, "session_invalid", "The session that started linking has ended; sign in and start again")
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), code, record.Nonce, record.CodeVerifier)
+	if err != nil {
+		h.redirectLoginError(c, "exchange_failed", "Failed to verify the login with the provider")
+		return
+	}
+
+	user, err := h.userForIdentity(identity, record.LinkUserID)
+	if err != nil {
+		code, message := identityErrorCode(err)
+		h.redirectLoginError(c, code, message)
+		return
+	}
+
+	fragment := url.Values{}
+	if record.RedirectTo != "" {
+		fragment.Set("redirect_to", record.RedirectTo)
+	}
+
+	if record.LinkUserID != nil {
+		fragment.Set("linked", identity.Provider)
+		c.Redirect(http.StatusFound, h.frontendCallbackURL(fragment))
+		return
+	}
+
+	if hasMFA(h.db, user.ID) {
+		token, err := h.createMFAChallenge(user.ID)
+		if err != nil {
+			h.redirectLoginError(c, "server_error", "Failed to start two-factor login")
+			return
+		}
+		fragment.Set("mfa_required", "true")
+		fragment.Set("mfa_token", token)
+		fragment.Set("expires_in", fmt.Sprint(int(mfaChallengeTTL.Seconds())))
+		c.Redirect(http.StatusFound, h.frontendCallbackURL(fragment))
+		return
+	}
+
+	response, err := h.startSession(c, user)
+	if err != nil {
+		h.redirectLoginError(c, "server_error", "Failed to start a session")
+		return
+	}
+
+	for _, key := range []string{"access_token", "token_type", "expires_in", "refresh_token", "session_id"} {
+		fragment.Set(key, fmt.Sprint(response[key]))
+	}
+	c.Redirect(http.StatusFound, h.frontendCallbackURL(fragment))
+}
+
+// @Summary Get linked identities
+// @Description List the external login identities linked to the current user
+// @Tags auth
+// @Security BearerAuth
+// @Success 200 {array} models.UserIdentity
+// @Router /me/identities [get]
+func (h *AuthHandler) GetIdentities(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var identities []models.UserIdentity
+	if err := h.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identities"})
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// @Summary Link identity
+// @Description Start linking an external login provider to the current user. Open the returned URL in the browser to continue.
+// @Tags auth
+// @Security BearerAuth
+// @Param provider path string true "Provider name"
+// @Param redirect_to query string false "Frontend path or URL to continue to after linking"
+// @Success 200 {object} map[string]interface{}
+// @Router /me/identities/{provider} [post]
+func (h *AuthHandler) LinkIdentity(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	redirectTo, ok := h.validRedirectTarget(c.Query("redirect_to"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "redirect_to must be a path or URL on the frontend"})
+		return
+	}
+
+	var count int64
+	h.db.Model(&models.UserIdentity{}).Where("user_id = ? AND provider = ?", userID, provider.Name()).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A " + provider.Name() + " identity is already linked"})
+		return
+	}
+
+	authURL, err := h.beginProviderFlow(c, provider, &linkRequest{userID: userID, sessionID: c.GetUint("sessionID")}, redirectTo)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start login with provider"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// @Summary Unlink identity
+// @Description Remove an external login identity from the current user. The last remaining identity cannot be removed unless the user has a password.
+// @Tags auth
+// @Security BearerAuth
+// @Param identityId path int true "Identity ID"
+// @Success 204
+// @Router /me/identities/{identityId} [delete]
+func (h *AuthHandler) UnlinkIdentity(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	identityID, err := strconv.Atoi(c.Param("identityId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+		return
+	}
+
+	var identity models.UserIdentity
+	if err := h.db.Where("user_id = ?", userID).First(&identity, identityID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var count int64
+	h.db.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count)
+	if count <= 1 && !user.HasPassword() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot unlink your only login method"})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&identity).Error; err != nil {
+			return err
+		}
+		// The encrypted provider token goes with the identity row
+		if identity.Provider == "github" {
+			return tx.Model(&models.User{}).Where("id = ?", userID).Update("git_hub_id", nil).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// linkRequest is the user and session that started linking an identity.
+type linkRequest struct {
+	userID    uint
+	sessionID uint
+}
+
+// beginProviderFlow records a pending login and returns the provider URL
+// to send the browser to. Every flow is bound to the browser with a cookie;
+// link flows (link set) are also bound to the session that started them.
+func (h *AuthHandler) beginProviderFlow(c *gin.Context, provider auth.Provider, link *linkRequest, redirectTo string) (string, error) {
+	state, err := auth.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := auth.RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := auth.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	record := models.OAuthState{
+		StateHash:    auth.HashToken(state),
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		RedirectTo:   redirectTo,
+		ExpiresAt:    time.Now().Add(oauthStateTTL),
+	}
+	if link != nil {
+		record.LinkUserID = &link.userID
+		record.LinkSessionID = &link.sessionID
+	}
+
+	binding, err := auth.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	record.BindingHash = auth.HashToken(binding)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthBindingCookie, binding, int(oauthStateTTL.Seconds()), oauthCookiePath, "", h.secureCookies(), true)
+
+	// Drop abandoned logins while we are here
+	h.db.Where("expires_at < ?", time.Now()).Delete(&models.OAuthState{})
+
+	if err := h.db.Create(&record).Error; err != nil {
+		return "", err
+	}
+
+	return authURL, nil
+}
+
+// linkSessionActive reports whether the session that started a link flow
+// is still active and still belongs to the user being linked.
+func (h *AuthHandler) linkSessionActive(record *models.OAuthState) bool {
+	if record.LinkSessionID == nil {
+		return false
+	}
+	var session models.Session
+	if err := h.db.First(&session, *record.LinkSessionID).Error; err != nil {
+		return false
+	}
+	return session.IsActive() && session.UserID == *record.LinkUserID
+}
+
+// consumeOAuthState looks up and deletes the pending login for state, so
+// every state value can be used only once.
+func (h *AuthHandler) consumeOAuthState(provider, state string) (*models.OAuthState, error) {
+	var record models.OAuthState
+	if err := h.db.Where("state_hash = ?", auth.HashToken(state)).First(&record).Error; err != nil {
+		return nil, errOAuthStateInvalid
+	}
+	if record.Provider != provider {
+		return nil, errOAuthStateMismatch
+	}
+
+	result := h.db.Delete(&record)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, errOAuthStateInvalid
+	}
+
+	if record.IsExpired() {
+		return nil, errOAuthStateExpired
+	}
+
+	return &record, nil
+}
+
+func (h *AuthHandler) secureCookies() bool {
+	return strings.HasPrefix(h.cfg.PublicURL, "https://")
+}
+
+// validRedirectTarget accepts an empty target, a local path, or an absolute
+// URL on the frontend or one of the allowed redirect origins. Anything else
+// could turn the login into an open redirect.
+func (h *AuthHandler) validRedirectTarget(target string) (string, bool) {
+	if target == "" {
+		return "", true
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.User != nil {
+		return "", false
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+			return "", false
+		}
+		return target, true
+	}
+
+	for _, origin := range append([]string{h.cfg.FrontendURL}, h.cfg.RedirectOrigins...) {
+		allowed, err := url.Parse(origin)
+		if err == nil && u.Scheme == allowed.Scheme && u.Host == allowed.Host {
+			return target, true
+		}
+	}
+
+	return "", false
+}
+
+// frontendCallbackURL builds the frontend callback page URL. Values go in
+// the fragment so tokens never reach server logs or Referer headers.
+func (h *AuthHandler) frontendCallbackURL(values url.Values) string {
+	return h.cfg.RedirectURL + "#" + values.Encode()
+}
+
+func (h *AuthHandler) redirectLoginError(c *gin.Context, code, message string) {
+	c.Redirect(http.StatusFound, h.frontendCallbackURL(url.Values{
+		"error":             {code},
+		"error_description": {message},
+	}))
+}
+
+// userForIdentity resolves the DevSync user for an external identity. A
+// known identity logs in its user. A new one is linked to linkUserID when
+// set, otherwise to the user with the same verified email, otherwise to a
+// newly created user.
+func (h *AuthHandler) userForIdentity(identity *auth.Identity, linkUserID *uint) (*models.User, error) {
+	var user models.User
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var link models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+		switch {
+		case err == nil:
+			if linkUserID != nil && link.UserID != *linkUserID {
+				return errIdentityLinkedElsewhere
+			}
+			if err := tx.First(&user, link.UserID).Error; err != nil {
+				return err
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := findOrCreateIdentityUser(tx, identity, linkUserID, &user); err != nil {
+				return err
+			}
+			link = models.UserIdentity{UserID: user.ID, Provider: identity.Provider, Subject: identity.Subject}
+		default:
+			return err
+		}
+
+		now := time.Now()
+		link.Email = identity.Email
+		link.LastLoginAt = &now
+		if identity.AccessToken != "" {
+			if err := h.tokens.Seal(&link, identity.AccessToken); err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(&link).Error; err != nil {
+			return err
+		}
+
+		return applyIdentityProfile(tx, &user, identity)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func findOrCreateIdentityUser(tx *gorm.DB, identity *auth.Identity, linkUserID *uint, user *models.User) error {
+	if linkUserID != nil {
+		return tx.First(user, *linkUserID).Error
+	}
+
+	if identity.Email == "" {
+		return errIdentityNoEmail
+	}
+
+	err := tx.Where("LOWER(email) = ?", normalizeEmail(identity.Email)).First(user).Error
+	if err == nil {
+		// Only a verified address shows the provider account belongs to this user
+		if !identity.EmailVerified || user.IsBot {
+			return errIdentityEmailTaken
+		}
+		if user.EmailVerifiedAt == nil {
+			// Whoever registered the unverified account never proved they
+			// own the address, so their password must not survive the link
+			now := time.Now()
+			user.PasswordHash, user.EmailVerifiedAt = "", &now
+			return tx.Model(user).Select("password_hash", "email_verified_at").Updates(user).Error
+		}
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	username, err := uniqueUsername(tx, identity.Username, strings.Split(identity.Email, "@")[0])
+	if err != nil {
+		return err
+	}
+
+	*user = models.User{
+		Username:  username,
+		Email:     identity.Email,
+		Name:      identity.Name,
+		AvatarURL: identity.AvatarURL,
+	}
+	if identity.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	return tx.Create(user).Error
+}
+
+// applyIdentityProfile copies profile data from identity onto user. GitHub
+// stays the source of truth for name and avatar; other providers only fill
+// gaps.
+func applyIdentityProfile(tx *gorm.DB, user *models.User, identity *auth.Identity) error {
+	var columns []string
+
+	if identity.Provider == "github" {
+		githubID, err := strconv.ParseInt(identity.Subject, 10, 64)
+		if err != nil {
+			return err
+		}
+		user.GitHubID = &githubID
+		columns = append(columns, "git_hub_id")
+		if identity.Name != "" {
+			user.Name = identity.Name
+			columns = append(columns, "name")
+		}
+		if identity.AvatarURL != "" {
+			user.AvatarURL = identity.AvatarURL
+			columns = append(columns, "avatar_url")
+		}
+	} else {
+		if user.Name == "" && identity.Name != "" {
+			user.Name = identity.Name
+			columns = append(columns, "name")
+		}
+		if user.AvatarURL == "" && identity.AvatarURL != "" {
+			user.AvatarURL = identity.AvatarURL
+			columns = append(columns, "avatar_url")
+		}
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+	return tx.Model(user).Select(columns).Updates(user).Error
+}
+
+// uniqueUsername returns the first usable candidate, made unique with a
+// random suffix when it is already taken.
+func uniqueUsername(tx *gorm.DB, candidates ...string) (string, error) {
+	base := "user"
+	for _, candidate := range candidates {
+		if cleaned := strings.Trim(usernameInvalidChars.ReplaceAllString(candidate, "-"), "-"); cleaned != "" {
+			base = cleaned
+			break
+		}
+	}
+
+	username := base
+	for attempt := 0; attempt < 5; attempt++ {
+		var count int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return username, nil
+		}
+
+		suffix, err := auth.RandomToken(3)
+		if err != nil {
+			return "", err
+		}
+		username = base + "-" + strings.ToLower(usernameInvalidChars.ReplaceAllString(suffix, ""))
+	}
+
+	return "", errors.New("could not find a free username")
+}
+
+// identityErrorCode maps errors from userForIdentity to the error code and
+// message sent to the frontend.
+func identityErrorCode(err error) (string, string) {
+	switch {
+	case errors.Is(err, errIdentityLinkedElsewhere):
+		return "identity_conflict", "This account is already linked to another DevSync user"
+	case errors.Is(err, errIdentityEmailTaken):
+		return "email_taken", "An account with this email already exists; sign in and link this provider from your account"
+	case errors.Is(err, errIdentityNoEmail):
+		return "email_missing", "The provider did not share an email address"
+	default:
+		return "server_error", "Failed to sign in"
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"devsync-be/internal/auth"
+	"devsync-be/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newMockAuthHandler(t *testing.T) (*AuthHandler, sqlmock.Sqlmock) {
+	db, mock := newMockDB(t)
+	return &AuthHandler{db: db}, mock
+}
+
+var (
+	identityColumns = []string{"id", "user_id", "provider", "subject", "email"}
+	userColumns     = []string{"id", "username", "email", "name", "avatar_url", "password_hash", "email_verified_at", "is_bot"}
+)
+
+func oidcIdentity(email string, verified bool) *auth.Identity {
+	return &auth.Identity{
+		Provider:      "oidc",
+		Subject:       "subject-1",
+		Username:      "ana",
+		Email:         email,
+		EmailVerified: verified,
+		Name:          "Ana",
+	}
+}
+
+func TestUserForIdentityLinksToSessionUser(t *testing.T) {
+	h, mock := newMockAuthHandler(t)
+	linkUserID := uint(7)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(exact(`SELECT * FROM "user_identities" WHERE provider = $1 AND subject = $2`)).
+		WithArgs("oidc", "subject-1").
+		WillReturnRows(sqlmock.NewRows(identityColumns))
+	// The email of the identity is not looked up: linking never moves it to
+	// the account that owns the address
+	mock.ExpectQuery(exact(`SELECT * FROM "users" WHERE "users"."id" = $1`)).
+		WithArgs(linkUserID).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(linkUserID, "bob", "bob@example.com", "Bob", "https://example.com/bob.png", "hash", time.Now(), false))
+	mock.ExpectQuery(exact(`INSERT INTO "user_identities"`)).
+		WithArgs(linkUserID, "oidc", "subject-1", "ana@example.com", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectCommit()
+
+	user, err := h.userForIdentity(oidcIdentity("ana@example.com", true), &linkUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != linkUserID || user.Name != "Bob" {
+		t.Errorf("userForIdentity() = %+v, want user %d unchanged", user, linkUserID)
+	}
+}
+
+func TestUserForIdentityLinkedElsewhere(t *testing.T) {
+	h, mock := newMockAuthHandler(t)
+	linkUserID := uint(7)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(exact(`SELECT * FROM "user_identities" WHERE provider = $1 AND subject = $2`)).
+		WithArgs("oidc", "subject-1").
+		WillReturnRows(sqlmock.NewRows(identityColumns).AddRow(3, 8, "oidc", "subject-1", "ana@example.com"))
+	mock.ExpectRollback()
+
+	_, err := h.userForIdentity(oidcIdentity("ana@example.com", true), &linkUserID)
+	if !errors.Is(err, errIdentityLinkedElsewhere) {
+		t.Fatalf("userForIdentity() error = %v, want %v", err, errIdentityLinkedElsewhere)
+	}
+}
+
+func TestUserForIdentityKnownIdentityLogsIn(t *testing.T) {
+	h, mock := newMockAuthHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(exact(`SELECT * FROM "user_identities" WHERE provider = $1 AND subject = $2`)).
+		WithArgs("oidc", "subject-1").
+		WillReturnRows(sqlmock.NewRows(identityColumns).AddRow(3, 8, "oidc", "subject-1", "old@example.com"))
+	mock.ExpectQuery(exact(`SELECT * FROM "users" WHERE "users"."id" = $1`)).
+		WithArgs(8).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(8, "ana", "ana@example.com", "Ana", "https://example.com/ana.png", "", time.Now(), false))
+	mock.ExpectExec(exact(`UPDATE "user_identities" SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := h.userForIdentity(oidcIdentity("new@example.com", false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 8 {
+		t.Errorf("userForIdentity() = user %d, want 8", user.ID)
+	}
+}
+
+func TestUserForIdentityVerifiedEmail(t *testing.T) {
+	h, mock := newMockAuthHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(exact(`SELECT * FROM "user_identities" WHERE provider = $1 AND subject = $2`)).
+		WithArgs("oidc", "subject-1").
+		WillReturnRows(sqlmock.NewRows(identityColumns))
+	mock.ExpectQuery(exact(`SELECT * FROM "users" WHERE LOWER(email) = $1`)).
+		WithArgs("ana@example.com").
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(8, "ana", "ana@example.com", "Ana", "", "hash", time.Now(), false))
+	mock.ExpectQuery(exact(`INSERT INTO "user_identities"`)).
+		WithArgs(8, "oidc", "subject-1", "Ana@Example.com", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectCommit()
+
+	user, err := h.userForIdentity(oidcIdentity("Ana@Example.com", true), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 8 || user.PasswordHash != "hash" {
+		t.Errorf("userForIdentity() = %+v, want user 8 with its password", user)
+	}
+}
+
+func TestUserForIdentityUnverifiedEmailTaken(t *testing.T) {
+	h, mock := newMockAuthHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(exact(`SELECT * FROM "user_identities" WHERE provider = $1 AND subject = $2`)).
+		WithArgs("oidc", "subject-1").
+		WillReturnRows(sqlmock.NewRows(identityColumns))
+	mock.ExpectQuery(exact(`SELECT * FROM "users" WHERE LOWER(email) = $1`)).
+		WithArgs("ana@example.com").
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(8, "ana", "ana@example.com", "Ana", "", "hash", time.Now(), false))
+	mock.ExpectRollback()
+
+	_, err := h.userForIdentity(oidcIdentity("ana@example.com", false), nil)
+	if !errors.Is(err, errIdentityEmailTaken) {
+		t.Fatalf("userForIdentity() error = %v, want %v", err, errIdentityEmailTaken)
+	}
+}
+
+func TestLinkSessionActive(t *testing.T) {
+	linkUserID, sessionID := uint(7), uint(5)
+	sessionColumns := []string{"id", "user_id", "expires_at", "revoked_at"}
+	tests := []struct {
+		name string
+		row  []driver.Value
+		want bool
+	}{
+		{"active", []driver.Value{sessionID, linkUserID, time.Now().Add(time.Hour), nil}, true},
+		{"revoked", []driver.Value{sessionID, linkUserID, time.Now().Add(time.Hour), time.Now()}, false},
+		{"expired", []driver.Value{sessionID, linkUserID, time.Now().Add(-time.Minute), nil}, false},
+		{"other user", []driver.Value{sessionID, uint(8), time.Now().Add(time.Hour), nil}, false},
+		{"missing", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newMockAuthHandler(t)
+			rows := sqlmock.NewRows(sessionColumns)
+			if tt.row != nil {
+				rows.AddRow(tt.row...)
+			}
+			mock.ExpectQuery(exact(`SELECT * FROM "sessions" WHERE "sessions"."id" = $1`)).
+				WithArgs(sessionID).
+				WillReturnRows(rows)
+
+			record := &models.OAuthState{LinkUserID: &linkUserID, LinkSessionID: &sessionID}
+			if got := h.linkSessionActive(record); got != tt.want {
+				t.Errorf("linkSessionActive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A link flow without a session is never accepted
+	h, _ := newMockAuthHandler(t)
+	if h.linkSessionActive(&models.OAuthState{LinkUserID: &linkUserID}) {
+		t.Error("linkSessionActive() = true without a session")
+	}
+}
@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"devsync-be/internal/auth"
+	"devsync-be/internal/config"
+	"devsync-be/internal/mailer"
+	"devsync-be/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type InvitationHandler struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer mailer.Mailer
+}
+
+func NewInvitationHandler(db *gorm.DB, cfg *config.Config, m mailer.Mailer) *InvitationHandler {
+	return &InvitationHandler{
+		db:     db,
+		cfg:    cfg,
+		mailer: m,
+	}
+}
+
+// CreateInvitationRequest represents the request body for inviting a member.
+// Existing users may be referenced by user_id or username instead of email.
+type CreateInvitationRequest struct {
+	Email    string             `json:"email,omitempty"`
+	UserID   *uint              `json:"user_id,omitempty"`
+	Username string             `json:"username,omitempty"`
+	Role     models.ProjectRole `json:"role,omitempty"`
+}
+
+// AcceptInvitationRequest represents the request body for accepting an invitation
+type AcceptInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// @Summary List invitations
+// @Description List invitations of a project
+// @Tags invitations
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param status query string false "Filter by status (pending, accepted, revoked)"
+// @Success 200 {array} models.ProjectInvitation
+// @Router /projects/{id}/invitations [get]
+func (h *InvitationHandler) GetInvitations(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	query := h.db.Where("project_id = ?", projectID).Preload("Inviter")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var invitations []models.ProjectInvitation
+	if err := query.Order("created_at DESC").Find(&invitations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invitations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// @Summary Invite member
+// @Description Invite someone to the project by email (or an existing user by user_id/username)
+// @Tags invitations
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param invitation body CreateInvitationRequest true "Invitation data"
+// @Success 201 {object} models.ProjectInvitation
+// @Router /projects/{id}/invitations [post]
+func (h *InvitationHandler) CreateInvitation(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	project := c.MustGet("project").(*models.Project)
+	actorRole := c.MustGet("projectRole").(models.ProjectRole)
+
+	var req CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = models.ProjectRoleMember
+	}
+	if !req.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+	if !canAssignRole(actorRole, req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot grant this role"})
+		return
+	}
+
+	// Resolve the invitee's email
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" {
+		var user models.User
+		var query *gorm.DB
+		if req.UserID != nil {
+			query = h.db.Where("id = ?", *req.UserID)
+		} else if req.Username != "" {
+			query = h.db.Where("username = ?", req.Username)
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide email, user_id, or username"})
+			return
+		}
+		if err := query.First(&user).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		email = strings.ToLower(user.Email)
+	}
+	if !strings.Contains(email, "@") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email"})
+		return
+	}
+
+	// Reject invitations for existing members
+	var memberCount int64
+	h.db.Model(&models.User{}).
+		Joins("JOIN user_projects ON user_projects.user_id = users.id").
+		Where("user_projects.project_id = ? AND LOWER(users.email) = ?", project.ID, email).
+		Count(&memberCount)
+	if memberCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member of this project"})
+		return
+	}
+
+	var pendingCount int64
+	h.db.Model(&models.ProjectInvitation{}).
+		Where("project_id = ? AND email = ? AND status = ? AND expires_at > ?", project.ID, email, models.InvitationStatusPending, time.Now()).
+		Count(&pendingCount)
+	if pendingCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A pending invitation already exists for this email; resend it instead"})
+		return
+	}
+
+	invitation := models.ProjectInvitation{
+		ProjectID: project.ID,
+		Email:     email,
+		Role:      req.Role,
+		Status:    models.InvitationStatusPending,
+		InvitedBy: userID,
+	}
+
+	if err := h.rotateToken(&invitation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invitation token"})
+		return
+	}
+	if err := h.db.Create(&invitation).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	// The token embeds the invitation ID, so it is signed once the row exists
+	token, err := h.signToken(&invitation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invitation token"})
+		return
+	}
+
+	sent := h.send(c.Request.Context(), project, &invitation, token)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"invitation": invitation,
+		"email_sent": sent,
+	})
+}
+
+// @Summary Resend invitation
+// @Description Issue a fresh token for a pending invitation and email it again
+// @Tags invitations
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param invitationId path int true "Invitation ID"
+// @Success 200 {object} models.ProjectInvitation
+// @Router /projects/{id}/invitations/{invitationId}/resend [post]
+func (h *InvitationHandler) ResendInvitation(c *gin.Context) {
+	project := c.MustGet("project").(*models.Project)
+
+	invitation, ok := h.findInvitation(c, project.ID)
+	if !ok {
+		return
+	}
+
+	if invitation.Status != models.InvitationStatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only pending invitations can be resent"})
+		return
+	}
+
+	if err := h.rotateToken(invitation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invitation token"})
+		return
+	}
+	token, err := h.signToken(invitation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invitation token"})
+		return
+	}
+	if err := h.db.Save(invitation).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invitation"})
+		return
+	}
+
+	sent := h.send(c.Request.Context(), project, invitation, token)
+
+	c.JSON(http.StatusOK, gin.H{
+		"invitation": invitation,
+		"email_sent": sent,
+	})
+}
+
+// @Summary Revoke invitation
+// @Description Revoke a pending invitation
+// @Tags invitations
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param invitationId path int true "Invitation ID"
+// @Success 200 {object} models.ProjectInvitation
+// @Router /projects/{id}/invitations/{invitationId} [delete]
+func (h *InvitationHandler) RevokeInvitation(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	invitation, ok := h.findInvitation(c, projectID)
+	if !ok {
+		return
+	}
+
+	if invitation.Status != models.InvitationStatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only pending invitations can be revoked"})
+		return
+	}
+
+	now := time.Now()
+	invitation.Status = models.InvitationStatusRevoked
+	invitation.RevokedAt = &now
+	if err := h.db.Save(invitation).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitation)
+}
+
+// @Summary Preview invitation
+// @Description Show the project and role an invitation token grants, without accepting it
+// @Tags invitations
+// @Param token query string true "Invitation token"
+// @Success 200 {object} map[string]interface{}
+// @Router /invitations/preview [get]
+func (h *InvitationHandler) PreviewInvitation(c *gin.Context) {
+	invitation, status, msg := h.resolveToken(c.Query("token"))
+	if invitation == nil {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	var project models.Project
+	h.db.First(&project, invitation.ProjectID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"project_id":   project.ID,
+		"project_name": project.Name,
+		"email":        invitation.Email,
+		"role":         invitation.Role,
+		"expires_at":   invitation.ExpiresAt,
+	})
+}
+
+// @Summary Accept invitation
+// @Description Accept an invitation as the current user and join the project. The user's verified email must be the one invited.
+// @Tags invitations
+// @Security BearerAuth
+// @Param request body AcceptInvitationRequest true "Invitation token"
+// @Success 200 {object} models.UserProject
+// @Failure 403 {object} map[string]interface{}
+// @Router /invitations/accept [post]
+func (h *InvitationHandler) AcceptInvitation(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitation, status, msg := h.resolveToken(req.Token)
+	if invitation == nil {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	// The token only proves someone saw the link; the invitation is for
+	// whoever owns the address it was sent to
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Verify your email address before accepting invitations", "code": "email_not_verified"})
+		return
+	}
+	if normalizeEmail(user.Email) != normalizeEmail(invitation.Email) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This invitation was sent to another email address", "code": "invitation_email_mismatch"})
+		return
+	}
+
+	if isProjectMember(h.db, userID, invitation.ProjectID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are already a member of this project"})
+		return
+	}
+
+	membership := models.UserProject{
+		UserID:    userID,
+		ProjectID: invitation.ProjectID,
+		Role:      invitation.Role,
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Only flip a still-pending row so a token cannot be redeemed twice
+		now := time.Now()
+		result := tx.Model(&models.ProjectInvitation{}).
+			Where("id = ? AND status = ?", invitation.ID, models.InvitationStatusPending).
+			Updates(map[string]interface{}{
+				"status":      models.InvitationStatusAccepted,
+				"accepted_by": userID,
+				"accepted_at": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(&membership).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusGone, gin.H{"error": "Invitation is no longer pending"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Invitation accepted",
+		"membership": membership,
+	})
+}
+
+// findInvitation loads the :invitationId route parameter scoped to the
+// project, writing an error response and returning false if it fails.
+func (h *InvitationHandler) findInvitation(c *gin.Context, projectID uint) (*models.ProjectInvitation, bool) {
+	invitationID, err := strconv.Atoi(c.Param("invitationId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return nil, false
+	}
+
+	var invitation models.ProjectInvitation
+	if err := h.db.Where("project_id = ?", projectID).First(&invitation, invitationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return nil, false
+	}
+
+	return &invitation, true
+}
+
+// resolveToken validates an invitation token against its row. On failure it
+// returns nil with the HTTP status and message to report.
+func (h *InvitationHandler) resolveToken(token string) (*models.ProjectInvitation, int, string) {
+	if token == "" {
+		return nil, http.StatusBadRequest, "Invitation token is required"
+	}
+
+	claims, err := auth.ValidateInvitationToken(token, h.cfg.JWTSecret)
+	if err != nil {
+		return nil, http.StatusBadRequest, "Invalid or expired invitation token"
+	}
+
+	var invitation models.ProjectInvitation
+	if err := h.db.First(&invitation, claims.InvitationID).Error; err != nil {
+		return nil, http.StatusNotFound, "Invitation not found"
+	}
+
+	if invitation.TokenNonce != claims.ID {
+		return nil, http.StatusGone, "Invitation link has been replaced by a newer one"
+	}
+	if invitation.Status != models.InvitationStatusPending {
+		return nil, http.StatusGone, "Invitation is no longer pending"
+	}
+	if invitation.IsExpired() {
+		return nil, http.StatusGone, "Invitation has expired"
+	}
+
+	return &invitation, http.StatusOK, ""
+}
+
+// rotateToken gives the invitation a new nonce and expiry, which invalidates
+// every token signed for it before.
+func (h *InvitationHandler) rotateToken(invitation *models.ProjectInvitation) error {
+	nonce, err := auth.RandomToken(16)
+	if err != nil {
+		return err
+	}
+	invitation.TokenNonce = nonce
+	invitation.ExpiresAt = time.Now().Add(h.cfg.InvitationTTL)
+	invitation.SentAt = time.Now()
+	return nil
+}
+
+func (h *InvitationHandler) signToken(invitation *models.ProjectInvitation) (string, error) {
+	return auth.GenerateInvitationToken(invitation.ID, invitation.TokenNonce, invitation.ExpiresAt, h.cfg.JWTSecret)
+}
+
+// send emails the invitation link and reports whether delivery succeeded.
+// A failed delivery leaves the invitation pending so it can be resent.
+func (h *InvitationHandler) send(ctx context.Context, project *models.Project, invitation *models.ProjectInvitation, token string) bool {
+	link := fmt.Sprintf("%s/invitations/accept?token=%s", strings.TrimRight(h.cfg.FrontendURL, "/"), url.QueryEscape(token))
+
+	msg := mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You have been invited to %s on DevSync", project.Name),
+		Body: fmt.Sprintf(
+			"You have been invited to join the project %q on DevSync as %s.\n\n"+
+				"Accept the invitation here:\n%s\n\n"+
+				"This link expires on %s.\n",
+			project.Name, invitation.Role, link, invitation.ExpiresAt.Format(time.RFC1123),
+		),
+	}
+
+	if err := h.mailer.Send(ctx, msg); err != nil {
+		log.Printf("Failed to send invitation %d to %s: %v", invitation.ID, invitation.Email, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"devsync-be/internal/auth"
+	"devsync-be/internal/config"
+	"devsync-be/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testInvitationSecret = "invitation-secret-for-tests-0123456789"
+
+// acceptInvitation posts a valid token for an invitation of project 4 to
+// ana@example.com as user 7, whose row is user.
+func acceptInvitation(t *testing.T, user []driver.Value, expect func(mock sqlmock.Sqlmock)) *httptest.ResponseRecorder {
+	t.Helper()
+	db, mock := newMockDB(t)
+	h := &InvitationHandler{db: db, cfg: &config.Config{JWTSecret: testInvitationSecret}}
+
+	expires := time.Now().Add(time.Hour)
+	token, err := auth.GenerateInvitationToken(3, "nonce", expires, testInvitationSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(exact(`SELECT * FROM "project_invitations" WHERE "project_invitations"."id" = $1`)).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "project_id", "email", "role", "status", "token_nonce", "expires_at"}).
+			AddRow(3, 4, "ana@example.com", "admin", models.InvitationStatusPending, "nonce", expires))
+	mock.ExpectQuery(exact(`SELECT * FROM "users" WHERE "users"."id" = $1`)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "email_verified_at"}).AddRow(user...))
+	if expect != nil {
+		expect(mock)
+	}
+
+	c, w := newTestContext(map[string]interface{}{"userID": uint(7)})
+	c.Request = httptest.NewRequest(http.MethodPost, "/invitations/accept", strings.NewReader(`{"token":"`+token+`"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	h.AcceptInvitation(c)
+	return w
+}
+
+func TestAcceptInvitationOtherEmail(t *testing.T) {
+	// The invitation stays pending: sqlmock fails on the update
+	w := acceptInvitation(t, []driver.Value{7, "mallory@example.com", time.Now()}, nil)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "invitation_email_mismatch") {
+		t.Errorf("AcceptInvitation() = %d %s, want 403 invitation_email_mismatch", w.Code, w.Body)
+	}
+}
+
+func TestAcceptInvitationUnverifiedEmail(t *testing.T) {
+	w := acceptInvitation(t, []driver.Value{7, "ana@example.com", nil}, nil)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "email_not_verified") {
+		t.Errorf("AcceptInvitation() = %d %s, want 403 email_not_verified", w.Code, w.Body)
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	w := acceptInvitation(t, []driver.Value{7, "Ana@Example.com", time.Now()}, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(exact(`SELECT count(*) FROM "user_projects" WHERE user_id = $1 AND project_id = $2`)).
+			WithArgs(7, 4).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectBegin()
+		mock.ExpectExec(exact(`UPDATE "project_invitations" SET`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(exact(`INSERT INTO "user_projects"`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	})
+	if w.Code != http.StatusOK {
+		t.Errorf("AcceptInvitation() = %d %s, want 200", w.Code, w.Body)
+	}
+}
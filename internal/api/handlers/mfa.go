@@ -0,0 +1,517 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"devsync-be/internal/auth"
+	"devsync-be/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	maxMFAAttempts    = 5
+	mfaLockoutWindow  = 15 * time.Minute
+	maxMFAFailures    = 10
+	recoveryCodeCount = 10
+	totpIssuer        = "DevSync"
+)
+
+// MFACodeRequest carries a TOTP code from the user's authenticator app
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFAVerifyRequest completes a two-step login with either a TOTP code or a
+// recovery code
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// DisableMFARequest proves possession of the second factor before it is removed
+type DisableMFARequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// @Summary Get two-factor status
+// @Description Two-factor authentication status of the current user
+// @Tags auth
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /me/mfa [get]
+func (h *AuthHandler) GetMFAStatus(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	mfa, err := h.loadMFA(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch two-factor status"})
+		return
+	}
+
+	var remaining int64
+	h.db.Model(&models.MFARecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&remaining)
+
+	response := gin.H{
+		"enabled":                  mfa != nil && mfa.IsEnabled(),
+		"pending_enrollment":       mfa != nil && !mfa.IsEnabled(),
+		"recovery_codes_remaining": remaining,
+	}
+	if mfa != nil {
+		response["confirmed_at"] = mfa.ConfirmedAt
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Start TOTP enrollment
+// @Description Generate a new TOTP secret. Show provisioning_uri as a QR code, then confirm with a code from the app.
+// @Tags auth
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /me/mfa/totp [post]
+func (h *AuthHandler) EnrollTOTP(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	existing, err := h.loadMFA(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+	if existing != nil && existing.IsEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	mfa := models.UserMFA{UserID: userID}
+	if err := h.tokens.SealTOTPSecret(&mfa, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	// A new enrollment replaces any unconfirmed one
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND confirmed_at IS NULL", userID).Delete(&models.UserMFA{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&mfa).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": auth.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// @Summary Confirm TOTP enrollment
+// @Description Turn on two-factor authentication with a code from the authenticator app. Returns one-time recovery codes, which are only shown once.
+// @Tags auth
+// @Security BearerAuth
+// @Param request body MFACodeRequest true "TOTP code"
+// @Success 200 {object} map[string]interface{}
+// @Router /me/mfa/totp/confirm [post]
+func (h *AuthHandler) ConfirmTOTP(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mfa, err := h.loadMFA(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm enrollment"})
+		return
+	}
+	if mfa == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending two-factor enrollment"})
+		return
+	}
+	if mfa.IsEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	if !h.recordMFAAttempt(c, userID) {
+		return
+	}
+	if ok, err := h.verifyTOTP(mfa, req.Code); err != nil || !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code", "code": "invalid_code"})
+		return
+	}
+	h.clearMFAFailures(userID)
+
+	var codes []string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(mfa).Update("confirmed_at", time.Now()).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes. Requires a current TOTP code.
+// @Tags auth
+// @Security BearerAuth
+// @Param request body MFACodeRequest true "TOTP code"
+// @Success 200 {object} map[string]interface{}
+// @Router /me/mfa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mfa, err := h.loadMFA(userID)
+	if err != nil || mfa == nil || !mfa.IsEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	if !h.recordMFAAttempt(c, userID) {
+		return
+	}
+	if ok, err := h.verifyTOTP(mfa, req.Code); err != nil || !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code", "code": "invalid_code"})
+		return
+	}
+	h.clearMFAFailures(userID)
+
+	var codes []string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// @Summary Disable two-factor authentication
+// @Description Turn off two-factor authentication with a TOTP or recovery code. Not allowed while the user belongs to a project that requires it.
+// @Tags auth
+// @Security BearerAuth
+// @Param request body DisableMFARequest true "TOTP or recovery code"
+// @Success 204
+// @Router /me/mfa [delete]
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req DisableMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mfa, err := h.loadMFA(userID)
+	if err != nil || mfa == nil || !mfa.IsEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	var enforced int64
+	h.db.Model(&models.UserProject{}).
+		Joins("JOIN projects ON projects.id = user_projects.project_id AND projects.deleted_at IS NULL").
+		Where("user_projects.user_id = ? AND projects.require_mfa = ?", userID, true).
+		Count(&enforced)
+	if enforced > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You belong to projects that require two-factor authentication"})
+		return
+	}
+
+	if !h.recordMFAAttempt(c, userID) {
+		return
+	}
+	if ok, err := h.verifySecondFactor(mfa, req.Code, req.RecoveryCode); err != nil || !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code", "code": "invalid_code"})
+		return
+	}
+	h.clearMFAFailures(userID)
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.UserMFA{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Verify second factor
+// @Description Exchange the mfa_token from a login plus a TOTP or recovery code for a session
+// @Tags auth
+// @Param request body MFAVerifyRequest true "MFA token and code"
+// @Success 200 {object} map[string]interface{}
+// @Router /auth/mfa/verify [post]
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code is required"})
+		return
+	}
+
+	var challenge models.MFAChallenge
+	if err := h.db.Where("token_hash = ?", auth.HashToken(req.MFAToken)).First(&challenge).Error; err != nil ||
+		challenge.ConsumedAt != nil || time.Now().After(challenge.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired; sign in again", "code": "mfa_token_invalid"})
+		return
+	}
+
+	if h.mfaFailures(challenge.UserID) >= maxMFAFailures {
+		h.respondMFALocked(c)
+		return
+	}
+
+	// Count the attempt before checking the code so parallel guesses are capped too
+	result := h.db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND attempts < ?", challenge.ID, maxMFAAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many invalid codes; sign in again", "code": "mfa_token_invalid"})
+		return
+	}
+
+	mfa, err := h.loadMFA(challenge.UserID)
+	if err != nil || mfa == nil || !mfa.IsEnabled() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired; sign in again", "code": "mfa_token_invalid"})
+		return
+	}
+
+	if !h.recordMFAAttempt(c, challenge.UserID) {
+		return
+	}
+
+	if ok, err := h.verifySecondFactor(mfa, req.Code, req.RecoveryCode); err != nil || !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":              "Invalid code",
+			"code":               "invalid_code",
+			"attempts_remaining": maxMFAAttempts - challenge.Attempts - 1,
+		})
+		return
+	}
+
+	result = h.db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND consumed_at IS NULL", challenge.ID).
+		Update("consumed_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired; sign in again", "code": "mfa_token_invalid"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, challenge.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	h.clearMFAFailures(user.ID)
+	h.db.Where("email = ?", normalizeEmail(user.Email)).Delete(&models.LoginAttempt{})
+
+	h.respondWithSession(c, &user)
+}
+
+// recordMFAAttempt counts a second factor check against the user before
+// the code is checked, so parallel guesses are capped too; the failures in
+// the window stay counted until a check passes. It responds and returns
+// false if the user is locked out.
+func (h *AuthHandler) recordMFAAttempt(c *gin.Context, userID uint) bool {
+	if h.mfaFailures(userID) >= maxMFAFailures {
+		h.respondMFALocked(c)
+		return false
+	}
+	h.db.Where("created_at < ?", time.Now().Add(-mfaLockoutWindow)).Delete(&models.MFAFailure{})
+	failure := models.MFAFailure{UserID: userID}
+	if err := h.db.Create(&failure).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return false
+	}
+	if h.mfaFailures(userID) > maxMFAFailures {
+		h.respondMFALocked(c)
+		return false
+	}
+	return true
+}
+
+// clearMFAFailures resets the lockout count after a check passed.
+func (h *AuthHandler) clearMFAFailures(userID uint) {
+	h.db.Where("user_id = ?", userID).Delete(&models.MFAFailure{})
+}
+
+// mfaFailures counts the user's failed second factor checks in the lockout
+// window.
+func (h *AuthHandler) mfaFailures(userID uint) int64 {
+	var count int64
+	h.db.Model(&models.MFAFailure{}).
+		Where("user_id = ? AND created_at > ?", userID, time.Now().Add(-mfaLockoutWindow)).
+		Count(&count)
+	return count
+}
+
+func (h *AuthHandler) respondMFALocked(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(int(mfaLockoutWindow.Seconds())))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes; try again later", "code": "mfa_locked"})
+}
+
+// loadMFA returns the user's enrollment, or nil if there is none.
+func (h *AuthHandler) loadMFA(userID uint) (*models.UserMFA, error) {
+	var mfa models.UserMFA
+	err := h.db.Where("user_id = ?", userID).First(&mfa).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+// createMFAChallenge starts the second login step and returns the opaque
+// "mfa pending" token for it.
+func (h *AuthHandler) createMFAChallenge(userID uint) (string, error) {
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	h.db.Where("expires_at < ?", time.Now()).Delete(&models.MFAChallenge{})
+
+	challenge := models.MFAChallenge{
+		UserID:    userID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	}
+	if err := h.db.Create(&challenge).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// respondMFAChallenge answers a successful first factor with an MFA token
+// instead of a session.
+func (h *AuthHandler) respondMFAChallenge(c *gin.Context, userID uint) {
+	token, err := h.createMFAChallenge(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_in":   int(mfaChallengeTTL.Seconds()),
+	})
+}
+
+// verifyTOTP checks code and records its time step, so a code that was
+// already accepted cannot be used again.
+func (h *AuthHandler) verifyTOTP(mfa *models.UserMFA, code string) (bool, error) {
+	secret, err := h.tokens.TOTPSecret(mfa)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := auth.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	result := h.db.Model(&models.UserMFA{}).
+		Where("user_id = ? AND last_used_step < ?", mfa.UserID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code,
+// which is consumed.
+func (h *AuthHandler) verifySecondFactor(mfa *models.UserMFA, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		return h.verifyTOTP(mfa, code)
+	}
+	if recoveryCode == "" {
+		return false, nil
+	}
+
+	result := h.db.Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", mfa.UserID, auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode))).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and returns a new
+// set. Only hashes are stored, so this is the only time they are visible.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := auth.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		record := models.MFARecoveryCode{
+			UserID:   userID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// TestMFACodeLockout checks that every endpoint taking a TOTP code shares
+// the lockout of the login step: with maxMFAFailures failures in the
+// window the code is not even checked.
+func TestMFACodeLockout(t *testing.T) {
+	tests := []struct {
+		name      string
+		confirmed interface{}
+		body      string
+		handler   func(*AuthHandler) gin.HandlerFunc
+		expect    func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:    "confirm enrollment",
+			body:    `{"code":"123456"}`,
+			handler: func(h *AuthHandler) gin.HandlerFunc { return h.ConfirmTOTP },
+		},
+		{
+			name:      "regenerate recovery codes",
+			confirmed: time.Now(),
+			body:      `{"code":"123456"}`,
+			handler:   func(h *AuthHandler) gin.HandlerFunc { return h.RegenerateRecoveryCodes },
+		},
+		{
+			name:      "disable",
+			confirmed: time.Now(),
+			body:      `{"recovery_code":"aaaa-bbbb"}`,
+			handler:   func(h *AuthHandler) gin.HandlerFunc { return h.DisableMFA },
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(exact(`SELECT count(*) FROM "user_projects"`)).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newMockAuthHandler(t)
+			mock.ExpectQuery(exact(`SELECT * FROM "user_mfas" WHERE user_id = $1`)).
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id", "confirmed_at"}).AddRow(7, tt.confirmed))
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+			mock.ExpectQuery(exact(`SELECT count(*) FROM "mfa_failures" WHERE user_id = $1 AND created_at > $2`)).
+				WithArgs(7, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(maxMFAFailures))
+
+			c, w := newTestContext(map[string]interface{}{"userID": uint(7)})
+			c.Request = httptest.NewRequest(http.MethodPost, "/me/mfa", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			tt.handler(h)(c)
+
+			if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "mfa_locked") {
+				t.Errorf("%s = %d %s, want 429 mfa_locked", tt.name, w.Code, w.Body)
+			}
+		})
+	}
+}
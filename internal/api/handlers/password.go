@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"devsync-be/internal/auth"
+	"devsync-be/internal/mailer"
+	"devsync-be/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	loginThrottleWindow     = 15 * time.Minute
+	maxLoginFailuresAccount = 5
+	maxLoginFailuresIP      = 20
+)
+
+var errAccountTokenInvalid = errors.New("account token invalid or expired")
+
+// dummyPasswordHash is checked against when the account does not exist, so
+// a failed login takes as long whether or not the email is registered.
+var dummyPasswordHash, _ = auth.HashPassword("devsync-timing-equaliser")
+
+// RegisterRequest represents the request body for creating a password account
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=50"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=10,max=128"`
+	Name     string `json:"name"`
+}
+
+// PasswordLoginRequest represents the request body for password login
+type PasswordLoginRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// AccountTokenRequest carries a token from a verification or reset email
+type AccountTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// AccountEmailRequest identifies an account by email address
+type AccountEmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents the request body for completing a password reset
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=10,max=128"`
+}
+
+// ChangePasswordRequest represents the request body for changing or setting a password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required,min=10,max=128"`
+}
+
+// @Summary Register
+// @Description Create an email/password account. The email address must be verified before the first login.
+// @Tags auth
+// @Param request body RegisterRequest true "Account details"
+// @Success 201 {object} map[string]interface{}
+// @Router /auth/register [post]
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+	if usernameInvalidChars.MatchString(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username may only contain letters, digits, '.', '_' and '-'"})
+		return
+	}
+
+	var count int64
+	h.db.Unscoped().Model(&models.User{}).Where("LOWER(email) = ?", email).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	}
+	h.db.Unscoped().Model(&models.User{}).Where("username = ?", req.Username).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = req.Username
+	}
+
+	user := models.User{
+		Username:     req.Username,
+		Email:        email,
+		Name:         name,
+		PasswordHash: hash,
+	}
+	if err := h.db.Create(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+		return
+	}
+
+	h.sendAccountEmail(c.Request.Context(), &user, models.AccountTokenEmailVerification)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Account created; check your email to verify your address",
+		"user": gin.H{
+			"id":       user.ID,
+			"username": user.Username,
+			"email":    user.Email,
+		},
+	})
+}
+
+// @Summary Password login
+// @Description Login with email and password. Repeated failures for an account or IP address are throttled.
+// @Tags auth
+// @Param request body PasswordLoginRequest true "Credentials"
+// @Success 200 {object} map[string]interface{}
+// @Router /auth/login [post]
+func (h *AuthHandler) PasswordLogin(c *gin.Context) {
+	var req PasswordLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+	ip := c.ClientIP()
+
+	if h.loginThrottled(email, ip) {
+		c.Header("Retry-After", strconv.Itoa(int(loginThrottleWindow.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts; try again later", "code": "login_throttled"})
+		return
+	}
+
+	var user models.User
+	err := h.db.Where("LOWER(email) = ? AND is_bot = ?", email, false).First(&user).Error
+	if err != nil || !user.HasPassword() {
+		auth.CheckPassword(req.Password, dummyPasswordHash)
+		h.recordLoginFailure(email, ip)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password", "code": "invalid_credentials"})
+		return
+	}
+
+	if ok, err := auth.CheckPassword(req.Password, user.PasswordHash); err != nil || !ok {
+		h.recordLoginFailure(email, ip)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password", "code": "invalid_credentials"})
+		return
+	}
+
+	if user.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Verify your email address before signing in", "code": "email_not_verified"})
+		return
+	}
+
+	// Failures are only forgiven once the whole login succeeded
+	if hasMFA(h.db, user.ID) {
+		h.respondMFAChallenge(c, user.ID)
+		return
+	}
+	h.db.Where("email = ?", email).Delete(&models.LoginAttempt{})
+
+	h.respondWithSession(c, &user)
+}
+
+// @Summary Verify email
+// @Description Confirm an email address with the token from the verification email
+// @Tags auth
+// @Param request body AccountTokenRequest true "Verification token"
+// @Success 200 {object} map[string]interface{}
+// @Router /auth/verify-email [post]
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req AccountTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeAccountToken(tx, req.Token, models.AccountTokenEmailVerification)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).
+			Where("id = ? AND email_verified_at IS NULL", token.UserID).
+			Update("email_verified_at", time.Now()).Error
+	})
+	if errors.Is(err, errAccountTokenInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired", "code": "token_invalid"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// @Summary Resend verification email
+// @Description Send a new verification email. Always succeeds so it cannot be used to probe for accounts.
+// @Tags auth
+// @Param request body AccountEmailRequest true "Email address"
+// @Success 202 {object} map[string]interface{}
+// @Router /auth/verify-email/resend [post]
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	var req AccountEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("LOWER(email) = ? AND is_bot = ?", normalizeEmail(req.Email), false).First(&user).Error; err == nil &&
+		user.EmailVerifiedAt == nil && !h.recentlyMailed(user.ID, models.AccountTokenEmailVerification) {
+		h.sendAccountEmail(c.Request.Context(), &user, models.AccountTokenEmailVerification)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists and is unverified, a verification email has been sent"})
+}
+
+// @Summary Forgot password
+// @Description Email a single-use password reset link. Always succeeds so it cannot be used to probe for accounts.
+// @Tags auth
+// @Param request body AccountEmailRequest true "Email address"
+// @Success 202 {object} map[string]interface{}
+// @Router /auth/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req AccountEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("LOWER(email) = ? AND is_bot = ?", normalizeEmail(req.Email), false).First(&user).Error; err == nil &&
+		!h.recentlyMailed(user.ID, models.AccountTokenPasswordReset) {
+		h.sendAccountEmail(c.Request.Context(), &user, models.AccountTokenPasswordReset)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a password reset email has been sent"})
+}
+
+// @Summary Reset password
+// @Description Set a new password with the token from the reset email. Every session of the account is revoked.
+// @Tags auth
+// @Param request body ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} map[string]interface{}
+// @Router /auth/password/reset [post]
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeAccountToken(tx, req.Token, models.AccountTokenPasswordReset)
+		if err != nil {
+			return err
+		}
+
+		// Receiving the mail proves control of the address
+		if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).Updates(map[string]interface{}{
+			"password_hash":     hash,
+			"email_verified_at": gorm.Expr("COALESCE(email_verified_at, ?)", time.Now()),
+		}).Error; err != nil {
+			return err
+		}
+
+		// Outstanding reset links die with this one
+		if err := tx.Model(&models.AccountToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, models.AccountTokenPasswordReset).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return revokeSessions(tx.Where("user_id = ?", token.UserID), "password_reset")
+	})
+	if errors.Is(err, errAccountTokenInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid or has expired", "code": "token_invalid"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset; sign in with your new password"})
+}
+
+// @Summary Change password
+// @Description Change the current user's password, or set one for an account that only uses external logins. Other sessions are revoked.
+// @Tags auth
+// @Security BearerAuth
+// @Param request body ChangePasswordRequest true "Current and new password"
+// @Success 204
+// @Router /me/password [put]
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	userID := c.GetUint("userID")
+	sessionID := c.GetUint("sessionID")
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.HasPassword() {
+		if ok, err := auth.CheckPassword(req.CurrentPassword, user.PasswordHash); err != nil || !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+		return revokeSessions(tx.Where("user_id = ? AND id <> ?", userID, sessionID), "password_changed")
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) loginThrottled(email, ip string) bool {
+	since := time.Now().Add(-loginThrottleWindow)
+
+	var accountFailures, ipFailures int64
+	h.db.Model(&models.LoginAttempt{}).Where("email = ? AND created_at > ?", email, since).Count(&accountFailures)
+	h.db.Model(&models.LoginAttempt{}).Where("ip_address = ? AND created_at > ?", ip, since).Count(&ipFailures)
+
+	return accountFailures >= maxLoginFailuresAccount || ipFailures >= maxLoginFailuresIP
+}
+
+func (h *AuthHandler) recordLoginFailure(email, ip string) {
+	h.db.Where("created_at < ?", time.Now().Add(-loginThrottleWindow)).Delete(&models.LoginAttempt{})
+	h.db.Create(&models.LoginAttempt{Email: email, IPAddress: ip})
+}
+
+// recentlyMailed reports whether a token of purpose was sent to the user in
+// the last minute, to stop the public endpoints from being used to spam.
+func (h *AuthHandler) recentlyMailed(userID uint, purpose models.AccountTokenPurpose) bool {
+	var count int64
+	h.db.Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, time.Now().Add(-time.Minute)).
+		Count(&count)
+	return count > 0
+}
+
+// consumeAccountToken marks the token as used and returns it. Only the
+// first caller succeeds, so every token works exactly once.
+func consumeAccountToken(tx *gorm.DB, token string, purpose models.AccountTokenPurpose) (*models.AccountToken, error) {
+	var record models.AccountToken
+	if err := tx.Where("token_hash = ? AND purpose = ?", auth.HashToken(token), purpose).First(&record).Error; err != nil {
+		return nil, errAccountTokenInvalid
+	}
+	if !record.IsUsable() {
+		return nil, errAccountTokenInvalid
+	}
+
+	result := tx.Model(&models.AccountToken{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errAccountTokenInvalid
+	}
+
+	return &record, nil
+}
+
+// sendAccountEmail issues a token of purpose for user and mails the link.
+// Failures are logged rather than returned so responses do not reveal
+// whether an account exists.
+func (h *AuthHandler) sendAccountEmail(ctx context.Context, user *models.User, purpose models.AccountTokenPurpose) {
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		log.Printf("Failed to generate %s token for user %d: %v", purpose, user.ID, err)
+		return
+	}
+
+	ttl, path, subject, intro := h.cfg.VerifyEmailTTL, "/verify-email", "Verify your DevSync email address",
+		"Confirm your email address to finish setting up your DevSync account:"
+	if purpose == models.AccountTokenPasswordReset {
+		ttl, path, subject, intro = h.cfg.PasswordResetTTL, "/reset-password", "Reset your DevSync password",
+			"Someone asked to reset the password of your DevSync account. If that was you, choose a new password here:"
+	}
+
+	record := models.AccountToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := h.db.Create(&record).Error; err != nil {
+		log.Printf("Failed to store %s token for user %d: %v", purpose, user.ID, err)
+		return
+	}
+
+	link := fmt.Sprintf("%s%s?token=%s", strings.TrimRight(h.cfg.FrontendURL, "/"), path, url.QueryEscape(token))
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body: fmt.Sprintf("%s\n%s\n\nThis link expires on %s and can only be used once.\n",
+			intro, link, record.ExpiresAt.Format(time.RFC1123)),
+	}
+	if err := h.mailer.Send(ctx, msg); err != nil {
+		log.Printf("Failed to send %s email to user %d: %v", purpose, user.ID, err)
+	}
+}
+
+// respondWithSession starts a session for user and writes the token pair
+// and profile as the login response.
+func (h *AuthHandler) respondWithSession(c *gin.Context, user *models.User) {
+	response, err := h.startSession(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	response["message"] = "Login successful"
+	response["user"] = gin.H{
+		"id":         user.ID,
+		"username":   user.Username,
+		"email":      user.Email,
+		"name":       user.Name,
+		"avatar_url": user.AvatarURL,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
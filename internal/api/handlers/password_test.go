@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"devsync-be/internal/auth"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func postLogin(h *AuthHandler, body string) *httptest.ResponseRecorder {
+	c, w := newTestContext(nil)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.RemoteAddr = "192.0.2.1:1234"
+	h.PasswordLogin(c)
+	return w
+}
+
+// expectLoginFailures expects the throttle to count the failures of
+// ana@example.com and of the client's IP address.
+func expectLoginFailures(mock sqlmock.Sqlmock, account, ip int) {
+	mock.ExpectQuery(exact(`SELECT count(*) FROM "login_attempts" WHERE email = $1 AND created_at > $2`)).
+		WithArgs("ana@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(account))
+	mock.ExpectQuery(exact(`SELECT count(*) FROM "login_attempts" WHERE ip_address = $1 AND created_at > $2`)).
+		WithArgs("192.0.2.1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(ip))
+}
+
+func TestPasswordLoginThrottled(t *testing.T) {
+	tests := []struct {
+		name        string
+		account, ip int
+	}{
+		{"account", maxLoginFailuresAccount, 0},
+		{"ip address", 0, maxLoginFailuresIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newMockAuthHandler(t)
+			expectLoginFailures(mock, tt.account, tt.ip)
+
+			// The password is not even checked
+			w := postLogin(h, `{"email":"Ana@Example.com","password":"correct horse"}`)
+			if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "login_throttled") {
+				t.Errorf("PasswordLogin() = %d %s, want 429 login_throttled", w.Code, w.Body)
+			}
+			if w.Header().Get("Retry-After") == "" {
+				t.Error("PasswordLogin() did not set Retry-After")
+			}
+		})
+	}
+}
+
+func TestPasswordLoginRecordsFailure(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+	}{
+		{"unknown email", sqlmock.NewRows(userColumns)},
+		{"wrong password", sqlmock.NewRows(userColumns).AddRow(7, "ana", "ana@example.com", "Ana", "", hash, time.Now(), false)},
+		{"no password", sqlmock.NewRows(userColumns).AddRow(7, "ana", "ana@example.com", "Ana", "", "", time.Now(), false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newMockAuthHandler(t)
+			expectLoginFailures(mock, maxLoginFailuresAccount-1, 0)
+			mock.ExpectQuery(exact(`SELECT * FROM "users" WHERE (LOWER(email) = $1 AND is_bot = $2) AND "users"."deleted_at" IS NULL`)).
+				WithArgs("ana@example.com", false).
+				WillReturnRows(tt.rows)
+			mock.ExpectBegin()
+			mock.ExpectExec(exact(`DELETE FROM "login_attempts" WHERE created_at < $1`)).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+			mock.ExpectBegin()
+			mock.ExpectQuery(exact(`INSERT INTO "login_attempts"`)).
+				WithArgs("ana@example.com", "192.0.2.1", sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectCommit()
+
+			// Every failure looks the same to the client
+			w := postLogin(h, `{"email":"Ana@Example.com","password":"wrong horse"}`)
+			if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "invalid_credentials") {
+				t.Errorf("PasswordLogin() = %d %s, want 401 invalid_credentials", w.Code, w.Body)
+			}
+		})
+	}
+}
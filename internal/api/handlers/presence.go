@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"devsync-be/internal/models"
+	"devsync-be/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PresenceHandler struct {
+	db  *gorm.DB
+	hub *websocket.Hub
+}
+
+func NewPresenceHandler(db *gorm.DB, hub *websocket.Hub) *PresenceHandler {
+	return &PresenceHandler{db: db, hub: hub}
+}
+
+// PresenceUser is one online member, combining all of their connections.
+type PresenceUser struct {
+	User         PresenceProfile `json:"user"`
+	Status       string          `json:"status"`
+	Connections  int             `json:"connections"`
+	FileIDs      []uint          `json:"file_ids"`
+	TaskIDs      []uint          `json:"task_ids"`
+	LastActiveAt time.Time       `json:"last_active_at"`
+}
+
+type PresenceProfile struct {
+	ID        uint   `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// PresenceResponse is the presence snapshot of a project. Files and Tasks
+// map an ID to the users viewing it.
+type PresenceResponse struct {
+	Users []PresenceUser  `json:"users"`
+	Files map[uint][]uint `json:"files"`
+	Tasks map[uint][]uint `json:"tasks"`
+}
+
+// statusRank orders statuses so a user shows the most present one of their
+// connections.
+var statusRank = map[string]int{
+	websocket.StatusActive: 2,
+	websocket.StatusIdle:   1,
+	websocket.StatusAway:   0,
+}
+
+// @Summary Get project presence
+// @Description Get who is online in a project and which files and tasks they are viewing
+// @Tags projects
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Success 200 {object} PresenceResponse
+// @Router /projects/{id}/presence [get]
+func (h *PresenceHandler) GetPresence(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	sessions, err := h.hub.Presence(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch presence"})
+		return
+	}
+
+	response := PresenceResponse{
+		Users: []PresenceUser{},
+		Files: make(map[uint][]uint),
+		Tasks: make(map[uint][]uint),
+	}
+
+	byUser := make(map[uint]*PresenceUser)
+	var userIDs []uint
+	for _, session := range sessions {
+		user := byUser[session.UserID]
+		if user == nil {
+			user = &PresenceUser{Status: session.Status, FileIDs: []uint{}, TaskIDs: []uint{}}
+			byUser[session.UserID] = user
+			userIDs = append(userIDs, session.UserID)
+		}
+		user.Connections++
+		if statusRank[session.Status] > statusRank[user.Status] {
+			user.Status = session.Status
+		}
+		if session.LastActiveAt.After(user.LastActiveAt) {
+			user.LastActiveAt = session.LastActiveAt
+		}
+		if session.FileID != nil {
+			user.FileIDs = appendUnique(user.FileIDs, *session.FileID)
+			response.Files[*session.FileID] = appendUnique(response.Files[*session.FileID], session.UserID)
+		}
+		if session.TaskID != nil {
+			user.TaskIDs = appendUnique(user.TaskIDs, *session.TaskID)
+			response.Tasks[*session.TaskID] = appendUnique(response.Tasks[*session.TaskID], session.UserID)
+		}
+	}
+
+	if len(userIDs) > 0 {
+		var profiles []PresenceProfile
+		if err := h.db.Model(&models.User{}).
+			Where("id IN ?", userIDs).
+			Select("id, username, name, avatar_url").
+			Scan(&profiles).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch presence"})
+			return
+		}
+		for _, profile := range profiles {
+			byUser[profile.ID].User = profile
+		}
+	}
+
+	for _, id := range userIDs {
+		response.Users = append(response.Users, *byUser[id])
+	}
+	sort.SliceStable(response.Users, func(i, j int) bool {
+		return response.Users[i].LastActiveAt.After(response.Users[j].LastActiveAt)
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+func appendUnique(ids []uint, id uint) []uint {
+	for _, existing := range ids {
+		if existing == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
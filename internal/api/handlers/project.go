@@ -1,30 +1,26 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"devsync-be/internal/models"
+	"devsync-be/internal/websocket"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProjectHandler struct {
-	db *gorm.DB
+	db  *gorm.DB
+	hub *websocket.Hub
 }
 
-func NewProjectHandler(db *gorm.DB) *ProjectHandler {
-	return &ProjectHandler{db: db}
-}
-
-// Helper function to check if user is a member of the project
-func (h *ProjectHandler) isProjectMember(userID, projectID uint) bool {
-	var count int64
-	h.db.Table("user_projects").
-		Where("user_id = ? AND project_id = ?", userID, projectID).
-		Count(&count)
-	return count > 0
+func NewProjectHandler(db *gorm.DB, hub *websocket.Hub) *ProjectHandler {
+	return &ProjectHandler{db: db, hub: hub}
 }
 
 // @Summary Get projects
@@ -40,11 +36,16 @@ func (h *ProjectHandler) GetProjects(c *gin.Context) {
 		return
 	}
 
+	query := h.db.Joins("JOIN user_projects ON user_projects.project_id = projects.id").
+		Where("user_projects.user_id = ?", userID)
+
+	// Project-restricted API tokens only see their own project
+	if tokenProjectID, restricted := c.Get("tokenProjectID"); restricted {
+		query = query.Where("projects.id = ?", tokenProjectID)
+	}
+
 	var projects []models.Project
-	err := h.db.Joins("JOIN user_projects ON user_projects.project_id = projects.id").
-		Where("user_projects.user_id = ?", userID).
-		Preload("Users").
-		Find(&projects).Error
+	err := query.Preload("Users").Find(&projects).Error
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
@@ -68,6 +69,12 @@ func (h *ProjectHandler) CreateProject(c *gin.Context) {
 		return
 	}
 
+	// Bots and project-restricted tokens cannot create new projects
+	if _, restricted := c.Get("tokenProjectID"); restricted || c.GetBool("isBot") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This token cannot create projects"})
+		return
+	}
+
 	var project models.Project
 	if err := c.ShouldBindJSON(&project); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -77,6 +84,7 @@ func (h *ProjectHandler) CreateProject(c *gin.Context) {
 	// Set creator
 	creatorID := userID.(uint)
 	project.CreatedBy = &creatorID
+	project.Version = 1
 
 	// Create project
 	if err := h.db.Create(&project).Error; err != nil {
@@ -91,7 +99,12 @@ func (h *ProjectHandler) CreateProject(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Model(&project).Association("Users").Append(&user); err != nil {
+	membership := models.UserProject{
+		UserID:    user.ID,
+		ProjectID: project.ID,
+		Role:      models.ProjectRoleOwner,
+	}
+	if err := h.db.Create(&membership).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add user to project"})
 		return
 	}
@@ -105,339 +118,424 @@ func (h *ProjectHandler) CreateProject(c *gin.Context) {
 // @Security BearerAuth
 // @Param id path int true "Project ID"
 // @Success 200 {object} models.Project
+// @Header 200 {string} ETag "Version of the project"
 // @Router /projects/{id} [get]
 func (h *ProjectHandler) GetProject(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-		return
-	}
-
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-		return
-	}
-
-	// Check if user is a member of the project
-	if !h.isProjectMember(userID.(uint), uint(id)) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: You are not a member of this project"})
-		return
-	}
+	projectID := c.GetUint("projectID")
 
 	var project models.Project
-	if err := h.db.Preload("Users").Preload("Files").Preload("Tasks").First(&project, id).Error; err != nil {
+	if err := h.db.Preload("Users").Preload("Files").Preload("Tasks").First(&project, projectID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, project)
+	respondWithETag(c, entityTag(project.Version), project)
 }
 
 // @Summary Update project
-// @Description Update project by ID
+// @Description Update project by ID. Requires If-Match with the project's ETag.
 // @Tags projects
 // @Security BearerAuth
 // @Param id path int true "Project ID"
+// @Param If-Match header string true "ETag of the project"
 // @Param project body models.Project true "Project data"
 // @Success 200 {object} models.Project
+// @Failure 412 {object} map[string]interface{}
 // @Router /projects/{id} [put]
 func (h *ProjectHandler) UpdateProject(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+	project := *c.MustGet("project").(*models.Project)
+	if !checkIfMatch(c, entityTag(project.Version), project) {
 		return
 	}
+	createdBy, requireMFA, version := project.CreatedBy, project.RequireMFA, project.Version
 
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+	if err := c.ShouldBindJSON(&project); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Check if user is a member of the project
-	if !h.isProjectMember(userID.(uint), uint(id)) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: You are not a member of this project"})
-		return
-	}
+	// The body must not move the row, hand the project to someone else or
+	// change security settings
+	project.ID = c.GetUint("projectID")
+	project.CreatedBy = createdBy
+	project.RequireMFA = requireMFA
+	project.Version = version + 1
 
-	var project models.Project
-	if err := h.db.First(&project, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	result := h.db.Select("*").Omit(clause.Associations).Where("version = ?", version).Save(&project)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
 		return
 	}
-
-	if err := c.ShouldBindJSON(&project); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if result.RowsAffected == 0 {
+		h.projectChanged(c, project.ID)
 		return
 	}
 
-	if err := h.db.Save(&project).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
-		return
+	// Broadcast project update to WebSocket clients
+	message := map[string]interface{}{
+		"type":       "project_updated",
+		"project_id": project.ID,
+		"data":       project,
+	}
+	if msgBytes, err := json.Marshal(message); err == nil {
+		h.hub.Broadcast(msgBytes)
 	}
 
+	c.Header("ETag", entityTag(project.Version))
 	c.JSON(http.StatusOK, project)
 }
 
 // @Summary Delete project
-// @Description Delete project by ID
+// @Description Delete project by ID (owner only). Requires If-Match with the project's ETag.
 // @Tags projects
 // @Security BearerAuth
 // @Param id path int true "Project ID"
+// @Param If-Match header string true "ETag of the project"
 // @Success 204
+// @Failure 412 {object} map[string]interface{}
 // @Router /projects/{id} [delete]
 func (h *ProjectHandler) DeleteProject(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+	project := c.MustGet("project").(*models.Project)
+	if !checkIfMatch(c, entityTag(project.Version), project) {
 		return
 	}
 
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+	result := h.db.Where("version = ?", project.Version).Delete(&models.Project{}, project.ID)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
 		return
 	}
-
-	// Check if project exists and user is the creator
-	var project models.Project
-	if err := h.db.First(&project, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	if result.RowsAffected == 0 {
+		h.projectChanged(c, project.ID)
 		return
 	}
 
-	// Only creator can delete the project
-	if project.CreatedBy == nil || *project.CreatedBy != userID.(uint) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only project creator can delete this project"})
-		return
-	}
+	c.Status(http.StatusNoContent)
+}
 
-	if err := h.db.Delete(&models.Project{}, id).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
+// projectChanged responds 412 with the current project after a conditional
+// write matched no row.
+func (h *ProjectHandler) projectChanged(c *gin.Context, projectID uint) {
+	var current models.Project
+	if err := h.db.First(&current, projectID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 		return
 	}
+	preconditionFailed(c, entityTag(current.Version), current)
+}
 
-	c.Status(http.StatusNoContent)
+// MemberResponse is a project member together with their role
+type MemberResponse struct {
+	ID        uint               `json:"id"`
+	Username  string             `json:"username"`
+	Name      string             `json:"name"`
+	Email     string             `json:"email"`
+	AvatarURL string             `json:"avatar_url"`
+	CreatedAt time.Time          `json:"created_at"`
+	Role      models.ProjectRole `json:"role"`
+	JoinedAt  time.Time          `json:"joined_at"`
 }
 
 // @Summary Get project members
-// @Description Get all members of a project
+// @Description Get all members of a project with their roles
 // @Tags projects
 // @Security BearerAuth
 // @Param id path int true "Project ID"
-// @Success 200 {array} models.User
+// @Success 200 {array} MemberResponse
 // @Router /projects/{id}/members [get]
 func (h *ProjectHandler) GetMembers(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+	projectID := c.GetUint("projectID")
+
+	// Get all members of the project
+	var members []MemberResponse
+	err := h.db.Model(&models.User{}).
+		Joins("JOIN user_projects ON user_projects.user_id = users.id").
+		Where("user_projects.project_id = ?", projectID).
+		Select("users.id, users.username, users.name, users.email, users.avatar_url, users.created_at, user_projects.role, user_projects.created_at AS joined_at").
+		Order("user_projects.created_at ASC").
+		Scan(&members).Error
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project members"})
 		return
 	}
 
-	projectID, err := strconv.Atoi(c.Param("id"))
+	c.JSON(http.StatusOK, members)
+}
+
+// @Summary Get my project permissions
+// @Description Get the current user's role and permissions in a project
+// @Tags projects
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /projects/{id}/permissions [get]
+func (h *ProjectHandler) GetMyPermissions(c *gin.Context) {
+	role := c.MustGet("projectRole").(models.ProjectRole)
+
+	c.JSON(http.StatusOK, gin.H{
+		"role":        role,
+		"permissions": role.Permissions(),
+	})
+}
+
+// UpdateMemberRoleRequest represents the request body for changing a member's role
+type UpdateMemberRoleRequest struct {
+	Role models.ProjectRole `json:"role" binding:"required"`
+}
+
+// TransferOwnershipRequest represents the request body for transferring ownership
+type TransferOwnershipRequest struct {
+	UserID uint `json:"user_id" binding:"required"`
+}
+
+// canAssignRole reports whether a member with role actor may grant role to
+// someone else. Ownership only changes hands through TransferOwnership.
+func canAssignRole(actor, role models.ProjectRole) bool {
+	if role == models.ProjectRoleOwner {
+		return false
+	}
+	return actor == models.ProjectRoleOwner || role.Rank() < actor.Rank()
+}
+
+// canManageMember reports whether a member with role actor may change or
+// remove a member that currently holds role target.
+func canManageMember(actor, target models.ProjectRole) bool {
+	if target == models.ProjectRoleOwner {
+		return false
+	}
+	return actor == models.ProjectRoleOwner || target.Rank() < actor.Rank()
+}
+
+// @Summary Remove member from project
+// @Description Remove a user from project
+// @Tags projects
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param userId path int true "User ID"
+// @Success 204
+// @Router /projects/{id}/members/{userId} [delete]
+func (h *ProjectHandler) RemoveMember(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+	actorRole := c.MustGet("projectRole").(models.ProjectRole)
+
+	userID, err := strconv.Atoi(c.Param("userId"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
-	// Check if user is a member of the project
-	if !h.isProjectMember(userID.(uint), uint(projectID)) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied. You are not a member of this project"})
+	var membership models.UserProject
+	if err := h.db.Where("user_id = ? AND project_id = ?", userID, projectID).First(&membership).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this project"})
 		return
 	}
 
-	// Check if project exists
-	var project models.Project
-	if err := h.db.First(&project, projectID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	// Owner cannot be removed; transfer ownership first
+	if membership.Role == models.ProjectRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Project owner cannot be removed from the project"})
 		return
 	}
 
-	// Get all members of the project
-	var users []models.User
-	err = h.db.Joins("JOIN user_projects ON user_projects.user_id = users.id").
-		Where("user_projects.project_id = ?", projectID).
-		Select("users.id, users.username, users.name, users.email, users.avatar_url, users.created_at").
-		Find(&users).Error
+	if !canManageMember(actorRole, membership.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot remove a member with an equal or higher role"})
+		return
+	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project members"})
+	// Remove user from project
+	if err := h.db.Where("user_id = ? AND project_id = ?", userID, projectID).Delete(&models.UserProject{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove user from project"})
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
-}
+	// Stop live updates to the removed member's open connections
+	h.hub.DisconnectMember(projectID, uint(userID))
 
-// AddMemberRequest represents the request body for adding a member
-type AddMemberRequest struct {
-	UserID   *uint  `json:"user_id,omitempty"`
-	Email    string `json:"email,omitempty"`
-	Username string `json:"username,omitempty"`
+	c.Status(http.StatusNoContent)
 }
 
-// @Summary Add member to project
-// @Description Add a user to project by user_id, email, or username
+// @Summary Update member role
+// @Description Change the role of a project member (admin, member or viewer)
 // @Tags projects
 // @Security BearerAuth
 // @Param id path int true "Project ID"
-// @Param member body AddMemberRequest true "Member data"
-// @Success 201 {object} models.User
-// @Router /projects/{id}/members [post]
-func (h *ProjectHandler) AddMember(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-		return
-	}
+// @Param userId path int true "User ID"
+// @Param role body UpdateMemberRoleRequest true "New role"
+// @Success 200 {object} models.UserProject
+// @Router /projects/{id}/members/{userId}/role [put]
+func (h *ProjectHandler) UpdateMemberRole(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+	actorRole := c.MustGet("projectRole").(models.ProjectRole)
 
-	projectID, err := strconv.Atoi(c.Param("id"))
+	userID, err := strconv.Atoi(c.Param("userId"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-		return
-	}
-
-	// Check if user is a member of the project
-	if !h.isProjectMember(userID.(uint), uint(projectID)) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied. You are not a member of this project"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
-	var req AddMemberRequest
+	var req UpdateMemberRoleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Check if project exists
-	var project models.Project
-	if err := h.db.First(&project, projectID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	if !req.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
 		return
 	}
-
-	// Find user by different criteria
-	var user models.User
-	var query *gorm.DB
-
-	if req.UserID != nil {
-		query = h.db.Where("id = ?", *req.UserID)
-	} else if req.Email != "" {
-		query = h.db.Where("email = ?", req.Email)
-	} else if req.Username != "" {
-		query = h.db.Where("username = ?", req.Username)
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide user_id, email, or username"})
+	if req.Role == models.ProjectRoleOwner {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use transfer-ownership to change the project owner"})
 		return
 	}
 
-	if err := query.First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	var membership models.UserProject
+	if err := h.db.Where("user_id = ? AND project_id = ?", userID, projectID).First(&membership).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this project"})
 		return
 	}
 
-	// Check if user is already a member
-	var count int64
-	h.db.Table("user_projects").
-		Where("user_id = ? AND project_id = ?", user.ID, projectID).
-		Count(&count)
-
-	if count > 0 {
-		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member of this project"})
+	if !canManageMember(actorRole, membership.Role) || !canAssignRole(actorRole, req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change this member's role"})
 		return
 	}
 
-	// Add user to project
-	if err := h.db.Model(&project).Association("Users").Append(&user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add user to project"})
+	if req.Role.Rank() > models.ProjectRoleMember.Rank() && isBotUser(h.db, uint(userID)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bots can only be members or viewers"})
 		return
 	}
 
-	// Return user info (without sensitive data)
-	userResponse := models.User{
-		ID:        user.ID,
-		Username:  user.Username,
-		Name:      user.Name,
-		Email:     user.Email,
-		AvatarURL: user.AvatarURL,
-		CreatedAt: user.CreatedAt,
+	if err := h.db.Model(&models.UserProject{}).
+		Where("user_id = ? AND project_id = ?", userID, projectID).
+		Update("role", req.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member role"})
+		return
 	}
+	membership.Role = req.Role
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "User successfully added to project",
-		"user":    userResponse,
-	})
+	c.JSON(http.StatusOK, membership)
 }
 
-// @Summary Remove member from project
-// @Description Remove a user from project
+// @Summary Transfer project ownership
+// @Description Make another member the project owner; the current owner becomes an admin
 // @Tags projects
 // @Security BearerAuth
 // @Param id path int true "Project ID"
-// @Param userId path int true "User ID"
-// @Success 204
-// @Router /projects/{id}/members/{userId} [delete]
-func (h *ProjectHandler) RemoveMember(c *gin.Context) {
-	currentUserID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+// @Param request body TransferOwnershipRequest true "New owner"
+// @Success 200 {object} models.Project
+// @Router /projects/{id}/transfer-ownership [post]
+func (h *ProjectHandler) TransferOwnership(c *gin.Context) {
+	currentUserID := c.MustGet("userID").(uint)
+	project := *c.MustGet("project").(*models.Project)
+
+	var req TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	projectID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"}) 
+	if req.UserID == currentUserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You already own this project"})
 		return
 	}
 
-	userID, err := strconv.Atoi(c.Param("userId"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	if !isProjectMember(h.db, req.UserID, project.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this project"})
 		return
 	}
 
-	// Check if project exists
-	var project models.Project
-	if err := h.db.First(&project, projectID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	if isBotUser(h.db, req.UserID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bots can only be members or viewers"})
 		return
 	}
 
-	// Only creator can remove members
-	if project.CreatedBy == nil || *project.CreatedBy != currentUserID.(uint) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only project creator can remove members"})
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserProject{}).
+			Where("user_id = ? AND project_id = ?", currentUserID, project.ID).
+			Update("role", models.ProjectRoleAdmin).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.UserProject{}).
+			Where("user_id = ? AND project_id = ?", req.UserID, project.ID).
+			Update("role", models.ProjectRoleOwner).Error; err != nil {
+			return err
+		}
+		return tx.Model(&project).Updates(map[string]interface{}{
+			"created_by": req.UserID,
+			"version":    gorm.Expr("version + 1"),
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
 		return
 	}
+	project.CreatedBy = &req.UserID
+	project.Version++
+
+	c.JSON(http.StatusOK, project)
+}
 
-	// Creator cannot remove themselves
-	if project.CreatedBy != nil && uint(userID) == *project.CreatedBy {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Project creator cannot be removed from the project"})
+// UpdateSecurityRequest changes project-wide security settings
+type UpdateSecurityRequest struct {
+	RequireMFA *bool `json:"require_mfa" binding:"required"`
+}
+
+// @Summary Update project security settings
+// @Description Require two-factor authentication for all human members (owner only). Members without it lose access until they enable it.
+// @Tags projects
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param request body UpdateSecurityRequest true "Security settings"
+// @Success 200 {object} map[string]interface{}
+// @Router /projects/{id}/security [put]
+func (h *ProjectHandler) UpdateSecurity(c *gin.Context) {
+	userID := c.GetUint("userID")
+	project := *c.MustGet("project").(*models.Project)
+
+	var req UpdateSecurityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Check if user exists
-	var user models.User
-	if err := h.db.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	// Otherwise the owner would lock themselves out
+	if *req.RequireMFA && !hasMFA(h.db, userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Enable two-factor authentication on your own account first", "code": "mfa_required"})
 		return
 	}
 
-	// Check if user is a member of the project
-	var count int64
-	h.db.Table("user_projects").
-		Where("user_id = ? AND project_id = ?", userID, projectID).
-		Count(&count)
-
-	if count == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this project"})
+	if err := h.db.Model(&project).Updates(map[string]interface{}{
+		"require_mfa": *req.RequireMFA,
+		"version":     gorm.Expr("version + 1"),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update security settings"})
 		return
 	}
+	project.RequireMFA = *req.RequireMFA
+	project.Version++
 
-	// Remove user from project
-	if err := h.db.Model(&project).Association("Users").Delete(&user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove user from project"})
-		return
+	var pending []MemberResponse
+	if project.RequireMFA {
+		if err := h.db.Model(&models.User{}).
+			Joins("JOIN user_projects ON user_projects.user_id = users.id").
+			Where("user_projects.project_id = ? AND users.is_bot = ?", project.ID, false).
+			Where("NOT EXISTS (SELECT 1 FROM user_mfas WHERE user_mfas.user_id = users.id AND user_mfas.confirmed_at IS NOT NULL)").
+			Select("users.id, users.username, users.name, users.email, users.avatar_url, users.created_at, user_projects.role, user_projects.created_at AS joined_at").
+			Scan(&pending).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project members"})
+			return
+		}
 	}
 
-	c.Status(http.StatusNoContent)
+	c.JSON(http.StatusOK, gin.H{
+		"require_mfa": project.RequireMFA,
+		// Members who cannot reach the project until they enable 2FA
+		"members_without_mfa": pending,
+	})
+}
+
+// hasMFA reports whether userID has confirmed two-factor authentication.
+func hasMFA(db *gorm.DB, userID uint) bool {
+	var count int64
+	db.Model(&models.UserMFA{}).Where("user_id = ? AND confirmed_at IS NOT NULL", userID).Count(&count)
+	return count > 0
 }
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"devsync-be/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// belongsToProject reports whether the row with the given ID in model's
+// table is owned by projectID.
+func belongsToProject(db *gorm.DB, model interface{}, id, projectID uint) bool {
+	var count int64
+	db.Model(model).
+		Where("id = ? AND project_id = ?", id, projectID).
+		Count(&count)
+	return count > 0
+}
+
+// isProjectMember reports whether userID is a member of projectID.
+func isProjectMember(db *gorm.DB, userID, projectID uint) bool {
+	var count int64
+	db.Table("user_projects").
+		Where("user_id = ? AND project_id = ?", userID, projectID).
+		Count(&count)
+	return count > 0
+}
+
+// isBotUser reports whether userID is a bot account. Bots never hold a role
+// above member.
+func isBotUser(db *gorm.DB, userID uint) bool {
+	var user models.User
+	return db.Select("is_bot").First(&user, userID).Error == nil && user.IsBot
+}
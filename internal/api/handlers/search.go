@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"devsync-be/internal/api/middleware"
+	"devsync-be/internal/search"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// searchScopes is the token scope each result type needs. Documentation
+// belongs to the project itself.
+var searchScopes = map[string]string{
+	search.TypeFile:    "files:read",
+	search.TypeTask:    "tasks:read",
+	search.TypeMessage: "chat:read",
+	search.TypeDoc:     "projects:read",
+}
+
+type SearchHandler struct {
+	db *gorm.DB
+}
+
+func NewSearchHandler(db *gorm.DB) *SearchHandler {
+	return &SearchHandler{db: db}
+}
+
+// SearchResponse is one page of search results, best match first
+type SearchResponse struct {
+	Query   string          `json:"query"`
+	Mode    string          `json:"mode"`
+	Types   []string        `json:"types"`
+	Results []search.Result `json:"results"`
+	HasMore bool            `json:"has_more"`
+}
+
+// @Summary Search project
+// @Description Search files, tasks, chat messages and documentation of a project. mode text (default) matches words with web search syntax ("quoted phrases", or, -excluded) and ranks results; mode code finds a literal string such as an identifier, ranking whole-word matches first; mode regex matches a POSIX regular expression. API tokens only see the types their scopes allow.
+// @Tags search
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param q query string true "Search query"
+// @Param mode query string false "text, code or regex (default: text)"
+// @Param type query string false "Comma separated result types: file, task, message, doc (default: all)"
+// @Param case_sensitive query bool false "Match case in code and regex mode"
+// @Param limit query int false "Limit results (default: 20, max: 50)"
+// @Param offset query int false "Offset for pagination (default: 0)"
+// @Success 200 {object} SearchResponse
+// @Router /projects/{id}/search [get]
+func (h *SearchHandler) Search(c *gin.Context) {
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+	if len(text) > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is too long"})
+		return
+	}
+
+	mode := c.DefaultQuery("mode", search.ModeText)
+	if mode != search.ModeText && mode != search.ModeCode && mode != search.ModeRegex {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be text, code or regex"})
+		return
+	}
+
+	types, ok := searchTypes(c)
+	if !ok {
+		return
+	}
+
+	limit, offset := 20, 0
+	if parsedLimit := parseLimit(c.Query("limit")); parsedLimit > 0 && parsedLimit <= 50 {
+		limit = parsedLimit
+	}
+	if parsedOffset := parseLimit(c.Query("offset")); parsedOffset > 0 {
+		offset = parsedOffset
+	}
+
+	results, more, err := search.Search(h.db, search.Query{
+		ProjectID:     c.GetUint("projectID"),
+		Text:          text,
+		Mode:          mode,
+		Types:         types,
+		CaseSensitive: c.Query("case_sensitive") == "true",
+		Limit:         limit,
+		Offset:        offset,
+	})
+	if errors.Is(err, search.ErrInvalidPattern) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_pattern"})
+		return
+	}
+	if errors.Is(err, search.ErrTimeout) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "code": "search_timeout"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search project"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SearchResponse{Query: text, Mode: mode, Types: types, Results: results, HasMore: more})
+}
+
+// searchTypes returns the result types to search. Without ?type it is every
+// type the caller may read; asking for a type the token cannot read is an
+// error. It responds and returns false on errors.
+func searchTypes(c *gin.Context) ([]string, bool) {
+	param := c.Query("type")
+	if param == "" {
+		var types []string
+		for _, typ := range search.Types {
+			if middleware.HasScope(c, searchScopes[typ]) {
+				types = append(types, typ)
+			}
+		}
+		if len(types) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token has no scope to search this project"})
+			return nil, false
+		}
+		return types, true
+	}
+
+	requested := make(map[string]bool)
+	for _, typ := range strings.Split(param, ",") {
+		typ = strings.TrimSpace(typ)
+		scope, known := searchScopes[typ]
+		if !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown result type " + typ + "; use file, task, message or doc"})
+			return nil, false
+		}
+		if !middleware.HasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the required scope", "scope": scope})
+			return nil, false
+		}
+		requested[typ] = true
+	}
+
+	var types []string
+	for _, typ := range search.Types {
+		if requested[typ] {
+			types = append(types, typ)
+		}
+	}
+	return types, true
+}
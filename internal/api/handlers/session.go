@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"devsync-be/internal/auth"
+	"devsync-be/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+// RefreshTokenRequest represents the request body for refreshing a session
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// startSession opens a new session for user on the requesting device and
+// returns the access/refresh token pair to send to the client.
+func (h *AuthHandler) startSession(c *gin.Context, user *models.User) (gin.H, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:     user.ID,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(h.cfg.RefreshTokenTTL),
+	}
+
+	var refreshToken string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		refreshToken, err = h.issueRefreshToken(tx, &session)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h.tokenResponse(user.ID, user.Username, &session, refreshToken)
+}
+
+// issueRefreshToken stores a new refresh token for session and returns its
+// plaintext value.
+func (h *AuthHandler) issueRefreshToken(tx *gorm.DB, session *models.Session) (string, error) {
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	record := models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: session.ExpiresAt,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (h *AuthHandler) tokenResponse(userID uint, username string, session *models.Session, refreshToken string) (gin.H, error) {
+	accessToken, err := auth.GenerateToken(h.keys, userID, username, session.ID, h.cfg.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":              accessToken,
+		"access_token":       accessToken,
+		"token_type":         "Bearer",
+		"expires_in":         int(h.cfg.AccessTokenTTL.Seconds()),
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": session.ExpiresAt,
+		"session_id":         session.ID,
+	}, nil
+}
+
+// revokeSessions revokes every active session matched by query.
+func revokeSessions(query *gorm.DB, reason string) error {
+	return query.Model(&models.Session{}).
+		Where("revoked_at IS NULL").
+		Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"revoked_reason": reason,
+		}).Error
+}
+
+// @Summary Refresh token
+// @Description Exchange a refresh token for a new access token and a rotated refresh token. Reusing an already exchanged refresh token revokes the whole session.
+// @Tags auth
+// @Param request body RefreshTokenRequest true "Refresh token"
+// @Success 200 {object} map[string]interface{}
+// @Router /auth/refresh [post]
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var record models.RefreshToken
+	if err := h.db.Preload("Session.User").
+		Where("token_hash = ?", auth.HashToken(req.RefreshToken)).
+		First(&record).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	session := record.Session
+	if !session.IsActive() || time.Now().After(record.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+		return
+	}
+
+	var refreshToken string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Only the first exchange may flip used_at; a second one is reuse
+		now := time.Now()
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", record.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+
+		session.LastSeenAt = now
+		session.IPAddress = c.ClientIP()
+		session.UserAgent = c.Request.UserAgent()
+		session.ExpiresAt = now.Add(h.cfg.RefreshTokenTTL)
+		if err := tx.Model(&session).Select("last_seen_at", "ip_address", "user_agent", "expires_at").Updates(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		refreshToken, err = h.issueRefreshToken(tx, &session)
+		return err
+	})
+	if err == errRefreshTokenReused {
+		// A stolen token was replayed; kill the session for both parties
+		revokeSessions(h.db.Where("id = ?", session.ID), "refresh_token_reuse")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected; session revoked"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	response, err := h.tokenResponse(session.UserID, session.User.Username, &session, refreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Logout
+// @Description Revoke the current session
+// @Tags auth
+// @Security BearerAuth
+// @Success 204
+// @Router /auth/logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	sessionID := c.GetUint("sessionID")
+
+	if err := revokeSessions(h.db.Where("id = ?", sessionID), "logout"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Logout everywhere
+// @Description Revoke every session of the current user, including this one
+// @Tags auth
+// @Security BearerAuth
+// @Success 204
+// @Router /auth/logout-all [post]
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	if err := revokeSessions(h.db.Where("user_id = ?", userID), "logout_all"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Get sessions
+// @Description List active sessions of the current user
+// @Tags auth
+// @Security BearerAuth
+// @Success 200 {array} map[string]interface{}
+// @Router /me/sessions [get]
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	userID := c.GetUint("userID")
+	currentSessionID := c.GetUint("sessionID")
+
+	var sessions []models.Session
+	if err := h.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	response := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, gin.H{
+			"id":           session.ID,
+			"user_agent":   session.UserAgent,
+			"ip_address":   session.IPAddress,
+			"last_seen_at": session.LastSeenAt,
+			"created_at":   session.CreatedAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      session.ID == currentSessionID,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Revoke session
+// @Description Revoke one of the current user's sessions
+// @Tags auth
+// @Security BearerAuth
+// @Param sessionId path int true "Session ID"
+// @Success 204
+// @Router /me/sessions/{sessionId} [delete]
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	sessionID, err := strconv.Atoi(c.Param("sessionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	var session models.Session
+	if err := h.db.Where("user_id = ?", userID).First(&session, sessionID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := revokeSessions(h.db.Where("id = ?", session.ID), "revoked_by_user"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
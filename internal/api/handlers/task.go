@@ -10,6 +10,7 @@ import (
 
     "github.com/gin-gonic/gin"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
 )
 
 type TaskHandler struct {
@@ -24,6 +25,18 @@ func NewTaskHandler(db *gorm.DB, hub *websocket.Hub) *TaskHandler {
     }
 }
 
+// validateTaskRefs makes sure the sprint and assignee referenced by a task
+// belong to the task's project. It returns an error message, or "" if valid.
+func (h *TaskHandler) validateTaskRefs(task *models.Task) string {
+    if task.SprintID != nil && !belongsToProject(h.db, &models.Sprint{}, *task.SprintID, task.ProjectID) {
+        return "Sprint does not belong to this project"
+    }
+    if task.AssigneeID != nil && !isProjectMember(h.db, *task.AssigneeID, task.ProjectID) {
+        return "Assignee is not a member of this project"
+    }
+    return ""
+}
+
 // @Summary Get tasks
 // @Description Get all tasks in a project
 // @Tags tasks
@@ -32,16 +45,14 @@ func NewTaskHandler(db *gorm.DB, hub *websocket.Hub) *TaskHandler {
 // @Success 200 {array} models.Task
 // @Router /projects/{id}/tasks [get]
 func (h *TaskHandler) GetTasks(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     var tasks []models.Task
     if err := h.db.Where("project_id = ?", projectID).
         Preload("Assignee").
         Preload("Sprint").
+        Preload("Creator").
+        Preload("Updater").
         Preload("Comments").
         Find(&tasks).Error; err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tasks"})
@@ -60,11 +71,7 @@ func (h *TaskHandler) GetTasks(c *gin.Context) {
 // @Success 201 {object} models.Task
 // @Router /projects/{id}/tasks [post]
 func (h *TaskHandler) CreateTask(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     var task models.Task
     if err := c.ShouldBindJSON(&task); err != nil {
@@ -72,20 +79,33 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
         return
     }
 
-    task.ProjectID = uint(projectID)
+    task.ID = 0
+    task.ProjectID = projectID
+    task.Version = 1
+
+    // Record who created the task; bots show up as their own user
+    actorID := c.GetUint("userID")
+    task.CreatedBy = &actorID
+    task.UpdatedBy = &actorID
+
+    if msg := h.validateTaskRefs(&task); msg != "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+        return
+    }
 
-    if err := h.db.Create(&task).Error; err != nil {
+    if err := h.db.Omit(clause.Associations).Create(&task).Error; err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create task"})
         return
     }
 
     // Load relationships
-    h.db.Preload("Assignee").Preload("Sprint").First(&task, task.ID)
+    h.db.Preload("Assignee").Preload("Sprint").Preload("Creator").Preload("Updater").First(&task, task.ID)
 
     // Broadcast task creation to WebSocket clients
     message := map[string]interface{}{
         "type":       "task_created",
         "project_id": projectID,
+        "user_id":    c.GetUint("userID"),
         "data":       task,
     }
     if msgBytes, err := json.Marshal(message); err == nil {
@@ -95,21 +115,44 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
     c.JSON(http.StatusCreated, task)
 }
 
+// @Summary Get task
+// @Description Get task by ID. The ETag header is needed to update or delete it.
+// @Tags tasks
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param taskId path int true "Task ID"
+// @Success 200 {object} models.Task
+// @Router /projects/{id}/tasks/{taskId} [get]
+func (h *TaskHandler) GetTask(c *gin.Context) {
+    projectID := c.GetUint("projectID")
+
+    taskID, err := strconv.Atoi(c.Param("taskId"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+        return
+    }
+
+    task, ok := h.loadTask(c, projectID, uint(taskID))
+    if !ok {
+        return
+    }
+
+    respondWithETag(c, entityTag(task.Version), task)
+}
+
 // @Summary Update task
-// @Description Update task by ID
+// @Description Update task by ID. Requires If-Match with the task's ETag; responds 412 with the current task if it changed.
 // @Tags tasks
 // @Security BearerAuth
 // @Param id path int true "Project ID"
 // @Param taskId path int true "Task ID"
+// @Param If-Match header string true "ETag of the task"
 // @Param task body models.Task true "Task data"
 // @Success 200 {object} models.Task
+// @Failure 412 {object} map[string]interface{}
 // @Router /projects/{id}/tasks/{taskId} [put]
 func (h *TaskHandler) UpdateTask(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     taskID, err := strconv.Atoi(c.Param("taskId"))
     if err != nil {
@@ -117,52 +160,77 @@ func (h *TaskHandler) UpdateTask(c *gin.Context) {
         return
     }
 
-    var task models.Task
-    if err := h.db.First(&task, taskID).Error; err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+    task, ok := h.loadTask(c, projectID, uint(taskID))
+    if !ok {
         return
     }
+    if !checkIfMatch(c, entityTag(task.Version), task) {
+        return
+    }
+    createdBy, version := task.CreatedBy, task.Version
 
-    if err := c.ShouldBindJSON(&task); err != nil {
+    if err := c.ShouldBindJSON(task); err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
-    if err := h.db.Save(&task).Error; err != nil {
+    // The body must not move the task to another row or project
+    task.ID = uint(taskID)
+    task.ProjectID = projectID
+    task.Version = version + 1
+
+    actorID := c.GetUint("userID")
+    task.CreatedBy = createdBy
+    task.UpdatedBy = &actorID
+
+    if msg := h.validateTaskRefs(task); msg != "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+        return
+    }
+
+    // Only update the row if nobody else did since the If-Match check
+    result := h.db.Select("*").Omit(clause.Associations).Where("version = ?", version).Save(task)
+    if result.Error != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update task"})
         return
     }
+    if result.RowsAffected == 0 {
+        if current, ok := h.loadTask(c, projectID, task.ID); ok {
+            preconditionFailed(c, entityTag(current.Version), current)
+        }
+        return
+    }
 
     // Load relationships
-    h.db.Preload("Assignee").Preload("Sprint").First(&task, task.ID)
+    h.db.Preload("Assignee").Preload("Sprint").Preload("Creator").Preload("Updater").First(task, task.ID)
 
     // Broadcast task update to WebSocket clients
     message := map[string]interface{}{
         "type":       "task_updated",
         "project_id": projectID,
+        "user_id":    c.GetUint("userID"),
         "data":       task,
     }
     if msgBytes, err := json.Marshal(message); err == nil {
         h.hub.Broadcast(msgBytes)
     }
 
+    c.Header("ETag", entityTag(task.Version))
     c.JSON(http.StatusOK, task)
 }
 
 // @Summary Delete task
-// @Description Delete task by ID
+// @Description Delete task by ID. Requires If-Match with the task's ETag.
 // @Tags tasks
 // @Security BearerAuth
 // @Param id path int true "Project ID"
 // @Param taskId path int true "Task ID"
+// @Param If-Match header string true "ETag of the task"
 // @Success 204
+// @Failure 412 {object} map[string]interface{}
 // @Router /projects/{id}/tasks/{taskId} [delete]
 func (h *TaskHandler) DeleteTask(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     taskID, err := strconv.Atoi(c.Param("taskId"))
     if err != nil {
@@ -170,16 +238,32 @@ func (h *TaskHandler) DeleteTask(c *gin.Context) {
         return
     }
 
-    if err := h.db.Delete(&models.Task{}, taskID).Error; err != nil {
+    task, ok := h.loadTask(c, projectID, uint(taskID))
+    if !ok {
+        return
+    }
+    if !checkIfMatch(c, entityTag(task.Version), task) {
+        return
+    }
+
+    result := h.db.Where("project_id = ? AND version = ?", projectID, task.Version).Delete(&models.Task{}, taskID)
+    if result.Error != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete task"})
         return
     }
+    if result.RowsAffected == 0 {
+        if current, ok := h.loadTask(c, projectID, task.ID); ok {
+            preconditionFailed(c, entityTag(current.Version), current)
+        }
+        return
+    }
 
     // Broadcast task deletion to WebSocket clients
     message := map[string]interface{}{
         "type":       "task_deleted",
         "project_id": projectID,
-        "data":       map[string]interface{}{"id": taskID},
+        "user_id":    c.GetUint("userID"),
+        "data":       map[string]interface{}{"id": taskID, "version": task.Version},
     }
     if msgBytes, err := json.Marshal(message); err == nil {
         h.hub.Broadcast(msgBytes)
@@ -188,6 +272,23 @@ func (h *TaskHandler) DeleteTask(c *gin.Context) {
     c.Status(http.StatusNoContent)
 }
 
+// loadTask fetches a task of the project with its relationships. It
+// responds 404 and returns false if there is none.
+func (h *TaskHandler) loadTask(c *gin.Context, projectID, taskID uint) (*models.Task, bool) {
+    var task models.Task
+    if err := h.db.Where("project_id = ?", projectID).
+        Preload("Assignee").
+        Preload("Sprint").
+        Preload("Creator").
+        Preload("Updater").
+        Preload("Comments").
+        First(&task, taskID).Error; err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+        return nil, false
+    }
+    return &task, true
+}
+
 // @Summary Get sprints
 // @Description Get all sprints in a project
 // @Tags sprints
@@ -196,11 +297,7 @@ func (h *TaskHandler) DeleteTask(c *gin.Context) {
 // @Success 200 {array} models.Sprint
 // @Router /projects/{id}/sprints [get]
 func (h *TaskHandler) GetSprints(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     var sprints []models.Sprint
     if err := h.db.Where("project_id = ?", projectID).
@@ -222,11 +319,7 @@ func (h *TaskHandler) GetSprints(c *gin.Context) {
 // @Success 201 {object} models.Sprint
 // @Router /projects/{id}/sprints [post]
 func (h *TaskHandler) CreateSprint(c *gin.Context) {
-    projectID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-        return
-    }
+    projectID := c.GetUint("projectID")
 
     var sprint models.Sprint
     if err := c.ShouldBindJSON(&sprint); err != nil {
@@ -234,9 +327,10 @@ func (h *TaskHandler) CreateSprint(c *gin.Context) {
         return
     }
 
-    sprint.ProjectID = uint(projectID)
+    sprint.ID = 0
+    sprint.ProjectID = projectID
 
-    if err := h.db.Create(&sprint).Error; err != nil {
+    if err := h.db.Omit(clause.Associations).Create(&sprint).Error; err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sprint"})
         return
     }
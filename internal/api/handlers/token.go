@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"devsync-be/internal/auth"
+	"devsync-be/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TokenHandler struct {
+	db *gorm.DB
+}
+
+func NewTokenHandler(db *gorm.DB) *TokenHandler {
+	return &TokenHandler{db: db}
+}
+
+// CreateTokenRequest represents the request body for creating an API token
+type CreateTokenRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ProjectID *uint      `json:"project_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// createAPIToken validates the requested scopes and stores a new token for
+// userID. The plaintext token is returned once and never stored.
+func createAPIToken(db *gorm.DB, userID uint, prefix string, req CreateTokenRequest) (*models.APIToken, string, error) {
+	if len(req.Scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !models.ValidTokenScope(scope) {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("expires_at must be in the future")
+	}
+
+	secret, err := auth.RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := prefix + secret
+
+	token := models.APIToken{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    plaintext[:len(prefix)+6],
+		TokenHash: auth.HashToken(plaintext),
+		Scopes:    strings.Join(req.Scopes, " "),
+		ProjectID: req.ProjectID,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := db.Create(&token).Error; err != nil {
+		return nil, "", err
+	}
+
+	return &token, plaintext, nil
+}
+
+// @Summary List personal access tokens
+// @Description List the current user's personal access tokens
+// @Tags tokens
+// @Security BearerAuth
+// @Success 200 {array} models.APIToken
+// @Router /me/tokens [get]
+func (h *TokenHandler) GetTokens(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var tokens []models.APIToken
+	if err := h.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// @Summary Create personal access token
+// @Description Create a scoped personal access token, optionally restricted to one project. The token is only shown once.
+// @Tags tokens
+// @Security BearerAuth
+// @Param token body CreateTokenRequest true "Token data"
+// @Success 201 {object} map[string]interface{}
+// @Router /me/tokens [post]
+func (h *TokenHandler) CreateToken(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ProjectID != nil && !isProjectMember(h.db, userID, *req.ProjectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: You are not a member of this project"})
+		return
+	}
+
+	token, plaintext, err := createAPIToken(h.db, userID, models.PersonalTokenPrefix, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":   plaintext,
+		"details": token,
+	})
+}
+
+// @Summary Revoke personal access token
+// @Description Revoke one of the current user's personal access tokens
+// @Tags tokens
+// @Security BearerAuth
+// @Param tokenId path int true "Token ID"
+// @Success 204
+// @Router /me/tokens/{tokenId} [delete]
+func (h *TokenHandler) RevokeToken(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	tokenID, err := strconv.Atoi(c.Param("tokenId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	result := h.db.Model(&models.APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
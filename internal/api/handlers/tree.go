@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"devsync-be/internal/filetree"
+	"devsync-be/internal/gitstore"
+	"devsync-be/internal/models"
+	"devsync-be/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TreeHandler struct {
+	db    *gorm.DB
+	hub   *websocket.Hub
+	repos *gitstore.Store
+}
+
+func NewTreeHandler(db *gorm.DB, hub *websocket.Hub, repos *gitstore.Store) *TreeHandler {
+	return &TreeHandler{db: db, hub: hub, repos: repos}
+}
+
+// TreeResponse lists the direct children of a folder
+type TreeResponse struct {
+	Path     string          `json:"path"`
+	Children []filetree.Node `json:"children"`
+}
+
+// CreateFolderRequest creates a folder and its missing parents
+type CreateFolderRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// MoveRequest moves a file or folder to Path, or renames it to Name in
+// the same folder
+type MoveRequest struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+// target returns the clean destination path of a move from dir. It
+// responds 400 and returns false if there is none.
+func (r MoveRequest) target(c *gin.Context, dir string) (string, bool) {
+	p := r.Path
+	if p == "" {
+		if r.Name == "" || strings.Contains(r.Name, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Either path or a name without slashes is required", "code": "invalid_path"})
+			return "", false
+		}
+		p = filetree.Join(dir, r.Name)
+	}
+
+	cleaned, err := filetree.Clean(p)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_path"})
+		return "", false
+	}
+	return cleaned, true
+}
+
+// @Summary Get file tree
+// @Description List the folders and files directly inside a folder, without file content. Load deeper levels by passing a folder path.
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param path query string false "Folder path (default: project root)"
+// @Success 200 {object} TreeResponse
+// @Router /projects/{id}/tree [get]
+func (h *TreeHandler) GetTree(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	dir := c.Query("path")
+	if strings.Trim(dir, "/") != "" {
+		cleaned, err := filetree.Clean(dir)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_path"})
+			return
+		}
+		dir = cleaned
+	} else {
+		dir = ""
+	}
+
+	children, err := filetree.List(h.db, projectID, dir)
+	if errors.Is(err, filetree.ErrFolderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch file tree"})
+		return
+	}
+
+	c.JSON(http.StatusOK, TreeResponse{Path: dir, Children: children})
+}
+
+// @Summary Create folder
+// @Description Create a folder. Missing parent folders are created too.
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param request body CreateFolderRequest true "Folder path"
+// @Success 201 {object} models.Folder
+// @Failure 409 {object} map[string]interface{}
+// @Router /projects/{id}/folders [post]
+func (h *TreeHandler) CreateFolder(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	var req CreateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p, err := filetree.Clean(req.Path)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_path"})
+		return
+	}
+
+	var folder *models.Folder
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		folder, err = filetree.CreateFolder(tx, projectID, c.GetUint("userID"), p)
+		return err
+	})
+	if respondTreeError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
+		return
+	}
+
+	h.broadcast(projectID, "folder_created", folder)
+	c.JSON(http.StatusCreated, folder)
+}
+
+// @Summary Move folder
+// @Description Move or rename a folder together with everything in it, in one transaction. Send the new full path, or only a new name to rename it in place.
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param folderId path int true "Folder ID"
+// @Param request body MoveRequest true "New path or name"
+// @Success 200 {object} models.Folder
+// @Failure 409 {object} map[string]interface{}
+// @Router /projects/{id}/folders/{folderId}/move [post]
+func (h *TreeHandler) MoveFolder(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	folder, ok := h.loadFolder(c, projectID)
+	if !ok {
+		return
+	}
+
+	var req MoveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	target, ok := req.target(c, folder.Dir)
+	if !ok {
+		return
+	}
+
+	oldPath := folder.Path
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return filetree.MoveFolder(tx, folder, c.GetUint("userID"), target)
+	})
+	if errors.Is(err, filetree.ErrFolderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if respondTreeError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move folder"})
+		return
+	}
+	h.repos.Record(projectID, c.GetUint("userID"), "Move "+oldPath+" to "+folder.Path)
+
+	h.broadcast(projectID, "folder_moved", map[string]interface{}{
+		"id":       folder.ID,
+		"old_path": oldPath,
+		"path":     folder.Path,
+		"name":     folder.Name,
+		"dir":      folder.Dir,
+	})
+	c.JSON(http.StatusOK, folder)
+}
+
+// @Summary Delete folder
+// @Description Delete a folder together with every file and folder in it
+// @Tags files
+// @Security BearerAuth
+// @Param id path int true "Project ID"
+// @Param folderId path int true "Folder ID"
+// @Success 204
+// @Router /projects/{id}/folders/{folderId} [delete]
+func (h *TreeHandler) DeleteFolder(c *gin.Context) {
+	projectID := c.GetUint("projectID")
+
+	folder, ok := h.loadFolder(c, projectID)
+	if !ok {
+		return
+	}
+
+	var fileIDs []uint
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		fileIDs, err = filetree.DeleteFolder(tx, folder)
+		return err
+	})
+	if errors.Is(err, filetree.ErrFolderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+		return
+	}
+	for _, fileID := range fileIDs {
+		h.hub.FileDeleted(projectID, fileID)
+	}
+	h.repos.Record(projectID, c.GetUint("userID"), "Delete "+folder.Path)
+
+	h.broadcast(projectID, "folder_deleted", map[string]interface{}{
+		"id":       folder.ID,
+		"path":     folder.Path,
+		"file_ids": fileIDs,
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TreeHandler) loadFolder(c *gin.Context, projectID uint) (*models.Folder, bool) {
+	folderID, err := strconv.Atoi(c.Param("folderId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return nil, false
+	}
+
+	var folder models.Folder
+	if err := h.db.Where("project_id = ?", projectID).First(&folder, folderID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return nil, false
+	}
+	return &folder, true
+}
+
+// broadcast sends one event for a change to the tree
+func (h *TreeHandler) broadcast(projectID uint, eventType string, data interface{}) {
+	message := map[string]interface{}{
+		"type":       eventType,
+		"project_id": projectID,
+		"data":       data,
+	}
+	if msgBytes, err := json.Marshal(message); err == nil {
+		h.hub.Broadcast(msgBytes)
+	}
+}
+
+// respondTreeError responds 409 for a change that does not fit the file
+// tree and reports whether it did.
+func respondTreeError(c *gin.Context, err error) bool {
+	var code string
+	switch {
+	case errors.Is(err, filetree.ErrPathExists):
+		code = "path_exists"
+	case errors.Is(err, filetree.ErrParentNotFolder):
+		code = "parent_not_folder"
+	case errors.Is(err, filetree.ErrMoveIntoSelf):
+		code = "move_into_self"
+	default:
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": code})
+	return true
+}
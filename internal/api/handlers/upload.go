@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"path/filepath"
-	"strconv"
 	"strings"
 
+	"devsync-be/internal/filetree"
 	"devsync-be/internal/models"
 	"devsync-be/internal/storage"
 
@@ -15,6 +15,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// maxUploadSize is the largest file stored in GCS, by upload or import
+const maxUploadSize = 10 * 1024 * 1024
+
 type UploadHandler struct {
 	db      *gorm.DB
 	storage *storage.GCSStorage
@@ -33,14 +36,12 @@ func NewUploadHandler(db *gorm.DB, storage *storage.GCSStorage) *UploadHandler {
 // @Security BearerAuth
 // @Param id path int true "Project ID"
 // @Param file formData file true "File to upload"
+// @Param path formData string false "Path in the file tree (default: the file name at the project root)"
 // @Success 201 {object} models.File
+// @Failure 409 {object} map[string]interface{}
 // @Router /projects/{id}/upload [post]
 func (h *UploadHandler) UploadFile(c *gin.Context) {
-	projectID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-		return
-	}
+	projectID := c.GetUint("projectID")
 
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -55,14 +56,26 @@ func (h *UploadHandler) UploadFile(c *gin.Context) {
 	}
 
 	// Validate file size (max 10MB)
-	if file.Size > 10*1024*1024 {
+	if file.Size > maxUploadSize {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "File size too large (max 10MB)"})
 		return
 	}
 
+	treePath, err := filetree.Clean(c.DefaultPostForm("path", file.Filename))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_path"})
+		return
+	}
+	// Checked again when the file is saved; this avoids most uploads
+	// that would be thrown away
+	if exists, err := filetree.Exists(h.db, projectID, treePath); err == nil && exists {
+		respondTreeError(c, fmt.Errorf("%w: %s", filetree.ErrPathExists, treePath))
+		return
+	}
+
 	// Determine file type
 	fileType := getFileType(file.Filename)
-	folder := fmt.Sprintf("projects/%d/%s", projectID, fileType)
+	folder := storage.ProjectFolder(projectID) + fileType
 
 	// Upload to GCS
 	ctx := context.Background()
@@ -74,17 +87,25 @@ func (h *UploadHandler) UploadFile(c *gin.Context) {
 
 	// Save file info to database
 	fileModel := models.File{
-		Name:       file.Filename,
-		Path:       filepath.Join(folder, file.Filename),
+		Path:       treePath,
 		FileURL:    fileURL,
 		FileType:   fileType,
 		FileSize:   file.Size,
 		MimeType:   file.Header.Get("Content-Type"),
-		ProjectID:  uint(projectID),
+		ProjectID:  projectID,
 		UploadedBy: userID.(uint),
 	}
 
-	if err := h.db.Create(&fileModel).Error; err != nil {
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := filetree.Place(tx, &fileModel); err != nil {
+			return err
+		}
+		return tx.Create(&fileModel).Error
+	})
+	if respondTreeError(c, err) {
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file info"})
 		return
 	}
@@ -3,13 +3,20 @@ package middleware
 import (
     "net/http"
     "strings"
+    "time"
 
     "devsync-be/internal/auth"
+    "devsync-be/internal/models"
 
     "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware accepts either a session-bound access token (JWT) or an API
+// token (personal access token or bot key). For API tokens the granted
+// scopes and optional project restriction are stored in the context so
+// RequireScope and ProjectAccess can enforce them.
+func AuthMiddleware(keys *auth.KeySet, db *gorm.DB) gin.HandlerFunc {
     return func(c *gin.Context) {
         authHeader := c.GetHeader("Authorization")
         if authHeader == "" {
@@ -25,15 +32,160 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
             return
         }
 
-        claims, err := auth.ValidateToken(tokenString, jwtSecret)
+        if strings.HasPrefix(tokenString, models.PersonalTokenPrefix) || strings.HasPrefix(tokenString, models.BotTokenPrefix) {
+            if authenticateAPIToken(c, db, tokenString) {
+                c.Next()
+            }
+            return
+        }
+
+        claims, err := auth.ValidateToken(tokenString, keys)
         if err != nil {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
             c.Abort()
             return
         }
 
+        // Access tokens are only honoured while their session is active
+        var session models.Session
+        if claims.SessionID == 0 || db.First(&session, claims.SessionID).Error != nil ||
+            session.UserID != claims.UserID || !session.IsActive() {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+            c.Abort()
+            return
+        }
+
+        // Keep last-seen fresh without writing on every request
+        if time.Since(session.LastSeenAt) > time.Minute {
+            db.Model(&session).Update("last_seen_at", time.Now())
+        }
+
         c.Set("userID", claims.UserID)
         c.Set("username", claims.Username)
+        c.Set("sessionID", claims.SessionID)
+        c.Set("authMethod", "session")
+        c.Next()
+    }
+}
+
+// authenticateAPIToken stores the user and grants of an API token in the
+// context. It responds 401 and returns false if the token is not valid.
+func authenticateAPIToken(c *gin.Context, db *gorm.DB, tokenString string) bool {
+    var token models.APIToken
+    if err := db.Preload("User").
+        Where("token_hash = ?", auth.HashToken(tokenString)).
+        First(&token).Error; err != nil || token.User.ID == 0 {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+        c.Abort()
+        return false
+    }
+
+    if !token.IsActive() {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Token expired or revoked"})
+        c.Abort()
+        return false
+    }
+
+    if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > time.Minute {
+        db.Model(&token).Update("last_used_at", time.Now())
+    }
+
+    c.Set("userID", token.UserID)
+    c.Set("username", token.User.Username)
+    c.Set("isBot", token.User.IsBot)
+    c.Set("authMethod", "token")
+    c.Set("tokenScopes", token.ScopeList())
+    if token.ProjectID != nil {
+        c.Set("tokenProjectID", *token.ProjectID)
+    }
+    return true
+}
+
+// GitAuth authenticates git clients, which send an API token as the
+// password of HTTP basic auth; the username is ignored. The token needs
+// the files:read scope. A ".git" suffix on the :id route parameter is
+// dropped so ProjectAccess can follow.
+func GitAuth(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        for i, param := range c.Params {
+            if param.Key == "id" {
+                c.Params[i].Value = strings.TrimSuffix(param.Value, ".git")
+            }
+        }
+
+        // Git only sends credentials after a challenge
+        c.Header("WWW-Authenticate", `Basic realm="DevSync"`)
+        _, password, ok := c.Request.BasicAuth()
+        if !ok || !(strings.HasPrefix(password, models.PersonalTokenPrefix) || strings.HasPrefix(password, models.BotTokenPrefix)) {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "A personal access token is required as the password"})
+            c.Abort()
+            return
+        }
+        if !authenticateAPIToken(c, db, password) {
+            return
+        }
+
+        for _, scope := range c.GetStringSlice("tokenScopes") {
+            if scope == "files:read" {
+                c.Writer.Header().Del("WWW-Authenticate")
+                c.Next()
+                return
+            }
+        }
+        c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the required scope", "scope": "files:read"})
+        c.Abort()
+    }
+}
+
+// RequireScope limits API tokens to the resources they were granted. GET and
+// HEAD requests need "<resource>:read", anything else "<resource>:write".
+// Session-authenticated requests are not restricted.
+func RequireScope(resource string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.GetString("authMethod") != "token" {
+            c.Next()
+            return
+        }
+
+        action := "write"
+        if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+            action = "read"
+        }
+        required := resource + ":" + action
+
+        if HasScope(c, required) {
+            c.Next()
+            return
+        }
+
+        c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the required scope", "scope": required})
+        c.Abort()
+    }
+}
+
+// HasScope reports whether the request may use scope, for handlers that
+// serve several resources. Only API tokens are limited by scopes.
+func HasScope(c *gin.Context, scope string) bool {
+    if c.GetString("authMethod") != "token" {
+        return true
+    }
+    for _, granted := range c.GetStringSlice("tokenScopes") {
+        if granted == scope {
+            return true
+        }
+    }
+    return false
+}
+
+// RequireSession rejects API tokens, for endpoints such as token and session
+// management that must only be reachable by an interactive login.
+func RequireSession() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.GetString("authMethod") != "session" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires an interactive login"})
+            c.Abort()
+            return
+        }
         c.Next()
     }
-}
\ No newline at end of file
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"devsync-be/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// projectScopedParams maps route parameters that reference project-owned
+// rows to the model they point at, so a child ID from another project is
+// rejected before any handler runs.
+var projectScopedParams = []struct {
+	param    string
+	model    interface{}
+	invalid  string
+	notFound string
+}{
+	{"fileId", &models.File{}, "Invalid file ID", "File not found"},
+	{"taskId", &models.Task{}, "Invalid task ID", "Task not found"},
+	{"sprintId", &models.Sprint{}, "Invalid sprint ID", "Sprint not found"},
+	{"folderId", &models.Folder{}, "Invalid folder ID", "Folder not found"},
+}
+
+// ProjectAccess resolves the :id route parameter, verifies the current user
+// is a member of that project and stores the project, its ID and the
+// caller's role in the gin context.
+func ProjectAccess(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil || id <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+
+		// Project-restricted API tokens only reach their own project
+		if tokenProjectID, restricted := c.Get("tokenProjectID"); restricted && tokenProjectID.(uint) != uint(id) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token is restricted to another project"})
+			return
+		}
+
+		var project models.Project
+		if err := db.First(&project, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			}
+			return
+		}
+
+		var membership models.UserProject
+		if err := db.Where("user_id = ? AND project_id = ?", userID, project.ID).
+			First(&membership).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied: You are not a member of this project"})
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			}
+			return
+		}
+
+		// Bots have no second factor to enroll; human members do
+		if project.RequireMFA && !c.GetBool("isBot") {
+			var enrolled int64
+			if err := db.Model(&models.UserMFA{}).
+				Where("user_id = ? AND confirmed_at IS NOT NULL", userID).
+				Count(&enrolled).Error; err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if enrolled == 0 {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "This project requires two-factor authentication; enable it in your account settings",
+					"code":  "mfa_required",
+				})
+				return
+			}
+		}
+
+		for _, scoped := range projectScopedParams {
+			raw := c.Param(scoped.param)
+			if raw == "" {
+				continue
+			}
+
+			childID, err := strconv.Atoi(raw)
+			if err != nil || childID <= 0 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": scoped.invalid})
+				return
+			}
+
+			var childCount int64
+			if err := db.Model(scoped.model).
+				Where("id = ? AND project_id = ?", childID, project.ID).
+				Count(&childCount).Error; err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if childCount == 0 {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": scoped.notFound})
+				return
+			}
+		}
+
+		c.Set("project", &project)
+		c.Set("projectID", project.ID)
+		c.Set("projectRole", membership.Role)
+		c.Next()
+	}
+}
+
+// RequireProjectPermission aborts with 403 unless the role stored by
+// ProjectAccess grants the given permission.
+func RequireProjectPermission(permission models.ProjectPermission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("projectRole")
+		projectRole, ok := role.(models.ProjectRole)
+		if !ok || !projectRole.Can(permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":      "Access denied: your project role does not allow this action",
+				"role":       projectRole,
+				"permission": permission,
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+    "devsync-be/internal/auth"
+    "devsync-be/internal/config"
+)
+
+// loginProviders builds the external login providers enabled in cfg. Each
+// provider calls back to /api/v1/auth/providers/<name>/callback.
+func loginProviders(cfg *config.Config) auth.Providers {
+    providers := auth.Providers{}
+    callbackURL := func(name string) string {
+        return cfg.PublicURL + "/api/v1/auth/providers/" + name + "/callback"
+    }
+
+    if cfg.GitHubClientID != "" {
+        providers.Register(auth.NewGitHubProvider(cfg.GitHubClientID, cfg.GitHubSecret, callbackURL("github")))
+    }
+
+    if cfg.OIDCIssuerURL != "" {
+        name := cfg.OIDCProviderName
+        providers.Register(auth.NewOIDCProvider(name, cfg.OIDCIssuerURL, cfg.OIDCClientID, cfg.OIDCClientSecret, callbackURL(name), cfg.OIDCScopes))
+    }
+
+    return providers
+}
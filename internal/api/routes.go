@@ -4,9 +4,14 @@ import (
     "time"
     
     "devsync-be/internal/api/handlers"
+    "devsync-be/internal/auth"
     "devsync-be/internal/storage"
     "devsync-be/internal/api/middleware"
     "devsync-be/internal/config"
+    "devsync-be/internal/gitstore"
+    "devsync-be/internal/mailer"
+    "devsync-be/internal/models"
+    "devsync-be/internal/secrets"
     "devsync-be/internal/websocket"
 
     "github.com/gin-gonic/gin"
@@ -16,7 +21,7 @@ import (
     "gorm.io/gorm"
 )
 
-func SetupRoutes(r *gin.Engine, db *gorm.DB, hub *websocket.Hub, cfg *config.Config, gcsStorage *storage.GCSStorage) {
+func SetupRoutes(r *gin.Engine, db *gorm.DB, hub *websocket.Hub, cfg *config.Config, gcsStorage *storage.GCSStorage, repos *gitstore.Store, mail mailer.Mailer, keys *auth.KeySet, tokens *secrets.TokenStore) {
     // CORS configuration - Allow all for development
     r.Use(cors.New(cors.Config{
         AllowAllOrigins:  true,
@@ -32,20 +37,39 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, hub *websocket.Hub, cfg *config.Con
     r.Use(gin.Recovery())
     
     // Initialize handlers
-    authHandler := handlers.NewAuthHandler(db, cfg)
-    projectHandler := handlers.NewProjectHandler(db)
-    fileHandler := handlers.NewFileHandler(db, hub)
+    authHandler := handlers.NewAuthHandler(db, cfg, keys, loginProviders(cfg), tokens, mail)
+    projectHandler := handlers.NewProjectHandler(db, hub)
+    fileHandler := handlers.NewFileHandler(db, hub, repos)
+    treeHandler := handlers.NewTreeHandler(db, hub, repos)
     uploadHandler := handlers.NewUploadHandler(db, gcsStorage)
+    archiveHandler := handlers.NewArchiveHandler(db, hub, gcsStorage, repos)
+    gitHandler := handlers.NewGitHandler(db, repos)
+    githubSyncHandler := handlers.NewGitHubSyncHandler(db, hub, repos, tokens, cfg.GitHubAPIURL)
     taskHandler := handlers.NewTaskHandler(db, hub)
     chatHandler := handlers.NewChatHandler(db, hub)
+    presenceHandler := handlers.NewPresenceHandler(db, hub)
     userHandler := handlers.NewUserHandler(db)
+    searchHandler := handlers.NewSearchHandler(db)
+    invitationHandler := handlers.NewInvitationHandler(db, cfg, mail)
+    tokenHandler := handlers.NewTokenHandler(db)
+    botHandler := handlers.NewBotHandler(db)
 
     // Swagger documentation
     r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+    // Public verification keys for access tokens
+    r.GET("/.well-known/jwks.json", authHandler.JWKS)
+
     // WebSocket endpoint (auth via query params)
     r.GET("/ws", hub.HandleWebSocket)
 
+    // Git smart HTTP for cloning project repositories (auth via token as password)
+    gitHTTP := r.Group("/git/projects/:id", middleware.GitAuth(db), middleware.ProjectAccess(db))
+    {
+        gitHTTP.GET("/info/refs", gitHandler.InfoRefs)
+        gitHTTP.POST("/git-upload-pack", gitHandler.UploadPack)
+    }
+
     // API routes
     api := r.Group("/api/v1")
     {
@@ -54,55 +78,161 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, hub *websocket.Hub, cfg *config.Con
         {
             auth.GET("/github", authHandler.GitHubLogin)
             auth.GET("/github/callback", authHandler.GitHubCallback)
-            auth.POST("/dev-login", authHandler.DevLogin)
+            auth.GET("/providers", authHandler.GetProviders)
+            auth.GET("/providers/:provider/login", authHandler.ProviderLogin)
+            auth.GET("/providers/:provider/callback", authHandler.ProviderCallback)
+            auth.POST("/register", authHandler.Register)
+            auth.POST("/login", authHandler.PasswordLogin)
+            auth.POST("/verify-email", authHandler.VerifyEmail)
+            auth.POST("/verify-email/resend", authHandler.ResendVerification)
+            auth.POST("/password/forgot", authHandler.ForgotPassword)
+            auth.POST("/password/reset", authHandler.ResetPassword)
             auth.POST("/refresh", authHandler.RefreshToken)
+            auth.POST("/mfa/verify", authHandler.VerifyMFA)
+
+            // Passwordless login for any email; never expose it in production
+            if cfg.DevMode {
+                auth.POST("/dev-login", authHandler.DevLogin)
+            }
         }
 
+        // Invitation preview (public, token-authenticated)
+        api.GET("/invitations/preview", invitationHandler.PreviewInvitation)
+
         // Protected routes
         protected := api.Group("/")
-        protected.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+        protected.Use(middleware.AuthMiddleware(keys, db))
         {
             // User routes
-            protected.GET("/me", authHandler.GetCurrentUser)
-            protected.GET("/users/search", userHandler.SearchUsers)
-            protected.GET("/users", userHandler.GetUsers)
+            protected.GET("/me", middleware.RequireScope("user"), authHandler.GetCurrentUser)
+            protected.GET("/users/search", middleware.RequireScope("user"), userHandler.SearchUsers)
+            protected.GET("/users", middleware.RequireScope("user"), userHandler.GetUsers)
+
+            // Account routes only reachable from an interactive login
+            account := protected.Group("/")
+            account.Use(middleware.RequireSession())
+            {
+                account.GET("/me/sessions", authHandler.GetSessions)
+                account.DELETE("/me/sessions/:sessionId", authHandler.RevokeSession)
+                account.POST("/auth/logout", authHandler.Logout)
+                account.POST("/auth/logout-all", authHandler.LogoutAll)
+                account.PUT("/me/password", authHandler.ChangePassword)
+
+                // Linked login identities
+                account.GET("/me/identities", authHandler.GetIdentities)
+                account.POST("/me/identities/:provider", authHandler.LinkIdentity)
+                account.DELETE("/me/identities/:identityId", authHandler.UnlinkIdentity)
+
+                // Two-factor authentication
+                account.GET("/me/mfa", authHandler.GetMFAStatus)
+                account.DELETE("/me/mfa", authHandler.DisableMFA)
+                account.POST("/me/mfa/totp", authHandler.EnrollTOTP)
+                account.POST("/me/mfa/totp/confirm", authHandler.ConfirmTOTP)
+                account.POST("/me/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+
+                // Personal access token routes
+                account.GET("/me/tokens", tokenHandler.GetTokens)
+                account.POST("/me/tokens", tokenHandler.CreateToken)
+                account.DELETE("/me/tokens/:tokenId", tokenHandler.RevokeToken)
+
+                // Invitation routes
+                account.POST("/invitations/accept", invitationHandler.AcceptInvitation)
+            }
 
             // Project routes
             projects := protected.Group("/projects")
             {
-                projects.GET("", projectHandler.GetProjects)  // Remove trailing slash
-                projects.POST("", projectHandler.CreateProject)
-                projects.GET("/:id", projectHandler.GetProject)
-                projects.PUT("/:id", projectHandler.UpdateProject)
-                projects.DELETE("/:id", projectHandler.DeleteProject)
+                projects.GET("", middleware.RequireScope("projects"), projectHandler.GetProjects)  // Remove trailing slash
+                projects.POST("", middleware.RequireScope("projects"), projectHandler.CreateProject)
+
+                // Routes scoped to a single project require membership
+                project := projects.Group("/:id")
+                project.Use(middleware.ProjectAccess(db))
+
+                projectScope := project.Group("", middleware.RequireScope("projects"))
+                {
+                    projectScope.GET("", projectHandler.GetProject)
+                    projectScope.PUT("", middleware.RequireProjectPermission(models.PermissionEditProject), projectHandler.UpdateProject)
+                    projectScope.DELETE("", middleware.RequireProjectPermission(models.PermissionDeleteProject), projectHandler.DeleteProject)
+                    projectScope.PUT("/security", middleware.RequireSession(), middleware.RequireProjectPermission(models.PermissionManageSecurity), projectHandler.UpdateSecurity)
 
-                // Member routes
-                projects.GET("/:id/members", projectHandler.GetMembers)
-                projects.POST("/:id/members", projectHandler.AddMember)
-                projects.DELETE("/:id/members/:userId", projectHandler.RemoveMember)
+                    // Member routes
+                    projectScope.GET("/permissions", projectHandler.GetMyPermissions)
+                    projectScope.GET("/presence", presenceHandler.GetPresence)
+                    projectScope.GET("/members", projectHandler.GetMembers)
+                    projectScope.DELETE("/members/:userId", middleware.RequireProjectPermission(models.PermissionManageMembers), projectHandler.RemoveMember)
+                    projectScope.PUT("/members/:userId/role", middleware.RequireProjectPermission(models.PermissionManageMembers), projectHandler.UpdateMemberRole)
+                    projectScope.POST("/transfer-ownership", middleware.RequireProjectPermission(models.PermissionTransferOwnership), projectHandler.TransferOwnership)
+
+                    // Invitation routes
+                    projectScope.GET("/invitations", middleware.RequireProjectPermission(models.PermissionManageMembers), invitationHandler.GetInvitations)
+                    projectScope.POST("/invitations", middleware.RequireProjectPermission(models.PermissionManageMembers), invitationHandler.CreateInvitation)
+                    projectScope.POST("/invitations/:invitationId/resend", middleware.RequireProjectPermission(models.PermissionManageMembers), invitationHandler.ResendInvitation)
+                    projectScope.DELETE("/invitations/:invitationId", middleware.RequireProjectPermission(models.PermissionManageMembers), invitationHandler.RevokeInvitation)
+                }
+
+                // Search checks the scope of every result type itself
+                project.GET("/search", searchHandler.Search)
+
+                // Bot routes
+                bots := project.Group("/bots", middleware.RequireSession(), middleware.RequireProjectPermission(models.PermissionManageBots))
+                {
+                    bots.GET("", botHandler.GetBots)
+                    bots.POST("", botHandler.CreateBot)
+                    bots.DELETE("/:botId", botHandler.DeleteBot)
+                    bots.POST("/:botId/keys", botHandler.CreateBotKey)
+                    bots.DELETE("/:botId/keys/:keyId", botHandler.RevokeBotKey)
+                }
 
                 // File routes
-                projects.GET("/:id/files", fileHandler.GetFiles)
-                projects.POST("/:id/files", fileHandler.CreateFile)
-                projects.GET("/:id/files/:fileId", fileHandler.GetFile)
-                projects.PUT("/:id/files/:fileId", fileHandler.UpdateFile)
-                projects.DELETE("/:id/files/:fileId", fileHandler.DeleteFile)
-
-                // Task routes
-                projects.GET("/:id/tasks", taskHandler.GetTasks)
-                projects.POST("/:id/tasks", taskHandler.CreateTask)
-                projects.PUT("/:id/tasks/:taskId", taskHandler.UpdateTask)
-                projects.DELETE("/:id/tasks/:taskId", taskHandler.DeleteTask)
-
-                // Sprint routes
-                projects.GET("/:id/sprints", taskHandler.GetSprints)
-                projects.POST("/:id/sprints", taskHandler.CreateSprint)
+                files := project.Group("", middleware.RequireScope("files"))
+                {
+                    files.GET("/files", fileHandler.GetFiles)
+                    files.POST("/files", middleware.RequireProjectPermission(models.PermissionEditFiles), fileHandler.CreateFile)
+                    files.GET("/files/:fileId", fileHandler.GetFile)
+                    files.PUT("/files/:fileId", middleware.RequireProjectPermission(models.PermissionEditFiles), fileHandler.UpdateFile)
+                    files.DELETE("/files/:fileId", middleware.RequireProjectPermission(models.PermissionDeleteFiles), fileHandler.DeleteFile)
+                    files.GET("/files/:fileId/revisions", fileHandler.GetRevisions)
+                    files.GET("/files/:fileId/revisions/:number", fileHandler.GetRevision)
+                    files.POST("/files/:fileId/revisions/:number/restore", middleware.RequireProjectPermission(models.PermissionEditFiles), fileHandler.RestoreRevision)
+                    files.GET("/files/:fileId/diff", fileHandler.DiffRevisions)
+                    files.POST("/files/:fileId/move", middleware.RequireProjectPermission(models.PermissionEditFiles), fileHandler.MoveFile)
+                    files.GET("/tree", treeHandler.GetTree)
+                    files.POST("/folders", middleware.RequireProjectPermission(models.PermissionEditFiles), treeHandler.CreateFolder)
+                    files.POST("/folders/:folderId/move", middleware.RequireProjectPermission(models.PermissionEditFiles), treeHandler.MoveFolder)
+                    files.DELETE("/folders/:folderId", middleware.RequireProjectPermission(models.PermissionDeleteFiles), treeHandler.DeleteFolder)
+                    files.POST("/upload", middleware.RequireProjectPermission(models.PermissionEditFiles), uploadHandler.UploadFile)
+                    files.GET("/export", archiveHandler.Export)
+                    files.POST("/import", middleware.RequireProjectPermission(models.PermissionEditFiles), archiveHandler.Import)
+                    files.GET("/git/commits", gitHandler.GetLog)
+                    files.GET("/git/commits/:sha", gitHandler.GetCommit)
+                    files.GET("/git/commits/:sha/tree", gitHandler.GetTreeAtCommit)
+                    files.GET("/git/diff", gitHandler.Diff)
+                    files.GET("/github/sync", githubSyncHandler.GetStatus)
+                    files.POST("/github/pull", middleware.RequireProjectPermission(models.PermissionEditFiles), githubSyncHandler.Pull)
+                    files.POST("/github/push", middleware.RequireProjectPermission(models.PermissionEditFiles), githubSyncHandler.Push)
+                }
+
+                // Task and sprint routes
+                tasks := project.Group("", middleware.RequireScope("tasks"))
+                {
+                    tasks.GET("/tasks", taskHandler.GetTasks)
+                    tasks.GET("/tasks/:taskId", taskHandler.GetTask)
+                    tasks.POST("/tasks", middleware.RequireProjectPermission(models.PermissionEditTasks), taskHandler.CreateTask)
+                    tasks.PUT("/tasks/:taskId", middleware.RequireProjectPermission(models.PermissionEditTasks), taskHandler.UpdateTask)
+                    tasks.DELETE("/tasks/:taskId", middleware.RequireProjectPermission(models.PermissionDeleteTasks), taskHandler.DeleteTask)
+
+                    tasks.GET("/sprints", taskHandler.GetSprints)
+                    tasks.POST("/sprints", middleware.RequireProjectPermission(models.PermissionManageSprints), taskHandler.CreateSprint)
+                }
 
                 // Chat routes
-                projects.GET("/:id/messages", chatHandler.GetMessages)
-                projects.POST("/:id/messages", chatHandler.SendMessage)
-                projects.POST("/:id/upload", uploadHandler.UploadFile)
+                chat := project.Group("", middleware.RequireScope("chat"))
+                {
+                    chat.GET("/messages", chatHandler.GetMessages)
+                    chat.POST("/messages", middleware.RequireProjectPermission(models.PermissionSendMessages), chatHandler.SendMessage)
+                }
             }
         }
     }
-}
\ No newline at end of file
+}
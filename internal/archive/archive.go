@@ -0,0 +1,301 @@
+// Package archive reads and writes zip and tar.gz archives of project
+// files.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"devsync-be/internal/filetree"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported archive format")
+	ErrUnsafePath        = errors.New("archive entry points outside the project")
+	ErrTooLarge          = errors.New("archive content is too large")
+	ErrTooManyEntries    = errors.New("archive has too many entries")
+)
+
+// Limits bound what Read accepts. An archive exceeding MaxEntries or
+// MaxTotalSize is rejected; single files over MaxFileSize are skipped.
+type Limits struct {
+	MaxEntries   int
+	MaxFileSize  int64
+	MaxTotalSize int64
+}
+
+// Entry is a file or folder read from an archive.
+type Entry struct {
+	Path    string
+	Dir     bool
+	Data    []byte
+	ModTime time.Time
+}
+
+// Skipped is an archive entry Read left out, with the reason.
+type Skipped struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Detect returns the format of an archive from its file name, or from its
+// first bytes if the name does not tell.
+func Detect(name string, head []byte) (string, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return FormatZip, nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Extension returns the file name extension of a format.
+func Extension(format string) string {
+	return "." + format
+}
+
+// ContentType returns the MIME type of a format.
+func ContentType(format string) string {
+	if format == FormatZip {
+		return "application/zip"
+	}
+	return "application/gzip"
+}
+
+// Read returns the entries of an archive with the first strip path
+// elements removed. Any entry whose path leaves the archive root rejects
+// the whole archive with ErrUnsafePath. Links and special files are
+// skipped.
+func Read(format string, r io.ReaderAt, size int64, strip int, limits Limits) ([]Entry, []Skipped, error) {
+	reader := &reader{strip: strip, limits: limits, seen: make(map[string]bool)}
+
+	switch format {
+	case FormatZip:
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range zr.File {
+			mode := f.Mode()
+			switch {
+			case mode.IsDir():
+				err = reader.add(f.Name, true, f.Modified, nil)
+			case !mode.IsRegular():
+				err = reader.skip(f.Name, "not a regular file")
+			default:
+				var rc io.ReadCloser
+				if rc, err = f.Open(); err == nil {
+					err = reader.add(f.Name, false, f.Modified, rc)
+					rc.Close()
+				}
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+	case FormatTarGz:
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			switch header.Typeflag {
+			case tar.TypeDir:
+				err = reader.add(header.Name, true, header.ModTime, nil)
+			case tar.TypeReg:
+				err = reader.add(header.Name, false, header.ModTime, tr)
+			case tar.TypeXGlobalHeader:
+				continue
+			default:
+				err = reader.skip(header.Name, "not a regular file")
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+	default:
+		return nil, nil, ErrUnsupportedFormat
+	}
+	return reader.entries, reader.skipped, nil
+}
+
+type reader struct {
+	strip   int
+	limits  Limits
+	seen    map[string]bool
+	total   int64
+	count   int
+	entries []Entry
+	skipped []Skipped
+}
+
+func (r *reader) add(name string, dir bool, modTime time.Time, content io.Reader) error {
+	p, ok, err := r.next(name)
+	if err != nil || !ok {
+		return err
+	}
+	if r.seen[p] {
+		r.skipped = append(r.skipped, Skipped{Path: p, Reason: "duplicate entry"})
+		return nil
+	}
+	r.seen[p] = true
+
+	entry := Entry{Path: p, Dir: dir, ModTime: modTime}
+	if !dir {
+		data, err := io.ReadAll(io.LimitReader(content, r.limits.MaxFileSize+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > r.limits.MaxFileSize {
+			r.skipped = append(r.skipped, Skipped{Path: p, Reason: "file is too large"})
+			return nil
+		}
+		r.total += int64(len(data))
+		if r.total > r.limits.MaxTotalSize {
+			return ErrTooLarge
+		}
+		entry.Data = data
+	}
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func (r *reader) skip(name, reason string) error {
+	p, ok, err := r.next(name)
+	if err != nil || !ok {
+		return err
+	}
+	r.skipped = append(r.skipped, Skipped{Path: p, Reason: reason})
+	return nil
+}
+
+// next counts an entry and returns its clean project path, or false for
+// entries removed by strip.
+func (r *reader) next(name string) (string, bool, error) {
+	r.count++
+	if r.count > r.limits.MaxEntries {
+		return "", false, ErrTooManyEntries
+	}
+
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", false, fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+
+	// Archives made with "tar -C dir ." name entries ./path and include ./
+	// itself, which is the root
+	var segments []string
+	for _, segment := range strings.Split(name, "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return "", false, fmt.Errorf("%w: %s", ErrUnsafePath, name)
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) <= r.strip {
+		return "", false, nil
+	}
+
+	p, err := filetree.Clean(strings.Join(segments[r.strip:], "/"))
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	return p, true, nil
+}
+
+// Writer writes the entries of an archive.
+type Writer interface {
+	Dir(path string, modTime time.Time) error
+	File(path string, size int64, modTime time.Time, content io.Reader) error
+	Close() error
+}
+
+// NewWriter starts an archive of format on w.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatZip:
+		return &zipWriter{zip.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) Dir(path string, modTime time.Time) error {
+	_, err := w.zw.CreateHeader(&zip.FileHeader{Name: path + "/", Modified: modTime})
+	return err
+}
+
+func (w *zipWriter) File(path string, size int64, modTime time.Time, content io.Reader) error {
+	f, err := w.zw.CreateHeader(&zip.FileHeader{Name: path, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(f, content, size)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+type tarWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (w *tarWriter) Dir(path string, modTime time.Time) error {
+	return w.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: path + "/", Mode: 0o755, ModTime: modTime})
+}
+
+func (w *tarWriter) File(path string, size int64, modTime time.Time, content io.Reader) error {
+	if err := w.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: path, Mode: 0o644, Size: size, ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w.tw, content, size)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
@@ -0,0 +1,255 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEntry is an archive entry to write. Names ending in / are folders.
+type testEntry struct {
+	name    string
+	data    string
+	symlink bool
+}
+
+var testLimits = Limits{MaxEntries: 10, MaxFileSize: 8, MaxTotalSize: 16}
+
+func buildZip(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name}
+		if e.symlink {
+			header.SetMode(os.ModeSymlink | 0o777)
+		} else if strings.HasSuffix(e.name, "/") {
+			header.SetMode(os.ModeDir | 0o755)
+		}
+		f, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(e.data))}
+		switch {
+		case e.symlink:
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.data, 0
+		case strings.HasSuffix(e.name, "/"):
+			header.Typeflag, header.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// paths lists the entries Read returned as path, with a trailing / for
+// folders, mapped to their data.
+func paths(entries []Entry) map[string]string {
+	out := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if e.Dir {
+			out[e.Path+"/"] = ""
+		} else {
+			out[e.Path] = string(e.Data)
+		}
+	}
+	return out
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testEntry
+		strip   int
+		want    map[string]string
+		skipped []Skipped
+		err     error
+	}{
+		{
+			name:    "files and folders",
+			entries: []testEntry{{name: "src/"}, {name: "src/main.go", data: "package"}, {name: "README", data: "hi"}},
+			want:    map[string]string{"src/": "", "src/main.go": "package", "README": "hi"},
+		},
+		{
+			name:    "dot entries of tar -C dir .",
+			entries: []testEntry{{name: "./"}, {name: "./a/"}, {name: "./a/b.txt", data: "b"}},
+			want:    map[string]string{"a/": "", "a/b.txt": "b"},
+		},
+		{
+			name:    "backslashes are separators",
+			entries: []testEntry{{name: `docs\guide.md`, data: "g"}},
+			want:    map[string]string{"docs/guide.md": "g"},
+		},
+		{
+			name:    "strip components",
+			entries: []testEntry{{name: "project-main/"}, {name: "project-main/a.txt", data: "a"}, {name: "project-main/lib/b.txt", data: "b"}, {name: "top.txt", data: "t"}},
+			strip:   1,
+			want:    map[string]string{"a.txt": "a", "lib/b.txt": "b"},
+		},
+		{
+			name:    "symlinks are skipped",
+			entries: []testEntry{{name: "link", data: "/etc/passwd", symlink: true}, {name: "a", data: "a"}},
+			want:    map[string]string{"a": "a"},
+			skipped: []Skipped{{Path: "link", Reason: "not a regular file"}},
+		},
+		{
+			name:    "duplicates are skipped",
+			entries: []testEntry{{name: "a", data: "1"}, {name: "./a", data: "2"}},
+			want:    map[string]string{"a": "1"},
+			skipped: []Skipped{{Path: "a", Reason: "duplicate entry"}},
+		},
+		{
+			name:    "large files are skipped",
+			entries: []testEntry{{name: "big", data: "123456789"}, {name: "a", data: "12345678"}},
+			want:    map[string]string{"a": "12345678"},
+			skipped: []Skipped{{Path: "big", Reason: "file is too large"}},
+		},
+		{name: "parent", entries: []testEntry{{name: "a", data: "a"}, {name: "../evil", data: "x"}}, err: ErrUnsafePath},
+		{name: "parent inside", entries: []testEntry{{name: "a/../../evil", data: "x"}}, err: ErrUnsafePath},
+		{name: "parent with backslashes", entries: []testEntry{{name: `a\..\..\evil`, data: "x"}}, err: ErrUnsafePath},
+		{name: "parent in stripped part", entries: []testEntry{{name: "../a.txt", data: "x"}}, strip: 1, err: ErrUnsafePath},
+		{name: "absolute", entries: []testEntry{{name: "/etc/passwd", data: "x"}}, err: ErrUnsafePath},
+		{name: "drive letter", entries: []testEntry{{name: "C:/Windows/x", data: "x"}}, err: ErrUnsafePath},
+		{name: "drive letter with backslash", entries: []testEntry{{name: `c:\x`, data: "x"}}, err: ErrUnsafePath},
+		{name: "control character", entries: []testEntry{{name: "a\x01b", data: "x"}}, err: ErrUnsafePath},
+		{
+			name:    "total size",
+			entries: []testEntry{{name: "a", data: "12345678"}, {name: "b", data: "12345678"}, {name: "c", data: "1"}},
+			err:     ErrTooLarge,
+		},
+		{
+			name:    "entry count",
+			entries: []testEntry{{name: "0/"}, {name: "1/"}, {name: "2/"}, {name: "3/"}, {name: "4/"}, {name: "5/"}, {name: "6/"}, {name: "7/"}, {name: "8/"}, {name: "9/"}, {name: "10/"}},
+			err:     ErrTooManyEntries,
+		},
+	}
+
+	formats := map[string]func(*testing.T, []testEntry) []byte{
+		FormatZip:   buildZip,
+		FormatTarGz: buildTarGz,
+	}
+	for format, build := range formats {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				data := build(t, tt.entries)
+				entries, skipped, err := Read(format, bytes.NewReader(data), int64(len(data)), tt.strip, testLimits)
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("Read() error = %v, want %v", err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := paths(entries); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Read() entries = %v, want %v", got, tt.want)
+				}
+				if !reflect.DeepEqual(skipped, tt.skipped) {
+					t.Errorf("Read() skipped = %v, want %v", skipped, tt.skipped)
+				}
+			})
+		}
+	}
+}
+
+func TestReadUnsupported(t *testing.T) {
+	if _, _, err := Read("rar", bytes.NewReader(nil), 0, 0, testLimits); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Read() error = %v, want %v", err, ErrUnsupportedFormat)
+	}
+	if _, _, err := Read(FormatZip, strings.NewReader("not a zip"), 9, 0, testLimits); err == nil {
+		t.Error("Read() of a corrupt zip succeeded")
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, format := range []string{FormatZip, FormatTarGz} {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Dir("src", modTime); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.File("src/a.go", 7, modTime, strings.NewReader("package")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, _, err := Read(format, bytes.NewReader(buf.Bytes()), int64(buf.Len()), 0, testLimits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"src/": "", "src/a.go": "package"}
+		if got := paths(entries); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Read() = %v, want %v", format, got, want)
+		}
+		for _, e := range entries {
+			if !e.ModTime.Equal(modTime) {
+				t.Errorf("%s: %s ModTime = %v, want %v", format, e.Path, e.ModTime, modTime)
+			}
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"project.zip", "", FormatZip},
+		{"project.TAR.GZ", "", FormatTarGz},
+		{"project.tgz", "", FormatTarGz},
+		{"upload", "PK\x03\x04", FormatZip},
+		{"upload", "\x1f\x8b\x08", FormatTarGz},
+	}
+	for _, tt := range tests {
+		if got, err := Detect(tt.name, []byte(tt.head)); err != nil || got != tt.want {
+			t.Errorf("Detect(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := Detect("project.rar", []byte("Rar!")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Detect() error = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
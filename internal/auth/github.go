@@ -3,17 +3,21 @@ package auth
 import (
     "context"
     "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
 
     "golang.org/x/oauth2"
     "golang.org/x/oauth2/github"
 )
 
 type GitHubUser struct {
-    ID        int64  `json:"id"`
-    Login     string `json:"login"`
-    Email     string `json:"email"`
-    Name      string `json:"name"`
-    AvatarURL string `json:"avatar_url"`
+    ID            int64  `json:"id"`
+    Login         string `json:"login"`
+    Email         string `json:"email"`
+    EmailVerified bool   `json:"-"`
+    Name          string `json:"name"`
+    AvatarURL     string `json:"avatar_url"`
 }
 
 func GetGitHubOAuthConfig(clientID, clientSecret, redirectURL string) *oauth2.Config {
@@ -26,43 +30,91 @@ func GetGitHubOAuthConfig(clientID, clientSecret, redirectURL string) *oauth2.Co
     }
 }
 
-func GetGitHubUser(ctx context.Context, config *oauth2.Config, code string) (*GitHubUser, string, error) {
-    token, err := config.Exchange(ctx, code)
-    if err != nil {
-        return nil, "", err
-    }
-
-    client := config.Client(ctx, token)
+func fetchGitHubUser(client *http.Client) (*GitHubUser, error) {
     resp, err := client.Get("https://api.github.com/user")
     if err != nil {
-        return nil, "", err
+        return nil, err
     }
     defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("github: unexpected status %d fetching user", resp.StatusCode)
+    }
 
     var user GitHubUser
     if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-        return nil, "", err
+        return nil, err
     }
 
-    // Get primary email if not public
-    if user.Email == "" {
-        emailResp, err := client.Get("https://api.github.com/user/emails")
-        if err == nil {
-            defer emailResp.Body.Close()
-            var emails []struct {
-                Email   string `json:"email"`
-                Primary bool   `json:"primary"`
-            }
-            if json.NewDecoder(emailResp.Body).Decode(&emails) == nil {
-                for _, email := range emails {
-                    if email.Primary {
-                        user.Email = email.Email
-                        break
-                    }
+    // Use the primary email if none is public, and find out whether the
+    // address is verified so it can be trusted for account linking
+    emailResp, err := client.Get("https://api.github.com/user/emails")
+    if err == nil {
+        defer emailResp.Body.Close()
+        var emails []struct {
+            Email    string `json:"email"`
+            Primary  bool   `json:"primary"`
+            Verified bool   `json:"verified"`
+        }
+        if json.NewDecoder(emailResp.Body).Decode(&emails) == nil {
+            for _, email := range emails {
+                if user.Email == "" && email.Primary {
+                    user.Email = email.Email
+                }
+                if email.Email == user.Email {
+                    user.EmailVerified = email.Verified
                 }
             }
         }
     }
 
-    return &user, token.AccessToken, nil
-}
\ No newline at end of file
+    return &user, nil
+}
+
+// GitHubProvider signs users in with GitHub OAuth. The GitHub access token
+// is returned with the identity so repository features can use it.
+type GitHubProvider struct {
+    config *oauth2.Config
+}
+
+func NewGitHubProvider(clientID, clientSecret, redirectURL string) *GitHubProvider {
+    return &GitHubProvider{config: GetGitHubOAuthConfig(clientID, clientSecret, redirectURL)}
+}
+
+func (p *GitHubProvider) Name() string {
+    return "github"
+}
+
+func (p *GitHubProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+    var opts []oauth2.AuthCodeOption
+    if verifier != "" {
+        opts = append(opts, oauth2.S256ChallengeOption(verifier))
+    }
+    return p.config.AuthCodeURL(state, opts...), nil
+}
+
+func (p *GitHubProvider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+    var opts []oauth2.AuthCodeOption
+    if verifier != "" {
+        opts = append(opts, oauth2.VerifierOption(verifier))
+    }
+    token, err := p.config.Exchange(ctx, code, opts...)
+    if err != nil {
+        return nil, err
+    }
+
+    user, err := fetchGitHubUser(p.config.Client(ctx, token))
+    if err != nil {
+        return nil, err
+    }
+
+    return &Identity{
+        Provider:      p.Name(),
+        Subject:       strconv.FormatInt(user.ID, 10),
+        Username:      user.Login,
+        Email:         user.Email,
+        EmailVerified: user.EmailVerified,
+        Name:          user.Name,
+        AvatarURL:     user.AvatarURL,
+        AccessToken:   token.AccessToken,
+    }, nil
+}
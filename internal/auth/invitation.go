@@ -0,0 +1,47 @@
+package auth
+
+import (
+    "errors"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+type InvitationClaims struct {
+    InvitationID uint `json:"invitation_id"`
+    jwt.RegisteredClaims
+}
+
+// GenerateInvitationToken signs a token for the given invitation. nonce is
+// stored on the invitation row so resending invalidates earlier links.
+func GenerateInvitationToken(invitationID uint, nonce string, expiresAt time.Time, secret string) (string, error) {
+    claims := &InvitationClaims{
+        InvitationID: invitationID,
+        RegisteredClaims: jwt.RegisteredClaims{
+            ID:        nonce,
+            Subject:   "invitation",
+            ExpiresAt: jwt.NewNumericDate(expiresAt),
+            IssuedAt:  jwt.NewNumericDate(time.Now()),
+        },
+    }
+
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+    return token.SignedString([]byte(secret))
+}
+
+func ValidateInvitationToken(tokenString, secret string) (*InvitationClaims, error) {
+    claims := &InvitationClaims{}
+    token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+        return []byte(secret), nil
+    }, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+    if err != nil {
+        return nil, err
+    }
+
+    if !token.Valid || claims.Subject != "invitation" {
+        return nil, errors.New("invalid invitation token")
+    }
+
+    return claims, nil
+}
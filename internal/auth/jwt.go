@@ -8,30 +8,34 @@ import (
 )
 
 type Claims struct {
-    UserID   uint   `json:"user_id"`
-    Username string `json:"username"`
+    UserID    uint   `json:"user_id"`
+    Username  string `json:"username"`
+    SessionID uint   `json:"sid"`
     jwt.RegisteredClaims
 }
 
-func GenerateToken(userID uint, username, secret string) (string, error) {
+// GenerateToken issues a short-lived access token bound to a session.
+func GenerateToken(keys *KeySet, userID uint, username string, sessionID uint, ttl time.Duration) (string, error) {
     claims := &Claims{
-        UserID:   userID,
-        Username: username,
+        UserID:    userID,
+        Username:  username,
+        SessionID: sessionID,
         RegisteredClaims: jwt.RegisteredClaims{
-            ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+            Issuer:    keys.Issuer,
+            ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
             IssuedAt:  jwt.NewNumericDate(time.Now()),
         },
     }
 
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    return token.SignedString([]byte(secret))
+    return keys.Sign(claims)
 }
 
-func ValidateToken(tokenString, secret string) (*Claims, error) {
+func ValidateToken(tokenString string, keys *KeySet) (*Claims, error) {
     claims := &Claims{}
-    token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-        return []byte(secret), nil
-    })
+    token, err := jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc,
+        jwt.WithValidMethods(keys.ValidMethods()),
+        jwt.WithIssuer(keys.Issuer),
+    )
 
     if err != nil {
         return nil, err
@@ -42,4 +46,4 @@ func ValidateToken(tokenString, secret string) (*Claims, error) {
     }
 
     return claims, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+    "crypto"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "math/big"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one key of a KeySet. Private is nil for retired keys that
+// are only kept to verify tokens issued before a rotation.
+type SigningKey struct {
+    ID      string
+    Method  jwt.SigningMethod
+    Private crypto.Signer
+    Public  crypto.PublicKey
+}
+
+// KeySet holds the key used to sign new access tokens plus every key that
+// is still accepted for verification, indexed by "kid".
+type KeySet struct {
+    Issuer string
+    active *SigningKey
+    keys   map[string]*SigningKey
+}
+
+// JWK is the public part of a signing key in JSON Web Key format.
+type JWK struct {
+    Kty string `json:"kty"`
+    Kid string `json:"kid"`
+    Use string `json:"use"`
+    Alg string `json:"alg"`
+    N   string `json:"n,omitempty"`
+    E   string `json:"e,omitempty"`
+    Crv string `json:"crv,omitempty"`
+    X   string `json:"x,omitempty"`
+}
+
+// LoadKeySet reads PEM keys from dir. Each file is named "<kid>.pem" and
+// holds either a private key (RSA or Ed25519, PKCS#8 or PKCS#1) or, for a
+// retired key, just the PKIX public key. activeKID selects the signing key;
+// it may be empty when dir holds exactly one private key.
+//
+// With an empty dir and allowEphemeral set, a throwaway Ed25519 key is
+// generated so development setups work without any key material.
+func LoadKeySet(dir, activeKID, issuer string, allowEphemeral bool) (*KeySet, error) {
+    ks := &KeySet{Issuer: issuer, keys: make(map[string]*SigningKey)}
+
+    if dir == "" {
+        if !allowEphemeral {
+            return nil, errors.New("JWT_KEYS_DIR must be set outside dev mode")
+        }
+        _, priv, err := ed25519.GenerateKey(rand.Reader)
+        if err != nil {
+            return nil, err
+        }
+        key := &SigningKey{ID: "dev-ephemeral", Method: jwt.SigningMethodEdDSA, Private: priv, Public: priv.Public()}
+        ks.keys[key.ID] = key
+        ks.active = key
+        return ks, nil
+    }
+
+    paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+    if err != nil {
+        return nil, err
+    }
+
+    var privateIDs []string
+    for _, path := range paths {
+        kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return nil, err
+        }
+        key, err := parseKey(kid, data)
+        if err != nil {
+            return nil, fmt.Errorf("key %s: %w", kid, err)
+        }
+        ks.keys[kid] = key
+        if key.Private != nil {
+            privateIDs = append(privateIDs, kid)
+        }
+    }
+
+    if activeKID == "" {
+        if len(privateIDs) != 1 {
+            return nil, fmt.Errorf("JWT_ACTIVE_KEY_ID must be set when %s holds %d private keys", dir, len(privateIDs))
+        }
+        activeKID = privateIDs[0]
+    }
+
+    active, ok := ks.keys[activeKID]
+    if !ok || active.Private == nil {
+        return nil, fmt.Errorf("active key %q has no private key in %s", activeKID, dir)
+    }
+    ks.active = active
+
+    return ks, nil
+}
+
+func parseKey(kid string, data []byte) (*SigningKey, error) {
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, errors.New("no PEM block found")
+    }
+
+    var parsed interface{}
+    var err error
+    switch block.Type {
+    case "PRIVATE KEY":
+        parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+    case "RSA PRIVATE KEY":
+        parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+    case "PUBLIC KEY":
+        parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+    default:
+        return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    key := &SigningKey{ID: kid}
+    switch k := parsed.(type) {
+    case *rsa.PrivateKey:
+        key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+    case *rsa.PublicKey:
+        key.Method, key.Public = jwt.SigningMethodRS256, k
+    case ed25519.PrivateKey:
+        key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, k, k.Public()
+    case ed25519.PublicKey:
+        key.Method, key.Public = jwt.SigningMethodEdDSA, k
+    default:
+        return nil, fmt.Errorf("unsupported key type %T", parsed)
+    }
+
+    if pub, ok := key.Public.(*rsa.PublicKey); ok && pub.N.BitLen() < 2048 {
+        return nil, errors.New("RSA keys must be at least 2048 bits")
+    }
+
+    return key, nil
+}
+
+// ActiveKeyID returns the kid used for newly signed tokens.
+func (ks *KeySet) ActiveKeyID() string {
+    return ks.active.ID
+}
+
+// Sign signs claims with the active key and sets the "kid" header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+    token := jwt.NewWithClaims(ks.active.Method, claims)
+    token.Header["kid"] = ks.active.ID
+    return token.SignedString(ks.active.Private)
+}
+
+// Keyfunc resolves the verification key from the token's "kid" header and
+// rejects tokens whose "alg" does not match that key.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+    kid, _ := token.Header["kid"].(string)
+    key, ok := ks.keys[kid]
+    if !ok {
+        return nil, fmt.Errorf("unknown key id %q", kid)
+    }
+    if token.Method.Alg() != key.Method.Alg() {
+        return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+    }
+    return key.Public, nil
+}
+
+// ValidMethods lists the algorithms accepted by this key set.
+func (ks *KeySet) ValidMethods() []string {
+    seen := map[string]bool{}
+    var methods []string
+    for _, key := range ks.keys {
+        if alg := key.Method.Alg(); !seen[alg] {
+            seen[alg] = true
+            methods = append(methods, alg)
+        }
+    }
+    return methods
+}
+
+// JWKS returns the public keys in JSON Web Key Set format, sorted by kid.
+func (ks *KeySet) JWKS() map[string][]JWK {
+    ids := make([]string, 0, len(ks.keys))
+    for kid := range ks.keys {
+        ids = append(ids, kid)
+    }
+    sort.Strings(ids)
+
+    keys := make([]JWK, 0, len(ids))
+    for _, kid := range ids {
+        key := ks.keys[kid]
+        jwk := JWK{Kid: kid, Use: "sig", Alg: key.Method.Alg()}
+        switch pub := key.Public.(type) {
+        case *rsa.PublicKey:
+            jwk.Kty = "RSA"
+            jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+            jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+        case ed25519.PublicKey:
+            jwk.Kty = "OKP"
+            jwk.Crv = "Ed25519"
+            jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+        }
+        keys = append(keys, jwk)
+    }
+
+    return map[string][]JWK{"keys": keys}
+}
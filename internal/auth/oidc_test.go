@@ -0,0 +1,342 @@
+package auth
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+    testClientID    = "devsync"
+    testRedirectURL = "http://localhost:8080/api/v1/auth/providers/oidc/callback"
+)
+
+// authRequest is what the issuer remembers about an authorization until its
+// code is redeemed.
+type authRequest struct {
+    challenge string
+    nonce     string
+}
+
+// fakeIssuer is an OpenID Connect provider that signs ID tokens with an RSA
+// key and checks PKCE on the token endpoint.
+type fakeIssuer struct {
+    *httptest.Server
+    key *rsa.PrivateKey
+
+    mu        sync.Mutex
+    down      bool
+    codes     map[string]authRequest
+    claims    map[string]interface{}
+    userinfo  map[string]interface{}
+    discovery int
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+    t.Helper()
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f := &fakeIssuer{
+        key:   key,
+        codes: make(map[string]authRequest),
+        claims: map[string]interface{}{
+            "email":              "ana@example.com",
+            "email_verified":     true,
+            "name":               "Ana",
+            "preferred_username": "ana",
+            "picture":            "https://example.com/ana.png",
+        },
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/.well-known/openid-configuration", f.handleDiscovery)
+    mux.HandleFunc("/keys", f.handleKeys)
+    mux.HandleFunc("/token", f.handleToken)
+    mux.HandleFunc("/userinfo", f.handleUserInfo)
+    f.Server = httptest.NewServer(mux)
+    t.Cleanup(f.Close)
+    return f
+}
+
+func (f *fakeIssuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.discovery++
+    if f.down {
+        http.Error(w, "unavailable", http.StatusServiceUnavailable)
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "issuer":                                f.URL,
+        "authorization_endpoint":                f.URL + "/authorize",
+        "token_endpoint":                        f.URL + "/token",
+        "jwks_uri":                              f.URL + "/keys",
+        "userinfo_endpoint":                     f.URL + "/userinfo",
+        "id_token_signing_alg_values_supported": []string{"RS256"},
+    })
+}
+
+func (f *fakeIssuer) handleKeys(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "keys": []map[string]string{{
+            "kty": "RSA",
+            "alg": "RS256",
+            "use": "sig",
+            "kid": "test",
+            "n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+            "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+        }},
+    })
+}
+
+func (f *fakeIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+        return
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    code := r.PostForm.Get("code")
+    req, ok := f.codes[code]
+    if !ok {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+        return
+    }
+    delete(f.codes, code)
+
+    sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+    if base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code_verifier does not match"})
+        return
+    }
+
+    now := time.Now()
+    claims := jwt.MapClaims{
+        "iss":   f.URL,
+        "aud":   testClientID,
+        "sub":   "subject-1",
+        "iat":   now.Unix(),
+        "exp":   now.Add(time.Hour).Unix(),
+        "nonce": req.nonce,
+    }
+    for k, v := range f.claims {
+        claims[k] = v
+    }
+    token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+    token.Header["kid"] = "test"
+    idToken, err := token.SignedString(f.key)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "access_token": "access-" + code,
+        "token_type":   "Bearer",
+        "expires_in":   3600,
+        "id_token":     idToken,
+    })
+}
+
+func (f *fakeIssuer) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer access-") || f.userinfo == nil {
+        w.WriteHeader(http.StatusUnauthorized)
+        return
+    }
+    writeJSON(w, http.StatusOK, f.userinfo)
+}
+
+// authorize plays the user approving the login at authURL and returns the
+// code the issuer redirects back with.
+func (f *fakeIssuer) authorize(t *testing.T, authURL string) string {
+    t.Helper()
+    u, err := url.Parse(authURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := u.Scheme + "://" + u.Host + u.Path; got != f.URL+"/authorize" {
+        t.Fatalf("authorization endpoint = %s, want %s/authorize", got, f.URL)
+    }
+    q := u.Query()
+    if q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL || q.Get("response_type") != "code" {
+        t.Fatalf("unexpected authorization request %s", authURL)
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    code := "code-" + q.Get("state")
+    f.codes[code] = authRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+    return code
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+func newTestOIDCProvider(f *fakeIssuer) *OIDCProvider {
+    return NewOIDCProvider("oidc", f.URL, testClientID, "secret", testRedirectURL, []string{"openid", "email", "profile"})
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+    f := newFakeIssuer(t)
+    p := newTestOIDCProvider(f)
+
+    authURL, err := p.AuthCodeURL(context.Background(), "state-1", "nonce-1", "verifier-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    q, _ := url.ParseQuery(authURL[strings.Index(authURL, "?")+1:])
+
+    sum := sha256.Sum256([]byte("verifier-1"))
+    want := map[string]string{
+        "state":                 "state-1",
+        "nonce":                 "nonce-1",
+        "scope":                 "openid email profile",
+        "code_challenge":        base64.RawURLEncoding.EncodeToString(sum[:]),
+        "code_challenge_method": "S256",
+    }
+    for k, v := range want {
+        if got := q.Get(k); got != v {
+            t.Errorf("%s = %q, want %q", k, got, v)
+        }
+    }
+}
+
+func TestOIDCDiscoveryIsLazy(t *testing.T) {
+    f := newFakeIssuer(t)
+    f.down = true
+    p := newTestOIDCProvider(f)
+    ctx := context.Background()
+
+    if _, err := p.AuthCodeURL(ctx, "s", "n", "v"); err == nil {
+        t.Fatal("AuthCodeURL succeeded while discovery fails")
+    }
+
+    f.mu.Lock()
+    f.down = false
+    f.mu.Unlock()
+    for i := 0; i < 2; i++ {
+        if _, err := p.AuthCodeURL(ctx, "s", "n", "v"); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Retried after the failure, then cached
+    if f.discovery != 2 {
+        t.Errorf("discovery fetched %d times, want 2", f.discovery)
+    }
+}
+
+func TestOIDCExchange(t *testing.T) {
+    f := newFakeIssuer(t)
+    p := newTestOIDCProvider(f)
+    ctx := context.Background()
+
+    authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    identity, err := p.Exchange(ctx, f.authorize(t, authURL), "nonce-1", "verifier-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := Identity{
+        Provider:      "oidc",
+        Subject:       "subject-1",
+        Username:      "ana",
+        Email:         "ana@example.com",
+        EmailVerified: true,
+        Name:          "Ana",
+        AvatarURL:     "https://example.com/ana.png",
+    }
+    if *identity != want {
+        t.Errorf("Exchange() = %+v, want %+v", *identity, want)
+    }
+}
+
+func TestOIDCExchangeWrongVerifier(t *testing.T) {
+    f := newFakeIssuer(t)
+    p := newTestOIDCProvider(f)
+    ctx := context.Background()
+
+    authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := p.Exchange(ctx, f.authorize(t, authURL), "nonce-1", "verifier-2"); err == nil {
+        t.Fatal("Exchange succeeded with another code verifier")
+    }
+}
+
+func TestOIDCExchangeNonceMismatch(t *testing.T) {
+    f := newFakeIssuer(t)
+    p := newTestOIDCProvider(f)
+    ctx := context.Background()
+
+    authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = p.Exchange(ctx, f.authorize(t, authURL), "nonce-2", "verifier-1")
+    if err == nil || !strings.Contains(err.Error(), "nonce") {
+        t.Fatalf("Exchange() error = %v, want nonce mismatch", err)
+    }
+}
+
+func TestOIDCExchangeUserInfo(t *testing.T) {
+    f := newFakeIssuer(t)
+    f.claims = map[string]interface{}{}
+    f.userinfo = map[string]interface{}{
+        "sub":            "subject-1",
+        "email":          "ana@example.com",
+        "email_verified": true,
+        "name":           "Ana",
+    }
+    p := newTestOIDCProvider(f)
+    ctx := context.Background()
+
+    authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    identity, err := p.Exchange(ctx, f.authorize(t, authURL), "nonce-1", "verifier-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if identity.Email != "ana@example.com" || !identity.EmailVerified || identity.Name != "Ana" {
+        t.Errorf("Exchange() = %+v, want profile from userinfo", *identity)
+    }
+
+    // Userinfo of another subject is ignored
+    f.mu.Lock()
+    f.userinfo["sub"] = "subject-2"
+    f.mu.Unlock()
+    authURL, _ = p.AuthCodeURL(ctx, "state-2", "nonce-2", "verifier-2")
+    identity, err = p.Exchange(ctx, f.authorize(t, authURL), "nonce-2", "verifier-2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if identity.Email != "" {
+        t.Errorf("Email = %q from userinfo of another subject", identity.Email)
+    }
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+    "crypto/rand"
+    "crypto/subtle"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "strings"
+
+    "golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for new hashes (OWASP recommended minimums are
+// m=19MiB, t=2; these are somewhat stronger). Stored hashes carry their own
+// parameters, so raising them only affects new and rehashed passwords.
+const (
+    argonMemory  = 64 * 1024
+    argonTime    = 3
+    argonThreads = 2
+    argonSaltLen = 16
+    argonKeyLen  = 32
+)
+
+var errInvalidPasswordHash = errors.New("invalid password hash")
+
+// HashPassword hashes password with Argon2id and returns it in PHC string
+// format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+func HashPassword(password string) (string, error) {
+    salt := make([]byte, argonSaltLen)
+    if _, err := rand.Read(salt); err != nil {
+        return "", err
+    }
+
+    key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+    return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+        argon2.Version, argonMemory, argonTime, argonThreads,
+        base64.RawStdEncoding.EncodeToString(salt),
+        base64.RawStdEncoding.EncodeToString(key),
+    ), nil
+}
+
+// CheckPassword reports whether password matches encoded, comparing in
+// constant time.
+func CheckPassword(password, encoded string) (bool, error) {
+    parts := strings.Split(encoded, "$")
+    if len(parts) != 6 || parts[1] != "argon2id" {
+        return false, errInvalidPasswordHash
+    }
+
+    var version int
+    if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+        return false, errInvalidPasswordHash
+    }
+
+    var memory, time uint32
+    var threads uint8
+    if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+        return false, errInvalidPasswordHash
+    }
+
+    salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+    if err != nil {
+        return false, errInvalidPasswordHash
+    }
+    want, err := base64.RawStdEncoding.DecodeString(parts[5])
+    if err != nil {
+        return false, errInvalidPasswordHash
+    }
+
+    got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+    return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+    "encoding/base64"
+    "fmt"
+    "strings"
+    "testing"
+
+    "golang.org/x/crypto/argon2"
+)
+
+func TestHashPassword(t *testing.T) {
+    hash, err := HashPassword("correct horse battery staple")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.HasPrefix(hash, fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$", argon2.Version, argonMemory, argonTime, argonThreads)) {
+        t.Errorf("HashPassword() = %q, want argon2id with the current parameters", hash)
+    }
+
+    if ok, err := CheckPassword("correct horse battery staple", hash); err != nil || !ok {
+        t.Errorf("CheckPassword() of the right password = %v, %v", ok, err)
+    }
+    for _, wrong := range []string{"", "correct horse battery stapl", "Correct horse battery staple"} {
+        if ok, err := CheckPassword(wrong, hash); err != nil || ok {
+            t.Errorf("CheckPassword(%q) = %v, %v, want false", wrong, ok, err)
+        }
+    }
+
+    // Every hash gets its own salt
+    again, err := HashPassword("correct horse battery staple")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if again == hash {
+        t.Error("HashPassword() returned the same hash twice")
+    }
+}
+
+func TestCheckPasswordStoredParameters(t *testing.T) {
+    // A hash from before the parameters were raised still verifies with
+    // the parameters stored in it
+    salt := []byte("0123456789abcdef")
+    key := argon2.IDKey([]byte("hunter22hunter22"), salt, 2, 19*1024, 1, 32)
+    hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 19*1024, 2, 1,
+        base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+    if ok, err := CheckPassword("hunter22hunter22", hash); err != nil || !ok {
+        t.Errorf("CheckPassword() = %v, %v, want true", ok, err)
+    }
+}
+
+func TestCheckPasswordInvalidHash(t *testing.T) {
+    salt := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef"))
+    key := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+    tests := []string{
+        "",
+        "plaintext",
+        "$2a$10$abcdefghijklmnopqrstuuJ9h0aQ1S7lSzGdvJEdoT1vZ8J4m3G5W",
+        "$argon2i$v=19$m=65536,t=3,p=2$" + salt + "$" + key,
+        "$argon2id$v=16$m=65536,t=3,p=2$" + salt + "$" + key,
+        "$argon2id$v=19$m=x,t=3,p=2$" + salt + "$" + key,
+        "$argon2id$v=19$m=65536,t=3,p=2$not base64!$" + key,
+        "$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$not base64!",
+        "$argon2id$v=19$m=65536,t=3,p=2$" + salt,
+    }
+    for _, hash := range tests {
+        if ok, err := CheckPassword("password", hash); err == nil || ok {
+            t.Errorf("CheckPassword(%q) = %v, %v, want an error", hash, ok, err)
+        }
+    }
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+    "context"
+    "sort"
+)
+
+// Identity is the account an external login provider vouches for. Subject
+// is the provider's stable user ID; everything else is profile data that
+// may change between logins.
+type Identity struct {
+    Provider      string
+    Subject       string
+    Username      string
+    Email         string
+    EmailVerified bool
+    Name          string
+    AvatarURL     string
+    AccessToken   string
+}
+
+// Provider is an external login provider driven through the OAuth 2.0
+// authorization code flow. verifier is the PKCE code verifier and nonce is
+// bound into the ID token by providers that issue one; either may be empty.
+type Provider interface {
+    Name() string
+    AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+    Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error)
+}
+
+// Providers holds the enabled login providers indexed by name.
+type Providers map[string]Provider
+
+// Register adds p under its name, replacing any provider of the same name.
+func (ps Providers) Register(p Provider) {
+    ps[p.Name()] = p
+}
+
+// Names returns the registered provider names in sorted order.
+func (ps Providers) Names() []string {
+    names := make([]string, 0, len(ps))
+    for name := range ps {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
@@ -0,0 +1,439 @@
+// Package collab implements the operational transformation used by
+// collaborative file editing. Operations use the ot.js wire format so
+// browser editors can use an existing client: a JSON array where a positive
+// number retains that many characters, a negative number deletes that many
+// and a string inserts itself. Lengths count UTF-16 code units, like
+// JavaScript strings.
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// ErrInvalidOperation is returned for operations that do not fit the
+// document they are applied to.
+var ErrInvalidOperation = errors.New("invalid operation")
+
+type component struct {
+	retain int
+	delete int
+	insert string
+	// insertLen is the length of insert in UTF-16 code units
+	insertLen int
+}
+
+// Operation is a sequence of retain, insert and delete components that
+// covers a whole document of BaseLength and turns it into one of
+// TargetLength.
+type Operation struct {
+	components   []component
+	BaseLength   int
+	TargetLength int
+	// overflow is set when a component would overflow the lengths; such an
+	// operation cannot be applied or transformed
+	overflow bool
+}
+
+// grow adds to the lengths, or marks the operation invalid if they would
+// overflow.
+func (o *Operation) grow(base, target int) bool {
+	if o.overflow || base > math.MaxInt-o.BaseLength || target > math.MaxInt-o.TargetLength {
+		o.overflow = true
+		return false
+	}
+	o.BaseLength += base
+	o.TargetLength += target
+	return true
+}
+
+// Retain skips over n characters.
+func (o *Operation) Retain(n int) *Operation {
+	if n <= 0 || !o.grow(n, n) {
+		return o
+	}
+	if last := o.last(); last != nil && last.retain > 0 {
+		last.retain += n
+		return o
+	}
+	o.components = append(o.components, component{retain: n})
+	return o
+}
+
+// Insert inserts s at the current position.
+func (o *Operation) Insert(s string) *Operation {
+	if s == "" {
+		return o
+	}
+	n := utf16Len(s)
+	if !o.grow(0, n) {
+		return o
+	}
+
+	last := o.last()
+	switch {
+	case last != nil && last.insertLen > 0:
+		last.insert += s
+		last.insertLen += n
+	case last != nil && last.delete > 0:
+		// Keep inserts before deletes so equal operations have one form
+		if prev := o.at(len(o.components) - 2); prev != nil && prev.insertLen > 0 {
+			prev.insert += s
+			prev.insertLen += n
+		} else {
+			del := *last
+			*last = component{insert: s, insertLen: n}
+			o.components = append(o.components, del)
+		}
+	default:
+		o.components = append(o.components, component{insert: s, insertLen: n})
+	}
+	return o
+}
+
+// Delete removes n characters.
+func (o *Operation) Delete(n int) *Operation {
+	if n <= 0 || !o.grow(n, 0) {
+		return o
+	}
+	if last := o.last(); last != nil && last.delete > 0 {
+		last.delete += n
+		return o
+	}
+	o.components = append(o.components, component{delete: n})
+	return o
+}
+
+// IsNoop reports whether the operation leaves the document unchanged.
+func (o *Operation) IsNoop() bool {
+	return len(o.components) == 0 || (len(o.components) == 1 && o.components[0].retain > 0)
+}
+
+func (o *Operation) last() *component {
+	return o.at(len(o.components) - 1)
+}
+
+func (o *Operation) at(i int) *component {
+	if i < 0 || i >= len(o.components) {
+		return nil
+	}
+	return &o.components[i]
+}
+
+// valid reports an error for an operation whose lengths overflowed.
+func (o *Operation) valid() error {
+	if o.overflow {
+		return fmt.Errorf("%w: operation is too long", ErrInvalidOperation)
+	}
+	return nil
+}
+
+// Apply returns doc with the operation applied.
+func (o *Operation) Apply(doc []uint16) ([]uint16, error) {
+	if err := o.valid(); err != nil {
+		return nil, err
+	}
+	if len(doc) != o.BaseLength {
+		return nil, fmt.Errorf("%w: base length %d does not match document length %d", ErrInvalidOperation, o.BaseLength, len(doc))
+	}
+	if o.TargetLength > MaxDocumentLength {
+		return nil, ErrDocumentTooLarge
+	}
+
+	result := make([]uint16, 0, o.TargetLength)
+	pos := 0
+	for _, c := range o.components {
+		switch {
+		case c.retain > 0:
+			if c.retain > len(doc)-pos {
+				return nil, fmt.Errorf("%w: retain past the end of the document", ErrInvalidOperation)
+			}
+			result = append(result, doc[pos:pos+c.retain]...)
+			pos += c.retain
+		case c.insertLen > 0:
+			result = append(result, utf16.Encode([]rune(c.insert))...)
+		default:
+			if c.delete > len(doc)-pos {
+				return nil, fmt.Errorf("%w: delete past the end of the document", ErrInvalidOperation)
+			}
+			pos += c.delete
+		}
+	}
+	if pos != len(doc) || len(result) != o.TargetLength {
+		return nil, fmt.Errorf("%w: operation does not cover the document", ErrInvalidOperation)
+	}
+	return result, nil
+}
+
+// Transform takes two operations a and b on the same document and returns
+// a' and b' such that applying a then b' gives the same document as b then
+// a'. When both insert at the same position, a's insert goes first.
+func Transform(a, b *Operation) (*Operation, *Operation, error) {
+	if err := a.valid(); err != nil {
+		return nil, nil, err
+	}
+	if err := b.valid(); err != nil {
+		return nil, nil, err
+	}
+	if a.BaseLength != b.BaseLength {
+		return nil, nil, fmt.Errorf("%w: operations have different base lengths", ErrInvalidOperation)
+	}
+
+	aPrime, bPrime := &Operation{}, &Operation{}
+	ai, bi := 0, 0
+	ac, bc := next(a, &ai), next(b, &bi)
+
+	for ac != nil || bc != nil {
+		if ac != nil && ac.insertLen > 0 {
+			aPrime.Insert(ac.insert)
+			bPrime.Retain(ac.insertLen)
+			ac = next(a, &ai)
+			continue
+		}
+		if bc != nil && bc.insertLen > 0 {
+			aPrime.Retain(bc.insertLen)
+			bPrime.Insert(bc.insert)
+			bc = next(b, &bi)
+			continue
+		}
+		if ac == nil || bc == nil {
+			return nil, nil, fmt.Errorf("%w: operations have different lengths", ErrInvalidOperation)
+		}
+
+		switch {
+		case ac.retain > 0 && bc.retain > 0:
+			n := min(ac.retain, bc.retain)
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+			ac.retain -= n
+			bc.retain -= n
+		case ac.delete > 0 && bc.delete > 0:
+			n := min(ac.delete, bc.delete)
+			ac.delete -= n
+			bc.delete -= n
+		case ac.delete > 0:
+			n := min(ac.delete, bc.retain)
+			aPrime.Delete(n)
+			ac.delete -= n
+			bc.retain -= n
+		default:
+			n := min(ac.retain, bc.delete)
+			bPrime.Delete(n)
+			ac.retain -= n
+			bc.delete -= n
+		}
+
+		if ac.retain == 0 && ac.delete == 0 {
+			ac = next(a, &ai)
+		}
+		if bc.retain == 0 && bc.delete == 0 {
+			bc = next(b, &bi)
+		}
+	}
+	return aPrime, bPrime, nil
+}
+
+// Compose returns one operation that has the same effect as applying a and
+// then b.
+func Compose(a, b *Operation) (*Operation, error) {
+	if err := a.valid(); err != nil {
+		return nil, err
+	}
+	if err := b.valid(); err != nil {
+		return nil, err
+	}
+	if a.TargetLength != b.BaseLength {
+		return nil, fmt.Errorf("%w: target length of the first operation does not match base length of the second", ErrInvalidOperation)
+	}
+
+	composed := &Operation{}
+	ai, bi := 0, 0
+	ac, bc := next(a, &ai), next(b, &bi)
+
+	for ac != nil || bc != nil {
+		if ac != nil && ac.delete > 0 {
+			composed.Delete(ac.delete)
+			ac = next(a, &ai)
+			continue
+		}
+		if bc != nil && bc.insertLen > 0 {
+			composed.Insert(bc.insert)
+			bc = next(b, &bi)
+			continue
+		}
+		if ac == nil || bc == nil {
+			return nil, fmt.Errorf("%w: operations have different lengths", ErrInvalidOperation)
+		}
+
+		switch {
+		case ac.retain > 0 && bc.retain > 0:
+			n := min(ac.retain, bc.retain)
+			composed.Retain(n)
+			ac.retain -= n
+			bc.retain -= n
+		case ac.retain > 0:
+			n := min(ac.retain, bc.delete)
+			composed.Delete(n)
+			ac.retain -= n
+			bc.delete -= n
+		case bc.retain > 0:
+			n := min(ac.insertLen, bc.retain)
+			composed.Insert(ac.take(n))
+			bc.retain -= n
+		default:
+			// b deletes what a inserted
+			n := min(ac.insertLen, bc.delete)
+			ac.take(n)
+			bc.delete -= n
+		}
+
+		if ac.retain == 0 && ac.delete == 0 && ac.insertLen == 0 {
+			ac = next(a, &ai)
+		}
+		if bc.retain == 0 && bc.delete == 0 {
+			bc = next(b, &bi)
+		}
+	}
+	return composed, nil
+}
+
+// next returns a copy of the component at *i and advances *i, or nil at
+// the end of o.
+func next(o *Operation, i *int) *component {
+	if *i >= len(o.components) {
+		return nil
+	}
+	c := o.components[*i]
+	*i++
+	return &c
+}
+
+// take removes the first n code units of an insert and returns them.
+func (c *component) take(n int) string {
+	units := Encode(c.insert)
+	c.insert, c.insertLen = Decode(units[n:]), c.insertLen-n
+	return Decode(units[:n])
+}
+
+// TransformIndex moves a cursor position so it points at the same place
+// after the operation is applied.
+func (o *Operation) TransformIndex(index int) int {
+	newIndex := index
+	for _, c := range o.components {
+		switch {
+		case c.retain > 0:
+			index -= c.retain
+		case c.insertLen > 0:
+			newIndex += c.insertLen
+		default:
+			newIndex -= min(index, c.delete)
+			index -= c.delete
+		}
+		if index < 0 {
+			break
+		}
+	}
+	return newIndex
+}
+
+// Replace returns the operation that turns old into new, touching only the
+// part between their common prefix and suffix so cursors elsewhere stay in
+// place.
+func Replace(old, new []uint16) *Operation {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	// Never split a surrogate pair
+	if prefix > 0 && utf16.IsSurrogate(rune(old[prefix-1])) && old[prefix-1] < 0xdc00 {
+		prefix--
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	if suffix > 0 && utf16.IsSurrogate(rune(new[len(new)-suffix])) && new[len(new)-suffix] >= 0xdc00 {
+		suffix--
+	}
+
+	op := &Operation{}
+	op.Retain(prefix)
+	op.Insert(string(utf16.Decode(new[prefix : len(new)-suffix])))
+	op.Delete(len(old) - prefix - suffix)
+	op.Retain(suffix)
+	return op
+}
+
+// MarshalJSON encodes the operation in the ot.js format.
+func (o *Operation) MarshalJSON() ([]byte, error) {
+	out := make([]interface{}, 0, len(o.components))
+	for _, c := range o.components {
+		switch {
+		case c.retain > 0:
+			out = append(out, c.retain)
+		case c.insertLen > 0:
+			out = append(out, c.insert)
+		default:
+			out = append(out, -c.delete)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes an operation in the ot.js format.
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*o = Operation{}
+	for _, item := range raw {
+		var s string
+		if err := json.Unmarshal(item, &s); err == nil {
+			if s == "" {
+				return fmt.Errorf("%w: empty insert", ErrInvalidOperation)
+			}
+			if utf16Len(s) > MaxDocumentLength {
+				return fmt.Errorf("%w: insert is longer than a document", ErrInvalidOperation)
+			}
+			o.Insert(s)
+			continue
+		}
+
+		var n int
+		if err := json.Unmarshal(item, &n); err != nil || n == 0 {
+			return fmt.Errorf("%w: components must be non-zero integers or strings", ErrInvalidOperation)
+		}
+		if n > MaxDocumentLength || n < -MaxDocumentLength {
+			return fmt.Errorf("%w: component is longer than a document", ErrInvalidOperation)
+		}
+		if n > 0 {
+			o.Retain(n)
+		} else {
+			o.Delete(-n)
+		}
+	}
+	return o.valid()
+}
+
+// Encode converts text to the UTF-16 code units operations work on.
+func Encode(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+// Decode converts UTF-16 code units back to text.
+func Decode(doc []uint16) string {
+	return string(utf16.Decode(doc))
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,264 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// op decodes an operation in the ot.js format.
+func op(t *testing.T, raw string) *Operation {
+	t.Helper()
+	o := &Operation{}
+	if err := json.Unmarshal([]byte(raw), o); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+	return o
+}
+
+func apply(t *testing.T, o *Operation, doc string) string {
+	t.Helper()
+	out, err := o.Apply(Encode(doc))
+	if err != nil {
+		t.Fatalf("Apply(%q): %v", doc, err)
+	}
+	return Decode(out)
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		doc, op, want string
+	}{
+		{"", `["abc"]`, "abc"},
+		{"hello", `[5, " world"]`, "hello world"},
+		{"hello world", `[5, -6]`, "hello"},
+		{"hello", `["oh ", -1, "j", 4]`, "oh jello"},
+		// Lengths count UTF-16 code units, so an emoji is two
+		{"a😀b", `[1, -2, "é", 1]`, "aéb"},
+		{"a😀b", `[3, "😃", 1]`, "a😀😃b"},
+	}
+	for _, tt := range tests {
+		o := op(t, tt.op)
+		if got := apply(t, o, tt.doc); got != tt.want {
+			t.Errorf("Apply(%q, %s) = %q, want %q", tt.doc, tt.op, got, tt.want)
+		}
+		if got := len(Encode(tt.want)); got != o.TargetLength {
+			t.Errorf("%s: TargetLength = %d, want %d", tt.op, o.TargetLength, got)
+		}
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	doc := Encode("hello")
+	tests := map[string]*Operation{
+		"empty":         &Operation{},
+		"short base":    (&Operation{}).Retain(4),
+		"long base":     (&Operation{}).Retain(5).Delete(1),
+		"overflow":      (&Operation{}).Retain(math.MaxInt).Retain(1),
+		"overflow mix":  (&Operation{}).Retain(math.MaxInt).Delete(math.MaxInt).Retain(5),
+		"insert+delete": (&Operation{}).Insert("x").Delete(math.MaxInt).Retain(math.MaxInt),
+	}
+	for name, o := range tests {
+		if _, err := o.Apply(doc); !errors.Is(err, ErrInvalidOperation) {
+			t.Errorf("%s: Apply() error = %v, want %v", name, err, ErrInvalidOperation)
+		}
+	}
+
+	tooLarge := (&Operation{}).Retain(5).Insert(strings.Repeat("x", MaxDocumentLength))
+	if _, err := tooLarge.Apply(doc); !errors.Is(err, ErrDocumentTooLarge) {
+		t.Errorf("Apply() error = %v, want %v", err, ErrDocumentTooLarge)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	tests := []string{
+		// Sums to the length of a 1-character document but would make Apply
+		// allocate and slice past the end
+		fmt.Sprintf(`[%d, %d, 3]`, math.MaxInt, -math.MaxInt),
+		fmt.Sprintf(`[%d]`, MaxDocumentLength+1),
+		fmt.Sprintf(`[%d]`, -MaxDocumentLength-1),
+		fmt.Sprintf(`[%q]`, strings.Repeat("x", MaxDocumentLength+1)),
+		`[1e30]`,
+		`[1.5]`,
+		`[0]`,
+		`[""]`,
+		`[null]`,
+		`[{"retain": 1}]`,
+		`[true]`,
+		`{"ops": [1]}`,
+	}
+	for _, raw := range tests {
+		var o Operation
+		if err := json.Unmarshal([]byte(raw), &o); err == nil {
+			t.Errorf("Unmarshal(%.40s) succeeded", raw)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	// Inserts before deletes and adjacent components merge, so there is one
+	// form of every operation
+	o := (&Operation{}).Retain(2).Retain(1).Delete(2).Insert("a").Insert("b").Retain(1)
+	raw, err := json.Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(raw), `[3,"ab",-2,1]`; got != want {
+		t.Errorf("Marshal() = %s, want %s", got, want)
+	}
+	if o.BaseLength != 6 || o.TargetLength != 6 {
+		t.Errorf("lengths = %d, %d, want 6, 6", o.BaseLength, o.TargetLength)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		doc, a, b, want string
+	}{
+		{"abc", `[1, "x", 2]`, `[2, "y", 1]`, "axbyc"},
+		// Inserts at the same position: a goes first
+		{"abc", `[1, "x", 2]`, `[1, "y", 2]`, "axybc"},
+		{"abc", `[-1, 2]`, `[-1, 2]`, "bc"},
+		{"abcdef", `[1, -3, 2]`, `[2, -3, 1]`, "af"},
+		{"abc", `[1, -2]`, `[2, "x", 1]`, "ax"},
+		{"abc", `[3, "!"]`, `[-3]`, "!"},
+	}
+	for _, tt := range tests {
+		a, b := op(t, tt.a), op(t, tt.b)
+		aPrime, bPrime, err := Transform(a, b)
+		if err != nil {
+			t.Fatalf("Transform(%s, %s): %v", tt.a, tt.b, err)
+		}
+		ab := apply(t, bPrime, apply(t, a, tt.doc))
+		ba := apply(t, aPrime, apply(t, b, tt.doc))
+		if ab != tt.want || ba != tt.want {
+			t.Errorf("Transform(%s, %s) on %q: a then b' = %q, b then a' = %q, want %q", tt.a, tt.b, tt.doc, ab, ba, tt.want)
+		}
+	}
+
+	if _, _, err := Transform(op(t, `[1]`), op(t, `[2]`)); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("Transform() of different base lengths error = %v", err)
+	}
+	overflow := (&Operation{}).Retain(math.MaxInt).Retain(1)
+	if _, _, err := Transform(overflow, overflow); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("Transform() of overflowed operations error = %v", err)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	tests := []struct {
+		doc, a, b, want string
+	}{
+		{"abc", `[1, "x", 2]`, `[4, "y"]`, "axbcy"},
+		// b deletes part of what a inserted
+		{"abc", `[1, "xyz", 2]`, `[2, -1, 3]`, "axzbc"},
+		{"abc", `[-1, 2]`, `[-1, 1]`, "c"},
+		{"", `["😀x"]`, `[2, -1]`, "😀"},
+		{"abc", `[3]`, `[-3, "new"]`, "new"},
+	}
+	for _, tt := range tests {
+		a, b := op(t, tt.a), op(t, tt.b)
+		composed, err := Compose(a, b)
+		if err != nil {
+			t.Fatalf("Compose(%s, %s): %v", tt.a, tt.b, err)
+		}
+		if got := apply(t, composed, tt.doc); got != tt.want {
+			t.Errorf("Compose(%s, %s) on %q = %q, want %q", tt.a, tt.b, tt.doc, got, tt.want)
+		}
+	}
+
+	if _, err := Compose(op(t, `[1, "x"]`), op(t, `[1]`)); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("Compose() of mismatched lengths error = %v", err)
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	tests := []struct {
+		op          string
+		index, want int
+	}{
+		{`[2, "xy", 3]`, 1, 1},
+		{`[2, "xy", 3]`, 2, 4},
+		{`[2, "xy", 3]`, 4, 6},
+		{`[1, -2, 2]`, 0, 0},
+		{`[1, -2, 2]`, 2, 1},
+		{`[1, -2, 2]`, 4, 2},
+		{`["a", -5]`, 5, 1},
+	}
+	for _, tt := range tests {
+		if got := op(t, tt.op).TransformIndex(tt.index); got != tt.want {
+			t.Errorf("%s.TransformIndex(%d) = %d, want %d", tt.op, tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	tests := [][2]string{
+		{"hello world", "hello there world"},
+		{"abc", ""},
+		{"", "abc"},
+		{"same", "same"},
+		// The common prefix must not end inside a surrogate pair
+		{"a😀", "a😃"},
+	}
+	for _, tt := range tests {
+		o := Replace(Encode(tt[0]), Encode(tt[1]))
+		if got := apply(t, o, tt[0]); got != tt[1] {
+			t.Errorf("Replace(%q, %q) gives %q", tt[0], tt[1], got)
+		}
+	}
+}
+
+// randomOperation returns a valid operation on a document of n code units.
+func randomOperation(r *rand.Rand, n int) *Operation {
+	o := &Operation{}
+	for n > 0 {
+		k := 1 + r.Intn(n)
+		switch r.Intn(3) {
+		case 0:
+			o.Retain(k)
+			n -= k
+		case 1:
+			o.Delete(k)
+			n -= k
+		default:
+			o.Insert(strings.Repeat(string(rune('a'+r.Intn(26))), 1+r.Intn(3)))
+		}
+	}
+	if r.Intn(2) == 0 {
+		o.Insert("z")
+	}
+	return o
+}
+
+func TestRandomConvergence(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		doc := "0123456789abcdef"[:r.Intn(17)]
+		a := randomOperation(r, len(doc))
+		b := randomOperation(r, len(doc))
+
+		aPrime, bPrime, err := Transform(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ab := apply(t, bPrime, apply(t, a, doc))
+		ba := apply(t, aPrime, apply(t, b, doc))
+		if ab != ba {
+			t.Fatalf("%q: a then b' = %q, b then a' = %q", doc, ab, ba)
+		}
+
+		c := randomOperation(r, b.TargetLength)
+		bc, err := Compose(b, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := apply(t, bc, doc), apply(t, c, apply(t, b, doc)); got != want {
+			t.Fatalf("%q: Compose() gives %q, want %q", doc, got, want)
+		}
+	}
+}
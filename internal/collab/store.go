@@ -0,0 +1,446 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"devsync-be/internal/history"
+	"devsync-be/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrFileNotFound        = errors.New("file not found")
+	ErrRevisionUnavailable = errors.New("revision is no longer available")
+	ErrDocumentTooLarge    = errors.New("document too large")
+)
+
+const (
+	// MaxDocumentLength is the largest document, in UTF-16 code units, an
+	// edit may produce
+	MaxDocumentLength = 1 << 20
+	// File.Content is saved after this many edits, and by Run for files
+	// that stopped changing
+	snapshotEvery = 50
+	flushInterval = 5 * time.Second
+	// Edits already in File.Content are kept this long, in revisions, for
+	// clients catching up
+	keepEdits = 500
+	// Cached documents unused for this long are dropped
+	evictAfter = 10 * time.Minute
+)
+
+// Edit is an operation as the server applied it, transformed against every
+// edit before Revision.
+type Edit struct {
+	FileID       uint
+	Revision     int64
+	UserID       uint
+	ConnectionID string
+	Operation    *Operation
+}
+
+// Store applies edits to files in a single order shared by every instance.
+// Each edit locks the file's FileEditState row, transforms the operation
+// against the edits the client had not seen yet and appends it to the
+// FileEdit log. The current text is cached per instance and brought up to
+// date from the log when another instance changed it.
+type Store struct {
+	db    *gorm.DB
+	mu    sync.Mutex
+	docs  map[uint]*document
+	saved func(fileID, userID uint, message string)
+}
+
+type document struct {
+	mu       sync.Mutex
+	loaded   bool
+	content  []uint16
+	revision int64
+	snapshot int64
+	lastUsed time.Time
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{db: db, docs: make(map[uint]*document)}
+}
+
+// OnSave sets a function called after File.Content of a file was saved,
+// with the author of the saved content. Set it before Run.
+func (s *Store) OnSave(fn func(fileID, userID uint, message string)) {
+	s.saved = fn
+}
+
+// Open returns the current content and revision of a file.
+func (s *Store) Open(projectID, fileID uint) (string, int64, error) {
+	d := s.doc(fileID)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		state, err := lockState(tx, projectID, fileID)
+		if err != nil {
+			return err
+		}
+		return catchUp(tx, fileID, d, state)
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return Decode(d.content), d.revision, nil
+}
+
+// Submit applies op, which the client based on revision base. publish is
+// called in the same transaction with the edit as applied.
+func (s *Store) Submit(projectID, fileID, userID uint, connectionID string, base int64, op *Operation, publish func(tx *gorm.DB, edit *Edit) error) (*Edit, error) {
+	return s.commit(projectID, fileID, "", false, func(tx *gorm.DB, current int64, doc []uint16) (*Operation, error) {
+		if base > current {
+			return nil, ErrRevisionUnavailable
+		}
+		if base < current {
+			concurrent, err := loadEdits(tx, fileID, base, current)
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(concurrent)) != current-base {
+				return nil, ErrRevisionUnavailable
+			}
+			for _, edit := range concurrent {
+				if op, _, err = Transform(op, edit.Operation); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return op, nil
+	}, func(edit *Edit) {
+		edit.UserID, edit.ConnectionID = userID, connectionID
+	}, publish)
+}
+
+// Replace sets the whole content of a file, as a REST update does, and
+// saves it right away as a file revision with message. It returns nil if
+// the content did not change. If revision is not negative the file must
+// still be at that revision. update runs in the same transaction, so other
+// changes to the file commit together with the content.
+func (s *Store) Replace(projectID, fileID, userID uint, content string, revision int64, message string, update func(tx *gorm.DB) error, publish func(tx *gorm.DB, edit *Edit) error) (*Edit, error) {
+	return s.commit(projectID, fileID, message, true, func(tx *gorm.DB, current int64, doc []uint16) (*Operation, error) {
+		if revision >= 0 && revision != current {
+			return nil, ErrRevisionUnavailable
+		}
+		if err := update(tx); err != nil {
+			return nil, err
+		}
+		op := Replace(doc, Encode(content))
+		if op.IsNoop() {
+			return nil, nil
+		}
+		return op, nil
+	}, func(edit *Edit) {
+		edit.UserID = userID
+	}, publish)
+}
+
+// Since returns the edits of a file after revision after, up to and
+// including upTo.
+func (s *Store) Since(fileID uint, after, upTo int64) ([]*Edit, error) {
+	return loadEdits(s.db, fileID, after, upTo)
+}
+
+// commit runs one edit: build returns the operation to apply to the
+// current document, or nil to change nothing.
+func (s *Store) commit(projectID, fileID uint, message string, snapshot bool, build func(tx *gorm.DB, current int64, doc []uint16) (*Operation, error), fill func(*Edit), publish func(tx *gorm.DB, edit *Edit) error) (*Edit, error) {
+	d := s.doc(fileID)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var edit *Edit
+	var content []uint16
+	var snapshotRevision int64
+	var savedBy uint
+	saved := false
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		state, err := lockState(tx, projectID, fileID)
+		if err != nil {
+			return err
+		}
+		if err := catchUp(tx, fileID, d, state); err != nil {
+			return err
+		}
+
+		op, err := build(tx, state.Revision, d.content)
+		if err != nil || op == nil {
+			return err
+		}
+		if content, err = op.Apply(d.content); err != nil {
+			return err
+		}
+		if len(content) > MaxDocumentLength {
+			return ErrDocumentTooLarge
+		}
+
+		edit = &Edit{FileID: fileID, Revision: state.Revision + 1, Operation: op}
+		fill(edit)
+		raw, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&models.FileEdit{
+			FileID:       fileID,
+			Revision:     edit.Revision,
+			UserID:       edit.UserID,
+			ConnectionID: edit.ConnectionID,
+			Operation:    raw,
+		}).Error; err != nil {
+			return err
+		}
+
+		state.Revision = edit.Revision
+		if snapshot || state.Revision-state.SnapshotRevision >= snapshotEvery {
+			if savedBy, err = saveSnapshot(tx, state, content, edit.UserID, message); err != nil {
+				return err
+			}
+			saved = true
+		} else if err := tx.Save(state).Error; err != nil {
+			return err
+		}
+		snapshotRevision = state.SnapshotRevision
+
+		return publish(tx, edit)
+	})
+	if err != nil || edit == nil {
+		return nil, err
+	}
+
+	d.content, d.revision, d.snapshot = content, edit.Revision, snapshotRevision
+	if saved && s.saved != nil {
+		s.saved(fileID, savedBy, message)
+	}
+	return edit, nil
+}
+
+// Run saves File.Content of files with unsaved edits every few seconds and
+// drops idle documents from the cache.
+func (s *Store) Run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		docs := make(map[uint]*document, len(s.docs))
+		for fileID, d := range s.docs {
+			docs[fileID] = d
+		}
+		s.mu.Unlock()
+
+		for fileID, d := range docs {
+			if err := s.flush(fileID, d); err != nil {
+				log.Printf("Collab: failed to save file %d: %v", fileID, err)
+			}
+		}
+	}
+}
+
+func (s *Store) flush(fileID uint, d *document) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.loaded && d.revision > d.snapshot {
+		var savedBy uint
+		saved := false
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var state models.FileEditState
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&state, "file_id = ?", fileID).Error; err != nil {
+				return err
+			}
+			if err := catchUp(tx, fileID, d, &state); err != nil {
+				return err
+			}
+			if state.SnapshotRevision < state.Revision {
+				var err error
+				savedBy, err = saveSnapshot(tx, &state, d.content, 0, "")
+				saved = err == nil
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		d.snapshot = d.revision
+		if saved && s.saved != nil {
+			s.saved(fileID, savedBy, "")
+		}
+	}
+
+	s.mu.Lock()
+	if time.Since(d.lastUsed) > evictAfter {
+		delete(s.docs, fileID)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Drop removes a deleted file from the cache, so its content is not saved
+// again.
+func (s *Store) Drop(fileID uint) {
+	s.mu.Lock()
+	d := s.docs[fileID]
+	delete(s.docs, fileID)
+	s.mu.Unlock()
+
+	if d != nil {
+		d.mu.Lock()
+		d.loaded = false
+		d.mu.Unlock()
+	}
+}
+
+func (s *Store) doc(fileID uint) *document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.docs[fileID]
+	if d == nil {
+		d = &document{}
+		s.docs[fileID] = d
+	}
+	d.lastUsed = time.Now()
+	return d
+}
+
+// LockRevision locks the edit state of a file in tx and returns its
+// revision. Edits wait for tx, so a change such as deleting the file can
+// check that nobody edited it since the revision was read.
+func LockRevision(tx *gorm.DB, projectID, fileID uint) (int64, error) {
+	state, err := lockState(tx, projectID, fileID)
+	if err != nil {
+		return 0, err
+	}
+	return state.Revision, nil
+}
+
+// Forget deletes the edit log and edit state of a file that tx deletes.
+// Call it after LockRevision, so edits waiting for the lock find the file
+// gone instead of starting a new log.
+func Forget(tx *gorm.DB, fileID uint) error {
+	if err := tx.Where("file_id = ?", fileID).Delete(&models.FileEdit{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("file_id = ?", fileID).Delete(&models.FileEditState{}).Error
+}
+
+// lockState checks that the file is in the project and locks its edit
+// state, creating it on the first edit. The file is checked after the lock
+// is held, so an edit that waited for a deletion fails.
+func lockState(tx *gorm.DB, projectID, fileID uint) (*models.FileEditState, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.FileEditState{FileID: fileID}).Error; err != nil {
+		return nil, err
+	}
+	var state models.FileEditState
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&state, "file_id = ?", fileID).Error; err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := tx.Model(&models.File{}).Where("id = ? AND project_id = ?", fileID, projectID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrFileNotFound
+	}
+	return &state, nil
+}
+
+// catchUp brings the cached document to the revision in state, reloading
+// it from File.Content if it is older than the last snapshot.
+func catchUp(tx *gorm.DB, fileID uint, d *document, state *models.FileEditState) error {
+	if d.loaded && d.revision == state.Revision {
+		return nil
+	}
+
+	content, revision := d.content, d.revision
+	if !d.loaded || revision > state.Revision || revision < state.SnapshotRevision {
+		var text string
+		if err := tx.Model(&models.File{}).Select("content").Where("id = ?", fileID).Scan(&text).Error; err != nil {
+			return err
+		}
+		content, revision = Encode(text), state.SnapshotRevision
+	}
+
+	edits, err := loadEdits(tx, fileID, revision, state.Revision)
+	if err != nil {
+		return err
+	}
+	if int64(len(edits)) != state.Revision-revision {
+		return fmt.Errorf("edit log of file %d is missing revisions %d to %d", fileID, revision+1, state.Revision)
+	}
+	for _, edit := range edits {
+		if content, err = edit.Operation.Apply(content); err != nil {
+			return fmt.Errorf("edit %d of file %d: %w", edit.Revision, fileID, err)
+		}
+	}
+
+	d.loaded, d.content, d.revision, d.snapshot = true, content, state.Revision, state.SnapshotRevision
+	return nil
+}
+
+// saveSnapshot writes content, which must be at state.Revision, to
+// File.Content, records it as a file revision and prunes edits no client
+// should still need. If userID is 0 the revision is attributed to the
+// author of the last edit. It returns the user the revision is attributed
+// to.
+func saveSnapshot(tx *gorm.DB, state *models.FileEditState, content []uint16, userID uint, message string) (uint, error) {
+	text := Decode(content)
+	if err := tx.Model(&models.File{}).Where("id = ?", state.FileID).Update("content", text).Error; err != nil {
+		return 0, err
+	}
+	if userID == 0 {
+		if err := tx.Model(&models.FileEdit{}).
+			Where("file_id = ? AND revision = ?", state.FileID, state.Revision).
+			Select("user_id").Scan(&userID).Error; err != nil {
+			return 0, err
+		}
+	}
+	if _, err := history.Record(tx, state.FileID, userID, text, message); err != nil {
+		return 0, err
+	}
+
+	state.SnapshotRevision = state.Revision
+	if err := tx.Save(state).Error; err != nil {
+		return 0, err
+	}
+	return userID, tx.Where("file_id = ? AND revision <= ?", state.FileID, state.SnapshotRevision-keepEdits).
+		Delete(&models.FileEdit{}).Error
+}
+
+func loadEdits(tx *gorm.DB, fileID uint, after, upTo int64) ([]*Edit, error) {
+	var rows []models.FileEdit
+	if err := tx.Where("file_id = ? AND revision > ? AND revision <= ?", fileID, after, upTo).
+		Order("revision ASC").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	edits := make([]*Edit, 0, len(rows))
+	for _, row := range rows {
+		op := &Operation{}
+		if err := json.Unmarshal(row.Operation, op); err != nil {
+			return nil, fmt.Errorf("edit %d of file %d: %w", row.Revision, fileID, err)
+		}
+		edits = append(edits, &Edit{
+			FileID:       row.FileID,
+			Revision:     row.Revision,
+			UserID:       row.UserID,
+			ConnectionID: row.ConnectionID,
+			Operation:    op,
+		})
+	}
+	return edits, nil
+}
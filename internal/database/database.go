@@ -1,7 +1,13 @@
 package database
 
 import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"devsync-be/internal/filetree"
 	"devsync-be/internal/models"
+	"devsync-be/internal/search"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -16,6 +22,14 @@ func Initialize(databaseURL string) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	// Use a custom join table so memberships can carry a role
+	if err := db.SetupJoinTable(&models.Project{}, "Users", &models.UserProject{}); err != nil {
+		return nil, err
+	}
+	if err := db.SetupJoinTable(&models.User{}, "Projects", &models.UserProject{}); err != nil {
+		return nil, err
+	}
+
 	// Auto migrate the schema
 	err = db.AutoMigrate(
 		&models.User{},
@@ -27,6 +41,29 @@ func Initialize(databaseURL string) (*gorm.DB, error) {
 		&models.Documentation{},
 		&models.ChatMessage{},
 		&models.Deployment{},
+		&models.UserProject{},
+		&models.ProjectInvitation{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.APIToken{},
+		&models.UserIdentity{},
+		&models.OAuthState{},
+		&models.AccountToken{},
+		&models.LoginAttempt{},
+		&models.UserMFA{},
+		&models.MFARecoveryCode{},
+		&models.MFAChallenge{},
+		&models.MFAFailure{},
+		&models.ProjectEvent{},
+		&models.ProjectEventCounter{},
+		&models.PresenceSession{},
+		&models.FileEdit{},
+		&models.FileEditState{},
+		&models.FileRevision{},
+		&models.FileBlob{},
+		&models.Folder{},
+		&models.GitHubSync{},
+		&models.GitHubSyncFile{},
 	)
 	if err != nil {
 		return nil, err
@@ -38,6 +75,38 @@ func Initialize(databaseURL string) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	// Users without a GitHub account used to be stored with github_id 0,
+	// which collides on the unique index; they now have NULL
+	err = db.Model(&models.User{}).Where("git_hub_id = 0").Update("git_hub_id", nil).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// GitHub logins predate linked identities; give them one each
+	err = migrateGitHubIdentities(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure every project creator holds the owner role
+	err = migrateProjectOwners(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// File paths used to be free-form; make them unique and give them
+	// folders
+	err = migrateFileTree(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Full-text and code search indexes
+	err = search.Migrate(db)
+	if err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
@@ -65,4 +134,130 @@ func migrateExistingProjects(db *gorm.DB) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+func migrateProjectOwners(db *gorm.DB) error {
+	return db.Exec(`
+		UPDATE user_projects SET role = ?
+		FROM projects
+		WHERE projects.id = user_projects.project_id
+		  AND projects.created_by = user_projects.user_id
+		  AND user_projects.role <> ?`,
+		models.ProjectRoleOwner, models.ProjectRoleOwner,
+	).Error
+}
+
+func migrateGitHubIdentities(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, created_at, updated_at)
+		SELECT id, 'github', git_hub_id::text, email, NOW(), NOW()
+		FROM users
+		WHERE git_hub_id IS NOT NULL AND deleted_at IS NULL
+		ON CONFLICT (provider, subject) DO NOTHING`,
+	).Error
+}
+
+func migrateFileTree(db *gorm.DB) error {
+	var migrated bool
+	if err := db.Raw("SELECT to_regclass('idx_files_project_path') IS NOT NULL").Scan(&migrated).Error; err != nil {
+		return err
+	}
+	if migrated {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var files []models.File
+		if err := tx.Select("id, project_id, name, path, uploaded_by").Order("project_id, id").Find(&files).Error; err != nil {
+			return err
+		}
+
+		// Paths were either the full path or the folder of Name
+		paths := make(map[uint]map[string]bool)
+		for i := range files {
+			file := &files[i]
+			p := legacyPath(file.Path, file.Name, file.ID)
+			if paths[file.ProjectID] == nil {
+				paths[file.ProjectID] = make(map[string]bool)
+			}
+			if paths[file.ProjectID][p] {
+				p = uniquePath(p, file.ID)
+			}
+			paths[file.ProjectID][p] = true
+			file.Path = p
+		}
+
+		// A file whose path is also a folder of another file moves aside
+		folders := make(map[uint]map[string]uint)
+		for _, file := range files {
+			if folders[file.ProjectID] == nil {
+				folders[file.ProjectID] = make(map[string]uint)
+			}
+			for dir, _ := filetree.Split(file.Path); dir != ""; dir, _ = filetree.Split(dir) {
+				if _, ok := folders[file.ProjectID][dir]; !ok {
+					folders[file.ProjectID][dir] = file.UploadedBy
+				}
+			}
+		}
+		for i := range files {
+			file := &files[i]
+			if _, ok := folders[file.ProjectID][file.Path]; ok {
+				file.Path = uniquePath(file.Path, file.ID)
+			}
+			file.Dir, file.Name = filetree.Split(file.Path)
+			if err := tx.Model(&models.File{}).Where("id = ?", file.ID).
+				UpdateColumns(map[string]interface{}{"path": file.Path, "name": file.Name, "dir": file.Dir}).Error; err != nil {
+				return err
+			}
+		}
+
+		for projectID, dirs := range folders {
+			for p, userID := range dirs {
+				dir, name := filetree.Split(p)
+				if err := tx.Create(&models.Folder{ProjectID: projectID, Name: name, Path: p, Dir: dir, CreatedBy: userID}).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, statement := range []string{
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_project_path ON folders (project_id, path) WHERE deleted_at IS NULL",
+			"CREATE INDEX IF NOT EXISTS idx_folders_project_dir ON folders (project_id, dir) WHERE deleted_at IS NULL",
+			"CREATE INDEX IF NOT EXISTS idx_files_project_dir ON files (project_id, dir) WHERE deleted_at IS NULL",
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_files_project_path ON files (project_id, path) WHERE deleted_at IS NULL",
+		} {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// legacyPath turns a free-form path and name into a clean file path
+func legacyPath(p, name string, id uint) string {
+	var segments []string
+	for _, segment := range strings.Split(strings.ReplaceAll(p, "\\", "/"), "/") {
+		if cleaned, err := filetree.Clean(segment); err == nil {
+			segments = append(segments, cleaned)
+		}
+	}
+	if cleaned, err := filetree.Clean(name); err == nil && !strings.Contains(cleaned, "/") &&
+		(len(segments) == 0 || segments[len(segments)-1] != cleaned) {
+		segments = append(segments, cleaned)
+	}
+	if len(segments) == 0 {
+		return fmt.Sprintf("file-%d", id)
+	}
+	return strings.Join(segments, "/")
+}
+
+// uniquePath adds the file ID before the extension of p
+func uniquePath(p string, id uint) string {
+	dir, name := filetree.Split(p)
+	ext := filepath.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	return filetree.Join(dir, fmt.Sprintf("%s~%d%s", strings.TrimSuffix(name, ext), id, ext))
+}
@@ -0,0 +1,385 @@
+// Package filetree keeps the folder hierarchy of project files. Paths are
+// slash-separated and relative to the project root. Changes to the tree of
+// a project lock its row so they apply one at a time.
+package filetree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"devsync-be/internal/collab"
+	"devsync-be/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidPath     = errors.New("invalid path")
+	ErrPathExists      = errors.New("path already exists")
+	ErrParentNotFolder = errors.New("a parent of the path is a file")
+	ErrMoveIntoSelf    = errors.New("a folder cannot be moved into itself")
+	ErrFolderNotFound  = errors.New("folder not found")
+	ErrVersionMismatch = errors.New("file version changed")
+)
+
+const (
+	maxPathLength = 1024
+	maxNameLength = 255
+)
+
+// Node is a file or folder in a tree listing, without file content.
+type Node struct {
+	Type        string    `json:"type"`
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	FileType    string    `json:"file_type,omitempty"`
+	MimeType    string    `json:"mime_type,omitempty"`
+	Size        int64     `json:"size"`
+	Version     int64     `json:"version,omitempty"`
+	HasChildren bool      `json:"has_children"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Clean normalizes p and checks that it is a valid path inside a project.
+// Leading, trailing and repeated slashes and "." are dropped; ".." and
+// control characters are rejected.
+func Clean(p string) (string, error) {
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("%w: %q must not contain ..", ErrInvalidPath, p)
+		}
+		if len(segment) > maxNameLength || !utf8.ValidString(segment) || strings.ContainsFunc(segment, isControl) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidPath, p)
+		}
+		segments = append(segments, segment)
+	}
+
+	cleaned := strings.Join(segments, "/")
+	if cleaned == "" || len(cleaned) > maxPathLength {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, p)
+	}
+	return cleaned, nil
+}
+
+// Split returns the parent folder and the last element of a clean path.
+func Split(p string) (dir, name string) {
+	i := strings.LastIndex(p, "/")
+	if i < 0 {
+		return "", p
+	}
+	return p[:i], p[i+1:]
+}
+
+// Join joins a folder path and a name.
+func Join(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+// Lock serializes changes to the tree of a project until tx ends.
+func Lock(tx *gorm.DB, projectID uint) error {
+	var id uint
+	return tx.Model(&models.Project{}).
+		Clauses(clause.Locking{Strength: "NO KEY UPDATE"}).
+		Where("id = ?", projectID).
+		Select("id").Scan(&id).Error
+}
+
+// Exists reports whether a file or folder has path p.
+func Exists(tx *gorm.DB, projectID uint, p string) (bool, error) {
+	var count int64
+	if err := tx.Model(&models.Folder{}).Where("project_id = ? AND path = ?", projectID, p).Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+	err := tx.Model(&models.File{}).Where("project_id = ? AND path = ?", projectID, p).Count(&count).Error
+	return count > 0, err
+}
+
+// MakeFolders creates dir and its missing parents, like mkdir -p, and
+// returns the folder at dir. It returns nil for the project root.
+func MakeFolders(tx *gorm.DB, projectID, userID uint, dir string) (*models.Folder, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := Lock(tx, projectID); err != nil {
+		return nil, err
+	}
+
+	var folder *models.Folder
+	segments := strings.Split(dir, "/")
+	for i := range segments {
+		p := strings.Join(segments[:i+1], "/")
+
+		var existing models.Folder
+		if err := tx.Where("project_id = ? AND path = ?", projectID, p).Limit(1).Find(&existing).Error; err != nil {
+			return nil, err
+		}
+		if existing.ID != 0 {
+			folder = &existing
+			continue
+		}
+
+		var count int64
+		if err := tx.Model(&models.File{}).Where("project_id = ? AND path = ?", projectID, p).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrParentNotFolder, p)
+		}
+
+		parent, name := Split(p)
+		folder = &models.Folder{ProjectID: projectID, Name: name, Path: p, Dir: parent, CreatedBy: userID}
+		if err := tx.Create(folder).Error; err != nil {
+			return nil, err
+		}
+	}
+	return folder, nil
+}
+
+// CreateFolder creates a new folder at p, which must be clean, along with
+// its missing parents.
+func CreateFolder(tx *gorm.DB, projectID, userID uint, p string) (*models.Folder, error) {
+	if err := Lock(tx, projectID); err != nil {
+		return nil, err
+	}
+	if exists, err := Exists(tx, projectID, p); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("%w: %s", ErrPathExists, p)
+		}
+		return nil, err
+	}
+	return MakeFolders(tx, projectID, userID, p)
+}
+
+// Place checks that file.Path, which must be clean, is free and creates
+// its parent folders. It sets Name and Dir from the path. The caller
+// creates the file in the same transaction.
+func Place(tx *gorm.DB, file *models.File) error {
+	if err := Lock(tx, file.ProjectID); err != nil {
+		return err
+	}
+	if exists, err := Exists(tx, file.ProjectID, file.Path); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("%w: %s", ErrPathExists, file.Path)
+		}
+		return err
+	}
+
+	file.Dir, file.Name = Split(file.Path)
+	_, err := MakeFolders(tx, file.ProjectID, file.UploadedBy, file.Dir)
+	return err
+}
+
+// MoveFile moves or renames a file to p, which must be clean, and bumps
+// its version. It returns ErrVersionMismatch if the file is no longer at
+// file.Version.
+func MoveFile(tx *gorm.DB, file *models.File, userID uint, p string) error {
+	if p == file.Path {
+		return nil
+	}
+	if err := Lock(tx, file.ProjectID); err != nil {
+		return err
+	}
+	if exists, err := Exists(tx, file.ProjectID, p); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("%w: %s", ErrPathExists, p)
+		}
+		return err
+	}
+
+	dir, name := Split(p)
+	if _, err := MakeFolders(tx, file.ProjectID, userID, dir); err != nil {
+		return err
+	}
+	result := tx.Model(&models.File{}).
+		Where("id = ? AND version = ?", file.ID, file.Version).
+		Updates(map[string]interface{}{
+			"path":    p,
+			"name":    name,
+			"dir":     dir,
+			"version": gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionMismatch
+	}
+
+	file.Path, file.Name, file.Dir = p, name, dir
+	file.Version++
+	return nil
+}
+
+// MoveFolder moves or renames a folder and everything below it to p, which
+// must be clean. Files below it get a new version. It returns
+// ErrFolderNotFound if the folder was deleted since it was loaded.
+func MoveFolder(tx *gorm.DB, folder *models.Folder, userID uint, p string) error {
+	if err := Lock(tx, folder.ProjectID); err != nil {
+		return err
+	}
+	if err := reload(tx, folder); err != nil {
+		return err
+	}
+	if p == folder.Path {
+		return nil
+	}
+	if strings.HasPrefix(p, folder.Path+"/") {
+		return ErrMoveIntoSelf
+	}
+	if exists, err := Exists(tx, folder.ProjectID, p); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("%w: %s", ErrPathExists, p)
+		}
+		return err
+	}
+
+	dir, name := Split(p)
+	if _, err := MakeFolders(tx, folder.ProjectID, userID, dir); err != nil {
+		return err
+	}
+
+	// substr counts characters and is 1-based
+	old := folder.Path
+	rest := utf8.RuneCountInString(old) + 1
+	below := escapeLike(old) + "/%"
+
+	if err := tx.Model(&models.Folder{}).Where("id = ?", folder.ID).
+		Updates(map[string]interface{}{"path": p, "name": name, "dir": dir}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Folder{}).
+		Where("project_id = ? AND path LIKE ?", folder.ProjectID, below).
+		Updates(map[string]interface{}{
+			"path": gorm.Expr("? || substr(path, ?)", p, rest),
+			"dir":  gorm.Expr("? || substr(dir, ?)", p, rest),
+		}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.File{}).
+		Where("project_id = ? AND path LIKE ?", folder.ProjectID, below).
+		Updates(map[string]interface{}{
+			"path":    gorm.Expr("? || substr(path, ?)", p, rest),
+			"dir":     gorm.Expr("? || substr(dir, ?)", p, rest),
+			"version": gorm.Expr("version + 1"),
+		}).Error; err != nil {
+		return err
+	}
+
+	folder.Path, folder.Name, folder.Dir = p, name, dir
+	return nil
+}
+
+// DeleteFolder deletes a folder with everything below it, including the
+// edit log of the files, and returns the IDs of the deleted files, or
+// ErrFolderNotFound if it is already gone.
+func DeleteFolder(tx *gorm.DB, folder *models.Folder) ([]uint, error) {
+	if err := Lock(tx, folder.ProjectID); err != nil {
+		return nil, err
+	}
+	if err := reload(tx, folder); err != nil {
+		return nil, err
+	}
+
+	below := escapeLike(folder.Path) + "/%"
+	var fileIDs []uint
+	if err := tx.Model(&models.File{}).
+		Where("project_id = ? AND path LIKE ?", folder.ProjectID, below).
+		Pluck("id", &fileIDs).Error; err != nil {
+		return nil, err
+	}
+	// Edits of the files wait for the commit and then fail instead of
+	// being applied to a deleted file
+	for _, fileID := range fileIDs {
+		if _, err := collab.LockRevision(tx, folder.ProjectID, fileID); err != nil {
+			return nil, err
+		}
+	}
+	if len(fileIDs) > 0 {
+		if err := tx.Delete(&models.File{}, fileIDs).Error; err != nil {
+			return nil, err
+		}
+	}
+	for _, fileID := range fileIDs {
+		if err := collab.Forget(tx, fileID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Where("project_id = ? AND (id = ? OR path LIKE ?)", folder.ProjectID, folder.ID, below).
+		Delete(&models.Folder{}).Error; err != nil {
+		return nil, err
+	}
+	return fileIDs, nil
+}
+
+// reload reads folder again under the tree lock, since it may have been
+// moved or deleted after the caller loaded it. It returns
+// ErrFolderNotFound if the folder is gone.
+func reload(tx *gorm.DB, folder *models.Folder) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("project_id = ?", folder.ProjectID).
+		First(folder, folder.ID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrFolderNotFound
+	}
+	return err
+}
+
+// List returns the folders and files directly inside dir, folders first.
+// It returns ErrFolderNotFound if dir is not a folder.
+func List(db *gorm.DB, projectID uint, dir string) ([]Node, error) {
+	if dir != "" {
+		var count int64
+		if err := db.Model(&models.Folder{}).Where("project_id = ? AND path = ?", projectID, dir).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrFolderNotFound
+		}
+	}
+
+	nodes := []Node{}
+	var folders []Node
+	if err := db.Model(&models.Folder{}).
+		Select(`'folder' AS type, id, name, path, updated_at,
+			EXISTS (SELECT 1 FROM folders c WHERE c.project_id = folders.project_id AND c.dir = folders.path AND c.deleted_at IS NULL)
+			OR EXISTS (SELECT 1 FROM files f WHERE f.project_id = folders.project_id AND f.dir = folders.path AND f.deleted_at IS NULL) AS has_children`).
+		Where("project_id = ? AND dir = ?", projectID, dir).
+		Order("name ASC").
+		Scan(&folders).Error; err != nil {
+		return nil, err
+	}
+	nodes = append(nodes, folders...)
+
+	var files []Node
+	if err := db.Model(&models.File{}).
+		Select(`'file' AS type, id, name, path, file_type, mime_type, version, updated_at,
+			COALESCE(NULLIF(file_size, 0), octet_length(content), 0) AS size`).
+		Where("project_id = ? AND dir = ?", projectID, dir).
+		Order("name ASC").
+		Scan(&files).Error; err != nil {
+		return nil, err
+	}
+	return append(nodes, files...), nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
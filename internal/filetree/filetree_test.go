@@ -0,0 +1,311 @@
+package filetree
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"devsync-be/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"a", "a"},
+		{"/a/b/", "a/b"},
+		{"a//b", "a/b"},
+		{"./a/./b/.", "a/b"},
+		{"a b/c.d", "a b/c.d"},
+		{"ünï/cödé.txt", "ünï/cödé.txt"},
+		{"...", "..."},
+		{"a/..b", "a/..b"},
+		{strings.Repeat("x", maxNameLength), strings.Repeat("x", maxNameLength)},
+	}
+	for _, tt := range tests {
+		if got, err := Clean(tt.in); err != nil || got != tt.want {
+			t.Errorf("Clean(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"/",
+		".",
+		"./",
+		"..",
+		"a/../b",
+		"a/..",
+		"a\x00b",
+		"a\nb",
+		"a\x7fb",
+		"a\xffb",
+		strings.Repeat("x", maxNameLength+1),
+		strings.Repeat("abcdefg/", maxPathLength/8) + "x",
+	}
+	for _, p := range invalid {
+		if got, err := Clean(p); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Clean(%.20q) = %q, %v, want %v", p, got, err, ErrInvalidPath)
+		}
+	}
+}
+
+func TestSplitJoin(t *testing.T) {
+	tests := []struct {
+		p, dir, name string
+	}{
+		{"a", "", "a"},
+		{"a/b", "a", "b"},
+		{"a/b/c.txt", "a/b", "c.txt"},
+	}
+	for _, tt := range tests {
+		dir, name := Split(tt.p)
+		if dir != tt.dir || name != tt.name {
+			t.Errorf("Split(%q) = %q, %q, want %q, %q", tt.p, dir, name, tt.dir, tt.name)
+		}
+		if got := Join(dir, name); got != tt.p {
+			t.Errorf("Join(%q, %q) = %q, want %q", dir, name, got, tt.p)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got, want := escapeLike(`50%_off\x`), `50\%\_off\\x`; got != want {
+		t.Errorf("escapeLike() = %q, want %q", got, want)
+	}
+}
+
+// newMockDB returns a Postgres dialect backed by sqlmock, which fails the
+// test on any statement it was not told to expect.
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+func exact(query string) string {
+	return regexp.QuoteMeta(query)
+}
+
+// expectLock expects the tree lock of project 1, which every change takes
+// before it looks at paths.
+func expectLock(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(exact(`SELECT "id" FROM "projects" WHERE id = $1 AND "projects"."deleted_at" IS NULL FOR NO KEY UPDATE`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+// expectExists expects Exists for p to find folders and then files.
+func expectExists(mock sqlmock.Sqlmock, p string, folders, files int) {
+	mock.ExpectQuery(exact(`SELECT count(*) FROM "folders" WHERE (project_id = $1 AND path = $2) AND "folders"."deleted_at" IS NULL`)).
+		WithArgs(1, p).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(folders))
+	if folders == 0 {
+		mock.ExpectQuery(exact(`SELECT count(*) FROM "files" WHERE (project_id = $1 AND path = $2) AND "files"."deleted_at" IS NULL`)).
+			WithArgs(1, p).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(files))
+	}
+}
+
+// expectReload expects the folder to be read again under the tree lock.
+func expectReload(mock sqlmock.Sqlmock, id uint, p string) {
+	dir, name := Split(p)
+	mock.ExpectQuery(exact(`SELECT * FROM "folders" WHERE project_id = $1 AND "folders"."id" = $2 AND "folders"."deleted_at" IS NULL AND "folders"."id" = $3 ORDER BY "folders"."id" LIMIT 1 FOR UPDATE`)).
+		WithArgs(1, id, id).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "project_id", "name", "path", "dir"}).AddRow(id, 1, name, p, dir))
+}
+
+func TestPlaceTaken(t *testing.T) {
+	for _, taken := range []struct{ folders, files int }{{1, 0}, {0, 1}} {
+		db, mock := newMockDB(t)
+		expectLock(mock)
+		expectExists(mock, "src/main.go", taken.folders, taken.files)
+
+		err := Place(db, &models.File{ProjectID: 1, Path: "src/main.go"})
+		if !errors.Is(err, ErrPathExists) {
+			t.Errorf("Place() error = %v, want %v", err, ErrPathExists)
+		}
+	}
+}
+
+func TestPlaceParentIsFile(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectLock(mock)
+	expectExists(mock, "README/notes.txt", 0, 0)
+	expectLock(mock)
+	mock.ExpectQuery(exact(`SELECT * FROM "folders" WHERE (project_id = $1 AND path = $2) AND "folders"."deleted_at" IS NULL LIMIT 1`)).
+		WithArgs(1, "README").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(exact(`SELECT count(*) FROM "files" WHERE (project_id = $1 AND path = $2) AND "files"."deleted_at" IS NULL`)).
+		WithArgs(1, "README").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	err := Place(db, &models.File{ProjectID: 1, Path: "README/notes.txt"})
+	if !errors.Is(err, ErrParentNotFolder) {
+		t.Errorf("Place() error = %v, want %v", err, ErrParentNotFolder)
+	}
+}
+
+func TestMakeFolders(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectLock(mock)
+	mock.ExpectQuery(exact(`SELECT * FROM "folders" WHERE (project_id = $1 AND path = $2) AND "folders"."deleted_at" IS NULL LIMIT 1`)).
+		WithArgs(1, "src").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "project_id", "name", "path", "dir"}).AddRow(4, 1, "src", "src", ""))
+	mock.ExpectQuery(exact(`SELECT * FROM "folders" WHERE (project_id = $1 AND path = $2) AND "folders"."deleted_at" IS NULL LIMIT 1`)).
+		WithArgs(1, "src/lib").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(exact(`SELECT count(*) FROM "files" WHERE (project_id = $1 AND path = $2) AND "files"."deleted_at" IS NULL`)).
+		WithArgs(1, "src/lib").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(exact(`INSERT INTO "folders"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectCommit()
+
+	folder, err := MakeFolders(db, 1, 7, "src/lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.ID != 5 || folder.Path != "src/lib" || folder.Dir != "src" || folder.Name != "lib" {
+		t.Errorf("MakeFolders() = %+v, want src/lib in src", folder)
+	}
+
+	// The project root is not a folder row
+	if folder, err := MakeFolders(db, 1, 7, ""); folder != nil || err != nil {
+		t.Errorf("MakeFolders(\"\") = %v, %v, want nil", folder, err)
+	}
+}
+
+func TestMoveFileTaken(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectLock(mock)
+	expectExists(mock, "b.txt", 0, 1)
+
+	file := &models.File{ID: 3, ProjectID: 1, Path: "a.txt", Version: 2}
+	if err := MoveFile(db, file, 7, "b.txt"); !errors.Is(err, ErrPathExists) {
+		t.Errorf("MoveFile() error = %v, want %v", err, ErrPathExists)
+	}
+	if file.Path != "a.txt" || file.Version != 2 {
+		t.Errorf("MoveFile() changed the file to %+v", file)
+	}
+}
+
+func TestMoveFileVersionMismatch(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectLock(mock)
+	expectExists(mock, "b.txt", 0, 0)
+	mock.ExpectBegin()
+	mock.ExpectExec(exact(`UPDATE "files" SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	file := &models.File{ID: 3, ProjectID: 1, Path: "a.txt", Version: 2}
+	if err := MoveFile(db, file, 7, "b.txt"); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("MoveFile() error = %v, want %v", err, ErrVersionMismatch)
+	}
+}
+
+func TestMoveFolderIntoItself(t *testing.T) {
+	for _, p := range []string{"src/lib", "src/lib/deeper"} {
+		db, mock := newMockDB(t)
+		expectLock(mock)
+		// The path is checked as it is under the lock, not as the caller
+		// loaded it
+		expectReload(mock, 4, "src")
+
+		folder := &models.Folder{ID: 4, ProjectID: 1, Path: "old"}
+		if err := MoveFolder(db, folder, 7, p); !errors.Is(err, ErrMoveIntoSelf) {
+			t.Errorf("MoveFolder(src, %s) error = %v, want %v", p, err, ErrMoveIntoSelf)
+		}
+	}
+
+	// A sibling that shares the prefix is not inside the folder
+	db, mock := newMockDB(t)
+	expectLock(mock)
+	expectReload(mock, 4, "src")
+	expectExists(mock, "src2", 1, 0)
+	folder := &models.Folder{ID: 4, ProjectID: 1, Path: "src"}
+	if err := MoveFolder(db, folder, 7, "src2"); !errors.Is(err, ErrPathExists) {
+		t.Errorf("MoveFolder(src, src2) error = %v, want %v", err, ErrPathExists)
+	}
+}
+
+func TestMoveFolderGone(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectLock(mock)
+	mock.ExpectQuery(exact(`SELECT * FROM "folders" WHERE project_id = $1 AND "folders"."id" = $2`)).
+		WithArgs(1, 4, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	folder := &models.Folder{ID: 4, ProjectID: 1, Path: "src"}
+	if err := MoveFolder(db, folder, 7, "lib"); !errors.Is(err, ErrFolderNotFound) {
+		t.Errorf("MoveFolder() error = %v, want %v", err, ErrFolderNotFound)
+	}
+}
+
+func TestDeleteFolder(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectLock(mock)
+	expectReload(mock, 4, "50%")
+	mock.ExpectQuery(exact(`SELECT "id" FROM "files" WHERE (project_id = $1 AND path LIKE $2) AND "files"."deleted_at" IS NULL`)).
+		WithArgs(1, `50\%/%`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	// Edits of the file wait for the deletion
+	mock.ExpectBegin()
+	mock.ExpectExec(exact(`INSERT INTO "file_edit_states"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(exact(`SELECT * FROM "file_edit_states" WHERE file_id = $1`) + `.*` + exact(`FOR UPDATE`)).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"file_id", "revision", "snapshot_revision"}).AddRow(9, 3, 3))
+	mock.ExpectQuery(exact(`SELECT count(*) FROM "files" WHERE (id = $1 AND project_id = $2)`)).
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec(exact(`UPDATE "files" SET "deleted_at"=$1 WHERE "files"."id" = $2`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(exact(`DELETE FROM "file_edits" WHERE file_id = $1`)).
+		WithArgs(9).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(exact(`DELETE FROM "file_edit_states" WHERE file_id = $1`)).
+		WithArgs(9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(exact(`UPDATE "folders" SET "deleted_at"=$1 WHERE (project_id = $2 AND (id = $3 OR path LIKE $4)) AND "folders"."deleted_at" IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), 1, 4, `50\%/%`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	fileIDs, err := DeleteFolder(db, &models.Folder{ID: 4, ProjectID: 1, Path: "50%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fileIDs) != 1 || fileIDs[0] != 9 {
+		t.Errorf("DeleteFolder() = %v, want [9]", fileIDs)
+	}
+}
@@ -0,0 +1,321 @@
+package githubsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAPIURL is the REST API of github.com.
+const DefaultAPIURL = "https://api.github.com"
+
+var (
+	ErrNotFound       = errors.New("not found on GitHub")
+	ErrUnauthorized   = errors.New("GitHub rejected the token")
+	ErrNotFastForward = errors.New("branch moved on GitHub")
+	ErrBranchExists   = errors.New("branch already exists on GitHub")
+	ErrTreeTruncated  = errors.New("repository tree is too large to sync")
+	ErrInvalidRepo    = errors.New("github_repo must be owner/name or a github.com URL")
+)
+
+// APIError is an unexpected response from the GitHub API.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("GitHub API responded %d: %s", e.Status, e.Message)
+}
+
+// Repo is a GitHub repository.
+type Repo struct {
+	Owner string
+	Name  string
+}
+
+func (r Repo) String() string {
+	return r.Owner + "/" + r.Name
+}
+
+// ParseRepo reads Project.GitHubRepo, either "owner/name" or a URL such as
+// https://github.com/owner/name.git.
+func ParseRepo(s string) (Repo, error) {
+	s = strings.TrimSpace(s)
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		s = u.Path
+	} else if rest, ok := strings.CutPrefix(s, "git@github.com:"); ok {
+		s = rest
+	}
+	s = strings.TrimSuffix(strings.Trim(s, "/"), ".git")
+
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Repo{}, ErrInvalidRepo
+	}
+	return Repo{Owner: parts[0], Name: parts[1]}, nil
+}
+
+// Client calls the GitHub git data API on behalf of a user.
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// TreeEntry is a blob in a recursive tree listing.
+type TreeEntry struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+	Size int64  `json:"size"`
+}
+
+// TreeChange sets path to the blob SHA, or deletes it if SHA is nil.
+type TreeChange struct {
+	Path string  `json:"path"`
+	Mode string  `json:"mode"`
+	Type string  `json:"type"`
+	SHA  *string `json:"sha"`
+}
+
+// Author is the author of a commit made by DevSync.
+type Author struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// PullRequest is an opened pull request.
+type PullRequest struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+	Branch string `json:"branch"`
+	Base   string `json:"base"`
+}
+
+// DefaultBranch returns the default branch of a repository.
+func (c *Client) DefaultBranch(ctx context.Context, repo Repo) (string, error) {
+	var result struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	err := c.do(ctx, http.MethodGet, "/repos/"+repo.String(), nil, &result)
+	return result.DefaultBranch, err
+}
+
+// BranchHead returns the commit a branch points at, or "" if the branch
+// does not exist.
+func (c *Client) BranchHead(ctx context.Context, repo Repo, branch string) (string, error) {
+	var result struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	err := c.do(ctx, http.MethodGet, "/repos/"+repo.String()+"/git/ref/heads/"+escapeRef(branch), nil, &result)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	return result.Object.SHA, err
+}
+
+// Tree returns the tree of a commit and the blobs in it.
+func (c *Client) Tree(ctx context.Context, repo Repo, commitSHA string) (string, []TreeEntry, error) {
+	var commit struct {
+		Tree struct {
+			SHA string `json:"sha"`
+		} `json:"tree"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/repos/"+repo.String()+"/git/commits/"+commitSHA, nil, &commit); err != nil {
+		return "", nil, err
+	}
+
+	var tree struct {
+		Tree      []TreeEntry `json:"tree"`
+		Truncated bool        `json:"truncated"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/repos/"+repo.String()+"/git/trees/"+commit.Tree.SHA+"?recursive=1", nil, &tree); err != nil {
+		return "", nil, err
+	}
+	if tree.Truncated {
+		return "", nil, ErrTreeTruncated
+	}
+
+	blobs := make([]TreeEntry, 0, len(tree.Tree))
+	for _, entry := range tree.Tree {
+		if entry.Type == "blob" {
+			blobs = append(blobs, entry)
+		}
+	}
+	return commit.Tree.SHA, blobs, nil
+}
+
+// Blob returns the content of a blob.
+func (c *Client) Blob(ctx context.Context, repo Repo, sha string) ([]byte, error) {
+	var blob struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/repos/"+repo.String()+"/git/blobs/"+sha, nil, &blob); err != nil {
+		return nil, err
+	}
+	if blob.Encoding != "base64" {
+		return []byte(blob.Content), nil
+	}
+	// GitHub wraps base64 content at 60 characters
+	return base64.StdEncoding.DecodeString(strings.ReplaceAll(blob.Content, "\n", ""))
+}
+
+// CreateBlob uploads content and returns its blob SHA.
+func (c *Client) CreateBlob(ctx context.Context, repo Repo, content []byte) (string, error) {
+	var result struct {
+		SHA string `json:"sha"`
+	}
+	err := c.do(ctx, http.MethodPost, "/repos/"+repo.String()+"/git/blobs", map[string]string{
+		"content":  base64.StdEncoding.EncodeToString(content),
+		"encoding": "base64",
+	}, &result)
+	return result.SHA, err
+}
+
+// CreateTree creates a tree from baseTree with changes applied. An empty
+// baseTree starts from nothing.
+func (c *Client) CreateTree(ctx context.Context, repo Repo, baseTree string, changes []TreeChange) (string, error) {
+	body := map[string]interface{}{"tree": changes}
+	if baseTree != "" {
+		body["base_tree"] = baseTree
+	}
+	var result struct {
+		SHA string `json:"sha"`
+	}
+	err := c.do(ctx, http.MethodPost, "/repos/"+repo.String()+"/git/trees", body, &result)
+	return result.SHA, err
+}
+
+// CreateCommit creates a commit of tree and returns its SHA.
+func (c *Client) CreateCommit(ctx context.Context, repo Repo, message, tree string, parents []string, author *Author) (string, error) {
+	body := map[string]interface{}{"message": message, "tree": tree, "parents": parents}
+	if author != nil {
+		body["author"] = author
+	}
+	var result struct {
+		SHA string `json:"sha"`
+	}
+	err := c.do(ctx, http.MethodPost, "/repos/"+repo.String()+"/git/commits", body, &result)
+	return result.SHA, err
+}
+
+// UpdateBranch moves a branch to sha. It returns ErrNotFastForward if sha
+// does not descend from the branch head.
+func (c *Client) UpdateBranch(ctx context.Context, repo Repo, branch, sha string) error {
+	err := c.do(ctx, http.MethodPatch, "/repos/"+repo.String()+"/git/refs/heads/"+escapeRef(branch), map[string]interface{}{
+		"sha":   sha,
+		"force": false,
+	}, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnprocessableEntity {
+		return ErrNotFastForward
+	}
+	return err
+}
+
+// CreateBranch creates a branch at sha. It returns ErrBranchExists if the
+// branch already exists.
+func (c *Client) CreateBranch(ctx context.Context, repo Repo, branch, sha string) error {
+	err := c.do(ctx, http.MethodPost, "/repos/"+repo.String()+"/git/refs", map[string]string{
+		"ref": "refs/heads/" + branch,
+		"sha": sha,
+	}, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnprocessableEntity {
+		return ErrBranchExists
+	}
+	return err
+}
+
+// CreatePullRequest opens a pull request from head into base.
+func (c *Client) CreatePullRequest(ctx context.Context, repo Repo, title, body, head, base string) (*PullRequest, error) {
+	var result struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	err := c.do(ctx, http.MethodPost, "/repos/"+repo.String()+"/pulls", map[string]string{
+		"title": title,
+		"body":  body,
+		"head":  head,
+		"base":  base,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &PullRequest{Number: result.Number, URL: result.HTMLURL, Branch: head, Base: base}, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case resp.StatusCode >= 300:
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&failure)
+		return &APIError{Status: resp.StatusCode, Message: failure.Message}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// escapeRef escapes a branch name for a URL path, keeping its slashes.
+func escapeRef(branch string) string {
+	segments := strings.Split(branch, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,650 @@
+package githubsync
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testToken = "gh-token"
+
+var testRepo = Repo{Owner: "octo", Name: "app"}
+
+// fakeGitHub is an in-memory repository behind the parts of the GitHub git
+// data API the client uses.
+type fakeGitHub struct {
+	t  *testing.T
+	mu sync.Mutex
+
+	blobs   map[string][]byte
+	trees   map[string]map[string]string
+	commits map[string]fakeCommit
+	refs    map[string]string
+	pulls   []map[string]string
+	count   int
+}
+
+type fakeCommit struct {
+	tree    string
+	parents []string
+	message string
+	author  *Author
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, *Client) {
+	f := &fakeGitHub{
+		t:       t,
+		blobs:   make(map[string][]byte),
+		trees:   make(map[string]map[string]string),
+		commits: make(map[string]fakeCommit),
+		refs:    make(map[string]string),
+	}
+
+	prefix := "/repos/" + testRepo.String()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+prefix, f.getRepo)
+	mux.HandleFunc("GET "+prefix+"/git/ref/heads/{branch...}", f.getRef)
+	mux.HandleFunc("GET "+prefix+"/git/commits/{sha}", f.getCommit)
+	mux.HandleFunc("GET "+prefix+"/git/trees/{sha}", f.getTree)
+	mux.HandleFunc("GET "+prefix+"/git/blobs/{sha}", f.getBlob)
+	mux.HandleFunc("POST "+prefix+"/git/blobs", f.createBlob)
+	mux.HandleFunc("POST "+prefix+"/git/trees", f.createTree)
+	mux.HandleFunc("POST "+prefix+"/git/commits", f.createCommit)
+	mux.HandleFunc("PATCH "+prefix+"/git/refs/heads/{branch...}", f.updateRef)
+	mux.HandleFunc("POST "+prefix+"/git/refs", f.createRef)
+	mux.HandleFunc("POST "+prefix+"/pulls", f.createPull)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return f, NewClient(server.URL+"/", testToken)
+}
+
+// commit adds a commit on top of parent that sets files and deletes the
+// paths in deleted, and returns its SHA.
+func (f *fakeGitHub) commit(parent string, files map[string]string, deleted ...string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tree := make(map[string]string)
+	var parents []string
+	if parent != "" {
+		for p, sha := range f.trees[f.commits[parent].tree] {
+			tree[p] = sha
+		}
+		parents = []string{parent}
+	}
+	for p, content := range files {
+		tree[p] = f.addBlob([]byte(content))
+	}
+	for _, p := range deleted {
+		delete(tree, p)
+	}
+	return f.addCommit(fakeCommit{tree: f.addTree(tree), parents: parents, message: "test"})
+}
+
+// files returns the content of every path at commit.
+func (f *fakeGitHub) files(commit string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	files := make(map[string]string)
+	for p, sha := range f.trees[f.commits[commit].tree] {
+		files[p] = string(f.blobs[sha])
+	}
+	return files
+}
+
+func (f *fakeGitHub) ref(branch string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refs[branch]
+}
+
+func (f *fakeGitHub) setRef(branch, sha string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refs[branch] = sha
+}
+
+func (f *fakeGitHub) addBlob(content []byte) string {
+	sha := BlobSHA(content)
+	f.blobs[sha] = content
+	return sha
+}
+
+func (f *fakeGitHub) addTree(tree map[string]string) string {
+	f.count++
+	sha := fmt.Sprintf("tree%d", f.count)
+	f.trees[sha] = tree
+	return sha
+}
+
+func (f *fakeGitHub) addCommit(commit fakeCommit) string {
+	f.count++
+	sha := fmt.Sprintf("commit%d", f.count)
+	f.commits[sha] = commit
+	return sha
+}
+
+// descends reports whether ancestor is sha or one of its ancestors.
+func (f *fakeGitHub) descends(sha, ancestor string) bool {
+	if sha == ancestor {
+		return true
+	}
+	for _, parent := range f.commits[sha].parents {
+		if f.descends(parent, ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeGitHub) getRepo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"default_branch": "main"})
+}
+
+func (f *fakeGitHub) getRef(w http.ResponseWriter, r *http.Request) {
+	sha, ok := f.refs[r.PathValue("branch")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": map[string]string{"sha": sha}})
+}
+
+func (f *fakeGitHub) getCommit(w http.ResponseWriter, r *http.Request) {
+	commit, ok := f.commits[r.PathValue("sha")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tree": map[string]string{"sha": commit.tree}})
+}
+
+func (f *fakeGitHub) getTree(w http.ResponseWriter, r *http.Request) {
+	tree, ok := f.trees[r.PathValue("sha")]
+	if !ok || r.URL.Query().Get("recursive") != "1" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+
+	// Recursive listings include the folders
+	entries := []TreeEntry{}
+	folders := make(map[string]bool)
+	for p, sha := range tree {
+		entries = append(entries, TreeEntry{Path: p, Mode: "100644", Type: "blob", SHA: sha, Size: int64(len(f.blobs[sha]))})
+		for dir := p; strings.Contains(dir, "/"); {
+			dir = dir[:strings.LastIndex(dir, "/")]
+			folders[dir] = true
+		}
+	}
+	for dir := range folders {
+		entries = append(entries, TreeEntry{Path: dir, Mode: "040000", Type: "tree", SHA: "folder-" + dir})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sha": r.PathValue("sha"), "tree": entries, "truncated": false})
+}
+
+func (f *fakeGitHub) getBlob(w http.ResponseWriter, r *http.Request) {
+	content, ok := f.blobs[r.PathValue("sha")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+
+	// GitHub wraps base64 content at 60 characters
+	encoded := base64.StdEncoding.EncodeToString(content)
+	var wrapped strings.Builder
+	for len(encoded) > 60 {
+		wrapped.WriteString(encoded[:60] + "\n")
+		encoded = encoded[60:]
+	}
+	wrapped.WriteString(encoded + "\n")
+	writeJSON(w, http.StatusOK, map[string]string{"content": wrapped.String(), "encoding": "base64"})
+}
+
+func (f *fakeGitHub) createBlob(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	f.decode(r, &body)
+	content, err := base64.StdEncoding.DecodeString(body.Content)
+	if err != nil || body.Encoding != "base64" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "invalid content"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"sha": f.addBlob(content)})
+}
+
+func (f *fakeGitHub) createTree(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		BaseTree string       `json:"base_tree"`
+		Tree     []TreeChange `json:"tree"`
+	}
+	f.decode(r, &body)
+
+	tree := make(map[string]string)
+	for p, sha := range f.trees[body.BaseTree] {
+		tree[p] = sha
+	}
+	for _, change := range body.Tree {
+		if change.SHA == nil {
+			delete(tree, change.Path)
+			continue
+		}
+		if _, ok := f.blobs[*change.SHA]; !ok {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "unknown blob " + *change.SHA})
+			return
+		}
+		tree[change.Path] = *change.SHA
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"sha": f.addTree(tree)})
+}
+
+func (f *fakeGitHub) createCommit(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Message string   `json:"message"`
+		Tree    string   `json:"tree"`
+		Parents []string `json:"parents"`
+		Author  *Author  `json:"author"`
+	}
+	f.decode(r, &body)
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"sha": f.addCommit(fakeCommit{tree: body.Tree, parents: body.Parents, message: body.Message, author: body.Author}),
+	})
+}
+
+func (f *fakeGitHub) updateRef(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		SHA   string `json:"sha"`
+		Force bool   `json:"force"`
+	}
+	f.decode(r, &body)
+
+	branch := r.PathValue("branch")
+	current, ok := f.refs[branch]
+	if !ok {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Reference does not exist"})
+		return
+	}
+	if !body.Force && !f.descends(body.SHA, current) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Update is not a fast forward"})
+		return
+	}
+	f.refs[branch] = body.SHA
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": map[string]string{"sha": body.SHA}})
+}
+
+func (f *fakeGitHub) createRef(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	}
+	f.decode(r, &body)
+
+	branch, ok := strings.CutPrefix(body.Ref, "refs/heads/")
+	if _, exists := f.refs[branch]; !ok || exists {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Reference already exists"})
+		return
+	}
+	f.refs[branch] = body.SHA
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"object": map[string]string{"sha": body.SHA}})
+}
+
+func (f *fakeGitHub) createPull(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	f.decode(r, &body)
+	if _, ok := f.refs[body["head"]]; !ok {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "head does not exist"})
+		return
+	}
+	f.pulls = append(f.pulls, body)
+	number := len(f.pulls)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"number":   number,
+		"html_url": fmt.Sprintf("https://github.com/%s/pull/%d", testRepo, number),
+	})
+}
+
+func (f *fakeGitHub) decode(r *http.Request, v interface{}) {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		f.t.Errorf("%s %s: invalid body: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// shas returns the blob SHA of every file content
+func shas(files map[string]string) map[string]string {
+	result := make(map[string]string, len(files))
+	for p, content := range files {
+		result[p] = BlobSHA([]byte(content))
+	}
+	return result
+}
+
+// syncedRepo sets up a repository and a project that were synced at the
+// returned commit, with the returned base.
+func syncedRepo(t *testing.T, files map[string]string) (*fakeGitHub, *Client, string, map[string]string) {
+	f, client := newFakeGitHub(t)
+	synced := f.commit("", files)
+	f.setRef("main", synced)
+	return f, client, synced, shas(files)
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	_, client := newFakeGitHub(t)
+	client.token = "wrong"
+	if _, err := client.DefaultBranch(context.Background(), testRepo); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("DefaultBranch() error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestBranchHead(t *testing.T) {
+	_, client, synced, _ := syncedRepo(t, map[string]string{"README.md": "hi\n"})
+	ctx := context.Background()
+
+	if head, err := client.BranchHead(ctx, testRepo, "main"); err != nil || head != synced {
+		t.Errorf("BranchHead(main) = %q, %v, want %q", head, err, synced)
+	}
+	if head, err := client.BranchHead(ctx, testRepo, "missing"); err != nil || head != "" {
+		t.Errorf("BranchHead(missing) = %q, %v, want no branch", head, err)
+	}
+}
+
+func TestPlanPull(t *testing.T) {
+	base := map[string]string{
+		"README.md":       "hello\n",
+		"src/main.go":     "package main\n",
+		"src/old.go":      "package main // old\n",
+		"docs/notes.md":   "notes\n",
+		"docs/shared.md":  "shared\n",
+		"docs/removed.md": "removed\n",
+	}
+	f, client, synced, baseSHAs := syncedRepo(t, base)
+
+	// GitHub edits, creates and deletes files; the project changed two of
+	// the same files
+	head := f.commit(synced, map[string]string{
+		"src/main.go":       "package main\n\nfunc main() {}\n",
+		"src/new.go":        "package main // new\n",
+		"docs/shared.md":    "shared on github\n",
+		"docs/removed.md":   "edited on github\n",
+		"bad\tname.txt":     "tab in name\n",
+		"docs/notes.md":     "notes\n",
+		"assets/empty.json": "",
+	}, "src/old.go")
+	f.setRef("main", head)
+
+	local := map[string]string{
+		"README.md":      "hello\n",
+		"src/main.go":    "package main\n",
+		"src/old.go":     "package main // old\n",
+		"docs/notes.md":  "notes\n",
+		"docs/shared.md": "shared locally\n",
+	}
+
+	plan, err := client.PlanPull(context.Background(), testRepo, head, baseSHAs, shas(local), false)
+	if err != nil {
+		t.Fatalf("PlanPull() error = %v", err)
+	}
+
+	wantChanges := []Change{
+		{Path: "assets/empty.json", Action: ActionCreate, SHA: BlobSHA(nil)},
+		{Path: "src/main.go", Action: ActionUpdate, SHA: BlobSHA([]byte("package main\n\nfunc main() {}\n"))},
+		{Path: "src/new.go", Action: ActionCreate, SHA: BlobSHA([]byte("package main // new\n"))},
+		{Path: "src/old.go", Action: ActionDelete},
+	}
+	if !reflect.DeepEqual(plan.Changes, wantChanges) {
+		t.Errorf("Changes = %+v, want %+v", plan.Changes, wantChanges)
+	}
+	wantConflicts := []Conflict{
+		{Path: "docs/removed.md", Reason: ConflictDeletedLocally, RemoteSHA: BlobSHA([]byte("edited on github\n"))},
+		{Path: "docs/shared.md", Reason: ConflictModifiedBoth, LocalSHA: BlobSHA([]byte("shared locally\n")), RemoteSHA: BlobSHA([]byte("shared on github\n"))},
+	}
+	if !reflect.DeepEqual(plan.Conflicts, wantConflicts) {
+		t.Errorf("Conflicts = %+v, want %+v", plan.Conflicts, wantConflicts)
+	}
+	if len(plan.Skipped) != 1 || plan.Skipped[0].Path != "bad\tname.txt" || plan.Skipped[0].Reason != "invalid_path" {
+		t.Errorf("Skipped = %+v, want bad\\tname.txt as invalid_path", plan.Skipped)
+	}
+	if _, ok := plan.Remote["bad\tname.txt"]; ok {
+		t.Error("Remote contains a path that cannot be pulled")
+	}
+	if len(plan.Same) != 0 {
+		t.Errorf("Same = %+v, want none", plan.Same)
+	}
+
+	// Only created and updated files are downloaded
+	wantData := map[string][]byte{
+		"assets/empty.json": {},
+		"src/main.go":       []byte("package main\n\nfunc main() {}\n"),
+		"src/new.go":        []byte("package main // new\n"),
+	}
+	if !reflect.DeepEqual(plan.Data, wantData) {
+		t.Errorf("Data = %q, want %q", plan.Data, wantData)
+	}
+}
+
+func TestPlanPullTheirs(t *testing.T) {
+	base := map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n"}
+	f, client, synced, baseSHAs := syncedRepo(t, base)
+	head := f.commit(synced, map[string]string{"a.txt": "a github\n", "b.txt": "b github\n", "new.txt": "new github\n"}, "c.txt")
+
+	// a changed on both sides, b deleted locally, c changed locally but
+	// deleted on GitHub, new created on both sides
+	local := map[string]string{"a.txt": "a local\n", "c.txt": "c local\n", "new.txt": "new local\n"}
+
+	plan, err := client.PlanPull(context.Background(), testRepo, head, baseSHAs, shas(local), true)
+	if err != nil {
+		t.Fatalf("PlanPull() error = %v", err)
+	}
+	if len(plan.Conflicts) != 0 {
+		t.Errorf("Conflicts = %+v, want none with theirs", plan.Conflicts)
+	}
+	want := []Change{
+		{Path: "a.txt", Action: ActionUpdate, SHA: BlobSHA([]byte("a github\n"))},
+		{Path: "b.txt", Action: ActionCreate, SHA: BlobSHA([]byte("b github\n"))},
+		{Path: "c.txt", Action: ActionDelete},
+		{Path: "new.txt", Action: ActionUpdate, SHA: BlobSHA([]byte("new github\n"))},
+	}
+	if !reflect.DeepEqual(plan.Changes, want) {
+		t.Errorf("Changes = %+v, want %+v", plan.Changes, want)
+	}
+	if string(plan.Data["b.txt"]) != "b github\n" {
+		t.Errorf("Data[b.txt] = %q, want the GitHub version", plan.Data["b.txt"])
+	}
+}
+
+func TestPushCommit(t *testing.T) {
+	base := map[string]string{"README.md": "hello\n", "src/main.go": "package main\n", "src/old.go": "old\n", "same.txt": "x\n"}
+	f, client, synced, baseSHAs := syncedRepo(t, base)
+
+	// GitHub changed a file the project did not touch and both made the
+	// same change to another
+	head := f.commit(synced, map[string]string{"README.md": "hello from github\n", "same.txt": "y\n"})
+	f.setRef("main", head)
+
+	local := map[string]string{
+		"README.md":   "hello\n",
+		"src/main.go": "package main\n\nfunc main() {}\n",
+		"src/new.go":  "package main // new\n",
+		"same.txt":    "y\n",
+	}
+	author := &Author{Name: "Dev", Email: "dev@example.com"}
+	pushed, err := client.Push(context.Background(), testRepo, "main", head, synced, baseSHAs, local, PushOptions{
+		Mode: ModeCommit, Message: "Update from DevSync", Author: author,
+	})
+	if err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	if got := f.ref("main"); got != pushed.Commit || pushed.Commit == head {
+		t.Fatalf("main = %s, want the new commit %s", got, pushed.Commit)
+	}
+	commit := f.commits[pushed.Commit]
+	if !reflect.DeepEqual(commit.parents, []string{head}) || commit.message != "Update from DevSync" || !reflect.DeepEqual(commit.author, author) {
+		t.Errorf("commit = %+v, want parent %s, the message and the author", commit, head)
+	}
+
+	wantFiles := map[string]string{
+		"README.md":   "hello from github\n",
+		"src/main.go": "package main\n\nfunc main() {}\n",
+		"src/new.go":  "package main // new\n",
+		"same.txt":    "y\n",
+	}
+	if got := f.files(pushed.Commit); !reflect.DeepEqual(got, wantFiles) {
+		t.Errorf("files = %q, want %q", got, wantFiles)
+	}
+
+	wantChanges := []Change{
+		{Path: "src/main.go", Action: ActionUpdate, SHA: BlobSHA([]byte(local["src/main.go"]))},
+		{Path: "src/new.go", Action: ActionCreate, SHA: BlobSHA([]byte(local["src/new.go"]))},
+		{Path: "src/old.go", Action: ActionDelete},
+	}
+	if !reflect.DeepEqual(pushed.Files, wantChanges) {
+		t.Errorf("Files = %+v, want %+v", pushed.Files, wantChanges)
+	}
+
+	// The pushed paths and the ones both sides agree on move their base;
+	// README.md still has to be pulled
+	wantSynced := map[string]string{
+		"src/main.go": BlobSHA([]byte(local["src/main.go"])),
+		"src/new.go":  BlobSHA([]byte(local["src/new.go"])),
+		"src/old.go":  "",
+		"same.txt":    BlobSHA([]byte("y\n")),
+	}
+	if !reflect.DeepEqual(pushed.Synced, wantSynced) {
+		t.Errorf("Synced = %+v, want %+v", pushed.Synced, wantSynced)
+	}
+}
+
+func TestPushNothingChanged(t *testing.T) {
+	base := map[string]string{"a.txt": "a\n"}
+	f, client, synced, baseSHAs := syncedRepo(t, base)
+
+	pushed, err := client.Push(context.Background(), testRepo, "main", synced, synced, baseSHAs, base, PushOptions{Mode: ModeCommit, Message: "noop"})
+	if err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if pushed.Commit != synced || len(pushed.Files) != 0 || pushed.Synced != nil {
+		t.Errorf("Push() = %+v, want no commit", pushed)
+	}
+	if got := f.ref("main"); got != synced {
+		t.Errorf("main = %s, want it unchanged at %s", got, synced)
+	}
+}
+
+func TestPushConflict(t *testing.T) {
+	base := map[string]string{"a.txt": "a\n", "b.txt": "b\n"}
+	f, client, synced, baseSHAs := syncedRepo(t, base)
+	head := f.commit(synced, map[string]string{"a.txt": "a github\n"})
+	f.setRef("main", head)
+
+	local := map[string]string{"a.txt": "a local\n", "b.txt": "b local\n"}
+	pushed, err := client.Push(context.Background(), testRepo, "main", head, synced, baseSHAs, local, PushOptions{Mode: ModeCommit, Message: "conflict"})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Push() error = %v, want ErrConflict", err)
+	}
+	want := []Conflict{{Path: "a.txt", Reason: ConflictModifiedBoth, LocalSHA: BlobSHA([]byte("a local\n")), RemoteSHA: BlobSHA([]byte("a github\n"))}}
+	if !reflect.DeepEqual(pushed.Conflicts, want) {
+		t.Errorf("Conflicts = %+v, want %+v", pushed.Conflicts, want)
+	}
+	if got := f.ref("main"); got != head {
+		t.Errorf("main = %s, want it unchanged at %s", got, head)
+	}
+}
+
+func TestPushNotFastForward(t *testing.T) {
+	base := map[string]string{"a.txt": "a\n", "b.txt": "b\n"}
+	f, client, synced, baseSHAs := syncedRepo(t, base)
+
+	// The branch moves after the caller read its head
+	moved := f.commit(synced, map[string]string{"b.txt": "b github\n"})
+	f.setRef("main", moved)
+
+	local := map[string]string{"a.txt": "a local\n", "b.txt": "b\n"}
+	_, err := client.Push(context.Background(), testRepo, "main", synced, synced, baseSHAs, local, PushOptions{Mode: ModeCommit, Message: "stale"})
+	if !errors.Is(err, ErrNotFastForward) {
+		t.Fatalf("Push() error = %v, want ErrNotFastForward", err)
+	}
+	if got := f.ref("main"); got != moved {
+		t.Errorf("main = %s, want it unchanged at %s", got, moved)
+	}
+}
+
+func TestPushPullRequest(t *testing.T) {
+	base := map[string]string{"a.txt": "a\n", "b.txt": "b\n"}
+	f, client, synced, baseSHAs := syncedRepo(t, base)
+
+	// A conflicting change on GitHub is left to the pull request
+	head := f.commit(synced, map[string]string{"a.txt": "a github\n"})
+	f.setRef("main", head)
+
+	local := map[string]string{"a.txt": "a local\n", "b.txt": "b\n", "c.txt": "c\n"}
+	pushed, err := client.Push(context.Background(), testRepo, "main", head, synced, baseSHAs, local, PushOptions{
+		Mode: ModePullRequest, Message: "Update from DevSync", Branch: "devsync/feature", Body: "From DevSync",
+	})
+	if err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	// The commit starts from the synced commit, on the new branch
+	if parents := f.commits[pushed.Commit].parents; !reflect.DeepEqual(parents, []string{synced}) {
+		t.Errorf("parents = %v, want [%s]", parents, synced)
+	}
+	if got := f.ref("devsync/feature"); got != pushed.Commit {
+		t.Errorf("devsync/feature = %s, want %s", got, pushed.Commit)
+	}
+	if got := f.ref("main"); got != head {
+		t.Errorf("main = %s, want it unchanged at %s", got, head)
+	}
+	if want := map[string]string{"a.txt": "a local\n", "b.txt": "b\n", "c.txt": "c\n"}; !reflect.DeepEqual(f.files(pushed.Commit), want) {
+		t.Errorf("files = %q, want %q", f.files(pushed.Commit), want)
+	}
+
+	wantPR := &PullRequest{Number: 1, URL: "https://github.com/octo/app/pull/1", Branch: "devsync/feature", Base: "main"}
+	if !reflect.DeepEqual(pushed.PullRequest, wantPR) {
+		t.Errorf("PullRequest = %+v, want %+v", pushed.PullRequest, wantPR)
+	}
+	wantBody := map[string]string{"title": "Update from DevSync", "body": "From DevSync", "head": "devsync/feature", "base": "main"}
+	if len(f.pulls) != 1 || !reflect.DeepEqual(f.pulls[0], wantBody) {
+		t.Errorf("pull requests = %+v, want %+v", f.pulls, wantBody)
+	}
+
+	// The tracked branch did not move, so neither does the base
+	if pushed.Synced != nil {
+		t.Errorf("Synced = %+v, want nil", pushed.Synced)
+	}
+
+	// The branch name is taken now
+	_, err = client.Push(context.Background(), testRepo, "main", head, synced, baseSHAs, local, PushOptions{
+		Mode: ModePullRequest, Message: "again", Branch: "devsync/feature",
+	})
+	if !errors.Is(err, ErrBranchExists) {
+		t.Errorf("second Push() error = %v, want ErrBranchExists", err)
+	}
+}
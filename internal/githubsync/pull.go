@@ -0,0 +1,69 @@
+package githubsync
+
+import (
+	"context"
+
+	"devsync-be/internal/filetree"
+)
+
+// PullPlan is what a pull changes in the project.
+type PullPlan struct {
+	// Remote maps every path on GitHub that can be pulled to its blob SHA
+	Remote map[string]string
+	// Changes lists the paths to create, update or delete locally
+	Changes []Change
+	// Data holds the content of every created or updated path
+	Data map[string][]byte
+	// Same lists paths that only need their base moved
+	Same []Change
+	// Conflicts lists paths changed on both sides that are left alone
+	Conflicts []Conflict
+	// Skipped lists GitHub paths that cannot be project paths
+	Skipped []Conflict
+}
+
+// PlanPull compares the tree of commit with the local blob SHAs and
+// downloads what changed on GitHub. With theirs, paths changed on both
+// sides take the GitHub version instead of being reported as conflicts.
+func (c *Client) PlanPull(ctx context.Context, repo Repo, commit string, base, local map[string]string, theirs bool) (*PullPlan, error) {
+	_, entries, err := c.Tree(ctx, repo, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &PullPlan{
+		Remote:    make(map[string]string, len(entries)),
+		Data:      make(map[string][]byte),
+		Changes:   []Change{},
+		Conflicts: []Conflict{},
+		Skipped:   []Conflict{},
+	}
+	for _, entry := range entries {
+		if p, err := filetree.Clean(entry.Path); err != nil || p != entry.Path {
+			plan.Skipped = append(plan.Skipped, Conflict{Path: entry.Path, Reason: "invalid_path", RemoteSHA: entry.SHA})
+			continue
+		}
+		plan.Remote[entry.Path] = entry.SHA
+	}
+
+	result := Compare(base, local, plan.Remote)
+	plan.Changes = append(plan.Changes, result.Pull...)
+	plan.Same = result.Same
+	if theirs {
+		for _, conflict := range result.Conflicts {
+			plan.Changes = append(plan.Changes, Change{Path: conflict.Path, Action: action(conflict.LocalSHA, conflict.RemoteSHA), SHA: conflict.RemoteSHA})
+		}
+	} else {
+		plan.Conflicts = append(plan.Conflicts, result.Conflicts...)
+	}
+
+	for _, change := range plan.Changes {
+		if change.Action == ActionDelete {
+			continue
+		}
+		if plan.Data[change.Path], err = c.Blob(ctx, repo, change.SHA); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
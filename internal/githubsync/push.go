@@ -0,0 +1,131 @@
+package githubsync
+
+import (
+	"context"
+	"errors"
+)
+
+// Push modes
+const (
+	ModeCommit      = "commit"
+	ModePullRequest = "pull_request"
+)
+
+// ErrConflict is returned by a commit push when GitHub changed some of the
+// same paths since the last sync.
+var ErrConflict = errors.New("files changed on GitHub since the last sync")
+
+// PushOptions describes the commit a push makes.
+type PushOptions struct {
+	Mode    string
+	Message string
+	Author  *Author
+	// Branch, Title and Body of the pull request in ModePullRequest. Title
+	// defaults to Message.
+	Branch string
+	Title  string
+	Body   string
+}
+
+// PushResult is the commit a push made.
+type PushResult struct {
+	// Commit is the new commit, or the branch head if nothing changed
+	Commit string
+	Files  []Change
+	// Synced maps the paths that are now the same on both sides to their
+	// blob SHA. It is only set when the tracked branch moved.
+	Synced map[string]string
+	// Conflicts is set with ErrConflict
+	Conflicts   []Conflict
+	PullRequest *PullRequest
+}
+
+// Push commits the local files changed since the last sync. local maps
+// paths to their content. In ModeCommit the commit goes on top of head
+// and the branch is moved to it, failing with ErrConflict or
+// ErrNotFastForward if GitHub changed too. In ModePullRequest it goes on
+// top of the synced commit, onto a new branch, and a pull request into
+// branch is opened, leaving conflicts to GitHub.
+func (c *Client) Push(ctx context.Context, repo Repo, branch, head, synced string, base, local map[string]string, opts PushOptions) (*PushResult, error) {
+	parent := head
+	if opts.Mode == ModePullRequest {
+		parent = synced
+	}
+	baseTree, entries, err := c.Tree(ctx, repo, parent)
+	if err != nil {
+		return nil, err
+	}
+	remote := make(map[string]string, len(entries))
+	modes := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		remote[entry.Path], modes[entry.Path] = entry.SHA, entry.Mode
+	}
+
+	shas := make(map[string]string, len(local))
+	for p, content := range local {
+		shas[p] = BlobSHA([]byte(content))
+	}
+
+	result := Compare(base, shas, remote)
+	if opts.Mode == ModeCommit && len(result.Conflicts) > 0 {
+		return &PushResult{Commit: head, Files: []Change{}, Conflicts: result.Conflicts}, ErrConflict
+	}
+	changes := result.Push
+	if opts.Mode == ModePullRequest {
+		// Against the synced commit every local change is a push
+		changes = Compare(base, shas, base).Push
+	}
+	if len(changes) == 0 {
+		return &PushResult{Commit: head, Files: []Change{}}, nil
+	}
+
+	treeChanges := make([]TreeChange, 0, len(changes))
+	for _, change := range changes {
+		mode := modes[change.Path]
+		if mode == "" {
+			mode = "100644"
+		}
+		treeChange := TreeChange{Path: change.Path, Mode: mode, Type: "blob"}
+		if change.Action != ActionDelete {
+			sha, err := c.CreateBlob(ctx, repo, []byte(local[change.Path]))
+			if err != nil {
+				return nil, err
+			}
+			treeChange.SHA = &sha
+		}
+		treeChanges = append(treeChanges, treeChange)
+	}
+
+	tree, err := c.CreateTree(ctx, repo, baseTree, treeChanges)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := c.CreateCommit(ctx, repo, opts.Message, tree, []string{parent}, opts.Author)
+	if err != nil {
+		return nil, err
+	}
+	pushed := &PushResult{Commit: commit, Files: changes}
+
+	if opts.Mode == ModePullRequest {
+		if err := c.CreateBranch(ctx, repo, opts.Branch, commit); err != nil {
+			return nil, err
+		}
+		title := opts.Title
+		if title == "" {
+			title = opts.Message
+		}
+		if pushed.PullRequest, err = c.CreatePullRequest(ctx, repo, title, opts.Body, opts.Branch, branch); err != nil {
+			return nil, err
+		}
+		return pushed, nil
+	}
+
+	if err := c.UpdateBranch(ctx, repo, branch, commit); err != nil {
+		return nil, err
+	}
+	pushed.Synced = make(map[string]string, len(changes)+len(result.Same))
+	for _, change := range append(changes, result.Same...) {
+		pushed.Synced[change.Path] = change.SHA
+	}
+	return pushed, nil
+}
@@ -0,0 +1,115 @@
+// Package githubsync syncs project files with a branch of a GitHub
+// repository. The blob SHA every path had at the last sync is the base of
+// a three-way comparison: a side that still has the base SHA did not touch
+// the path, and a path both sides changed differently is a conflict.
+package githubsync
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Conflict reasons
+const (
+	ConflictModifiedBoth     = "modified_on_both_sides"
+	ConflictDeletedOnGitHub  = "modified_locally_deleted_on_github"
+	ConflictDeletedLocally   = "deleted_locally_modified_on_github"
+	ConflictChangedDuringRun = "changed_during_sync"
+	ConflictPathTaken        = "path_conflict"
+)
+
+// Change is a path to create, update or delete on one side, with the blob
+// SHA it gets from the other side.
+type Change struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	SHA    string `json:"sha,omitempty"`
+}
+
+// Conflict is a path both sides changed since the last sync.
+type Conflict struct {
+	Path      string `json:"path"`
+	Reason    string `json:"reason"`
+	LocalSHA  string `json:"local_sha,omitempty"`
+	RemoteSHA string `json:"remote_sha,omitempty"`
+}
+
+// Result is the comparison of the project files with the GitHub branch.
+type Result struct {
+	// Pull lists paths only GitHub changed
+	Pull []Change
+	// Push lists paths only the project changed
+	Push []Change
+	// Same lists paths both sides changed the same way; only their base
+	// needs to move. An empty SHA means both deleted the path.
+	Same []Change
+	// Conflicts lists paths both sides changed differently
+	Conflicts []Conflict
+}
+
+// BlobSHA returns the git blob SHA of content, as GitHub computes it.
+func BlobSHA(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Compare compares the blob SHAs of local and remote paths with those at
+// the last sync.
+func Compare(base, local, remote map[string]string) Result {
+	paths := make(map[string]bool, len(base)+len(local)+len(remote))
+	for _, m := range []map[string]string{base, local, remote} {
+		for p := range m {
+			paths[p] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var result Result
+	for _, p := range sorted {
+		b, l, r := base[p], local[p], remote[p]
+		switch {
+		case l == r:
+			if b != l {
+				result.Same = append(result.Same, Change{Path: p, Action: action(b, l), SHA: l})
+			}
+		case l == b:
+			result.Pull = append(result.Pull, Change{Path: p, Action: action(l, r), SHA: r})
+		case r == b:
+			result.Push = append(result.Push, Change{Path: p, Action: action(r, l), SHA: l})
+		default:
+			reason := ConflictModifiedBoth
+			if r == "" {
+				reason = ConflictDeletedOnGitHub
+			} else if l == "" {
+				reason = ConflictDeletedLocally
+			}
+			result.Conflicts = append(result.Conflicts, Conflict{Path: p, Reason: reason, LocalSHA: l, RemoteSHA: r})
+		}
+	}
+	return result
+}
+
+// action names the change that turns from into to.
+func action(from, to string) string {
+	switch {
+	case from == "":
+		return ActionCreate
+	case to == "":
+		return ActionDelete
+	}
+	return ActionUpdate
+}
@@ -0,0 +1,93 @@
+package githubsync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBlobSHA(t *testing.T) {
+	// Same as git hash-object
+	tests := map[string]string{
+		"":        "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391",
+		"hello\n": "ce013625030ba8dba906f756967f9e9ca394464a",
+	}
+	for content, want := range tests {
+		if got := BlobSHA([]byte(content)); got != want {
+			t.Errorf("BlobSHA(%q) = %s, want %s", content, got, want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := map[string]string{
+		"same.txt":           "s1",
+		"pull-update.txt":    "p1",
+		"pull-delete.txt":    "p2",
+		"push-update.txt":    "u1",
+		"push-delete.txt":    "u2",
+		"both-same.txt":      "b1",
+		"both-deleted.txt":   "b2",
+		"conflict.txt":       "c1",
+		"deleted-remote.txt": "c2",
+		"deleted-local.txt":  "c3",
+	}
+	local := map[string]string{
+		"same.txt":           "s1",
+		"pull-update.txt":    "p1",
+		"pull-delete.txt":    "p2",
+		"push-update.txt":    "u1-local",
+		"push-create.txt":    "new-local",
+		"both-same.txt":      "b1-both",
+		"conflict.txt":       "c1-local",
+		"deleted-remote.txt": "c2-local",
+	}
+	remote := map[string]string{
+		"same.txt":          "s1",
+		"pull-update.txt":   "p1-remote",
+		"pull-create.txt":   "new-remote",
+		"push-update.txt":   "u1",
+		"push-delete.txt":   "u2",
+		"both-same.txt":     "b1-both",
+		"conflict.txt":      "c1-remote",
+		"deleted-local.txt": "c3-remote",
+	}
+
+	got := Compare(base, local, remote)
+	want := Result{
+		Pull: []Change{
+			{Path: "pull-create.txt", Action: ActionCreate, SHA: "new-remote"},
+			{Path: "pull-delete.txt", Action: ActionDelete},
+			{Path: "pull-update.txt", Action: ActionUpdate, SHA: "p1-remote"},
+		},
+		Push: []Change{
+			{Path: "push-create.txt", Action: ActionCreate, SHA: "new-local"},
+			{Path: "push-delete.txt", Action: ActionDelete},
+			{Path: "push-update.txt", Action: ActionUpdate, SHA: "u1-local"},
+		},
+		Same: []Change{
+			{Path: "both-deleted.txt", Action: ActionDelete},
+			{Path: "both-same.txt", Action: ActionUpdate, SHA: "b1-both"},
+		},
+		Conflicts: []Conflict{
+			{Path: "conflict.txt", Reason: ConflictModifiedBoth, LocalSHA: "c1-local", RemoteSHA: "c1-remote"},
+			{Path: "deleted-local.txt", Reason: ConflictDeletedLocally, RemoteSHA: "c3-remote"},
+			{Path: "deleted-remote.txt", Reason: ConflictDeletedOnGitHub, LocalSHA: "c2-local"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestCompareFirstSync(t *testing.T) {
+	// Without a base every difference is a conflict and equal files are
+	// only recorded
+	got := Compare(nil, map[string]string{"a": "1", "b": "2"}, map[string]string{"a": "1", "b": "3"})
+	want := Result{
+		Same:      []Change{{Path: "a", Action: ActionCreate, SHA: "1"}},
+		Conflicts: []Conflict{{Path: "b", Reason: ConflictModifiedBoth, LocalSHA: "2", RemoteSHA: "3"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare() = %+v, want %+v", got, want)
+	}
+}
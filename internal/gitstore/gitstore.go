@@ -0,0 +1,349 @@
+// Package gitstore keeps the text files of every project in a bare git
+// repository on local disk. Each change to the files is recorded as a
+// commit on the main branch, authored by the DevSync user who made it.
+// Files stored in the bucket are not part of the repository.
+package gitstore
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"devsync-be/internal/models"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gorm.io/gorm"
+)
+
+// Branch is the branch every commit is made on.
+const Branch = plumbing.ReferenceName("refs/heads/main")
+
+const (
+	committerName  = "DevSync"
+	committerEmail = "devsync@users.noreply.devsync.local"
+	// lockSpace is the first key of the advisory lock that serializes
+	// commits to one project
+	lockSpace = 0x64657673
+)
+
+var ErrNotFound = errors.New("revision or path not found")
+
+// Store opens and commits to the repositories of projects, one bare
+// repository per project under dir.
+type Store struct {
+	db  *gorm.DB
+	dir string
+}
+
+func New(db *gorm.DB, dir string) *Store {
+	return &Store{db: db, dir: dir}
+}
+
+// Commit is a commit as the API returns it.
+type Commit struct {
+	SHA         string    `json:"sha"`
+	Message     string    `json:"message"`
+	AuthorName  string    `json:"author_name"`
+	AuthorEmail string    `json:"author_email"`
+	AuthoredAt  time.Time `json:"authored_at"`
+	Parents     []string  `json:"parents"`
+}
+
+func newCommit(c *object.Commit) Commit {
+	parents := make([]string, len(c.ParentHashes))
+	for i, hash := range c.ParentHashes {
+		parents[i] = hash.String()
+	}
+	return Commit{
+		SHA:         c.Hash.String(),
+		Message:     c.Message,
+		AuthorName:  c.Author.Name,
+		AuthorEmail: c.Author.Email,
+		AuthoredAt:  c.Author.When,
+		Parents:     parents,
+	}
+}
+
+// Commit records the current text files of a project as a commit by
+// userID, or by DevSync itself if userID is 0. It returns nil if the files
+// did not change since the last commit.
+func (s *Store) Commit(projectID, userID uint, message string) (*Commit, error) {
+	var commit *Commit
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?::int, ?::int)", lockSpace, projectID).Error; err != nil {
+			return err
+		}
+		repo, err := s.init(projectID)
+		if err != nil {
+			return err
+		}
+		commit, err = s.commit(tx, repo, projectID, userID, message)
+		return err
+	})
+	return commit, err
+}
+
+// CommitFile commits the project of a file after its content was saved.
+// Errors are logged, the saved content stays the source of truth and is
+// picked up by the next commit.
+func (s *Store) CommitFile(fileID, userID uint, message string) {
+	var file models.File
+	if err := s.db.Unscoped().Select("id, project_id, path").First(&file, fileID).Error; err != nil {
+		log.Printf("Git: failed to find file %d: %v", fileID, err)
+		return
+	}
+	if message == "" {
+		message = "Update " + file.Path
+	}
+	if _, err := s.Commit(file.ProjectID, userID, message); err != nil {
+		log.Printf("Git: failed to commit project %d: %v", file.ProjectID, err)
+	}
+}
+
+// Record is Commit for callers that already changed the files and only
+// log a failure.
+func (s *Store) Record(projectID, userID uint, message string) {
+	if _, err := s.Commit(projectID, userID, message); err != nil {
+		log.Printf("Git: failed to commit project %d: %v", projectID, err)
+	}
+}
+
+func (s *Store) path(projectID uint) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%d.git", projectID))
+}
+
+// init opens the repository of a project, creating it if needed.
+func (s *Store) init(projectID uint) (*git.Repository, error) {
+	repo, err := git.PlainOpen(s.path(projectID))
+	if err != git.ErrRepositoryNotExists {
+		return repo, err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, err
+	}
+	return git.PlainInitWithOptions(s.path(projectID), &git.PlainInitOptions{
+		Bare:        true,
+		InitOptions: git.InitOptions{DefaultBranch: Branch},
+	})
+}
+
+// open opens the repository of a project for reading. A project that has
+// none yet gets one with its current files.
+func (s *Store) open(projectID uint) (*git.Repository, error) {
+	repo, err := git.PlainOpen(s.path(projectID))
+	if err != git.ErrRepositoryNotExists {
+		return repo, err
+	}
+	if _, err := s.Commit(projectID, 0, "Import existing files"); err != nil {
+		return nil, err
+	}
+	return git.PlainOpen(s.path(projectID))
+}
+
+// head returns the last commit on Branch, or nil if there is none.
+func head(repo *git.Repository) (*object.Commit, error) {
+	ref, err := repo.Reference(Branch, true)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return repo.CommitObject(ref.Hash())
+}
+
+func (s *Store) commit(tx *gorm.DB, repo *git.Repository, projectID, userID uint, message string) (*Commit, error) {
+	var files []struct {
+		Path    string
+		Content string
+	}
+	if err := tx.Model(&models.File{}).
+		Select("path, content").
+		Where("project_id = ? AND COALESCE(file_url, '') = ''", projectID).
+		Order("path ASC").
+		Scan(&files).Error; err != nil {
+		return nil, err
+	}
+
+	root := newDir()
+	for _, file := range files {
+		if !trackable(file.Path) {
+			continue
+		}
+		hash, err := writeObject(repo, plumbing.BlobObject, []byte(file.Content))
+		if err != nil {
+			return nil, err
+		}
+		root.add(strings.Split(file.Path, "/"), hash)
+	}
+	treeHash, err := root.write(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := head(repo)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil && len(root.entries) == 0 {
+		return nil, nil
+	}
+	if parent != nil && parent.TreeHash == treeHash {
+		return nil, nil
+	}
+
+	now := time.Now()
+	committer := object.Signature{Name: committerName, Email: committerEmail, When: now}
+	author := committer
+	if userID != 0 {
+		var user models.User
+		if err := tx.Unscoped().First(&user, userID).Error; err != nil {
+			return nil, err
+		}
+		author = signature(&user, now)
+	}
+	if message == "" {
+		message = "Update files"
+	}
+
+	commit := &object.Commit{
+		Author:    author,
+		Committer: committer,
+		Message:   message,
+		TreeHash:  treeHash,
+	}
+	if parent != nil {
+		commit.ParentHashes = []plumbing.Hash{parent.Hash}
+	}
+
+	obj := repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return nil, err
+	}
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(Branch, hash)); err != nil {
+		return nil, err
+	}
+
+	commit.Hash = hash
+	result := newCommit(commit)
+	return &result, nil
+}
+
+// signature returns the git identity of a DevSync user.
+func signature(user *models.User, when time.Time) object.Signature {
+	name := user.Name
+	if name == "" {
+		name = user.Username
+	}
+	email := user.Email
+	if email == "" {
+		email = user.Username + "@users.noreply.devsync.local"
+	}
+	return object.Signature{Name: name, Email: email, When: when}
+}
+
+// trackable reports whether git clients accept p in a tree. Clients refuse
+// to check out anything below a .git folder.
+func trackable(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if strings.EqualFold(segment, ".git") {
+			return false
+		}
+	}
+	return true
+}
+
+// writeObject stores an object unless the repository has it already.
+func writeObject(repo *git.Repository, t plumbing.ObjectType, data []byte) (plumbing.Hash, error) {
+	hash := plumbing.ComputeHash(t, data)
+	if repo.Storer.HasEncodedObject(hash) == nil {
+		return hash, nil
+	}
+
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(t)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+// dir is a folder of the tree being built.
+type dir struct {
+	entries map[string]*dir
+	blobs   map[string]plumbing.Hash
+}
+
+func newDir() *dir {
+	return &dir{entries: make(map[string]*dir), blobs: make(map[string]plumbing.Hash)}
+}
+
+func (d *dir) add(segments []string, hash plumbing.Hash) {
+	if len(segments) == 1 {
+		d.entries[segments[0]] = nil
+		d.blobs[segments[0]] = hash
+		return
+	}
+	child := d.entries[segments[0]]
+	if child == nil {
+		child = newDir()
+		d.entries[segments[0]] = child
+	}
+	child.add(segments[1:], hash)
+}
+
+// write stores the tree of d and everything below it.
+func (d *dir) write(repo *git.Repository) (plumbing.Hash, error) {
+	tree := &object.Tree{}
+	for name, child := range d.entries {
+		if child == nil {
+			tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: d.blobs[name]})
+			continue
+		}
+		hash, err := child.write(repo)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: hash})
+	}
+
+	// git orders entries by name, with folder names ending in a slash
+	sort.Slice(tree.Entries, func(i, j int) bool {
+		return sortName(tree.Entries[i]) < sortName(tree.Entries[j])
+	})
+
+	obj := repo.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if repo.Storer.HasEncodedObject(obj.Hash()) == nil {
+		return obj.Hash(), nil
+	}
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+func sortName(entry object.TreeEntry) string {
+	if entry.Mode == filemode.Dir {
+		return entry.Name + "/"
+	}
+	return entry.Name
+}
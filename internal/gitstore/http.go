@@ -0,0 +1,101 @@
+package gitstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// UploadPackService is the only git service served over HTTP. Pushing is
+// not supported; the repository follows the project files.
+const UploadPackService = "git-upload-pack"
+
+// AdvertiseRefs writes the reference advertisement a git client fetches
+// from info/refs before cloning over smart HTTP.
+func (s *Store) AdvertiseRefs(ctx context.Context, projectID uint, w io.Writer) error {
+	session, _, err := s.uploadPackSession(projectID)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	refs, err := session.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return err
+	}
+	refs.Prefix = [][]byte{[]byte("# service=" + UploadPackService), pktline.Flush}
+	return refs.Encode(w)
+}
+
+// UploadPack answers one stateless upload-pack request from a git client.
+// Common commits are never acknowledged, so the client sends all its haves
+// and then done, and gets a pack without the objects they reach.
+func (s *Store) UploadPack(ctx context.Context, projectID uint, r io.Reader, w io.Writer) error {
+	req := packp.NewUploadPackRequest()
+	if err := req.UploadRequest.Decode(r); err != nil {
+		return err
+	}
+
+	session, objects, err := s.uploadPackSession(projectID)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	done := false
+	scanner := pktline.NewScanner(r)
+	for scanner.Scan() {
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte("\n"))
+		switch {
+		case bytes.HasPrefix(line, []byte("have ")):
+			// Haves this repository lacks say nothing about what to send
+			hash := plumbing.NewHash(string(line[len("have "):]))
+			if objects.HasEncodedObject(hash) == nil {
+				req.Haves = append(req.Haves, hash)
+			}
+		case bytes.Equal(line, []byte("done")):
+			done = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !done {
+		return pktline.NewEncoder(w).Encodef("NAK\n")
+	}
+
+	resp, err := session.UploadPack(ctx, req)
+	if err != nil {
+		return err
+	}
+	return resp.Encode(w)
+}
+
+func (s *Store) uploadPackSession(projectID uint) (transport.UploadPackSession, storer.EncodedObjectStorer, error) {
+	repo, err := s.open(projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	endpoint, err := transport.NewEndpoint("/")
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := server.NewServer(loader{repo.Storer}).NewUploadPackSession(endpoint, nil)
+	return session, repo.Storer, err
+}
+
+// loader hands the server transport an already open repository.
+type loader struct {
+	storer storer.Storer
+}
+
+func (l loader) Load(*transport.Endpoint) (storer.Storer, error) {
+	return l.storer, nil
+}
@@ -0,0 +1,287 @@
+package gitstore
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// FileStat is the number of lines a commit or diff added to and removed
+// from one file.
+type FileStat struct {
+	Path      string `json:"path"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+}
+
+// CommitDetail is a commit with the files it changed.
+type CommitDetail struct {
+	Commit
+	Files []FileStat `json:"files"`
+}
+
+// Entry is a file or folder in a tree at some commit.
+type Entry struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+	SHA  string `json:"sha"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// Object is a file with its content, or a folder with its entries, at some
+// commit.
+type Object struct {
+	Commit  string  `json:"commit"`
+	Type    string  `json:"type"`
+	Path    string  `json:"path"`
+	SHA     string  `json:"sha"`
+	Size    int64   `json:"size,omitempty"`
+	Content *string `json:"content,omitempty"`
+	Entries []Entry `json:"entries,omitempty"`
+}
+
+// Log returns the commits of a project, newest first. If p is not empty
+// only commits that changed the file or folder at p are included.
+func (s *Store) Log(projectID uint, p string, limit, offset int) ([]Commit, error) {
+	commits := []Commit{}
+	repo, err := s.open(projectID)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := head(repo)
+	if err != nil || tip == nil {
+		return commits, err
+	}
+
+	options := &git.LogOptions{From: tip.Hash}
+	if p != "" {
+		options.PathFilter = func(changed string) bool {
+			return changed == p || strings.HasPrefix(changed, p+"/")
+		}
+	}
+	iter, err := repo.Log(options)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	err = iter.ForEach(func(c *object.Commit) error {
+		if offset > 0 {
+			offset--
+			return nil
+		}
+		commits = append(commits, newCommit(c))
+		if len(commits) == limit {
+			return storer.ErrStop
+		}
+		return nil
+	})
+	return commits, err
+}
+
+// Show returns a commit with the files it changed.
+func (s *Store) Show(projectID uint, rev string) (*CommitDetail, error) {
+	repo, err := s.open(projectID)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := resolve(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := parentTree(commit)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := diffTrees(parent, tree, "")
+	if err != nil {
+		return nil, err
+	}
+	return &CommitDetail{Commit: newCommit(commit), Files: fileStats(patch)}, nil
+}
+
+// Read returns the file or folder at p as it was at rev. An empty p is the
+// project root.
+func (s *Store) Read(projectID uint, rev, p string) (*Object, error) {
+	repo, err := s.open(projectID)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := resolve(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Object{Commit: commit.Hash.String(), Type: "folder", Path: p, SHA: tree.Hash.String()}
+	if p != "" {
+		entry, err := tree.FindEntry(p)
+		if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.SHA = entry.Hash.String()
+
+		if entry.Mode != filemode.Dir {
+			blob, err := repo.BlobObject(entry.Hash)
+			if err != nil {
+				return nil, err
+			}
+			content, err := readBlob(blob)
+			if err != nil {
+				return nil, err
+			}
+			result.Type, result.Size, result.Content = "file", blob.Size, &content
+			return result, nil
+		}
+		if tree, err = repo.TreeObject(entry.Hash); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Entries = []Entry{}
+	for _, entry := range tree.Entries {
+		item := Entry{Type: "file", Name: entry.Name, Path: join(p, entry.Name), SHA: entry.Hash.String()}
+		if entry.Mode == filemode.Dir {
+			item.Type = "folder"
+		} else if size, err := repo.Storer.EncodedObjectSize(entry.Hash); err == nil {
+			item.Size = size
+		}
+		result.Entries = append(result.Entries, item)
+	}
+	return result, nil
+}
+
+// Diff returns a unified diff from the commit from to the commit to, with
+// the files each changed. from defaults to the parent of to and to to the
+// last commit. If p is not empty only the file or folder at p is compared.
+func (s *Store) Diff(projectID uint, from, to, p string) (string, []FileStat, error) {
+	repo, err := s.open(projectID)
+	if err != nil {
+		return "", nil, err
+	}
+	if to == "" {
+		to = Branch.String()
+	}
+	target, err := resolve(repo, to)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var base *object.Tree
+	if from == "" {
+		base, err = parentTree(target)
+	} else {
+		var commit *object.Commit
+		if commit, err = resolve(repo, from); err == nil {
+			base, err = commit.Tree()
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	tree, err := target.Tree()
+	if err != nil {
+		return "", nil, err
+	}
+
+	patch, err := diffTrees(base, tree, p)
+	if err != nil {
+		return "", nil, err
+	}
+	var buf bytes.Buffer
+	if err := diff.NewUnifiedEncoder(&buf, diff.DefaultContextLines).Encode(patch); err != nil {
+		return "", nil, err
+	}
+	return buf.String(), fileStats(patch), nil
+}
+
+// resolve finds the commit a revision such as a SHA, a short SHA or
+// "main~2" points at.
+func resolve(repo *git.Repository, rev string) (*object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return repo.CommitObject(*hash)
+}
+
+// parentTree returns the tree of the first parent of c, or nil for the
+// first commit.
+func parentTree(c *object.Commit) (*object.Tree, error) {
+	if c.NumParents() == 0 {
+		return nil, nil
+	}
+	parent, err := c.Parent(0)
+	if err != nil {
+		return nil, err
+	}
+	return parent.Tree()
+}
+
+// diffTrees compares two trees, either of which may be nil, limited to the
+// file or folder at p if it is not empty.
+func diffTrees(from, to *object.Tree, p string) (*object.Patch, error) {
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if p != "" {
+		filtered := changes[:0]
+		for _, change := range changes {
+			if under(change.From.Name, p) || under(change.To.Name, p) {
+				filtered = append(filtered, change)
+			}
+		}
+		changes = filtered
+	}
+	return changes.Patch()
+}
+
+func fileStats(patch *object.Patch) []FileStat {
+	stats := []FileStat{}
+	for _, stat := range patch.Stats() {
+		stats = append(stats, FileStat{Path: stat.Name, Additions: stat.Addition, Deletions: stat.Deletion})
+	}
+	return stats
+}
+
+func readBlob(blob *object.Blob) (string, error) {
+	r, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+func under(name, p string) bool {
+	return name != "" && (name == p || strings.HasPrefix(name, p+"/"))
+}
+
+func join(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
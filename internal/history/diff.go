@@ -0,0 +1,205 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Lines of unchanged text around each hunk
+	diffContext = 3
+	// Texts further apart than this many line edits are diffed as one
+	// replaced block, which bounds the memory the diff uses
+	maxEdits = 1000
+)
+
+type lineEdit struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Unified returns the line diff from a to b in unified format, or "" if
+// they are equal.
+func Unified(fromName, toName, a, b string) string {
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	oldLine, newLine := 0, 0
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// A hunk runs until diffContext*2 unchanged lines in a row
+		start := max(0, i-diffContext)
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= diffContext*2 {
+				break
+			}
+		}
+		end = min(len(edits), end+diffContext)
+
+		before := i - start
+		oldStart, newStart := oldLine-before, newLine-before
+		oldCount, newCount := 0, 0
+		for _, e := range edits[start:end] {
+			if e.kind != '+' {
+				oldCount++
+			}
+			if e.kind != '-' {
+				newCount++
+			}
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, e := range edits[start:end] {
+			out.WriteByte(e.kind)
+			out.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		oldLine, newLine = oldStart+oldCount, newStart+newCount
+		i = end
+	}
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits text after each newline. The last line has none if
+// the text does not end with one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script from a to b.
+func diffLines(a, b []string) []lineEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]lineEdit, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		edits = append(edits, lineEdit{' ', line})
+	}
+	middle, ok := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	if !ok {
+		middle = middle[:0]
+		for _, line := range a[prefix : len(a)-suffix] {
+			middle = append(middle, lineEdit{'-', line})
+		}
+		for _, line := range b[prefix : len(b)-suffix] {
+			middle = append(middle, lineEdit{'+', line})
+		}
+	}
+	edits = append(edits, middle...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, lineEdit{' ', line})
+	}
+	return edits
+}
+
+// myers implements the O(ND) algorithm from "An O(ND) Difference Algorithm
+// and Its Variations". It gives up and returns false after maxEdits.
+func myers(a, b []string) ([]lineEdit, bool) {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+	offset := limit + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] is v[-d-1..d+1] at the start of round d
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, d), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func backtrack(a, b []string, trace [][]int, depth int) []lineEdit {
+	var reversed []lineEdit
+	x, y := len(a), len(b)
+	for d := depth; d > 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d+1] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, lineEdit{' ', a[x]})
+		}
+		if prevK == k+1 {
+			y--
+			reversed = append(reversed, lineEdit{'+', b[y]})
+		} else {
+			x--
+			reversed = append(reversed, lineEdit{'-', a[x]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 {
+		x--
+		reversed = append(reversed, lineEdit{' ', a[x]})
+	}
+
+	edits := make([]lineEdit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
@@ -0,0 +1,102 @@
+// Package history keeps the revisions of file content and diffs them.
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"devsync-be/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrBlobMissing is returned for a revision whose content is not stored.
+var ErrBlobMissing = errors.New("revision content is missing")
+
+// Content this large or larger is stored gzipped
+const compressAbove = 4 << 10
+
+// Record saves content as the next revision of a file and returns it. It
+// returns nil if content equals the latest revision. Callers must hold a
+// lock that serializes writes to the file, such as its FileEditState row.
+func Record(tx *gorm.DB, fileID, userID uint, content, message string) (*models.FileRevision, error) {
+	hash := Hash(content)
+
+	var latest models.FileRevision
+	err := tx.Where("file_id = ?", fileID).Order("number DESC").Limit(1).Find(&latest).Error
+	if err != nil {
+		return nil, err
+	}
+	if latest.ID != 0 && latest.ContentHash == hash {
+		return nil, nil
+	}
+
+	if err := saveBlob(tx, hash, content); err != nil {
+		return nil, err
+	}
+	revision := &models.FileRevision{
+		FileID:      fileID,
+		Number:      latest.Number + 1,
+		UserID:      userID,
+		ContentHash: hash,
+		Size:        int64(len(content)),
+		Message:     message,
+	}
+	if err := tx.Create(revision).Error; err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// Content returns the text of a revision.
+func Content(db *gorm.DB, revision *models.FileRevision) (string, error) {
+	var blob models.FileBlob
+	if err := db.Where("hash = ?", revision.ContentHash).Limit(1).Find(&blob).Error; err != nil {
+		return "", err
+	}
+	if blob.Hash == "" {
+		return "", ErrBlobMissing
+	}
+	if !blob.Compressed {
+		return string(blob.Data), nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(blob.Data))
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Hash returns the hex SHA-256 that content is stored under.
+func Hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func saveBlob(tx *gorm.DB, hash, content string) error {
+	blob := models.FileBlob{Hash: hash, Size: int64(len(content)), Data: []byte(content)}
+	if len(content) >= compressAbove {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := io.WriteString(w, content); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if buf.Len() < len(content) {
+			blob.Data, blob.Compressed = buf.Bytes(), true
+		}
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&blob).Error
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+    "time"
+)
+
+type AccountTokenPurpose string
+
+const (
+    AccountTokenEmailVerification AccountTokenPurpose = "email_verification"
+    AccountTokenPasswordReset     AccountTokenPurpose = "password_reset"
+)
+
+// AccountToken is a single-use token mailed to a user to verify their
+// email address or reset their password. Only its hash is stored.
+type AccountToken struct {
+    ID        uint                `json:"id" gorm:"primaryKey"`
+    UserID    uint                `json:"user_id" gorm:"not null;index"`
+    Purpose   AccountTokenPurpose `json:"purpose" gorm:"type:varchar(30);not null"`
+    TokenHash string              `json:"-" gorm:"uniqueIndex;not null"`
+    ExpiresAt time.Time           `json:"expires_at"`
+    UsedAt    *time.Time          `json:"used_at"`
+    CreatedAt time.Time           `json:"created_at"`
+
+    // Relationships
+    User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// LoginAttempt records a failed password login. Recent failures per email
+// and per IP address throttle further attempts.
+type LoginAttempt struct {
+    ID        uint      `json:"id" gorm:"primaryKey"`
+    Email     string    `json:"email" gorm:"index"`
+    IPAddress string    `json:"ip_address" gorm:"index"`
+    CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// IsUsable reports whether the token is neither used nor expired.
+func (t *AccountToken) IsUsable() bool {
+    return t.UsedAt == nil && time.Now().Before(t.ExpiresAt)
+}
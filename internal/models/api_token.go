@@ -0,0 +1,64 @@
+package models
+
+import (
+    "strings"
+    "time"
+)
+
+// Token prefixes make DevSync credentials easy to recognise in logs and
+// secret scanners, and let the auth middleware tell them apart from JWTs.
+const (
+    PersonalTokenPrefix = "dsp_"
+    BotTokenPrefix      = "dsb_"
+)
+
+// TokenScopes lists every scope an API token may be granted. Each resource
+// has a read scope for GET requests and a write scope for everything else.
+var TokenScopes = []string{
+    "user:read",
+    "projects:read", "projects:write",
+    "files:read", "files:write",
+    "tasks:read", "tasks:write",
+    "chat:read", "chat:write",
+}
+
+// APIToken is a personal access token or a bot key. Only a hash of the token
+// is stored; Prefix keeps enough of it to identify the token in listings.
+type APIToken struct {
+    ID         uint       `json:"id" gorm:"primaryKey"`
+    UserID     uint       `json:"user_id" gorm:"not null;index"`
+    Name       string     `json:"name" gorm:"not null"`
+    Prefix     string     `json:"prefix" gorm:"not null"`
+    TokenHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+    Scopes     string     `json:"scopes" gorm:"type:text;not null"` // space separated
+    ProjectID  *uint      `json:"project_id"`
+    ExpiresAt  *time.Time `json:"expires_at"`
+    LastUsedAt *time.Time `json:"last_used_at"`
+    RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+    CreatedAt  time.Time  `json:"created_at"`
+    UpdatedAt  time.Time  `json:"updated_at"`
+
+    // Relationships
+    User    User     `json:"-" gorm:"foreignKey:UserID"`
+    Project *Project `json:"-" gorm:"foreignKey:ProjectID"`
+}
+
+// IsActive reports whether the token has neither expired nor been revoked.
+func (t *APIToken) IsActive() bool {
+    return t.RevokedAt == nil && (t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt))
+}
+
+// ScopeList returns the token's scopes as a slice.
+func (t *APIToken) ScopeList() []string {
+    return strings.Fields(t.Scopes)
+}
+
+// ValidTokenScope reports whether scope is one of TokenScopes.
+func ValidTokenScope(scope string) bool {
+    for _, s := range TokenScopes {
+        if s == scope {
+            return true
+        }
+    }
+    return false
+}
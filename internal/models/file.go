@@ -16,6 +16,10 @@ type File struct {
     MimeType  string         `json:"mime_type"`
     ProjectID uint           `json:"project_id" gorm:"not null"`
     UploadedBy uint          `json:"uploaded_by"`
+    // Version increases on every change through the REST API
+    Version   int64          `json:"version" gorm:"not null;default:1"`
+    // Dir is the path of the parent folder, "" at the project root
+    Dir       string         `json:"dir" gorm:"not null;default:''"`
     CreatedAt time.Time      `json:"created_at"`
     UpdatedAt time.Time      `json:"updated_at"`
     DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -0,0 +1,29 @@
+package models
+
+import (
+    "time"
+)
+
+// FileEdit is one operation of a collaborative editing session, in the
+// order the server applied it. Revision increases by one per edit within a
+// file. Edits up to FileEditState.SnapshotRevision are already part of
+// File.Content and are only kept for a while so lagging clients can catch
+// up.
+type FileEdit struct {
+    ID           uint      `json:"id" gorm:"primaryKey"`
+    FileID       uint      `json:"file_id" gorm:"not null;uniqueIndex:idx_file_edit_revision"`
+    Revision     int64     `json:"revision" gorm:"not null;uniqueIndex:idx_file_edit_revision"`
+    UserID       uint      `json:"user_id" gorm:"not null"`
+    ConnectionID string    `json:"connection_id"`
+    Operation    []byte    `json:"-" gorm:"not null"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+// FileEditState holds the last edit revision of a file and the revision
+// File.Content was last saved at. It is updated with a row lock, so edits
+// of one file are applied one at a time across all instances.
+type FileEditState struct {
+    FileID           uint  `gorm:"primaryKey;autoIncrement:false"`
+    Revision         int64 `gorm:"not null;default:0"`
+    SnapshotRevision int64 `gorm:"not null;default:0"`
+}
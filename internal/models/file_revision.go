@@ -0,0 +1,31 @@
+package models
+
+import (
+    "time"
+)
+
+// FileRevision is a saved state of File.Content. Number counts up from 1
+// within a file. The content itself is stored once per distinct text in
+// FileBlob, so restoring or re-saving an old state costs no extra space.
+type FileRevision struct {
+    ID          uint      `json:"id" gorm:"primaryKey"`
+    FileID      uint      `json:"file_id" gorm:"not null;uniqueIndex:idx_file_revision_number"`
+    Number      int64     `json:"number" gorm:"not null;uniqueIndex:idx_file_revision_number"`
+    UserID      uint      `json:"user_id" gorm:"not null"`
+    ContentHash string    `json:"content_hash" gorm:"size:64;not null;index"`
+    Size        int64     `json:"size"`
+    Message     string    `json:"message"`
+    CreatedAt   time.Time `json:"created_at"`
+
+    // Relationships
+    User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// FileBlob holds file content by its SHA-256. Large content is gzipped.
+type FileBlob struct {
+    Hash       string    `gorm:"primaryKey;size:64"`
+    Size       int64     `gorm:"not null"`
+    Compressed bool      `gorm:"not null;default:false"`
+    Data       []byte    `gorm:"not null"`
+    CreatedAt  time.Time
+}
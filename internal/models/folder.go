@@ -0,0 +1,21 @@
+package models
+
+import (
+    "time"
+    "gorm.io/gorm"
+)
+
+// Folder is a directory of the project file tree. Path is the full
+// slash-separated path without a leading slash; a file or folder path is
+// unique within its project, and every parent of a file has a Folder.
+type Folder struct {
+    ID        uint           `json:"id" gorm:"primaryKey"`
+    ProjectID uint           `json:"project_id" gorm:"not null"`
+    Name      string         `json:"name" gorm:"not null"`
+    Path      string         `json:"path" gorm:"not null"`
+    Dir       string         `json:"dir" gorm:"not null;default:''"`
+    CreatedBy uint           `json:"created_by"`
+    CreatedAt time.Time      `json:"created_at"`
+    UpdatedAt time.Time      `json:"updated_at"`
+    DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+    "time"
+)
+
+// GitHubSync is the state of syncing a project with the branch of its
+// linked GitHub repository. LastSyncedSHA is the GitHub commit the project
+// files were last pulled from or pushed as.
+type GitHubSync struct {
+    ID            uint       `json:"id" gorm:"primaryKey"`
+    ProjectID     uint       `json:"project_id" gorm:"not null;uniqueIndex"`
+    Repo          string     `json:"repo" gorm:"not null"`
+    Branch        string     `json:"branch" gorm:"not null"`
+    LastSyncedSHA string     `json:"last_synced_sha" gorm:"size:40"`
+    LastSyncedAt  *time.Time `json:"last_synced_at"`
+    LastSyncedBy  *uint      `json:"last_synced_by"`
+    CreatedAt     time.Time  `json:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// GitHubSyncFile is the git blob SHA a path had when it was last synced,
+// the common base for telling local and remote changes apart.
+type GitHubSyncFile struct {
+    ID        uint   `gorm:"primaryKey"`
+    ProjectID uint   `gorm:"not null;uniqueIndex:idx_github_sync_file_path"`
+    Path      string `gorm:"not null;uniqueIndex:idx_github_sync_file_path"`
+    BlobSHA   string `gorm:"size:40;not null"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+    "time"
+)
+
+// UserIdentity links a user to an account at an external login provider.
+// A user may have several identities, but each provider account belongs to
+// exactly one user. The provider's OAuth token, if kept, is stored
+// envelope-encrypted and only accessed through secrets.TokenStore.
+type UserIdentity struct {
+    ID              uint       `json:"id" gorm:"primaryKey"`
+    UserID          uint       `json:"user_id" gorm:"not null;index"`
+    Provider        string     `json:"provider" gorm:"type:varchar(50);not null;uniqueIndex:idx_identity_provider_subject"`
+    Subject         string     `json:"-" gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+    Email           string     `json:"email"`
+    TokenCiphertext []byte     `json:"-"`
+    TokenKey        []byte     `json:"-"`
+    TokenKeyVersion uint       `json:"-" gorm:"not null;default:0;index"`
+    LastLoginAt     *time.Time `json:"last_login_at"`
+    CreatedAt       time.Time  `json:"created_at"`
+    UpdatedAt       time.Time  `json:"updated_at"`
+
+    // Relationships
+    User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// OAuthState is a pending external login. It is looked up by the hash of
+// the state parameter and consumed by the callback. BindingHash ties a
+// login to the browser that started it; link flows are also tied to the
+// session (LinkSessionID) of LinkUserID.
+type OAuthState struct {
+    ID            uint      `json:"id" gorm:"primaryKey"`
+    StateHash     string    `json:"-" gorm:"uniqueIndex;not null"`
+    Provider      string    `json:"provider" gorm:"type:varchar(50);not null"`
+    Nonce         string    `json:"-" gorm:"not null"`
+    CodeVerifier  string    `json:"-" gorm:"not null"`
+    BindingHash   string    `json:"-"`
+    LinkUserID    *uint     `json:"link_user_id"`
+    LinkSessionID *uint     `json:"-"`
+    RedirectTo    string    `json:"redirect_to"`
+    ExpiresAt     time.Time `json:"expires_at" gorm:"index"`
+    CreatedAt     time.Time `json:"created_at"`
+}
+
+// IsExpired reports whether the login took too long to complete.
+func (s *OAuthState) IsExpired() bool {
+    return time.Now().After(s.ExpiresAt)
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+    "time"
+    "gorm.io/gorm"
+)
+
+type InvitationStatus string
+
+const (
+    InvitationStatusPending  InvitationStatus = "pending"
+    InvitationStatusAccepted InvitationStatus = "accepted"
+    InvitationStatusRevoked  InvitationStatus = "revoked"
+)
+
+type ProjectInvitation struct {
+    ID         uint             `json:"id" gorm:"primaryKey"`
+    ProjectID  uint             `json:"project_id" gorm:"not null;index"`
+    Email      string           `json:"email" gorm:"not null;index"`
+    Role       ProjectRole      `json:"role" gorm:"type:varchar(20);not null;default:'member'"`
+    Status     InvitationStatus `json:"status" gorm:"type:varchar(20);not null;default:'pending'"`
+    TokenNonce string           `json:"-" gorm:"not null"`
+    InvitedBy  uint             `json:"invited_by" gorm:"not null"`
+    AcceptedBy *uint            `json:"accepted_by"`
+    ExpiresAt  time.Time        `json:"expires_at"`
+    SentAt     time.Time        `json:"sent_at"`
+    AcceptedAt *time.Time       `json:"accepted_at"`
+    RevokedAt  *time.Time       `json:"revoked_at"`
+    CreatedAt  time.Time        `json:"created_at"`
+    UpdatedAt  time.Time        `json:"updated_at"`
+    DeletedAt  gorm.DeletedAt   `json:"-" gorm:"index"`
+
+    // Relationships
+    Project Project `json:"project" gorm:"foreignKey:ProjectID"`
+    Inviter User    `json:"inviter" gorm:"foreignKey:InvitedBy"`
+}
+
+// IsExpired reports whether a pending invitation can no longer be accepted.
+func (i *ProjectInvitation) IsExpired() bool {
+    return time.Now().After(i.ExpiresAt)
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+    "time"
+)
+
+// UserMFA is a user's TOTP enrollment. The secret is envelope-encrypted and
+// only accessed through secrets.TokenStore. Two-factor login is enforced
+// once ConfirmedAt is set; LastUsedStep stops a code being replayed.
+type UserMFA struct {
+    UserID           uint       `json:"user_id" gorm:"primaryKey"`
+    SecretCiphertext []byte     `json:"-"`
+    SecretKey        []byte     `json:"-"`
+    SecretKeyVersion uint       `json:"-" gorm:"not null;default:0;index"`
+    ConfirmedAt      *time.Time `json:"confirmed_at"`
+    LastUsedStep     int64      `json:"-"`
+    CreatedAt        time.Time  `json:"created_at"`
+    UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// MFARecoveryCode is a one-time code that replaces a TOTP code when the
+// authenticator is lost. Only its hash is stored.
+type MFARecoveryCode struct {
+    ID        uint       `json:"id" gorm:"primaryKey"`
+    UserID    uint       `json:"user_id" gorm:"not null;index"`
+    CodeHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+    UsedAt    *time.Time `json:"used_at"`
+    CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFAChallenge is the "mfa pending" state between the first login factor
+// and the second. Its opaque token is only accepted by the MFA verify
+// endpoint and is consumed on success.
+type MFAChallenge struct {
+    ID         uint       `json:"id" gorm:"primaryKey"`
+    UserID     uint       `json:"user_id" gorm:"not null;index"`
+    TokenHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+    Attempts   int        `json:"attempts" gorm:"not null;default:0"`
+    ExpiresAt  time.Time  `json:"expires_at" gorm:"index"`
+    ConsumedAt *time.Time `json:"consumed_at"`
+    CreatedAt  time.Time  `json:"created_at"`
+}
+
+// MFAFailure records a second factor check. It is written before the code
+// is checked and the user's rows are cleared when a check passes, so what
+// remains are failures. Recent failures lock two-factor login across all
+// challenges, so signing in again with the password gives no fresh guesses.
+type MFAFailure struct {
+    ID        uint      `json:"id" gorm:"primaryKey"`
+    UserID    uint      `json:"user_id" gorm:"not null;index"`
+    CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// IsEnabled reports whether the enrollment has been confirmed.
+func (m *UserMFA) IsEnabled() bool {
+    return m.ConfirmedAt != nil
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+    "time"
+)
+
+// PresenceSession is one WebSocket connection's presence in a project. Each
+// server instance writes the rows of its own connections and refreshes
+// SeenAt while running, so rows of a crashed instance go stale and are
+// ignored.
+type PresenceSession struct {
+    ConnectionID string    `json:"connection_id" gorm:"primaryKey"`
+    ProjectID    uint      `json:"project_id" gorm:"primaryKey;autoIncrement:false;index"`
+    InstanceID   string    `json:"-" gorm:"not null;index"`
+    UserID       uint      `json:"user_id" gorm:"not null"`
+    Status       string    `json:"status" gorm:"not null"`
+    FileID       *uint     `json:"file_id"`
+    TaskID       *uint     `json:"task_id"`
+    ConnectedAt  time.Time `json:"connected_at"`
+    LastActiveAt time.Time `json:"last_active_at"`
+    SeenAt       time.Time `json:"-" gorm:"index"`
+}
@@ -11,7 +11,9 @@ type Project struct {
     Description string         `json:"description"`
     GitHubRepo  string         `json:"github_repo"`
     IsPublic    bool           `json:"is_public" gorm:"default:false"`
+    RequireMFA  bool           `json:"require_mfa" gorm:"default:false"`
     CreatedBy   *uint          `json:"created_by"`
+    Version     int64          `json:"version" gorm:"not null;default:1"`
     CreatedAt   time.Time      `json:"created_at"`
     UpdatedAt   time.Time      `json:"updated_at"`
     DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
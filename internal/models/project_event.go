@@ -0,0 +1,25 @@
+package models
+
+import (
+    "time"
+)
+
+// ProjectEvent is a broadcast WebSocket event kept so reconnecting clients
+// can replay what they missed. Seq increases by one per event within a
+// project; only the most recent events of each project are retained.
+type ProjectEvent struct {
+    ID        uint      `json:"id" gorm:"primaryKey"`
+    ProjectID uint      `json:"project_id" gorm:"not null;uniqueIndex:idx_project_event_seq"`
+    Seq       int64     `json:"seq" gorm:"not null;uniqueIndex:idx_project_event_seq"`
+    Type      string    `json:"type" gorm:"not null"`
+    Payload   []byte    `json:"-" gorm:"not null"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// ProjectEventCounter holds the last sequence number issued for a project.
+// It is incremented with a row lock, so numbers are gapless and commit in
+// order.
+type ProjectEventCounter struct {
+    ProjectID uint  `gorm:"primaryKey;autoIncrement:false"`
+    LastSeq   int64 `gorm:"not null;default:0"`
+}
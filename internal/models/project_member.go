@@ -0,0 +1,97 @@
+package models
+
+import (
+    "time"
+)
+
+type ProjectRole string
+
+const (
+    ProjectRoleOwner  ProjectRole = "owner"
+    ProjectRoleAdmin  ProjectRole = "admin"
+    ProjectRoleMember ProjectRole = "member"
+    ProjectRoleViewer ProjectRole = "viewer"
+)
+
+type ProjectPermission string
+
+const (
+    PermissionEditProject       ProjectPermission = "edit_project"
+    PermissionDeleteProject     ProjectPermission = "delete_project"
+    PermissionTransferOwnership ProjectPermission = "transfer_ownership"
+    PermissionManageMembers     ProjectPermission = "manage_members"
+    PermissionManageBots        ProjectPermission = "manage_bots"
+    PermissionManageSecurity    ProjectPermission = "manage_security"
+    PermissionEditFiles         ProjectPermission = "edit_files"
+    PermissionDeleteFiles       ProjectPermission = "delete_files"
+    PermissionEditTasks         ProjectPermission = "edit_tasks"
+    PermissionDeleteTasks       ProjectPermission = "delete_tasks"
+    PermissionManageSprints     ProjectPermission = "manage_sprints"
+    PermissionSendMessages      ProjectPermission = "send_messages"
+)
+
+// rolePermissions is the permission matrix for project roles. Reading a
+// project's files, tasks, sprints and chat only requires membership, so
+// viewers get no entries here.
+var rolePermissions = map[ProjectRole][]ProjectPermission{
+    ProjectRoleOwner: {
+        PermissionEditProject, PermissionDeleteProject, PermissionTransferOwnership,
+        PermissionManageSecurity, PermissionManageMembers, PermissionManageBots,
+        PermissionEditFiles, PermissionDeleteFiles,
+        PermissionEditTasks, PermissionDeleteTasks, PermissionManageSprints,
+        PermissionSendMessages,
+    },
+    ProjectRoleAdmin: {
+        PermissionEditProject, PermissionManageMembers, PermissionManageBots,
+        PermissionEditFiles, PermissionDeleteFiles, PermissionEditTasks, PermissionDeleteTasks,
+        PermissionManageSprints, PermissionSendMessages,
+    },
+    ProjectRoleMember: {
+        PermissionEditFiles, PermissionEditTasks, PermissionSendMessages,
+    },
+    ProjectRoleViewer: {},
+}
+
+// UserProject is the join table between users and projects. It carries the
+// member's role within the project.
+type UserProject struct {
+    UserID    uint        `json:"user_id" gorm:"primaryKey"`
+    ProjectID uint        `json:"project_id" gorm:"primaryKey"`
+    Role      ProjectRole `json:"role" gorm:"type:varchar(20);not null;default:'member'"`
+    CreatedAt time.Time   `json:"created_at"`
+}
+
+// Valid reports whether r is one of the known project roles.
+func (r ProjectRole) Valid() bool {
+    _, ok := rolePermissions[r]
+    return ok
+}
+
+// Can reports whether r grants the given permission.
+func (r ProjectRole) Can(permission ProjectPermission) bool {
+    for _, p := range rolePermissions[r] {
+        if p == permission {
+            return true
+        }
+    }
+    return false
+}
+
+// Permissions returns every permission granted by r.
+func (r ProjectRole) Permissions() []ProjectPermission {
+    return append([]ProjectPermission{}, rolePermissions[r]...)
+}
+
+// Rank orders roles from least (viewer) to most (owner) privileged.
+func (r ProjectRole) Rank() int {
+    switch r {
+    case ProjectRoleOwner:
+        return 3
+    case ProjectRoleAdmin:
+        return 2
+    case ProjectRoleMember:
+        return 1
+    default:
+        return 0
+    }
+}
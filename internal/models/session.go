@@ -0,0 +1,43 @@
+package models
+
+import (
+    "time"
+)
+
+// Session is a login on one device. Access tokens carry the session ID so a
+// revoked session stops working as soon as it is revoked.
+type Session struct {
+    ID            uint       `json:"id" gorm:"primaryKey"`
+    UserID        uint       `json:"user_id" gorm:"not null;index"`
+    UserAgent     string     `json:"user_agent"`
+    IPAddress     string     `json:"ip_address"`
+    LastSeenAt    time.Time  `json:"last_seen_at"`
+    ExpiresAt     time.Time  `json:"expires_at"`
+    RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+    RevokedReason string     `json:"revoked_reason,omitempty"`
+    CreatedAt     time.Time  `json:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+
+    // Relationships
+    User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// RefreshToken is one link in a session's rotation chain. Only the hash of
+// the opaque token is stored; UsedAt is set once it has been exchanged, so
+// presenting it again reveals token theft.
+type RefreshToken struct {
+    ID        uint       `json:"id" gorm:"primaryKey"`
+    SessionID uint       `json:"session_id" gorm:"not null;index"`
+    TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+    ExpiresAt time.Time  `json:"expires_at"`
+    UsedAt    *time.Time `json:"used_at"`
+    CreatedAt time.Time  `json:"created_at"`
+
+    // Relationships
+    Session Session `json:"-" gorm:"foreignKey:SessionID"`
+}
+
+// IsActive reports whether the session has neither expired nor been revoked.
+func (s *Session) IsActive() bool {
+    return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
@@ -23,6 +23,9 @@ type Task struct {
     Status      TaskStatus     `json:"status" gorm:"default:'todo'"`
     Priority    int            `json:"priority" gorm:"default:0"`
     GitHubIssue int            `json:"github_issue"`
+    CreatedBy   *uint          `json:"created_by"`
+    UpdatedBy   *uint          `json:"updated_by"`
+    Version     int64          `json:"version" gorm:"not null;default:1"`
     CreatedAt   time.Time      `json:"created_at"`
     UpdatedAt   time.Time      `json:"updated_at"`
     DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
@@ -31,5 +34,7 @@ type Task struct {
     Project  Project  `json:"project" gorm:"foreignKey:ProjectID"`
     Sprint   *Sprint  `json:"sprint" gorm:"foreignKey:SprintID"`
     Assignee *User    `json:"assignee" gorm:"foreignKey:AssigneeID"`
+    Creator  *User    `json:"creator,omitempty" gorm:"foreignKey:CreatedBy"`
+    Updater  *User    `json:"updater,omitempty" gorm:"foreignKey:UpdatedBy"`
     Comments []Comment `json:"comments" gorm:"foreignKey:TaskID"`
 }
\ No newline at end of file
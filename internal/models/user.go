@@ -6,16 +6,19 @@ import (
 )
 
 type User struct {
-    ID          uint           `json:"id" gorm:"primaryKey"`
-    GitHubID    int64          `json:"github_id" gorm:"uniqueIndex"`
-    Username    string         `json:"username" gorm:"uniqueIndex;not null"`
-    Email       string         `json:"email" gorm:"uniqueIndex;not null"`
-    Name        string         `json:"name"`
-    AvatarURL   string         `json:"avatar_url"`
-    AccessToken string         `json:"-"`
-    CreatedAt   time.Time      `json:"created_at"`
-    UpdatedAt   time.Time      `json:"updated_at"`
-    DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+    ID              uint           `json:"id" gorm:"primaryKey"`
+    GitHubID        *int64         `json:"github_id" gorm:"uniqueIndex"`
+    Username        string         `json:"username" gorm:"uniqueIndex;not null"`
+    Email           string         `json:"email" gorm:"uniqueIndex;not null"`
+    Name            string         `json:"name"`
+    AvatarURL       string         `json:"avatar_url"`
+    PasswordHash    string         `json:"-"`
+    EmailVerifiedAt *time.Time     `json:"email_verified_at"`
+    IsBot           bool           `json:"is_bot" gorm:"default:false"`
+    BotProjectID    *uint          `json:"bot_project_id,omitempty" gorm:"index"`
+    CreatedAt       time.Time      `json:"created_at"`
+    UpdatedAt       time.Time      `json:"updated_at"`
+    DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
 
     // Relationships
     Projects     []Project     `json:"projects" gorm:"many2many:user_projects;"`
@@ -24,6 +27,11 @@ type User struct {
     ChatMessages []ChatMessage `json:"chat_messages" gorm:"foreignKey:UserID"`
 }
 
+// HasPassword reports whether the user can sign in with a password.
+func (u *User) HasPassword() bool {
+    return u.PasswordHash != ""
+}
+
 type DevLoginRequest struct {
     Username string `json:"username" binding:"required"`
     Email    string `json:"email" binding:"required,email"`
@@ -0,0 +1,389 @@
+// Package search finds files, tasks, chat messages and documentation of a
+// project. Text search uses tsvector expression indexes and ranks matches
+// with ts_rank_cd; code and regex search match the raw text, which pg_trgm
+// indexes speed up when the extension is available.
+package search
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// Result types
+const (
+	TypeFile    = "file"
+	TypeTask    = "task"
+	TypeMessage = "message"
+	TypeDoc     = "doc"
+)
+
+// Types lists every result type in the order results of equal rank appear.
+var Types = []string{TypeFile, TypeTask, TypeMessage, TypeDoc}
+
+// Search modes
+const (
+	ModeText  = "text"
+	ModeCode  = "code"
+	ModeRegex = "regex"
+)
+
+var (
+	ErrInvalidPattern = errors.New("invalid regular expression")
+	ErrTimeout        = errors.New("search took too long; narrow the query")
+)
+
+// config is the text search configuration. It does not stem, so it works
+// the same for any language and for identifiers in code.
+const config = "simple"
+
+// indexedLength is how many characters of a long text go into its tsvector,
+// which Postgres limits to 1 MB. Code and regex search see the whole text.
+const indexedLength = 100000
+
+// timeout bounds a search, mostly for expensive regular expressions.
+const timeout = 5 * time.Second
+
+// Highlight markers for ts_headline, from the Unicode private use area
+const (
+	startSel = "\uE000"
+	stopSel  = "\uE001"
+)
+
+// source is how one result type is searched. vector must stay identical to
+// the expression of its index, or the planner will not use the index.
+type source struct {
+	typ     string
+	table   string
+	vector  string
+	body    string
+	columns []string
+	title   string
+	path    string
+	user    string
+}
+
+var sources = map[string]source{
+	TypeFile: {
+		typ:     TypeFile,
+		table:   "files",
+		vector:  weighted("name", "content"),
+		body:    "content",
+		columns: []string{"path", "content"},
+		title:   "name",
+		path:    "path",
+		user:    "uploaded_by",
+	},
+	TypeTask: {
+		typ:     TypeTask,
+		table:   "tasks",
+		vector:  weighted("title", "description"),
+		body:    "description",
+		columns: []string{"title", "description"},
+		title:   "title",
+		path:    "''",
+		user:    "created_by",
+	},
+	TypeMessage: {
+		typ:     TypeMessage,
+		table:   "chat_messages",
+		vector:  fmt.Sprintf("to_tsvector('%s', left(coalesce(content, ''), %d))", config, indexedLength),
+		body:    "content",
+		columns: []string{"content"},
+		title:   "''",
+		path:    "''",
+		user:    "user_id",
+	},
+	TypeDoc: {
+		typ:     TypeDoc,
+		table:   "documentations",
+		vector:  weighted("title", "content"),
+		body:    "content",
+		columns: []string{"title", "content"},
+		title:   "title",
+		path:    "path",
+		user:    "NULL::bigint",
+	},
+}
+
+// weighted ranks matches in title above matches in body
+func weighted(title, body string) string {
+	return fmt.Sprintf("setweight(to_tsvector('%[1]s', coalesce(%[2]s, '')), 'A') || setweight(to_tsvector('%[1]s', left(coalesce(%[3]s, ''), %[4]d)), 'B')",
+		config, title, body, indexedLength)
+}
+
+// Migrate creates the search indexes. Without permission to create the
+// pg_trgm extension, code and regex search still work but scan the rows of
+// the project.
+func Migrate(db *gorm.DB) error {
+	for _, typ := range Types {
+		src := sources[typ]
+		statement := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_search ON %s USING GIN ((%s))", src.table, src.table, src.vector)
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("Search: pg_trgm is not available, code search will not be indexed: %v", err)
+		return nil
+	}
+	for _, typ := range Types {
+		src := sources[typ]
+		for _, column := range src.columns {
+			statement := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_%s_trgm ON %s USING GIN (%s gin_trgm_ops)", src.table, column, src.table, column)
+			if err := db.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Query is a search within one project.
+type Query struct {
+	ProjectID uint
+	Text      string
+	Mode      string
+	Types     []string
+	// CaseSensitive applies to code and regex search
+	CaseSensitive bool
+	Limit         int
+	Offset        int
+}
+
+// Fragment is a piece of a snippet; Match marks the text that matched.
+type Fragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// Result is a file, task, chat message or document that matched.
+type Result struct {
+	Type      string     `json:"type"`
+	ID        uint       `json:"id"`
+	Title     string     `json:"title,omitempty"`
+	Path      string     `json:"path,omitempty"`
+	UserID    *uint      `json:"user_id,omitempty"`
+	Rank      float64    `json:"rank"`
+	Line      int        `json:"line,omitempty"`
+	Snippet   []Fragment `json:"snippet"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type hit struct {
+	Type      string
+	ID        uint
+	Rank      float64
+	UpdatedAt time.Time
+}
+
+type detail struct {
+	ID      uint
+	Title   string
+	Path    string
+	UserID  *uint
+	Snippet string
+}
+
+// Search returns one page of results, best first, and whether there are
+// more.
+func Search(db *gorm.DB, q Query) ([]Result, bool, error) {
+	var results []Result
+	var more bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())).Error; err != nil {
+			return err
+		}
+
+		hits, err := find(tx, q)
+		if err != nil {
+			return err
+		}
+		if len(hits) > q.Limit {
+			hits, more = hits[:q.Limit], true
+		}
+
+		results, err = describe(tx, q, hits)
+		return err
+	})
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "2201B":
+			return nil, false, fmt.Errorf("%w: %s", ErrInvalidPattern, pgErr.Message)
+		case "57014":
+			return nil, false, ErrTimeout
+		}
+	}
+	return results, more, err
+}
+
+// find ranks the matches of every type together and returns the page,
+// plus one more to tell whether another page follows.
+func find(tx *gorm.DB, q Query) ([]hit, error) {
+	var parts []string
+	var args []interface{}
+	for _, typ := range q.Types {
+		src := sources[typ]
+		var rank, match string
+		var matchArgs []interface{}
+
+		switch q.Mode {
+		case ModeText:
+			rank = fmt.Sprintf("ts_rank_cd(%s, websearch_to_tsquery('%s', ?))", src.vector, config)
+			match = fmt.Sprintf("%s @@ websearch_to_tsquery('%s', ?)", src.vector, config)
+			args = append(args, q.Text)
+			matchArgs = append(matchArgs, q.Text)
+		case ModeCode:
+			// Whole identifiers rank above matches inside longer words
+			word := `(^|[^[:alnum:]_])` + quoteRegex(q.Text) + `($|[^[:alnum:]_])`
+			like, regex := "ILIKE", "~*"
+			if q.CaseSensitive {
+				like, regex = "LIKE", "~"
+			}
+			pattern := "%" + escapeLike(q.Text) + "%"
+			var words, likes []string
+			for _, column := range src.columns {
+				words = append(words, fmt.Sprintf("%s %s ?", column, regex))
+				likes = append(likes, fmt.Sprintf("%s %s ?", column, like))
+				args = append(args, word)
+				matchArgs = append(matchArgs, pattern)
+			}
+			rank = fmt.Sprintf("CASE WHEN %s THEN 2 ELSE 1 END", strings.Join(words, " OR "))
+			match = strings.Join(likes, " OR ")
+		case ModeRegex:
+			regex := "~*"
+			if q.CaseSensitive {
+				regex = "~"
+			}
+			var matches []string
+			for _, column := range src.columns {
+				matches = append(matches, fmt.Sprintf("%s %s ?", column, regex))
+				matchArgs = append(matchArgs, q.Text)
+			}
+			rank = "1"
+			match = strings.Join(matches, " OR ")
+		}
+
+		parts = append(parts, fmt.Sprintf(
+			"SELECT '%s' AS type, id, %s AS rank, updated_at FROM %s WHERE project_id = ? AND deleted_at IS NULL AND (%s)",
+			src.typ, rank, src.table, match))
+		args = append(append(args, q.ProjectID), matchArgs...)
+	}
+
+	statement := "SELECT type, id, rank, updated_at FROM (" + strings.Join(parts, " UNION ALL ") + ") hits " +
+		"ORDER BY rank DESC, updated_at DESC, type, id LIMIT ? OFFSET ?"
+	args = append(args, q.Limit+1, q.Offset)
+
+	var hits []hit
+	err := tx.Raw(statement, args...).Scan(&hits).Error
+	return hits, err
+}
+
+// describe loads the title, path and snippet of every hit.
+func describe(tx *gorm.DB, q Query, hits []hit) ([]Result, error) {
+	ids := make(map[string][]uint)
+	for _, h := range hits {
+		ids[h.Type] = append(ids[h.Type], h.ID)
+	}
+
+	details := make(map[string]map[uint]detail)
+	for typ, typeIDs := range ids {
+		src := sources[typ]
+		snippet := src.body
+		var args []interface{}
+		if q.Mode == ModeText {
+			snippet = fmt.Sprintf("ts_headline('%s', left(coalesce(%s, ''), %d), websearch_to_tsquery('%s', ?), ?)", config, src.body, indexedLength, config)
+			args = append(args, q.Text, "StartSel=\""+startSel+"\", StopSel=\""+stopSel+"\", MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=\" … \"")
+		}
+		statement := fmt.Sprintf("SELECT id, %s AS title, %s AS path, %s AS user_id, %s AS snippet FROM %s WHERE id IN ?",
+			src.title, src.path, src.user, snippet, src.table)
+
+		var rows []detail
+		if err := tx.Raw(statement, append(args, typeIDs)...).Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		details[typ] = make(map[uint]detail, len(rows))
+		for _, row := range rows {
+			details[typ][row.ID] = row
+		}
+	}
+
+	matcher := newMatcher(q)
+	results := make([]Result, 0, len(hits))
+	for _, h := range hits {
+		d := details[h.Type][h.ID]
+		result := Result{
+			Type: h.Type, ID: h.ID, Title: d.Title, Path: d.Path, UserID: d.UserID,
+			Rank: h.Rank, UpdatedAt: h.UpdatedAt,
+		}
+		if q.Mode == ModeText {
+			result.Snippet = headline(d.Snippet)
+		} else {
+			result.Line, result.Snippet = matcher.snippet(d.Snippet)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// headline splits ts_headline output at its highlight markers.
+func headline(s string) []Fragment {
+	fragments := []Fragment{}
+	match := false
+	for len(s) > 0 {
+		marker := stopSel
+		if !match {
+			marker = startSel
+		}
+		i := strings.Index(s, marker)
+		if i < 0 {
+			i = len(s)
+		}
+		if i > 0 {
+			fragments = append(fragments, Fragment{Text: s[:i], Match: match})
+		}
+		s = strings.TrimPrefix(s[i:], marker)
+		match = !match
+	}
+	return merge(fragments)
+}
+
+// merge joins adjacent fragments that are both matches or both not, such
+// as two highlighted words with nothing between them.
+func merge(fragments []Fragment) []Fragment {
+	merged := fragments[:0]
+	for _, f := range fragments {
+		if n := len(merged); n > 0 && merged[n-1].Match == f.Match {
+			merged[n-1].Text += f.Text
+			continue
+		}
+		merged = append(merged, f)
+	}
+	return merged
+}
+
+// quoteRegex escapes s for a Postgres regular expression. A backslash
+// before an ASCII letter or digit starts an escape, so only punctuation is
+// quoted.
+func quoteRegex(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < 128 && !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
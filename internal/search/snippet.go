@@ -0,0 +1,99 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetWidth is about how many bytes of a matching line a code snippet
+// shows.
+const snippetWidth = 160
+
+// matcher finds matches in the text of a code or regex search result to
+// build its snippet. Postgres and Go regular expressions differ slightly;
+// a pattern Go cannot compile gets snippets without highlights.
+type matcher struct {
+	re *regexp.Regexp
+}
+
+func newMatcher(q Query) matcher {
+	pattern := q.Text
+	if q.Mode == ModeCode {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if !q.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return matcher{}
+	}
+	return matcher{re: re}
+}
+
+// snippet returns the 1-based number of the first line of text that
+// matches and that line with its matches highlighted. Text that matched
+// only in its title or path gets its first line and line 0.
+func (m matcher) snippet(text string) (int, []Fragment) {
+	var loc []int
+	if m.re != nil {
+		loc = m.re.FindStringIndex(text)
+	}
+	if loc == nil {
+		line, _, _ := strings.Cut(text, "\n")
+		return 0, []Fragment{{Text: truncate(strings.TrimSuffix(line, "\r"), snippetWidth)}}
+	}
+
+	start := strings.LastIndexByte(text[:loc[0]], '\n') + 1
+	end := len(text)
+	if i := strings.IndexByte(text[loc[0]:], '\n'); i >= 0 {
+		end = loc[0] + i
+	}
+	number := strings.Count(text[:start], "\n") + 1
+
+	// Keep the first match in view on long lines
+	from := start
+	if loc[0]-start > snippetWidth/2 {
+		from = loc[0] - snippetWidth/4
+	}
+	to := from + snippetWidth
+	if to > end {
+		to = end
+	}
+	from, to = runeStart(text, from), runeStart(text, to)
+
+	line := strings.TrimSuffix(text[from:to], "\r")
+	fragments := []Fragment{}
+	last := 0
+	for _, match := range m.re.FindAllStringIndex(line, -1) {
+		if match[0] == match[1] {
+			continue
+		}
+		if match[0] > last {
+			fragments = append(fragments, Fragment{Text: line[last:match[0]]})
+		}
+		fragments = append(fragments, Fragment{Text: line[match[0]:match[1]], Match: true})
+		last = match[1]
+	}
+	if last < len(line) {
+		fragments = append(fragments, Fragment{Text: line[last:]})
+	}
+	return number, fragments
+}
+
+// truncate cuts s to at most width bytes without splitting a character.
+func truncate(s string, width int) string {
+	if width >= len(s) {
+		return s
+	}
+	return s[:runeStart(s, width)]
+}
+
+// runeStart moves i back to the start of the character it falls in.
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,187 @@
+// Package secrets encrypts credentials such as provider OAuth tokens at rest
+// using envelope encryption: every value gets its own random data key, and
+// only that data key is encrypted with a versioned key-encryption key (KEK)
+// from the configuration.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const dataKeySize = 32
+
+var ErrUnknownKeyVersion = errors.New("secrets: unknown key version")
+
+// Envelope is an encrypted value. WrappedKey is the data key encrypted with
+// the KEK of KeyVersion; both fields are nonce-prefixed AES-GCM output.
+type Envelope struct {
+	Ciphertext []byte
+	WrappedKey []byte
+	KeyVersion uint
+}
+
+// Keyring holds the key-encryption keys by version. New values are always
+// sealed with the active version; older versions are kept for decryption
+// until every row has been rewrapped.
+type Keyring struct {
+	active uint
+	keys   map[uint][]byte
+}
+
+// ParseKeyring reads keys from spec, a comma separated list of
+// "<version>:<base64 32-byte key>". activeVersion selects the key used for
+// new values; zero picks the highest version. With an empty spec and
+// allowEphemeral set, a random key is generated, so values do not survive a
+// restart; that is only meant for development.
+func ParseKeyring(spec string, activeVersion uint, allowEphemeral bool) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[uint][]byte)}
+
+	if strings.TrimSpace(spec) == "" {
+		if !allowEphemeral {
+			return nil, errors.New("TOKEN_ENCRYPTION_KEYS must be set outside dev mode")
+		}
+		key := make([]byte, dataKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		kr.keys[1] = key
+		kr.active = 1
+		return kr, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		versionPart, keyPart, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("secrets: key entry %q is not <version>:<base64 key>", entry)
+		}
+		version, err := strconv.ParseUint(versionPart, 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("secrets: invalid key version %q", versionPart)
+		}
+		key, err := base64.StdEncoding.DecodeString(keyPart)
+		if err != nil {
+			return nil, fmt.Errorf("secrets: key version %d is not valid base64: %w", version, err)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("secrets: key version %d must be %d bytes, got %d", version, dataKeySize, len(key))
+		}
+		if _, dup := kr.keys[uint(version)]; dup {
+			return nil, fmt.Errorf("secrets: key version %d is listed twice", version)
+		}
+		kr.keys[uint(version)] = key
+		if activeVersion == 0 && uint(version) > kr.active {
+			kr.active = uint(version)
+		}
+	}
+
+	if activeVersion != 0 {
+		if _, ok := kr.keys[activeVersion]; !ok {
+			return nil, fmt.Errorf("secrets: active key version %d is not configured", activeVersion)
+		}
+		kr.active = activeVersion
+	}
+
+	return kr, nil
+}
+
+// ActiveVersion returns the KEK version used for new values.
+func (kr *Keyring) ActiveVersion() uint {
+	return kr.active
+}
+
+// Seal encrypts plaintext under a fresh data key. aad binds the ciphertext
+// to its context (for example the row it is stored in) so it cannot be
+// swapped into another record.
+func (kr *Keyring) Seal(plaintext, aad []byte) (*Envelope, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := seal(dataKey, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(kr.keys[kr.active], dataKey, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{Ciphertext: ciphertext, WrappedKey: wrapped, KeyVersion: kr.active}, nil
+}
+
+// Open decrypts an envelope sealed with the same aad.
+func (kr *Keyring) Open(env *Envelope, aad []byte) ([]byte, error) {
+	dataKey, err := kr.unwrap(env, aad)
+	if err != nil {
+		return nil, err
+	}
+	return open(dataKey, env.Ciphertext, aad)
+}
+
+// Rewrap re-encrypts the data key of env with the active KEK. The value
+// itself is not touched, which is all a KEK rotation needs.
+func (kr *Keyring) Rewrap(env *Envelope, aad []byte) (*Envelope, error) {
+	dataKey, err := kr.unwrap(env, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(kr.keys[kr.active], dataKey, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{Ciphertext: env.Ciphertext, WrappedKey: wrapped, KeyVersion: kr.active}, nil
+}
+
+func (kr *Keyring) unwrap(env *Envelope, aad []byte) ([]byte, error) {
+	kek, ok := kr.keys[env.KeyVersion]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownKeyVersion, env.KeyVersion)
+	}
+	return open(kek, env.WrappedKey, aad)
+}
+
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("secrets: ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,226 @@
+package secrets
+
+import (
+	"errors"
+	"strconv"
+
+	"devsync-be/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrNoToken = errors.New("secrets: no provider token stored")
+
+// TokenStore keeps provider OAuth tokens encrypted on their UserIdentity
+// row and TOTP secrets on their UserMFA row. All reads and writes of those
+// columns go through it.
+type TokenStore struct {
+	db   *gorm.DB
+	keys *Keyring
+}
+
+func NewTokenStore(db *gorm.DB, keys *Keyring) *TokenStore {
+	return &TokenStore{db: db, keys: keys}
+}
+
+// tokenAAD ties a ciphertext to the provider account it belongs to.
+func tokenAAD(identity *models.UserIdentity) []byte {
+	return []byte("user_identity:" + identity.Provider + ":" + identity.Subject)
+}
+
+// Seal encrypts token onto identity's token fields. An empty token clears
+// them. The caller saves the identity, so this can run inside its
+// transaction.
+func (s *TokenStore) Seal(identity *models.UserIdentity, token string) error {
+	if token == "" {
+		identity.TokenCiphertext, identity.TokenKey, identity.TokenKeyVersion = nil, nil, 0
+		return nil
+	}
+
+	env, err := s.keys.Seal([]byte(token), tokenAAD(identity))
+	if err != nil {
+		return err
+	}
+
+	identity.TokenCiphertext, identity.TokenKey, identity.TokenKeyVersion = env.Ciphertext, env.WrappedKey, env.KeyVersion
+	return nil
+}
+
+// ProviderToken returns the decrypted OAuth token userID obtained from
+// provider, or ErrNoToken if none is stored.
+func (s *TokenStore) ProviderToken(userID uint, provider string) (string, error) {
+	var identity models.UserIdentity
+	err := s.db.Where("user_id = ? AND provider = ?", userID, provider).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrNoToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(identity.TokenCiphertext) == 0 {
+		return "", ErrNoToken
+	}
+
+	plaintext, err := s.keys.Open(envelopeOf(&identity), tokenAAD(&identity))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// GitHubToken returns the user's GitHub OAuth token.
+func (s *TokenStore) GitHubToken(userID uint) (string, error) {
+	return s.ProviderToken(userID, "github")
+}
+
+// SealTOTPSecret encrypts secret onto mfa. The caller saves the row.
+func (s *TokenStore) SealTOTPSecret(mfa *models.UserMFA, secret string) error {
+	env, err := s.keys.Seal([]byte(secret), mfaAAD(mfa))
+	if err != nil {
+		return err
+	}
+
+	mfa.SecretCiphertext, mfa.SecretKey, mfa.SecretKeyVersion = env.Ciphertext, env.WrappedKey, env.KeyVersion
+	return nil
+}
+
+// TOTPSecret returns the decrypted TOTP secret of mfa.
+func (s *TokenStore) TOTPSecret(mfa *models.UserMFA) (string, error) {
+	plaintext, err := s.keys.Open(&Envelope{
+		Ciphertext: mfa.SecretCiphertext,
+		WrappedKey: mfa.SecretKey,
+		KeyVersion: mfa.SecretKeyVersion,
+	}, mfaAAD(mfa))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func mfaAAD(mfa *models.UserMFA) []byte {
+	return []byte("user_mfa:" + strconv.FormatUint(uint64(mfa.UserID), 10))
+}
+
+// Rotate rewraps every provider token and TOTP secret whose data key is
+// still encrypted with an old KEK version and returns how many were
+// updated. Once it reports zero the old key can be removed from
+// TOKEN_ENCRYPTION_KEYS.
+func (s *TokenStore) Rotate() (int, error) {
+	tokens, err := s.rotateIdentityTokens()
+	if err != nil {
+		return tokens, err
+	}
+	totp, err := s.rotateTOTPSecrets()
+	return tokens + totp, err
+}
+
+func (s *TokenStore) rotateIdentityTokens() (int, error) {
+	active := s.keys.ActiveVersion()
+	rotated := 0
+
+	for {
+		var identities []models.UserIdentity
+		if err := s.db.Where("token_key_version <> 0 AND token_key_version <> ?", active).
+			Limit(100).Find(&identities).Error; err != nil {
+			return rotated, err
+		}
+		if len(identities) == 0 {
+			return rotated, nil
+		}
+
+		for i := range identities {
+			identity := &identities[i]
+			env, err := s.keys.Rewrap(envelopeOf(identity), tokenAAD(identity))
+			if err != nil {
+				return rotated, err
+			}
+			if err := s.db.Model(identity).Updates(map[string]interface{}{
+				"token_key":         env.WrappedKey,
+				"token_key_version": env.KeyVersion,
+			}).Error; err != nil {
+				return rotated, err
+			}
+			rotated++
+		}
+	}
+}
+
+func (s *TokenStore) rotateTOTPSecrets() (int, error) {
+	active := s.keys.ActiveVersion()
+	rotated := 0
+
+	for {
+		var enrollments []models.UserMFA
+		if err := s.db.Where("secret_key_version <> 0 AND secret_key_version <> ?", active).
+			Limit(100).Find(&enrollments).Error; err != nil {
+			return rotated, err
+		}
+		if len(enrollments) == 0 {
+			return rotated, nil
+		}
+
+		for i := range enrollments {
+			mfa := &enrollments[i]
+			env, err := s.keys.Rewrap(&Envelope{
+				Ciphertext: mfa.SecretCiphertext,
+				WrappedKey: mfa.SecretKey,
+				KeyVersion: mfa.SecretKeyVersion,
+			}, mfaAAD(mfa))
+			if err != nil {
+				return rotated, err
+			}
+			if err := s.db.Model(mfa).Updates(map[string]interface{}{
+				"secret_key":         env.WrappedKey,
+				"secret_key_version": env.KeyVersion,
+			}).Error; err != nil {
+				return rotated, err
+			}
+			rotated++
+		}
+	}
+}
+
+// MigratePlaintextTokens moves GitHub tokens that older versions stored in
+// plaintext on users.access_token onto the user's encrypted GitHub
+// identity, then drops the column.
+func (s *TokenStore) MigratePlaintextTokens() (int, error) {
+	if !s.db.Migrator().HasColumn("users", "access_token") {
+		return 0, nil
+	}
+
+	var rows []struct {
+		ID          uint
+		AccessToken string
+	}
+	if err := s.db.Table("users").Select("id, access_token").
+		Where("access_token IS NOT NULL AND access_token <> ''").
+		Scan(&rows).Error; err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, row := range rows {
+		var identity models.UserIdentity
+		if err := s.db.Where("user_id = ? AND provider = ?", row.ID, "github").First(&identity).Error; err != nil {
+			// Without a GitHub identity the token cannot be used anyway
+			continue
+		}
+		if err := s.Seal(&identity, row.AccessToken); err != nil {
+			return migrated, err
+		}
+		if err := s.db.Model(&identity).Select("token_ciphertext", "token_key", "token_key_version").Updates(&identity).Error; err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, s.db.Migrator().DropColumn("users", "access_token")
+}
+
+func envelopeOf(identity *models.UserIdentity) *Envelope {
+	return &Envelope{
+		Ciphertext: identity.TokenCiphertext,
+		WrappedKey: identity.TokenKey,
+		KeyVersion: identity.TokenKeyVersion,
+	}
+}
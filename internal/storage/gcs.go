@@ -2,9 +2,12 @@ package storage
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
@@ -46,19 +49,29 @@ func (g *GCSStorage) UploadFile(ctx context.Context, file *multipart.FileHeader,
 	}
 	defer src.Close()
 
-	// Generate unique filename
-	filename := fmt.Sprintf("%s/%d_%s", folder, time.Now().Unix(), file.Filename)
+	return g.Upload(ctx, src, folder, file.Filename, file.Header.Get("Content-Type"))
+}
+
+// Upload stores the content of r as name in folder and returns its public URL
+func (g *GCSStorage) Upload(ctx context.Context, r io.Reader, folder, name, contentType string) (string, error) {
+	// Generate unique filename; the random part keeps files with the same
+	// name uploaded in the same second apart
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	filename := fmt.Sprintf("%s/%d_%s_%s", folder, time.Now().Unix(), hex.EncodeToString(random), name)
 	
 	obj := g.client.Bucket(g.bucketName).Object(filename)
 	writer := obj.NewWriter(ctx)
 	
 	// Set content type
-	writer.ContentType = file.Header.Get("Content-Type")
+	writer.ContentType = contentType
 	if writer.ContentType == "" {
 		writer.ContentType = "application/octet-stream"
 	}
 
-	if _, err := io.Copy(writer, src); err != nil {
+	if _, err := io.Copy(writer, r); err != nil {
 		return "", err
 	}
 
@@ -70,6 +83,45 @@ func (g *GCSStorage) UploadFile(ctx context.Context, file *multipart.FileHeader,
 	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", g.bucketName, filename), nil
 }
 
+// Open reads an object by the public URL Upload returned. It also returns
+// the object size.
+func (g *GCSStorage) Open(ctx context.Context, fileURL string) (io.ReadCloser, int64, error) {
+	name, err := g.ObjectName(fileURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reader, err := g.client.Bucket(g.bucketName).Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reader, reader.Attrs.Size, nil
+}
+
+// Delete removes an object by the public URL Upload returned.
+func (g *GCSStorage) Delete(ctx context.Context, fileURL string) error {
+	name, err := g.ObjectName(fileURL)
+	if err != nil {
+		return err
+	}
+	return g.DeleteFile(ctx, name)
+}
+
+// ObjectName returns the name of the object at a public URL Upload
+// returned.
+func (g *GCSStorage) ObjectName(fileURL string) (string, error) {
+	prefix := fmt.Sprintf("https://storage.googleapis.com/%s/", g.bucketName)
+	if !strings.HasPrefix(fileURL, prefix) {
+		return "", fmt.Errorf("%s is not in bucket %s", fileURL, g.bucketName)
+	}
+	return strings.TrimPrefix(fileURL, prefix), nil
+}
+
+// ProjectFolder is the folder every object uploaded to a project is in.
+func ProjectFolder(projectID uint) string {
+	return fmt.Sprintf("projects/%d/", projectID)
+}
+
 func (g *GCSStorage) DeleteFile(ctx context.Context, filename string) error {
 	obj := g.client.Bucket(g.bucketName).Object(filename)
 	return obj.Delete(ctx)
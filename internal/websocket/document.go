@@ -0,0 +1,539 @@
+package websocket
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "math"
+    "net/http"
+    "time"
+
+    "devsync-be/internal/collab"
+    "devsync-be/internal/models"
+
+    "gorm.io/gorm"
+)
+
+const (
+    // Edits of a file are delivered in revision order; one that arrives
+    // early waits this long for the missing ones before they are loaded
+    // from the edit log
+    docGapTimeout = time.Second
+    // Delivered edits kept per session, for members that opened the file
+    // at an older revision and for transforming late selections
+    docRecentEdits = 256
+)
+
+// DocOpenedData answers doc_open with the content at Revision and the
+// cursors of everyone else in the file.
+type DocOpenedData struct {
+    FileID     uint                `json:"file_id"`
+    Revision   int64               `json:"revision"`
+    Content    string              `json:"content"`
+    ReadOnly   bool                `json:"read_only"`
+    Selections []DocSelectionEvent `json:"selections"`
+}
+
+// DocOpEvent is an edit by another connection, to apply on top of
+// Revision-1.
+type DocOpEvent struct {
+    FileID       uint              `json:"file_id"`
+    Revision     int64             `json:"revision"`
+    Operation    *collab.Operation `json:"operation"`
+    ConnectionID string            `json:"connection_id,omitempty"`
+}
+
+// DocAckData confirms the client's own edit, which became Revision.
+type DocAckData struct {
+    FileID   uint  `json:"file_id"`
+    Revision int64 `json:"revision"`
+}
+
+// DocSelectionEvent is another connection's selections at Revision. It is
+// the payload of doc_selection and doc_left (with no ranges).
+type DocSelectionEvent struct {
+    FileID       uint             `json:"file_id"`
+    Revision     int64            `json:"revision"`
+    ConnectionID string           `json:"connection_id"`
+    UserID       uint             `json:"user_id"`
+    Ranges       []SelectionRange `json:"ranges"`
+}
+
+// docSession is the local side of the editing session of one file: the
+// connections on this instance that have it open. Edits are delivered in
+// revision order, wherever they were applied.
+type docSession struct {
+    projectID uint
+    // members maps each connection to the revision it has seen up to
+    members    map[*Client]int64
+    delivered  int64
+    recent     []docEdit
+    pending    map[int64]docEdit
+    gapSince   time.Time
+    loading    bool
+    selections map[string]*DocSelectionEvent
+}
+
+type docOpen struct {
+    client    *Client
+    projectID uint
+    fileID    uint
+    id        string
+    content   string
+    revision  int64
+    readOnly  bool
+}
+
+type docClose struct {
+    client *Client
+    fileID uint
+}
+
+// docMemberQuery asks Run whether client has fileID of projectID open.
+type docMemberQuery struct {
+    client    *Client
+    projectID uint
+    fileID    uint
+    reply     chan bool
+}
+
+// docEdit is an applied edit. author is set on the instance of the
+// connection that made it, which gets doc_ack instead of frame.
+type docEdit struct {
+    projectID uint
+    edit      *collab.Edit
+    frame     []byte
+    author    *Client
+}
+
+// docSelection is a selection change; client is nil for ones from other
+// instances.
+type docSelection struct {
+    client    *Client
+    projectID uint
+    event     DocSelectionEvent
+    left      bool
+}
+
+func (h *Hub) openDoc(o docOpen) {
+    if h.clients[o.client][o.projectID] == nil {
+        h.deliver(o.client, errorReply(o.id, o.projectID, ErrNotSubscribed, "subscribe to the project first"))
+        return
+    }
+
+    s := h.sessions[o.fileID]
+    if s == nil {
+        s = &docSession{
+            projectID:  o.projectID,
+            members:    make(map[*Client]int64),
+            delivered:  o.revision,
+            pending:    make(map[int64]docEdit),
+            selections: make(map[string]*DocSelectionEvent),
+            loading:    true,
+        }
+        h.sessions[o.fileID] = s
+
+        // Edits applied while the content was loading reached Run before
+        // the session existed
+        go h.loadEdits(o.fileID, o.projectID, o.revision, math.MaxInt64)
+    }
+
+    // Edits delivered since the content was loaded are sent right after it
+    var missed []docEdit
+    if o.revision < s.delivered {
+        if len(s.recent) == 0 || s.recent[0].edit.Revision > o.revision+1 {
+            h.deliver(o.client, errorReply(o.id, o.projectID, ErrRevisionUnavailable, "the file changed while opening, open it again"))
+            h.dropSession(o.fileID, s)
+            return
+        }
+        for _, e := range s.recent {
+            if e.edit.Revision > o.revision {
+                missed = append(missed, e)
+            }
+        }
+    }
+    s.members[o.client] = max(o.revision, s.delivered)
+
+    selections := []DocSelectionEvent{}
+    for connectionID, selection := range s.selections {
+        if connectionID != o.client.id {
+            selections = append(selections, *selection)
+        }
+    }
+    h.deliver(o.client, reply(TypeDocOpened, o.id, o.projectID, o.client.userID, DocOpenedData{
+        FileID:     o.fileID,
+        Revision:   o.revision,
+        Content:    o.content,
+        ReadOnly:   o.readOnly,
+        Selections: selections,
+    }))
+    for _, e := range missed {
+        h.deliver(o.client, e.frame)
+    }
+}
+
+// closeDoc removes client from the session of fileID and hides its cursor
+// from everyone else.
+func (h *Hub) closeDoc(client *Client, fileID uint) {
+    s := h.sessions[fileID]
+    if s == nil {
+        return
+    }
+    if _, ok := s.members[client]; !ok {
+        return
+    }
+    delete(s.members, client)
+
+    h.changeSelection(docSelection{
+        client:    client,
+        projectID: s.projectID,
+        event:     DocSelectionEvent{FileID: fileID, Revision: s.delivered, ConnectionID: client.id, UserID: client.userID},
+        left:      true,
+    })
+    h.dropSession(fileID, s)
+}
+
+// closeDocs closes every file client has open in projectID.
+func (h *Hub) closeDocs(client *Client, projectID uint) {
+    for fileID, s := range h.sessions {
+        if s.projectID == projectID {
+            h.closeDoc(client, fileID)
+        }
+    }
+}
+
+// deleteDoc ends the session of a deleted file. Its members get
+// doc_closed and must not send edits for it anymore.
+func (h *Hub) deleteDoc(fileID uint) {
+    s := h.sessions[fileID]
+    if s == nil {
+        return
+    }
+    frame := reply(TypeDocClosed, "", s.projectID, 0, DocData{FileID: fileID})
+    for client := range s.members {
+        h.deliver(client, frame)
+    }
+    delete(h.sessions, fileID)
+}
+
+func (h *Hub) dropSession(fileID uint, s *docSession) {
+    if len(s.members) == 0 && h.sessions[fileID] == s {
+        delete(h.sessions, fileID)
+    }
+}
+
+// receiveEdit delivers e once every edit before it has been delivered.
+func (h *Hub) receiveEdit(e docEdit) {
+    if e.author != nil {
+        h.touch(e.author, e.projectID)
+    }
+
+    s := h.sessions[e.edit.FileID]
+    if s == nil {
+        return
+    }
+    revision := e.edit.Revision
+    if _, ok := s.pending[revision]; ok || revision <= s.delivered {
+        return
+    }
+    s.pending[revision] = e
+
+    for {
+        next, ok := s.pending[s.delivered+1]
+        if !ok {
+            break
+        }
+        delete(s.pending, next.edit.Revision)
+        h.deliverEdit(next.edit.FileID, s, next)
+    }
+
+    if len(s.pending) == 0 {
+        s.gapSince = time.Time{}
+    } else if s.gapSince.IsZero() {
+        s.gapSince = time.Now()
+    }
+}
+
+func (h *Hub) deliverEdit(fileID uint, s *docSession, e docEdit) {
+    revision := e.edit.Revision
+    s.delivered = revision
+
+    for _, selection := range s.selections {
+        transformRanges(selection.Ranges, e.edit.Operation)
+        selection.Revision = revision
+    }
+    s.recent = append(s.recent, e)
+    if len(s.recent) > docRecentEdits {
+        s.recent = append([]docEdit(nil), s.recent[len(s.recent)-docRecentEdits:]...)
+    }
+
+    for client, seen := range s.members {
+        if revision <= seen {
+            continue
+        }
+        s.members[client] = revision
+        if client == e.author || client.id == e.edit.ConnectionID {
+            h.deliver(client, reply(TypeDocAck, "", s.projectID, client.userID, DocAckData{FileID: fileID, Revision: revision}))
+        } else {
+            h.deliver(client, e.frame)
+        }
+    }
+}
+
+// checkGaps loads edits that have been missing for docGapTimeout, for
+// example because a fan-out message was lost.
+func (h *Hub) checkGaps() {
+    for fileID, s := range h.sessions {
+        if s.loading || s.gapSince.IsZero() || time.Since(s.gapSince) < docGapTimeout {
+            continue
+        }
+        upTo := s.delivered
+        for revision := range s.pending {
+            upTo = max(upTo, revision)
+        }
+        s.loading = true
+        go h.loadEdits(fileID, s.projectID, s.delivered, upTo)
+    }
+}
+
+func (h *Hub) loadEdits(fileID, projectID uint, after, upTo int64) {
+    edits, err := h.docs.Since(fileID, after, upTo)
+    if err != nil {
+        log.Printf("WebSocket: failed to load edits of file %d: %v", fileID, err)
+    }
+    for _, edit := range edits {
+        h.docEdits <- docEdit{projectID: projectID, edit: edit, frame: editFrame(projectID, edit)}
+    }
+    h.docLoaded <- fileID
+}
+
+// changeSelection brings a selection to the session's revision and passes
+// it to the other members, and to other instances if it is local.
+func (h *Hub) changeSelection(c docSelection) {
+    fileID := c.event.FileID
+    s := h.sessions[fileID]
+    if s == nil {
+        return
+    }
+    if c.client != nil && !c.left {
+        if _, ok := s.members[c.client]; !ok {
+            h.deliver(c.client, errorReply("", c.projectID, ErrDocumentNotOpen, "open the file first"))
+            return
+        }
+    }
+
+    event := c.event
+    event.Ranges = append([]SelectionRange{}, event.Ranges...)
+    if event.Revision < s.delivered {
+        if len(s.recent) == 0 || s.recent[0].edit.Revision > event.Revision+1 {
+            // Too old to place; the client will send a newer one
+            return
+        }
+        for _, e := range s.recent {
+            if e.edit.Revision > event.Revision {
+                transformRanges(event.Ranges, e.edit.Operation)
+            }
+        }
+        event.Revision = s.delivered
+    }
+
+    msgType := TypeDocSelection
+    if c.left {
+        msgType = TypeDocLeft
+        delete(s.selections, event.ConnectionID)
+    } else {
+        s.selections[event.ConnectionID] = &event
+    }
+
+    frame := reply(msgType, "", s.projectID, event.UserID, event)
+    for client := range s.members {
+        if client != c.client {
+            h.deliver(client, frame)
+        }
+    }
+    if c.client != nil {
+        h.publishAsync(&Envelope{Kind: kindDoc, ProjectID: s.projectID, FileID: fileID, Data: frame})
+    }
+}
+
+// receiveDoc handles an editing session message from another instance.
+func (h *Hub) receiveDoc(env *Envelope) {
+    if env.Seq != 0 {
+        var edit *collab.Edit
+        if env.Ref {
+            edits, err := h.docs.Since(env.FileID, env.Seq-1, env.Seq)
+            if err != nil || len(edits) != 1 {
+                log.Printf("WebSocket: edit %d of file %d not in edit log: %v", env.Seq, env.FileID, err)
+                return
+            }
+            edit = edits[0]
+        } else {
+            var msg struct {
+                UserID uint       `json:"user_id"`
+                Data   DocOpEvent `json:"data"`
+            }
+            if err := json.Unmarshal(env.Data, &msg); err != nil || msg.Data.Operation == nil {
+                log.Println("WebSocket: ignoring malformed edit:", err)
+                return
+            }
+            edit = &collab.Edit{
+                FileID:       env.FileID,
+                Revision:     env.Seq,
+                UserID:       msg.UserID,
+                ConnectionID: msg.Data.ConnectionID,
+                Operation:    msg.Data.Operation,
+            }
+        }
+        h.docEdits <- docEdit{projectID: env.ProjectID, edit: edit, frame: editFrame(env.ProjectID, edit)}
+        return
+    }
+
+    var msg struct {
+        Type string            `json:"type"`
+        Data DocSelectionEvent `json:"data"`
+    }
+    if err := json.Unmarshal(env.Data, &msg); err != nil {
+        log.Println("WebSocket: ignoring malformed selection:", err)
+        return
+    }
+    if msg.Type == TypeDocClosed {
+        h.docs.Drop(env.FileID)
+        h.docDeleted <- env.FileID
+        return
+    }
+    h.docSelections <- docSelection{projectID: env.ProjectID, event: msg.Data, left: msg.Type == TypeDocLeft}
+}
+
+// isDocMember reports whether client has opened fileID in projectID and not
+// closed it since. It runs on the client's readPump.
+func (h *Hub) isDocMember(c *Client, projectID, fileID uint) bool {
+    reply := make(chan bool, 1)
+    h.docMembers <- docMemberQuery{client: c, projectID: projectID, fileID: fileID, reply: reply}
+    return <-reply
+}
+
+// submitEdit applies a client's edit. It runs on the client's readPump.
+func (h *Hub) submitEdit(c *Client, projectID uint, data DocOpData) error {
+    var frame []byte
+    edit, err := h.docs.Submit(projectID, data.FileID, c.userID, c.id, data.Revision, data.Operation, func(tx *gorm.DB, edit *collab.Edit) error {
+        frame = editFrame(projectID, edit)
+        return h.publishEdit(tx, projectID, edit, frame)
+    })
+    if err != nil {
+        return err
+    }
+    h.docEdits <- docEdit{projectID: projectID, edit: edit, frame: frame, author: c}
+    return nil
+}
+
+// UpdateFile sets the content of a file through the editing engine, so
+// editors that have it open receive the change as an edit. The file must
+// still be at revision unless it is negative, and update runs in the same
+// transaction for the rest of the row. A changed content is saved as a file
+// revision with message. It returns the file's new revision.
+func (h *Hub) UpdateFile(projectID, fileID, userID uint, content string, revision int64, message string, update func(tx *gorm.DB) error) (int64, error) {
+    var frame []byte
+    edit, err := h.docs.Replace(projectID, fileID, userID, content, revision, message, update, func(tx *gorm.DB, edit *collab.Edit) error {
+        frame = editFrame(projectID, edit)
+        return h.publishEdit(tx, projectID, edit, frame)
+    })
+    if err != nil {
+        return 0, err
+    }
+    if edit == nil {
+        return revision, nil
+    }
+    h.docEdits <- docEdit{projectID: projectID, edit: edit, frame: frame}
+    return edit.Revision, nil
+}
+
+// FileDeleted closes the editing sessions of a deleted file, on this and
+// every other instance. Call it after the transaction that deleted the file
+// with collab.Forget committed.
+func (h *Hub) FileDeleted(projectID, fileID uint) {
+    h.docs.Drop(fileID)
+    h.docDeleted <- fileID
+    frame := reply(TypeDocClosed, "", projectID, 0, DocData{FileID: fileID})
+    h.publishAsync(&Envelope{Kind: kindDoc, ProjectID: projectID, FileID: fileID, Data: frame})
+}
+
+// FileContent returns the current content and edit revision of a file,
+// including edits not yet saved to File.Content.
+func (h *Hub) FileContent(projectID, fileID uint) (string, int64, error) {
+    return h.docs.Open(projectID, fileID)
+}
+
+// OnFileSaved sets a function called after the content of a file was
+// saved, by a REST update or by collaborative edits. Set it before Run.
+func (h *Hub) OnFileSaved(fn func(fileID, userID uint, message string)) {
+    h.docs.OnSave(fn)
+}
+
+// publishEdit fans an edit out in the transaction that applies it, so
+// other instances receive edits of a file in revision order.
+func (h *Hub) publishEdit(tx *gorm.DB, projectID uint, edit *collab.Edit, frame []byte) error {
+    env := &Envelope{Kind: kindDoc, ProjectID: projectID, FileID: edit.FileID, Seq: edit.Revision, Data: frame}
+    h.stamp(env)
+    return h.backend.Publish(tx, env)
+}
+
+// docAccess applies the ProjectAccess rules to a doc_open or doc_op and
+// returns the sender's role. It replies with an error frame and returns
+// false when access is denied.
+func (c *Client) docAccess(msg *InboundMessage) (models.ProjectRole, bool) {
+    if status, message := c.hub.checkAccess(c.userID, msg.ProjectID); status != http.StatusOK {
+        code := ErrForbidden
+        if status == http.StatusNotFound {
+            code = ErrProjectNotFound
+        }
+        c.hub.direct <- directMessage{client: c, data: errorReply(msg.ID, msg.ProjectID, code, message)}
+        return "", false
+    }
+    role, ok := c.hub.projectRole(c.userID, msg.ProjectID)
+    if !ok {
+        c.hub.direct <- directMessage{client: c, data: errorReply(msg.ID, msg.ProjectID, ErrForbidden, "Access denied: You are not a member of this project")}
+    }
+    return role, ok
+}
+
+// projectRole returns userID's role in projectID, or false if they are
+// not a member.
+func (h *Hub) projectRole(userID, projectID uint) (models.ProjectRole, bool) {
+    var member models.UserProject
+    if err := h.db.Where("user_id = ? AND project_id = ?", userID, projectID).First(&member).Error; err != nil {
+        return "", false
+    }
+    return member.Role, true
+}
+
+func editFrame(projectID uint, edit *collab.Edit) []byte {
+    return reply(TypeDocOp, "", projectID, edit.UserID, DocOpEvent{
+        FileID:       edit.FileID,
+        Revision:     edit.Revision,
+        Operation:    edit.Operation,
+        ConnectionID: edit.ConnectionID,
+    })
+}
+
+func transformRanges(ranges []SelectionRange, op *collab.Operation) {
+    for i := range ranges {
+        ranges[i].Anchor = op.TransformIndex(ranges[i].Anchor)
+        ranges[i].Head = op.TransformIndex(ranges[i].Head)
+    }
+}
+
+// docErrorCode maps an editing error to an error frame code.
+func docErrorCode(err error) (string, string) {
+    switch {
+    case errors.Is(err, collab.ErrFileNotFound):
+        return ErrFileNotFound, "File not found"
+    case errors.Is(err, collab.ErrRevisionUnavailable):
+        return ErrRevisionUnavailable, "revision is not available, open the file again"
+    case errors.Is(err, collab.ErrDocumentTooLarge):
+        return ErrDocumentTooLarge, "the edit would make the file too large"
+    case errors.Is(err, collab.ErrInvalidOperation):
+        return ErrInvalidOperation, err.Error()
+    }
+    log.Println("WebSocket: edit failed:", err)
+    return ErrInternal, "the edit could not be applied"
+}
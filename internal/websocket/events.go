@@ -0,0 +1,101 @@
+package websocket
+
+import (
+    "encoding/json"
+    "fmt"
+
+    "devsync-be/internal/models"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// replayEvent is a persisted event ready to be sent again.
+type replayEvent struct {
+    Seq     int64
+    Payload []byte
+}
+
+// appendEvent assigns the next sequence number of projectID to the event,
+// stores it in the replay log and returns the frame with "seq" set. Events
+// older than the replay limit are pruned, and publish is called, in the
+// same transaction.
+func (h *Hub) appendEvent(projectID uint, fields map[string]json.RawMessage, publish func(tx *gorm.DB, seq int64, frame []byte) error) (int64, []byte, error) {
+    var seq int64
+    var frame []byte
+
+    err := h.db.Transaction(func(tx *gorm.DB) error {
+        counter := models.ProjectEventCounter{ProjectID: projectID, LastSeq: 1}
+        if err := tx.Clauses(
+            clause.OnConflict{
+                Columns:   []clause.Column{{Name: "project_id"}},
+                DoUpdates: clause.Assignments(map[string]interface{}{"last_seq": gorm.Expr("project_event_counters.last_seq + 1")}),
+            },
+            clause.Returning{Columns: []clause.Column{{Name: "last_seq"}}},
+        ).Create(&counter).Error; err != nil {
+            return err
+        }
+        seq = counter.LastSeq
+
+        fields["seq"] = json.RawMessage(fmt.Sprint(seq))
+        var err error
+        if frame, err = json.Marshal(fields); err != nil {
+            return err
+        }
+
+        var msgType string
+        json.Unmarshal(fields["type"], &msgType)
+
+        event := models.ProjectEvent{ProjectID: projectID, Seq: seq, Type: msgType, Payload: frame}
+        if err := tx.Create(&event).Error; err != nil {
+            return err
+        }
+
+        if err := tx.Where("project_id = ? AND seq <= ?", projectID, seq-h.config.WSReplayLimit).
+            Delete(&models.ProjectEvent{}).Error; err != nil {
+            return err
+        }
+
+        return publish(tx, seq, frame)
+    })
+    if err != nil {
+        return 0, nil, err
+    }
+    return seq, frame, nil
+}
+
+// currentSeq returns the last sequence number issued for projectID.
+func (h *Hub) currentSeq(projectID uint) (int64, error) {
+    var counter models.ProjectEventCounter
+    err := h.db.Where("project_id = ?", projectID).Limit(1).Find(&counter).Error
+    return counter.LastSeq, err
+}
+
+// eventPayload returns the stored frame of one event.
+func (h *Hub) eventPayload(projectID uint, seq int64) ([]byte, error) {
+    var event models.ProjectEvent
+    if err := h.db.Select("payload").Where("project_id = ? AND seq = ?", projectID, seq).First(&event).Error; err != nil {
+        return nil, err
+    }
+    return event.Payload, nil
+}
+
+// eventsSince returns the events of projectID after lastSeq up to current.
+// It reports false if any of them are no longer in the replay log, in
+// which case the client has to resync.
+func (h *Hub) eventsSince(projectID uint, lastSeq, current int64) ([]replayEvent, bool, error) {
+    if lastSeq > current || current-lastSeq > h.config.WSReplayLimit {
+        return nil, false, nil
+    }
+
+    var events []replayEvent
+    if err := h.db.Model(&models.ProjectEvent{}).
+        Select("seq, payload").
+        Where("project_id = ? AND seq > ? AND seq <= ?", projectID, lastSeq, current).
+        Order("seq ASC").
+        Scan(&events).Error; err != nil {
+        return nil, false, err
+    }
+
+    return events, int64(len(events)) == current-lastSeq, nil
+}
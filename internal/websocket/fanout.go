@@ -0,0 +1,134 @@
+package websocket
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sync"
+
+    "devsync-be/internal/config"
+
+    "gorm.io/gorm"
+)
+
+// Envelope kinds exchanged between instances.
+const (
+    kindEvent = "event" // sequenced project event from Broadcast
+    kindRelay = "relay" // ephemeral client event (typing, presence, cursor)
+    kindKick  = "kick"  // DisconnectMember
+    kindDoc   = "doc"   // editing session message; edits carry their revision in Seq
+)
+
+// Envelope carries a hub message to the other instances. ID is unique per
+// message so receivers can drop duplicates; Origin is the publishing
+// instance, which ignores its own messages since it already delivered them.
+type Envelope struct {
+    ID        string          `json:"id"`
+    Origin    string          `json:"origin"`
+    Kind      string          `json:"kind"`
+    ProjectID uint            `json:"project_id"`
+    FileID    uint            `json:"file_id,omitempty"`
+    UserID    uint            `json:"user_id,omitempty"`
+    Seq       int64           `json:"seq,omitempty"`
+    Data      json.RawMessage `json:"data,omitempty"`
+    // Ref means Data was too large to send and must be read from the
+    // replay log by ProjectID and Seq, or the edit log by FileID and Seq
+    Ref bool `json:"ref,omitempty"`
+}
+
+// Backend fans hub messages out to every instance of the server.
+type Backend interface {
+    // Publish sends env to the other instances. For project events tx is
+    // the transaction that stores the event; backends that can should
+    // publish as part of it, so other instances only see committed events,
+    // in commit order. tx is nil for ephemeral messages.
+    Publish(tx *gorm.DB, env *Envelope) error
+    // Listen starts passing messages published by any instance to handle
+    // and returns.
+    Listen(handle func(*Envelope)) error
+}
+
+// NewBackend returns the fan-out backend selected by WS_FANOUT.
+func NewBackend(cfg *config.Config, db *gorm.DB) (Backend, error) {
+    switch cfg.WSFanout {
+    case "memory":
+        return NewMemoryBackend(), nil
+    case "postgres":
+        return NewPostgresBackend(cfg.DatabaseURL, db), nil
+    }
+    return nil, fmt.Errorf("unknown WebSocket fan-out backend %q", cfg.WSFanout)
+}
+
+// MemoryBackend connects hubs within one process, which is all a single
+// instance needs.
+type MemoryBackend struct {
+    mu       sync.RWMutex
+    handlers []func(*Envelope)
+}
+
+func NewMemoryBackend() *MemoryBackend {
+    return &MemoryBackend{}
+}
+
+func (b *MemoryBackend) Publish(tx *gorm.DB, env *Envelope) error {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    for _, handle := range b.handlers {
+        handle(env)
+    }
+    return nil
+}
+
+func (b *MemoryBackend) Listen(handle func(*Envelope)) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.handlers = append(b.handlers, handle)
+    return nil
+}
+
+// recentIDs remembers the last IDs it was given, to drop messages that
+// arrive twice.
+type recentIDs struct {
+    mu    sync.Mutex
+    seen  map[string]bool
+    order []string
+    next  int
+}
+
+func newRecentIDs(size int) *recentIDs {
+    return &recentIDs{seen: make(map[string]bool, size), order: make([]string, size)}
+}
+
+// add reports whether id is new, remembering it if so.
+func (r *recentIDs) add(id string) bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.seen[id] {
+        return false
+    }
+    delete(r.seen, r.order[r.next])
+    r.order[r.next] = id
+    r.next = (r.next + 1) % len(r.order)
+    r.seen[id] = true
+    return true
+}
+
+func randomID() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// defaultInstanceID identifies this process when INSTANCE_ID is not set.
+func defaultInstanceID() string {
+    host, err := os.Hostname()
+    if err != nil {
+        host = "devsync"
+    }
+    return host + "-" + randomID()[:8]
+}
@@ -2,15 +2,22 @@ package websocket
 
 import (
     "encoding/json"
-    "fmt"
+    "errors"
     "log"
+    "net"
     "net/http"
+    "strconv"
+    "sync"
+    "time"
 
     "devsync-be/internal/auth"
+    "devsync-be/internal/collab"
     "devsync-be/internal/config"
+    "devsync-be/internal/models"
 
     "github.com/gin-gonic/gin"
     "github.com/gorilla/websocket"
+    "gorm.io/gorm"
 )
 
 var upgrader = websocket.Upgrader{
@@ -19,153 +26,891 @@ var upgrader = websocket.Upgrader{
     },
 }
 
+// Application close codes (4000-4999 are reserved for applications). Slow
+// consumers get the standard 1013 and oversized frames 1009.
+const (
+    CloseMembershipRevoked = 4403
+    ClosePongTimeout       = 4408
+)
+
+var closeReasons = map[int]string{
+    websocket.CloseNormalClosure: "",
+    websocket.CloseTryAgainLater: "slow consumer",
+    websocket.CloseMessageTooBig: "message too big",
+    CloseMembershipRevoked:       "membership revoked",
+    ClosePongTimeout:             "pong timeout",
+}
+
+// Hub keeps one room per project. A connection can be subscribed to several
+// rooms. Only Run touches rooms, clients and the send channels, so every
+// change goes through a channel.
+//
+// Broadcast events are numbered per project and kept in a bounded replay
+// log, so a client that reconnects with last_seq receives what it missed.
+// Everything that must reach clients on other instances is also published
+// to the fan-out backend.
+//
+// Each subscription also carries the connection's presence in the room,
+// which is announced to the room and stored for the REST snapshot.
+//
+// Connections can open files of their projects for collaborative editing.
+// Edits are applied by the collab store, which orders them across
+// instances, and delivered to the file's session in revision order.
 type Hub struct {
-    clients    map[*Client]bool
-    broadcast  chan []byte
-    register   chan *Client
-    unregister chan *Client
-    config     *config.Config
+    rooms       map[uint]map[*Client]bool
+    clients     map[*Client]map[uint]*roomSubscription
+    broadcast   chan roomMessage
+    replayed    chan replayResult
+    publishMu   sync.Mutex
+    register    chan subscription
+    unregister  chan closeRequest
+    subscribe   chan subscription
+    unsubscribe chan subscription
+    relay       chan relayMessage
+    presenceCh  chan presenceChange
+    direct      chan directMessage
+    kick        chan membership
+    config      *config.Config
+    keys        *auth.KeySet
+    db          *gorm.DB
+    backend     Backend
+    instanceID  string
+    outbox      chan *Envelope
+    seen        *recentIDs
+    presence    *presenceStore
+    stats       Stats
+
+    // Editing sessions by file ID
+    sessions      map[uint]*docSession
+    docOpened     chan docOpen
+    docClosed     chan docClose
+    docMembers    chan docMemberQuery
+    docEdits      chan docEdit
+    docSelections chan docSelection
+    docLoaded     chan uint
+    docDeleted    chan uint
+    docs          *collab.Store
 }
 
 type Client struct {
-    hub      *Hub
-    conn     *websocket.Conn
-    send     chan []byte
-    userID   uint
-    projectID uint
+    hub    *Hub
+    conn   *websocket.Conn
+    send   chan []byte
+    userID uint
+    // id tells a user's connections apart in presence events
+    id string
+    // typingSent is only touched by readPump
+    typingSent map[string]time.Time
+    // closing is closed by Run when the client is removed; closeCode is
+    // set just before
+    closing   chan struct{}
+    closeCode int
 }
 
+// Message is the envelope of every frame sent to clients. UserID and
+// ProjectID are always set by the server.
 type Message struct {
     Type      string      `json:"type"`
+    ID        string      `json:"id,omitempty"`
     ProjectID uint        `json:"project_id"`
     UserID    uint        `json:"user_id"`
     Data      interface{} `json:"data"`
 }
 
-func NewHub(cfg *config.Config) *Hub {
+type roomMessage struct {
+    projectID uint
+    seq       int64
+    data      []byte
+    // ephemeral messages are skipped for clients still replaying
+    ephemeral bool
+}
+
+// roomSubscription is a client's membership of one room. While the replay
+// for a new subscription is loading, live events are held in pending so
+// they are delivered after the replayed ones.
+type roomSubscription struct {
+    replaying bool
+    pending   []roomMessage
+    presence  presenceState
+}
+
+// replayResult is the loaded state of a new subscription.
+type replayResult struct {
+    subscription
+    state   *roomSubscription
+    current int64
+    events  []replayEvent
+    resync  bool
+}
+
+type closeRequest struct {
+    client *Client
+    code   int
+}
+
+type subscription struct {
+    client    *Client
+    projectID uint
+    id        string
+    lastSeq   *int64
+}
+
+// relayMessage is a validated client event for the other clients in a room.
+type relayMessage struct {
+    client    *Client
+    projectID uint
+    data      []byte
+}
+
+type directMessage struct {
+    client *Client
+    data   []byte
+}
+
+type membership struct {
+    projectID uint
+    userID    uint
+}
+
+func NewHub(cfg *config.Config, keys *auth.KeySet, db *gorm.DB, backend Backend) *Hub {
+    instanceID := cfg.InstanceID
+    if instanceID == "" {
+        instanceID = defaultInstanceID()
+    }
+
     return &Hub{
-        clients:    make(map[*Client]bool),
-        broadcast:  make(chan []byte),
-        register:   make(chan *Client),
-        unregister: make(chan *Client),
-        config:     cfg,
+        rooms:       make(map[uint]map[*Client]bool),
+        clients:     make(map[*Client]map[uint]*roomSubscription),
+        broadcast:   make(chan roomMessage),
+        replayed:    make(chan replayResult),
+        register:    make(chan subscription),
+        unregister:  make(chan closeRequest),
+        subscribe:   make(chan subscription),
+        unsubscribe: make(chan subscription),
+        relay:       make(chan relayMessage),
+        presenceCh:  make(chan presenceChange),
+        direct:      make(chan directMessage),
+        kick:        make(chan membership),
+        config:      cfg,
+        keys:        keys,
+        db:          db,
+        backend:     backend,
+        instanceID:  instanceID,
+        outbox:      make(chan *Envelope, 1024),
+        seen:        newRecentIDs(4096),
+        presence:    newPresenceStore(db, instanceID),
+
+        sessions:      make(map[uint]*docSession),
+        docOpened:     make(chan docOpen),
+        docClosed:     make(chan docClose),
+        docMembers:    make(chan docMemberQuery),
+        docEdits:      make(chan docEdit),
+        docSelections: make(chan docSelection),
+        docLoaded:     make(chan uint),
+        docDeleted:    make(chan uint),
+        docs:          collab.NewStore(db),
     }
 }
 
 func (h *Hub) Run() {
+    if err := h.backend.Listen(h.receive); err != nil {
+        log.Fatal("WebSocket: failed to start fan-out listener:", err)
+    }
+    go h.publishLoop()
+    go h.presence.run()
+    go h.docs.Run()
+    log.Printf("WebSocket hub running as instance %s", h.instanceID)
+
+    idle := time.NewTicker(idleCheckInterval)
+    defer idle.Stop()
+    gaps := time.NewTicker(docGapTimeout)
+    defer gaps.Stop()
+
     for {
         select {
-        case client := <-h.register:
-            h.clients[client] = true
-            log.Printf("Client connected: User %d, Project %d", client.userID, client.projectID)
+        case s := <-h.register:
+            h.clients[s.client] = make(map[uint]*roomSubscription)
+            if s.projectID != 0 {
+                h.join(s)
+            }
+            log.Printf("Client connected: User %d, Project %d", s.client.userID, s.projectID)
 
-        case client := <-h.unregister:
-            if _, ok := h.clients[client]; ok {
-                delete(h.clients, client)
-                close(client.send)
-                log.Printf("Client disconnected: User %d, Project %d", client.userID, client.projectID)
+        case r := <-h.unregister:
+            if h.remove(r.client, r.code) {
+                log.Printf("Client disconnected: User %d (%d %s)", r.client.userID, r.code, closeReasons[r.code])
             }
 
         case message := <-h.broadcast:
-            var msg Message
-            if err := json.Unmarshal(message, &msg); err != nil {
+            for client := range h.rooms[message.projectID] {
+                sub := h.clients[client][message.projectID]
+                switch {
+                case !sub.replaying:
+                    h.deliver(client, message.data)
+                case message.ephemeral:
+                    // Ephemeral events are not replayed, so clients still
+                    // replaying simply miss them
+                case len(sub.pending) < cap(client.send):
+                    sub.pending = append(sub.pending, message)
+                default:
+                    h.dropSlow(client)
+                }
+            }
+
+        case r := <-h.replayed:
+            h.finishReplay(r)
+
+        case s := <-h.subscribe:
+            if _, ok := h.clients[s.client]; ok {
+                h.join(s)
+            }
+
+        case s := <-h.unsubscribe:
+            if _, ok := h.clients[s.client]; ok {
+                h.leave(s.client, s.projectID)
+                h.deliver(s.client, reply(TypeUnsubscribed, s.id, s.projectID, s.client.userID, nil))
+            }
+
+        case r := <-h.relay:
+            if h.clients[r.client][r.projectID] == nil {
+                h.deliver(r.client, errorReply("", r.projectID, ErrNotSubscribed, "subscribe to the project first"))
                 continue
             }
+            for client := range h.rooms[r.projectID] {
+                if client != r.client && !h.clients[client][r.projectID].replaying {
+                    h.deliver(client, r.data)
+                }
+            }
+            h.publishAsync(&Envelope{Kind: kindRelay, ProjectID: r.projectID, Data: r.data})
+            h.touch(r.client, r.projectID)
+
+        case p := <-h.presenceCh:
+            if _, ok := h.clients[p.client]; ok {
+                h.changePresence(p)
+            }
+
+        case <-idle.C:
+            h.markIdle()
+
+        case o := <-h.docOpened:
+            if _, ok := h.clients[o.client]; ok {
+                h.openDoc(o)
+            }
+
+        case c := <-h.docClosed:
+            h.closeDoc(c.client, c.fileID)
+
+        case q := <-h.docMembers:
+            s := h.sessions[q.fileID]
+            open := false
+            if s != nil && s.projectID == q.projectID {
+                _, open = s.members[q.client]
+            }
+            q.reply <- open
+
+        case e := <-h.docEdits:
+            h.receiveEdit(e)
+
+        case s := <-h.docSelections:
+            if s.client == nil || h.clients[s.client] != nil {
+                h.changeSelection(s)
+            }
+
+        case fileID := <-h.docLoaded:
+            if s := h.sessions[fileID]; s != nil {
+                s.loading = false
+                s.gapSince = time.Time{}
+                if len(s.pending) > 0 {
+                    s.gapSince = time.Now()
+                }
+            }
 
-            for client := range h.clients {
-                if client.projectID == msg.ProjectID {
-                    select {
-                    case client.send <- message:
-                    default:
-                        close(client.send)
-                        delete(h.clients, client)
-                    }
+        case fileID := <-h.docDeleted:
+            h.deleteDoc(fileID)
+
+        case <-gaps.C:
+            h.checkGaps()
+
+        case d := <-h.direct:
+            if _, ok := h.clients[d.client]; ok {
+                h.deliver(d.client, d.data)
+            }
+
+        case m := <-h.kick:
+            for client := range h.rooms[m.projectID] {
+                if client.userID != m.userID {
+                    continue
                 }
+                h.leave(client, m.projectID)
+                log.Printf("Client removed: User %d, Project %d", client.userID, m.projectID)
+
+                // A connection with nothing left to receive is closed
+                if len(h.clients[client]) == 0 {
+                    h.stats.Revoked.Add(1)
+                    h.remove(client, CloseMembershipRevoked)
+                    continue
+                }
+                h.deliver(client, reply(TypeUnsubscribed, "", m.projectID, client.userID, gin.H{"reason": "membership_revoked"}))
             }
         }
     }
 }
 
-// Broadcast sends a message to all connected clients
-func (h *Hub) Broadcast(message []byte) {
-    h.broadcast <- message
+// join adds the client to the room right away, holding live events until
+// the replay loaded by loadReplay has been sent. Subscribing again, to
+// resume after a gap, keeps the connection's presence.
+func (h *Hub) join(s subscription) {
+    room := h.rooms[s.projectID]
+    if room == nil {
+        room = make(map[*Client]bool)
+        h.rooms[s.projectID] = room
+    }
+    room[s.client] = true
+
+    state := &roomSubscription{replaying: true}
+    if previous := h.clients[s.client][s.projectID]; previous != nil {
+        state.presence = previous.presence
+        h.clients[s.client][s.projectID] = state
+    } else {
+        now := time.Now()
+        state.presence = presenceState{status: StatusActive, connectedAt: now, lastActive: now}
+        h.clients[s.client][s.projectID] = state
+        h.announce(s.client, s.projectID, &state.presence, TypePresenceJoined)
+    }
+    go h.loadReplay(s, state)
 }
 
-func (h *Hub) HandleWebSocket(c *gin.Context) {
-    conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+// loadReplay reads the project's current sequence number and, if the
+// client sent last_seq, the events it missed. It runs outside Run so the
+// database is never queried from the hub loop.
+func (h *Hub) loadReplay(s subscription, state *roomSubscription) {
+    result := replayResult{subscription: s, state: state}
+
+    current, err := h.currentSeq(s.projectID)
     if err != nil {
-        log.Println("WebSocket upgrade error:", err)
+        log.Printf("WebSocket: failed to load sequence of project %d: %v", s.projectID, err)
+    }
+    result.current = current
+
+    if s.lastSeq != nil && *s.lastSeq != current {
+        events, complete, err := h.eventsSince(s.projectID, *s.lastSeq, current)
+        if err != nil {
+            log.Printf("WebSocket: failed to load replay of project %d: %v", s.projectID, err)
+        }
+        result.events, result.resync = events, !complete
+    }
+
+    h.replayed <- result
+}
+
+// finishReplay sends the subscription ack, the missed events or a resync
+// signal, and then the live events that arrived meanwhile.
+func (h *Hub) finishReplay(r replayResult) {
+    // The client may have left or resubscribed in the meantime
+    if h.clients[r.client][r.projectID] != r.state {
+        return
+    }
+
+    h.deliver(r.client, reply(TypeSubscribed, r.id, r.projectID, r.client.userID, gin.H{
+        "seq":           r.current,
+        "replayed":      len(r.events),
+        "connection_id": r.client.id,
+    }))
+    if r.resync {
+        h.deliver(r.client, reply(TypeResyncRequired, r.id, r.projectID, r.client.userID, gin.H{
+            "seq":      r.current,
+            "last_seq": r.lastSeq,
+        }))
+    } else {
+        for _, event := range r.events {
+            h.deliver(r.client, event.Payload)
+        }
+    }
+
+    // Live events up to current were part of the replay (or are covered
+    // by the resync); unsequenced ones are always passed on
+    for _, message := range r.state.pending {
+        if message.seq == 0 || message.seq > r.current {
+            h.deliver(r.client, message.data)
+        }
+    }
+    r.state.replaying, r.state.pending = false, nil
+}
+
+func (h *Hub) leave(client *Client, projectID uint) {
+    sub := h.clients[client][projectID]
+    if sub == nil {
+        return
+    }
+
+    h.closeDocs(client, projectID)
+
+    room := h.rooms[projectID]
+    delete(room, client)
+    if len(room) == 0 {
+        delete(h.rooms, projectID)
+    }
+    delete(h.clients[client], projectID)
+    h.announce(client, projectID, &sub.presence, TypePresenceLeft)
+}
+
+// remove drops client from all rooms and signals writePump to send a close
+// frame with code, discarding anything still queued. It reports whether
+// the client was still registered.
+func (h *Hub) remove(client *Client, code int) bool {
+    projects, ok := h.clients[client]
+    if !ok {
+        return false
+    }
+
+    for projectID := range projects {
+        h.leave(client, projectID)
+    }
+    delete(h.clients, client)
+    client.closeCode = code
+    close(client.closing)
+    h.stats.Active.Add(-1)
+    return true
+}
+
+// deliver queues data for client. A client whose buffer is full is too
+// slow to keep up and is disconnected rather than blocking the room.
+func (h *Hub) deliver(client *Client, data []byte) {
+    select {
+    case client.send <- data:
+    default:
+        h.dropSlow(client)
+    }
+}
+
+func (h *Hub) dropSlow(client *Client) {
+    if h.remove(client, websocket.CloseTryAgainLater) {
+        h.stats.SlowConsumers.Add(1)
+        log.Printf("Client dropped as slow consumer: User %d", client.userID)
+    }
+}
+
+// publishAsync queues env for the fan-out backend without blocking. Only
+// ephemeral messages go this way, so dropping them under load is fine.
+func (h *Hub) publishAsync(env *Envelope) {
+    h.stamp(env)
+    select {
+    case h.outbox <- env:
+    default:
+        log.Printf("WebSocket: fan-out queue full, dropping %s message", env.Kind)
+    }
+}
+
+func (h *Hub) publishLoop() {
+    for env := range h.outbox {
+        if err := h.backend.Publish(nil, env); err != nil {
+            log.Printf("WebSocket: failed to fan out %s message: %v", env.Kind, err)
+        }
+    }
+}
+
+func (h *Hub) stamp(env *Envelope) {
+    env.ID, env.Origin = randomID(), h.instanceID
+}
+
+// receive handles a message from the fan-out backend. Own messages were
+// already delivered locally.
+func (h *Hub) receive(env *Envelope) {
+    if env.Origin == h.instanceID || !h.seen.add(env.ID) {
         return
     }
 
+    switch env.Kind {
+    case kindEvent:
+        data := []byte(env.Data)
+        if env.Ref {
+            var err error
+            if data, err = h.eventPayload(env.ProjectID, env.Seq); err != nil {
+                log.Printf("WebSocket: event %d of project %d not in replay log: %v", env.Seq, env.ProjectID, err)
+                return
+            }
+        }
+        h.broadcast <- roomMessage{projectID: env.ProjectID, seq: env.Seq, data: data}
+
+    case kindRelay:
+        h.broadcast <- roomMessage{projectID: env.ProjectID, data: env.Data, ephemeral: true}
+
+    case kindKick:
+        h.kick <- membership{projectID: env.ProjectID, userID: env.UserID}
+
+    case kindDoc:
+        h.receiveDoc(env)
+    }
+}
+
+// Stats returns the hub's connection counters.
+func (h *Hub) Stats() map[string]int64 {
+    return h.stats.Snapshot()
+}
+
+// Broadcast numbers a message, stores it in its project's replay log and
+// sends it to all clients in the room of its project_id, on every instance
+func (h *Hub) Broadcast(message []byte) {
+    var fields map[string]json.RawMessage
+    var projectID uint
+    if err := json.Unmarshal(message, &fields); err != nil ||
+        json.Unmarshal(fields["project_id"], &projectID) != nil || projectID == 0 {
+        log.Println("WebSocket: dropping broadcast without project_id")
+        return
+    }
+
+    // Hold the lock until the message is queued so rooms see sequence
+    // numbers in order
+    h.publishMu.Lock()
+    defer h.publishMu.Unlock()
+
+    seq, frame, err := h.appendEvent(projectID, fields, func(tx *gorm.DB, seq int64, frame []byte) error {
+        env := &Envelope{Kind: kindEvent, ProjectID: projectID, Seq: seq, Data: frame}
+        h.stamp(env)
+        return h.backend.Publish(tx, env)
+    })
+    if err != nil {
+        // Live clients still get the event; reconnecting ones will resync
+        log.Printf("WebSocket: failed to store event for project %d: %v", projectID, err)
+        seq, frame = 0, message
+    }
+    h.broadcast <- roomMessage{projectID: projectID, seq: seq, data: frame}
+}
+
+// DisconnectMember unsubscribes every connection userID has open from
+// projectID on any instance, closing connections that have no other
+// subscription. Call it after the membership row is gone so the client
+// cannot subscribe again.
+func (h *Hub) DisconnectMember(projectID, userID uint) {
+    h.kick <- membership{projectID: projectID, userID: userID}
+    h.publishAsync(&Envelope{Kind: kindKick, ProjectID: projectID, UserID: userID})
+}
+
+// HandleWebSocket authenticates the access token before upgrading, so
+// rejected clients get a plain HTTP error. project_id is optional; when set
+// the connection starts subscribed to that project after the same
+// membership check as a subscribe message, resuming after last_seq if
+// given.
+func (h *Hub) HandleWebSocket(c *gin.Context) {
     // Extract and validate JWT token from query params
     tokenString := c.Query("token")
     if tokenString == "" {
-        log.Println("WebSocket: token query parameter required")
-        conn.Close()
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "token query parameter required"})
         return
     }
 
-    // Validate JWT token and extract userID
-    claims, err := auth.ValidateToken(tokenString, h.config.JWTSecret)
+    claims, err := auth.ValidateToken(tokenString, h.keys)
     if err != nil {
-        log.Println("WebSocket: Invalid token:", err)
-        conn.Close()
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
         return
     }
 
-    userID := claims.UserID
-
-    // Extract projectID from query params
-    projectIDStr := c.Query("project_id")
-    if projectIDStr == "" {
-        log.Println("WebSocket: project_id query parameter required")
-        conn.Close()
+    // Same rule as AuthMiddleware: the token's session must still be active
+    var session models.Session
+    if claims.SessionID == 0 || h.db.First(&session, claims.SessionID).Error != nil ||
+        session.UserID != claims.UserID || !session.IsActive() {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
         return
     }
-    
+
     var projectID uint
-    if _, err := fmt.Sscanf(projectIDStr, "%d", &projectID); err != nil {
-        log.Println("WebSocket: Invalid project_id format")
-        conn.Close()
+    var lastSeq *int64
+    if raw := c.Query("project_id"); raw != "" {
+        id, err := strconv.ParseUint(raw, 10, 64)
+        if err != nil || id == 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project_id"})
+            return
+        }
+        projectID = uint(id)
+
+        if status, message := h.checkAccess(claims.UserID, projectID); status != http.StatusOK {
+            c.JSON(status, gin.H{"error": message})
+            return
+        }
+
+        if raw := c.Query("last_seq"); raw != "" {
+            seq, err := strconv.ParseInt(raw, 10, 64)
+            if err != nil || seq < 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid last_seq"})
+                return
+            }
+            lastSeq = &seq
+        }
+    }
+
+    conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+    if err != nil {
+        log.Println("WebSocket upgrade error:", err)
         return
     }
 
     client := &Client{
-        hub:       h,
-        conn:      conn,
-        send:      make(chan []byte, 256),
-        userID:    userID,
-        projectID: projectID,
+        hub:        h,
+        conn:       conn,
+        send:       make(chan []byte, h.config.WSSendBuffer),
+        userID:     claims.UserID,
+        id:         randomID()[:16],
+        typingSent: make(map[string]time.Time),
+        closing:    make(chan struct{}),
     }
 
-    client.hub.register <- client
+    h.stats.Connections.Add(1)
+    h.stats.Active.Add(1)
+    client.hub.register <- subscription{client: client, projectID: projectID, lastSeq: lastSeq}
 
     go client.writePump()
     go client.readPump()
 }
 
+// checkAccess applies the ProjectAccess rules to a WebSocket subscription.
+func (h *Hub) checkAccess(userID, projectID uint) (int, string) {
+    var project models.Project
+    if err := h.db.First(&project, projectID).Error; err != nil {
+        return http.StatusNotFound, "Project not found"
+    }
+
+    var count int64
+    h.db.Model(&models.UserProject{}).Where("user_id = ? AND project_id = ?", userID, projectID).Count(&count)
+    if count == 0 {
+        return http.StatusForbidden, "Access denied: You are not a member of this project"
+    }
+
+    if project.RequireMFA {
+        h.db.Model(&models.UserMFA{}).Where("user_id = ? AND confirmed_at IS NOT NULL", userID).Count(&count)
+        if count == 0 {
+            return http.StatusForbidden, "This project requires two-factor authentication"
+        }
+    }
+
+    return http.StatusOK, ""
+}
+
+// inProject reports whether the file or task of view belongs to projectID.
+// It is checked before a view, typing or cursor naming it reaches the room.
+func (h *Hub) inProject(projectID uint, view ViewData) bool {
+    var count int64
+    switch {
+    case view.FileID != nil:
+        h.db.Model(&models.File{}).Where("id = ? AND project_id = ?", *view.FileID, projectID).Count(&count)
+    case view.TaskID != nil:
+        h.db.Model(&models.Task{}).Where("id = ? AND project_id = ?", *view.TaskID, projectID).Count(&count)
+    default:
+        return true
+    }
+    return count > 0
+}
+
+// handle processes one client frame. Replies and relayed events go through
+// Run, which owns the send channel.
+func (c *Client) handle(frame []byte) {
+    msg, payload, perr := parseInbound(frame)
+    if perr != nil {
+        var id string
+        var projectID uint
+        if msg != nil {
+            id, projectID = msg.ID, msg.ProjectID
+        }
+        c.hub.direct <- directMessage{client: c, data: errorReply(id, projectID, perr.code, perr.message)}
+        return
+    }
+
+    switch msg.Type {
+    case TypePing:
+        c.hub.direct <- directMessage{client: c, data: reply(TypePong, msg.ID, 0, c.userID, nil)}
+
+    case TypeSubscribe:
+        if status, message := c.hub.checkAccess(c.userID, msg.ProjectID); status != http.StatusOK {
+            code := ErrForbidden
+            if status == http.StatusNotFound {
+                code = ErrProjectNotFound
+            }
+            c.hub.direct <- directMessage{client: c, data: errorReply(msg.ID, msg.ProjectID, code, message)}
+            return
+        }
+        c.hub.subscribe <- subscription{client: c, projectID: msg.ProjectID, id: msg.ID, lastSeq: msg.LastSeq}
+
+    case TypeUnsubscribe:
+        c.hub.unsubscribe <- subscription{client: c, projectID: msg.ProjectID, id: msg.ID}
+
+    case TypePresence:
+        c.hub.presenceCh <- presenceChange{client: c, projectID: msg.ProjectID, status: payload.(PresenceData).Status}
+
+    case TypeView:
+        view := payload.(ViewData)
+        if !c.hub.inProject(msg.ProjectID, view) {
+            c.hub.direct <- directMessage{client: c, data: errorReply(msg.ID, msg.ProjectID, ErrInvalidPayload, "file or task not found in this project")}
+            return
+        }
+        c.hub.presenceCh <- presenceChange{client: c, projectID: msg.ProjectID, view: &view}
+
+    case TypeDocOpen:
+        data := payload.(DocData)
+        role, ok := c.docAccess(msg)
+        if !ok {
+            return
+        }
+        content, revision, err := c.hub.docs.Open(msg.ProjectID, data.FileID)
+        if err != nil {
+            code, message := docErrorCode(err)
+            c.hub.direct <- directMessage{client: c, data: errorReply(msg.ID, msg.ProjectID, code, message)}
+            return
+        }
+        c.hub.docOpened <- docOpen{
+            client:    c,
+            projectID: msg.ProjectID,
+            fileID:    data.FileID,
+            id:        msg.ID,
+            content:   content,
+            revision:  revision,
+            readOnly:  !role.Can(models.PermissionEditFiles),
+        }
+
+    case TypeDocClose:
+        c.hub.docClosed <- docClose{client: c, fileID: payload.(DocData).FileID}
+
+    case TypeDocOp:
+        data := payload.(DocOpData)
+        role, ok := c.docAccess(msg)
+        if !ok {
+            return
+        }
+        if !role.Can(models.PermissionEditFiles) {
+            c.hub.direct <- directMessage{client: c, data: errorReply(msg.ID, msg.ProjectID, ErrForbidden, "your project role does not allow editing files")}
+            return
+        }
+        if !c.hub.isDocMember(c, msg.ProjectID, data.FileID) {
+            c.hub.direct <- directMessage{client: c, data: errorReply(msg.ID, msg.ProjectID, ErrDocumentNotOpen, "open the file before editing it")}
+            return
+        }
+        if err := c.hub.submitEdit(c, msg.ProjectID, data); err != nil {
+            code, message := docErrorCode(err)
+            c.hub.direct <- directMessage{client: c, data: errorReply(msg.ID, msg.ProjectID, code, message)}
+        }
+
+    case TypeDocSelection:
+        data := payload.(DocSelectionData)
+        c.hub.docSelections <- docSelection{
+            client:    c,
+            projectID: msg.ProjectID,
+            event: DocSelectionEvent{
+                FileID:       data.FileID,
+                Revision:     data.Revision,
+                ConnectionID: c.id,
+                UserID:       c.userID,
+                Ranges:       data.Ranges,
+            },
+        }
+
+    default:
+        var target ViewData
+        switch data := payload.(type) {
+        case TypingData:
+            if !c.allowTyping(msg.ProjectID, data) {
+                return
+            }
+            target = ViewData{FileID: data.FileID, TaskID: data.TaskID}
+        case CursorData:
+            target = ViewData{FileID: &data.FileID}
+        }
+        if !c.hub.inProject(msg.ProjectID, target) {
+            c.hub.direct <- directMessage{client: c, data: errorReply(msg.ID, msg.ProjectID, ErrInvalidPayload, "file or task not found in this project")}
+            return
+        }
+
+        // typing and cursor are stamped with the sender and relayed to the
+        // rest of the room
+        c.hub.relay <- relayMessage{
+            client:    c,
+            projectID: msg.ProjectID,
+            data:      reply(msg.Type, "", msg.ProjectID, c.userID, payload),
+        }
+    }
+}
+
+func reply(msgType, id string, projectID, userID uint, data interface{}) []byte {
+    b, _ := json.Marshal(Message{Type: msgType, ID: id, ProjectID: projectID, UserID: userID, Data: data})
+    return b
+}
+
+func errorReply(id string, projectID uint, code, message string) []byte {
+    return reply(TypeError, id, projectID, 0, ErrorData{Code: code, Message: message})
+}
+
+// readPump reads client frames until the connection fails. Any frame or
+// pong extends the read deadline, so a peer that stops answering pings is
+// dropped after WSPongTimeout.
 func (c *Client) readPump() {
+    code := websocket.CloseNormalClosure
     defer func() {
-        c.hub.unregister <- c
-        c.conn.Close()
+        c.hub.unregister <- closeRequest{client: c, code: code}
     }()
 
+    cfg := c.hub.config
+    c.conn.SetReadLimit(cfg.WSMaxMessageSize)
+    c.conn.SetReadDeadline(time.Now().Add(cfg.WSPongTimeout))
+    c.conn.SetPongHandler(func(string) error {
+        return c.conn.SetReadDeadline(time.Now().Add(cfg.WSPongTimeout))
+    })
+
     for {
         _, message, err := c.conn.ReadMessage()
         if err != nil {
-            break
+            var netErr net.Error
+            switch {
+            case errors.Is(err, websocket.ErrReadLimit):
+                c.hub.stats.OversizedFrame.Add(1)
+                code = websocket.CloseMessageTooBig
+            case errors.As(err, &netErr) && netErr.Timeout():
+                c.hub.stats.PongTimeouts.Add(1)
+                code = ClosePongTimeout
+            }
+            return
         }
-        c.hub.broadcast <- message
+        c.conn.SetReadDeadline(time.Now().Add(cfg.WSPongTimeout))
+        c.handle(message)
     }
 }
 
+// writePump is the only writer on the connection. It sends queued frames
+// and pings, and on removal a close frame with the reason code.
 func (c *Client) writePump() {
-    defer c.conn.Close()
+    cfg := c.hub.config
+    ticker := time.NewTicker(cfg.WSPingInterval)
+    defer func() {
+        ticker.Stop()
+        c.conn.Close()
+    }()
+
+    for {
+        select {
+        case message := <-c.send:
+            c.conn.SetWriteDeadline(time.Now().Add(cfg.WSWriteTimeout))
+            if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+                c.writeFailed()
+                return
+            }
+
+        case <-ticker.C:
+            c.conn.SetWriteDeadline(time.Now().Add(cfg.WSWriteTimeout))
+            if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+                c.writeFailed()
+                return
+            }
 
-    for message := range c.send {
-        if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+        case <-c.closing:
+            frame := websocket.FormatCloseMessage(c.closeCode, closeReasons[c.closeCode])
+            c.conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(cfg.WSWriteTimeout))
             return
         }
     }
-}
\ No newline at end of file
+}
+
+// writeFailed records a failed write. Closing the connection makes
+// readPump fail and unregister the client.
+func (c *Client) writeFailed() {
+    select {
+    case <-c.closing:
+        // Already removed; the failure is a consequence
+    default:
+        c.hub.stats.WriteErrors.Add(1)
+    }
+}
@@ -0,0 +1,104 @@
+package websocket
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "gorm.io/gorm"
+)
+
+const (
+    notifyChannel = "devsync_ws"
+    // Postgres rejects NOTIFY payloads of 8000 bytes or more
+    maxNotifyPayload = 7900
+    maxListenBackoff = 30 * time.Second
+)
+
+// PostgresBackend fans out with LISTEN/NOTIFY on the application database.
+// Project events are notified inside the transaction that stores them, so
+// Postgres delivers them only after commit and in sequence order.
+type PostgresBackend struct {
+    dsn string
+    db  *gorm.DB
+}
+
+func NewPostgresBackend(dsn string, db *gorm.DB) *PostgresBackend {
+    return &PostgresBackend{dsn: dsn, db: db}
+}
+
+func (b *PostgresBackend) Publish(tx *gorm.DB, env *Envelope) error {
+    payload, err := json.Marshal(env)
+    if err != nil {
+        return err
+    }
+
+    // Large events and edits are already stored; send a reference
+    if len(payload) > maxNotifyPayload {
+        if env.Seq == 0 {
+            log.Printf("WebSocket: %s message for project %d too large to fan out", env.Kind, env.ProjectID)
+            return nil
+        }
+        ref := *env
+        ref.Data, ref.Ref = nil, true
+        if payload, err = json.Marshal(&ref); err != nil {
+            return err
+        }
+    }
+
+    if tx == nil {
+        tx = b.db
+    }
+    return tx.Exec("SELECT pg_notify(?, ?)", notifyChannel, string(payload)).Error
+}
+
+func (b *PostgresBackend) Listen(handle func(*Envelope)) error {
+    go b.listen(handle)
+    return nil
+}
+
+// listen holds a dedicated connection in LISTEN mode, reconnecting with
+// backoff. Notifications sent while disconnected are lost; clients notice
+// the gap in seq and resubscribe with last_seq.
+func (b *PostgresBackend) listen(handle func(*Envelope)) {
+    ctx := context.Background()
+    backoff := time.Second
+
+    for {
+        err := b.listenOnce(ctx, handle, func() { backoff = time.Second })
+        log.Printf("WebSocket: fan-out listener stopped, retrying in %s: %v", backoff, err)
+        time.Sleep(backoff)
+        if backoff *= 2; backoff > maxListenBackoff {
+            backoff = maxListenBackoff
+        }
+    }
+}
+
+func (b *PostgresBackend) listenOnce(ctx context.Context, handle func(*Envelope), connected func()) error {
+    conn, err := pgx.Connect(ctx, b.dsn)
+    if err != nil {
+        return err
+    }
+    defer conn.Close(ctx)
+
+    if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{notifyChannel}.Sanitize()); err != nil {
+        return err
+    }
+    connected()
+
+    for {
+        notification, err := conn.WaitForNotification(ctx)
+        if err != nil {
+            return err
+        }
+
+        var env Envelope
+        if err := json.Unmarshal([]byte(notification.Payload), &env); err != nil {
+            log.Println("WebSocket: ignoring malformed fan-out message:", err)
+            continue
+        }
+        handle(&env)
+    }
+}
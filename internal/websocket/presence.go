@@ -0,0 +1,266 @@
+package websocket
+
+import (
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    "devsync-be/internal/models"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+const (
+    // typing: true is passed on at most this often per chat; typing: false
+    // always is. Clients should drop a typing indicator that has not been
+    // refreshed for a few seconds.
+    typingThrottle    = 2 * time.Second
+    idleCheckInterval = 15 * time.Second
+    // Each instance refreshes the seen_at of its presence rows this often;
+    // rows older than PresenceTTL belong to an instance that is gone
+    presenceHeartbeat = 30 * time.Second
+    PresenceTTL       = 3 * presenceHeartbeat
+    // Presence rows are written at most this often, so bursts of cursor
+    // and typing activity collapse into one write per connection
+    presenceFlushInterval = time.Second
+)
+
+// presenceState is the presence of one connection in one room.
+type presenceState struct {
+    status      string
+    fileID      *uint
+    taskID      *uint
+    connectedAt time.Time
+    lastActive  time.Time
+}
+
+// PresenceEvent is the payload of presence_joined, presence_updated and
+// presence_left. A user with several tabs open has one connection each.
+type PresenceEvent struct {
+    ConnectionID string    `json:"connection_id"`
+    Status       string    `json:"status"`
+    FileID       *uint     `json:"file_id"`
+    TaskID       *uint     `json:"task_id"`
+    LastActiveAt time.Time `json:"last_active_at"`
+}
+
+// presenceChange is a presence or view message from a client.
+type presenceChange struct {
+    client    *Client
+    projectID uint
+    status    string
+    view      *ViewData
+}
+
+// announce tells the room, on every instance, about a presence change of
+// client and records it for the REST snapshot.
+func (h *Hub) announce(client *Client, projectID uint, p *presenceState, msgType string) {
+    data := reply(msgType, "", projectID, client.userID, PresenceEvent{
+        ConnectionID: client.id,
+        Status:       p.status,
+        FileID:       p.fileID,
+        TaskID:       p.taskID,
+        LastActiveAt: p.lastActive,
+    })
+    for other := range h.rooms[projectID] {
+        if other != client && !h.clients[other][projectID].replaying {
+            h.deliver(other, data)
+        }
+    }
+    h.publishAsync(&Envelope{Kind: kindRelay, ProjectID: projectID, Data: data})
+
+    if msgType == TypePresenceLeft {
+        h.presence.put(client.id, projectID, nil)
+    } else {
+        h.record(client, projectID, p)
+    }
+}
+
+// record stores the presence row without telling the room.
+func (h *Hub) record(client *Client, projectID uint, p *presenceState) {
+    h.presence.put(client.id, projectID, &models.PresenceSession{
+        ConnectionID: client.id,
+        ProjectID:    projectID,
+        InstanceID:   h.instanceID,
+        UserID:       client.userID,
+        Status:       p.status,
+        FileID:       p.fileID,
+        TaskID:       p.taskID,
+        ConnectedAt:  p.connectedAt,
+        LastActiveAt: p.lastActive,
+        SeenAt:       time.Now(),
+    })
+}
+
+// changePresence applies a presence or view message.
+func (h *Hub) changePresence(c presenceChange) {
+    sub := h.clients[c.client][c.projectID]
+    if sub == nil {
+        h.deliver(c.client, errorReply("", c.projectID, ErrNotSubscribed, "subscribe to the project first"))
+        return
+    }
+
+    p := &sub.presence
+    if c.status != "" {
+        p.status = c.status
+        if c.status == StatusActive {
+            p.lastActive = time.Now()
+        }
+    }
+    if c.view != nil {
+        p.fileID, p.taskID = c.view.FileID, c.view.TaskID
+        p.lastActive = time.Now()
+        if p.status == StatusIdle {
+            p.status = StatusActive
+        }
+    }
+    h.announce(c.client, c.projectID, p, TypePresenceUpdated)
+}
+
+// touch records activity such as typing or moving the cursor. An idle
+// connection becomes active again; away is only changed by the client.
+func (h *Hub) touch(client *Client, projectID uint) {
+    sub := h.clients[client][projectID]
+    if sub == nil {
+        return
+    }
+
+    p := &sub.presence
+    p.lastActive = time.Now()
+    if p.status == StatusIdle {
+        p.status = StatusActive
+        h.announce(client, projectID, p, TypePresenceUpdated)
+        return
+    }
+    h.record(client, projectID, p)
+}
+
+// markIdle turns connections without activity for WSIdleAfter idle.
+func (h *Hub) markIdle() {
+    cutoff := time.Now().Add(-h.config.WSIdleAfter)
+    for client, subs := range h.clients {
+        for projectID, sub := range subs {
+            if p := &sub.presence; p.status == StatusActive && p.lastActive.Before(cutoff) {
+                p.status = StatusIdle
+                h.announce(client, projectID, p, TypePresenceUpdated)
+            }
+        }
+    }
+}
+
+// Presence returns the live presence rows of projectID across all
+// instances.
+func (h *Hub) Presence(projectID uint) ([]models.PresenceSession, error) {
+    var sessions []models.PresenceSession
+    err := h.db.Where("project_id = ? AND seen_at > ?", projectID, time.Now().Add(-PresenceTTL)).
+        Order("connected_at ASC").
+        Find(&sessions).Error
+    return sessions, err
+}
+
+// allowTyping throttles typing: true per chat. It runs on the readPump
+// goroutine, which owns typingSent.
+func (c *Client) allowTyping(projectID uint, data TypingData) bool {
+    key := fmt.Sprintf("%d", projectID)
+    switch {
+    case data.FileID != nil:
+        key += fmt.Sprintf(":file:%d", *data.FileID)
+    case data.TaskID != nil:
+        key += fmt.Sprintf(":task:%d", *data.TaskID)
+    }
+
+    if !data.Typing {
+        delete(c.typingSent, key)
+        return true
+    }
+    if last, ok := c.typingSent[key]; ok && time.Since(last) < typingThrottle {
+        return false
+    }
+    c.typingSent[key] = time.Now()
+    return true
+}
+
+type presenceKey struct {
+    connectionID string
+    projectID    uint
+}
+
+// presenceStore writes the presence rows of this instance in the
+// background, so Run never waits for the database. Changes to the same row
+// are coalesced until the next write.
+type presenceStore struct {
+    db         *gorm.DB
+    instanceID string
+    mu         sync.Mutex
+    pending    map[presenceKey]*models.PresenceSession // nil deletes the row
+    wake       chan struct{}
+}
+
+func newPresenceStore(db *gorm.DB, instanceID string) *presenceStore {
+    return &presenceStore{
+        db:         db,
+        instanceID: instanceID,
+        pending:    make(map[presenceKey]*models.PresenceSession),
+        wake:       make(chan struct{}, 1),
+    }
+}
+
+func (s *presenceStore) put(connectionID string, projectID uint, row *models.PresenceSession) {
+    s.mu.Lock()
+    s.pending[presenceKey{connectionID, projectID}] = row
+    s.mu.Unlock()
+
+    select {
+    case s.wake <- struct{}{}:
+    default:
+    }
+}
+
+// run clears rows left by a previous run of this instance, then writes
+// changes and refreshes seen_at until the process exits.
+func (s *presenceStore) run() {
+    if err := s.db.Where("instance_id = ?", s.instanceID).Delete(&models.PresenceSession{}).Error; err != nil {
+        log.Println("WebSocket: failed to clear presence:", err)
+    }
+
+    heartbeat := time.NewTicker(presenceHeartbeat)
+    defer heartbeat.Stop()
+
+    for {
+        select {
+        case <-s.wake:
+            s.flush()
+            time.Sleep(presenceFlushInterval)
+
+        case <-heartbeat.C:
+            now := time.Now()
+            if err := s.db.Model(&models.PresenceSession{}).Where("instance_id = ?", s.instanceID).
+                Update("seen_at", now).Error; err != nil {
+                log.Println("WebSocket: failed to refresh presence:", err)
+            }
+            s.db.Where("seen_at < ?", now.Add(-PresenceTTL)).Delete(&models.PresenceSession{})
+        }
+    }
+}
+
+func (s *presenceStore) flush() {
+    s.mu.Lock()
+    batch := s.pending
+    s.pending = make(map[presenceKey]*models.PresenceSession)
+    s.mu.Unlock()
+
+    for key, row := range batch {
+        var err error
+        if row == nil {
+            err = s.db.Where("connection_id = ? AND project_id = ?", key.connectionID, key.projectID).
+                Delete(&models.PresenceSession{}).Error
+        } else {
+            err = s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(row).Error
+        }
+        if err != nil {
+            log.Printf("WebSocket: failed to store presence of connection %s: %v", key.connectionID, err)
+        }
+    }
+}
@@ -0,0 +1,307 @@
+package websocket
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+
+    "devsync-be/internal/collab"
+)
+
+// Inbound message types a client may send. Anything else is answered with
+// an error frame; client frames are never relayed as-is.
+const (
+    TypeSubscribe   = "subscribe"
+    TypeUnsubscribe = "unsubscribe"
+    TypeTyping      = "typing"
+    TypePresence    = "presence"
+    TypeCursor      = "cursor"
+    TypeView        = "view"
+    TypePing        = "ping"
+
+    TypeDocOpen      = "doc_open"
+    TypeDocClose     = "doc_close"
+    TypeDocOp        = "doc_op"
+    TypeDocSelection = "doc_selection"
+)
+
+// Outbound message types generated by the hub itself.
+const (
+    TypeSubscribed     = "subscribed"
+    TypeUnsubscribed   = "unsubscribed"
+    TypeResyncRequired = "resync_required"
+    TypePong           = "pong"
+    TypeError          = "error"
+
+    TypePresenceJoined  = "presence_joined"
+    TypePresenceUpdated = "presence_updated"
+    TypePresenceLeft    = "presence_left"
+
+    TypeDocOpened = "doc_opened"
+    TypeDocAck    = "doc_ack"
+    TypeDocLeft   = "doc_left"
+    TypeDocClosed = "doc_closed"
+)
+
+// Error codes sent in error frames.
+const (
+    ErrMalformedMessage = "malformed_message"
+    ErrUnknownType      = "unknown_type"
+    ErrInvalidPayload   = "invalid_payload"
+    ErrNotSubscribed    = "not_subscribed"
+    ErrForbidden        = "forbidden"
+    ErrProjectNotFound  = "project_not_found"
+
+    ErrFileNotFound        = "file_not_found"
+    ErrDocumentNotOpen     = "document_not_open"
+    ErrInvalidOperation    = "invalid_operation"
+    ErrRevisionUnavailable = "revision_unavailable"
+    ErrDocumentTooLarge    = "document_too_large"
+    ErrInternal            = "internal_error"
+)
+
+// maxSelectionRanges limits the ranges of one doc_selection (multiple
+// cursors).
+const maxSelectionRanges = 100
+
+// InboundMessage is the envelope of every client frame. ID is optional and
+// echoed on the reply so clients can match acknowledgements and errors.
+// LastSeq is only used by subscribe, to resume after the last event the
+// client received.
+type InboundMessage struct {
+    Type      string          `json:"type"`
+    ID        string          `json:"id,omitempty"`
+    ProjectID uint            `json:"project_id,omitempty"`
+    LastSeq   *int64          `json:"last_seq,omitempty"`
+    Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// TypingData reports whether the user is typing, optionally in the chat of
+// a file or task.
+type TypingData struct {
+    Typing bool  `json:"typing"`
+    FileID *uint `json:"file_id,omitempty"`
+    TaskID *uint `json:"task_id,omitempty"`
+}
+
+// PresenceData is the user's self-reported status.
+type PresenceData struct {
+    Status string `json:"status"`
+}
+
+const (
+    StatusActive = "active"
+    StatusIdle   = "idle"
+    StatusAway   = "away"
+)
+
+var presenceStatuses = map[string]bool{StatusActive: true, StatusIdle: true, StatusAway: true}
+
+// ViewData is the file or task the user has open. Sending neither means the
+// user is not viewing anything.
+type ViewData struct {
+    FileID *uint `json:"file_id,omitempty"`
+    TaskID *uint `json:"task_id,omitempty"`
+}
+
+// CursorPosition is a zero-based line and column in a file.
+type CursorPosition struct {
+    Line   int `json:"line"`
+    Column int `json:"column"`
+}
+
+// CursorData is the user's caret, and selection anchor if any, in a file.
+type CursorData struct {
+    FileID    uint            `json:"file_id"`
+    Position  CursorPosition  `json:"position"`
+    Selection *CursorPosition `json:"selection,omitempty"`
+}
+
+// DocData names the file of doc_open, doc_close and doc_closed.
+type DocData struct {
+    FileID uint `json:"file_id"`
+}
+
+// DocOpData is an edit of a file the client has open, based on the last
+// revision the client received.
+type DocOpData struct {
+    FileID    uint              `json:"file_id"`
+    Revision  int64             `json:"revision"`
+    Operation *collab.Operation `json:"operation"`
+}
+
+// SelectionRange is a selection, or a caret when Anchor equals Head, as
+// offsets in UTF-16 code units.
+type SelectionRange struct {
+    Anchor int `json:"anchor"`
+    Head   int `json:"head"`
+}
+
+// DocSelectionData is the client's selections at Revision. An empty list
+// hides the client's cursor.
+type DocSelectionData struct {
+    FileID   uint             `json:"file_id"`
+    Revision int64            `json:"revision"`
+    Ranges   []SelectionRange `json:"ranges"`
+}
+
+// ErrorData is the payload of an error frame.
+type ErrorData struct {
+    Code    string `json:"code"`
+    Message string `json:"message"`
+}
+
+// protocolError is an inbound frame that was rejected.
+type protocolError struct {
+    code    string
+    message string
+}
+
+func (e *protocolError) Error() string {
+    return e.code + ": " + e.message
+}
+
+func invalid(code, format string, args ...interface{}) *protocolError {
+    return &protocolError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// parseInbound decodes and validates a client frame. For project-scoped
+// types the returned payload is the validated struct, so only known fields
+// are ever passed on to other clients.
+func parseInbound(frame []byte) (*InboundMessage, interface{}, *protocolError) {
+    var msg InboundMessage
+    if err := decodeStrict(frame, &msg); err != nil {
+        return nil, nil, invalid(ErrMalformedMessage, "frame is not a valid message: %v", err)
+    }
+
+    switch msg.Type {
+    case TypePing:
+        return &msg, nil, nil
+
+    case TypeSubscribe, TypeUnsubscribe:
+        if msg.ProjectID == 0 {
+            return &msg, nil, invalid(ErrInvalidPayload, "project_id is required")
+        }
+        if msg.LastSeq != nil && (msg.Type != TypeSubscribe || *msg.LastSeq < 0) {
+            return &msg, nil, invalid(ErrInvalidPayload, "last_seq must be a non-negative number on subscribe")
+        }
+        return &msg, nil, nil
+
+    case TypeTyping:
+        var data TypingData
+        if err := decodePayload(&msg, &data); err != nil {
+            return &msg, nil, err
+        }
+        if data.FileID != nil && data.TaskID != nil {
+            return &msg, nil, invalid(ErrInvalidPayload, "set at most one of file_id and task_id")
+        }
+        return &msg, data, nil
+
+    case TypePresence:
+        var data PresenceData
+        if err := decodePayload(&msg, &data); err != nil {
+            return &msg, nil, err
+        }
+        if !presenceStatuses[data.Status] {
+            return &msg, nil, invalid(ErrInvalidPayload, "status must be active, idle or away")
+        }
+        return &msg, data, nil
+
+    case TypeView:
+        var data ViewData
+        if err := decodePayload(&msg, &data); err != nil {
+            return &msg, nil, err
+        }
+        if data.FileID != nil && data.TaskID != nil {
+            return &msg, nil, invalid(ErrInvalidPayload, "set at most one of file_id and task_id")
+        }
+        return &msg, data, nil
+
+    case TypeDocOpen, TypeDocClose:
+        var data DocData
+        if err := decodePayload(&msg, &data); err != nil {
+            return &msg, nil, err
+        }
+        if data.FileID == 0 {
+            return &msg, nil, invalid(ErrInvalidPayload, "file_id is required")
+        }
+        return &msg, data, nil
+
+    case TypeDocOp:
+        var data DocOpData
+        if err := decodePayload(&msg, &data); err != nil {
+            return &msg, nil, err
+        }
+        if data.FileID == 0 || data.Operation == nil || data.Revision < 0 {
+            return &msg, nil, invalid(ErrInvalidPayload, "file_id, revision and operation are required")
+        }
+        return &msg, data, nil
+
+    case TypeDocSelection:
+        var data DocSelectionData
+        if err := decodePayload(&msg, &data); err != nil {
+            return &msg, nil, err
+        }
+        if data.FileID == 0 || data.Revision < 0 {
+            return &msg, nil, invalid(ErrInvalidPayload, "file_id and revision are required")
+        }
+        if len(data.Ranges) > maxSelectionRanges {
+            return &msg, nil, invalid(ErrInvalidPayload, "at most %d ranges are allowed", maxSelectionRanges)
+        }
+        for _, r := range data.Ranges {
+            if r.Anchor < 0 || r.Head < 0 {
+                return &msg, nil, invalid(ErrInvalidPayload, "anchor and head must not be negative")
+            }
+        }
+        return &msg, data, nil
+
+    case TypeCursor:
+        var data CursorData
+        if err := decodePayload(&msg, &data); err != nil {
+            return &msg, nil, err
+        }
+        if data.FileID == 0 {
+            return &msg, nil, invalid(ErrInvalidPayload, "file_id is required")
+        }
+        if !data.Position.valid() || (data.Selection != nil && !data.Selection.valid()) {
+            return &msg, nil, invalid(ErrInvalidPayload, "line and column must not be negative")
+        }
+        return &msg, data, nil
+
+    case "":
+        return &msg, nil, invalid(ErrMalformedMessage, "type is required")
+
+    default:
+        return &msg, nil, invalid(ErrUnknownType, "unknown message type %q", msg.Type)
+    }
+}
+
+// decodePayload decodes the data of a project-scoped message.
+func decodePayload(msg *InboundMessage, v interface{}) *protocolError {
+    if msg.ProjectID == 0 {
+        return invalid(ErrInvalidPayload, "project_id is required")
+    }
+    if len(msg.Data) == 0 {
+        return invalid(ErrInvalidPayload, "data is required")
+    }
+    if err := decodeStrict(msg.Data, v); err != nil {
+        return invalid(ErrInvalidPayload, "invalid %s data: %v", msg.Type, err)
+    }
+    return nil
+}
+
+func decodeStrict(raw []byte, v interface{}) error {
+    dec := json.NewDecoder(bytes.NewReader(raw))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(v); err != nil {
+        return err
+    }
+    if dec.More() {
+        return fmt.Errorf("unexpected data after message")
+    }
+    return nil
+}
+
+func (p CursorPosition) valid() bool {
+    return p.Line >= 0 && p.Column >= 0
+}
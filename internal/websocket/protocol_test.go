@@ -0,0 +1,116 @@
+package websocket
+
+import (
+    "encoding/json"
+    "fmt"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParseInbound(t *testing.T) {
+    fileID, taskID := uint(3), uint(9)
+    tests := []struct {
+        frame   string
+        payload interface{}
+    }{
+        {`{"type": "ping"}`, nil},
+        {`{"type": "subscribe", "project_id": 1, "last_seq": 0}`, nil},
+        {`{"type": "unsubscribe", "project_id": 1, "id": "a"}`, nil},
+        {`{"type": "typing", "project_id": 1, "data": {"typing": true}}`, TypingData{Typing: true}},
+        {`{"type": "typing", "project_id": 1, "data": {"typing": false, "file_id": 3}}`, TypingData{FileID: &fileID}},
+        {`{"type": "presence", "project_id": 1, "data": {"status": "away"}}`, PresenceData{Status: StatusAway}},
+        {`{"type": "view", "project_id": 1, "data": {"task_id": 9}}`, ViewData{TaskID: &taskID}},
+        {`{"type": "view", "project_id": 1, "data": {}}`, ViewData{}},
+        {
+            `{"type": "cursor", "project_id": 1, "data": {"file_id": 3, "position": {"line": 2, "column": 0}}}`,
+            CursorData{FileID: 3, Position: CursorPosition{Line: 2}},
+        },
+        {`{"type": "doc_open", "project_id": 1, "data": {"file_id": 3}}`, DocData{FileID: 3}},
+        {`{"type": "doc_close", "project_id": 1, "data": {"file_id": 3}}`, DocData{FileID: 3}},
+        {
+            `{"type": "doc_selection", "project_id": 1, "data": {"file_id": 3, "revision": 0, "ranges": []}}`,
+            DocSelectionData{FileID: 3, Ranges: []SelectionRange{}},
+        },
+    }
+    for _, tt := range tests {
+        msg, payload, err := parseInbound([]byte(tt.frame))
+        if err != nil {
+            t.Errorf("parseInbound(%s): %v", tt.frame, err)
+            continue
+        }
+        if !reflect.DeepEqual(payload, tt.payload) {
+            t.Errorf("parseInbound(%s) payload = %#v, want %#v", tt.frame, payload, tt.payload)
+        }
+        if msg.Type == "" {
+            t.Errorf("parseInbound(%s) lost the type", tt.frame)
+        }
+    }
+}
+
+func TestParseInboundDocOp(t *testing.T) {
+    msg, payload, err := parseInbound([]byte(`{"type": "doc_op", "id": "e1", "project_id": 1, "data": {"file_id": 3, "revision": 7, "operation": [2, "ab", -1]}}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    data := payload.(DocOpData)
+    if msg.ID != "e1" || data.FileID != 3 || data.Revision != 7 {
+        t.Errorf("parseInbound() = %+v, %+v", msg, data)
+    }
+    raw, _ := json.Marshal(data.Operation)
+    if string(raw) != `[2,"ab",-1]` {
+        t.Errorf("operation = %s", raw)
+    }
+}
+
+func TestParseInboundInvalid(t *testing.T) {
+    ranges := strings.TrimSuffix(strings.Repeat(`{"anchor": 0, "head": 0},`, maxSelectionRanges+1), ",")
+    tests := []struct {
+        frame string
+        code  string
+    }{
+        {`not json`, ErrMalformedMessage},
+        {`{"type": "ping"} {"type": "ping"}`, ErrMalformedMessage},
+        {`{"type": "ping", "user_id": 2}`, ErrMalformedMessage},
+        {`{}`, ErrMalformedMessage},
+        {`{"type": "chat_message", "project_id": 1}`, ErrUnknownType},
+        {`{"type": "doc_opened", "project_id": 1}`, ErrUnknownType},
+
+        {`{"type": "subscribe"}`, ErrInvalidPayload},
+        {`{"type": "subscribe", "project_id": 1, "last_seq": -1}`, ErrInvalidPayload},
+        {`{"type": "unsubscribe", "project_id": 1, "last_seq": 3}`, ErrInvalidPayload},
+
+        {`{"type": "typing", "data": {"typing": true}}`, ErrInvalidPayload},
+        {`{"type": "typing", "project_id": 1}`, ErrInvalidPayload},
+        {`{"type": "typing", "project_id": 1, "data": {"typing": true, "user_id": 5}}`, ErrInvalidPayload},
+        {`{"type": "typing", "project_id": 1, "data": {"typing": true, "file_id": 3, "task_id": 9}}`, ErrInvalidPayload},
+        {`{"type": "typing", "project_id": 1, "data": {"typing": "yes"}}`, ErrInvalidPayload},
+        {`{"type": "presence", "project_id": 1, "data": {"status": "busy"}}`, ErrInvalidPayload},
+        {`{"type": "view", "project_id": 1, "data": {"file_id": 3, "task_id": 9}}`, ErrInvalidPayload},
+
+        {`{"type": "cursor", "project_id": 1, "data": {"position": {"line": 0, "column": 0}}}`, ErrInvalidPayload},
+        {`{"type": "cursor", "project_id": 1, "data": {"file_id": 3, "position": {"line": -1, "column": 0}}}`, ErrInvalidPayload},
+        {`{"type": "cursor", "project_id": 1, "data": {"file_id": 3, "position": {"line": 0, "column": 0}, "selection": {"line": 0, "column": -2}}}`, ErrInvalidPayload},
+
+        {`{"type": "doc_open", "project_id": 1, "data": {}}`, ErrInvalidPayload},
+        {`{"type": "doc_op", "project_id": 1, "data": {"file_id": 3, "revision": 7}}`, ErrInvalidPayload},
+        {`{"type": "doc_op", "project_id": 1, "data": {"file_id": 3, "revision": -1, "operation": [1]}}`, ErrInvalidPayload},
+        {`{"type": "doc_op", "project_id": 1, "data": {"file_id": 3, "revision": 0, "operation": [0]}}`, ErrInvalidPayload},
+        {`{"type": "doc_selection", "project_id": 1, "data": {"file_id": 3, "revision": 0, "ranges": [{"anchor": -1, "head": 0}]}}`, ErrInvalidPayload},
+        {fmt.Sprintf(`{"type": "doc_selection", "project_id": 1, "data": {"file_id": 3, "revision": 0, "ranges": [%s]}}`, ranges), ErrInvalidPayload},
+    }
+    for _, tt := range tests {
+        _, _, err := parseInbound([]byte(tt.frame))
+        if err == nil || err.code != tt.code {
+            t.Errorf("parseInbound(%.80s) error = %v, want %s", tt.frame, err, tt.code)
+        }
+    }
+}
+
+func TestParseInboundKeepsID(t *testing.T) {
+    // The id is echoed on the error frame, so it survives a bad payload
+    msg, _, err := parseInbound([]byte(`{"type": "doc_open", "id": "req-1", "project_id": 1, "data": {}}`))
+    if err == nil || msg == nil || msg.ID != "req-1" || msg.ProjectID != 1 {
+        t.Errorf("parseInbound() = %+v, %v, want the id and project of the frame", msg, err)
+    }
+}
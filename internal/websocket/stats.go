@@ -0,0 +1,30 @@
+package websocket
+
+import (
+    "sync/atomic"
+)
+
+// Stats counts connections and the reasons clients were dropped. The
+// counters are cumulative since start, except Active.
+type Stats struct {
+    Connections    atomic.Int64
+    Active         atomic.Int64
+    SlowConsumers  atomic.Int64
+    PongTimeouts   atomic.Int64
+    WriteErrors    atomic.Int64
+    OversizedFrame atomic.Int64
+    Revoked        atomic.Int64
+}
+
+// Snapshot returns the counters by name, e.g. for expvar.
+func (s *Stats) Snapshot() map[string]int64 {
+    return map[string]int64{
+        "connections_total":       s.Connections.Load(),
+        "connections_active":      s.Active.Load(),
+        "dropped_slow_consumer":   s.SlowConsumers.Load(),
+        "dropped_pong_timeout":    s.PongTimeouts.Load(),
+        "dropped_write_error":     s.WriteErrors.Load(),
+        "dropped_message_too_big": s.OversizedFrame.Load(),
+        "removed_from_project":    s.Revoked.Load(),
+    }
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"expvar"
 	"log"
+	"net/http"
 	"os"
 
 	"devsync-be/internal/api"
+	"devsync-be/internal/auth"
 	"devsync-be/internal/config"
 	"devsync-be/internal/database"
+	"devsync-be/internal/gitstore"
+	"devsync-be/internal/mailer"
+	"devsync-be/internal/secrets"
 	"devsync-be/internal/storage"
 	"devsync-be/internal/websocket"
 
@@ -20,12 +26,43 @@ func main() {
 	}
 
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	keys, err := auth.LoadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKeyID, cfg.JWTIssuer, cfg.DevMode)
+	if err != nil {
+		log.Fatal("Failed to load JWT signing keys:", err)
+	}
+	log.Printf("Signing access tokens with key %s", keys.ActiveKeyID())
 
 	db, err := database.Initialize(cfg.DatabaseURL)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 
+	tokenKeys, err := secrets.ParseKeyring(cfg.TokenKeys, cfg.TokenKeyVersion, cfg.DevMode)
+	if err != nil {
+		log.Fatal("Failed to load token encryption keys:", err)
+	}
+	tokens := secrets.NewTokenStore(db, tokenKeys)
+
+	if migrated, err := tokens.MigratePlaintextTokens(); err != nil {
+		log.Fatal("Failed to encrypt stored provider tokens:", err)
+	} else if migrated > 0 {
+		log.Printf("Encrypted %d plaintext provider tokens", migrated)
+	}
+
+	// "reencrypt-tokens" rewraps stored tokens with the active key and exits
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt-tokens" {
+		rotated, err := tokens.Rotate()
+		if err != nil {
+			log.Fatal("Failed to re-encrypt provider tokens:", err)
+		}
+		log.Printf("Re-encrypted %d provider tokens with key version %d", rotated, tokenKeys.ActiveVersion())
+		return
+	}
+
 	// Initialize GCS storage
 	gcsStorage, err := storage.NewGCSStorage(cfg.GCPProjectID, cfg.GCPBucketName, cfg.GCPCredentialsPath)
 	if err != nil {
@@ -33,12 +70,33 @@ func main() {
 	}
 	defer gcsStorage.Close()
 
-	hub := websocket.NewHub(cfg)
+	mail := mailer.New(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+
+	fanout, err := websocket.NewBackend(cfg, db)
+	if err != nil {
+		log.Fatal("Failed to set up WebSocket fan-out:", err)
+	}
+	hub := websocket.NewHub(cfg, keys, db, fanout)
+
+	// Saved file content is committed to the project repository
+	repos := gitstore.New(db, cfg.GitStorageDir)
+	hub.OnFileSaved(repos.CommitFile)
 	go hub.Run()
 
+	// Counters are served from a separate listener so they stay internal
+	if cfg.MetricsAddr != "" {
+		expvar.Publish("websocket", expvar.Func(func() interface{} { return hub.Stats() }))
+		go func() {
+			log.Printf("Metrics listening on %s/debug/vars", cfg.MetricsAddr)
+			if err := http.ListenAndServe(cfg.MetricsAddr, nil); err != nil {
+				log.Println("Metrics listener stopped:", err)
+			}
+		}()
+	}
+
 	r := gin.Default()
 
-	api.SetupRoutes(r, db, hub, cfg, gcsStorage)
+	api.SetupRoutes(r, db, hub, cfg, gcsStorage, repos, mail, keys, tokens)
 
 	port := os.Getenv("PORT")
 	if port == "" {
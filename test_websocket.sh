@@ -27,13 +27,18 @@ if ! command -v websocat &> /dev/null; then
 fi
 
 # Configuration
-WS_URL="ws://localhost:8080/ws"
-USER_ID=1
-PROJECT_ID=1
+PROJECT_ID=${PROJECT_ID:-1}
+
+if [ -z "$TOKEN" ]; then
+    echo -e "${RED}❌ TOKEN is not set${NC}"
+    echo "Export an access token first: export TOKEN=<access_token>"
+    exit 1
+fi
+
+WS_URL="ws://localhost:8080/ws?token=$TOKEN&project_id=$PROJECT_ID"
 
 echo -e "${BLUE}📡 Connecting to WebSocket...${NC}"
-echo "URL: $WS_URL"
-echo "User ID: $USER_ID"
+echo "URL: ws://localhost:8080/ws?project_id=$PROJECT_ID"
 echo "Project ID: $PROJECT_ID"
 echo ""
 echo -e "${GREEN}✅ Connected! Type messages below (Ctrl+C to exit)${NC}"
@@ -46,6 +51,6 @@ websocat "$WS_URL" | while read -r line; do
 done &
 
 # Send test messages
-echo '{"type":"chat_message","project_id":'$PROJECT_ID',"user_id":'$USER_ID',"data":{"message":"Hello from terminal!","user_id":'$USER_ID',"project_id":'$PROJECT_ID'}}' | websocat "$WS_URL"
+echo '{"type":"typing","project_id":'$PROJECT_ID',"data":{"typing":true}}' | websocat "$WS_URL"
 
 wait